@@ -25,8 +25,8 @@ func (a *AccessControl) CanDo(apiOp *types.APIRequest, resource, verb, namespace
 		}
 	}
 	group, resource := kv.Split(resource, "/")
-	accessSet := apiOp.Schemas.Attributes["accessSet"].(*AccessSet)
-	if accessSet.Grants(verb, schema.GroupResource{
+	accessSet, ok := AccessSetFromAPISchemas(apiOp.Schemas)
+	if ok && accessSet.Grants(verb, schema.GroupResource{
 		Group:    group,
 		Resource: resource,
 	}, namespace, name) {
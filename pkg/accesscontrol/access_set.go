@@ -1,7 +1,10 @@
 package accesscontrol
 
 import (
+	"context"
 	"sort"
+	"strings"
+	"sync/atomic"
 
 	"github.com/rancher/apiserver/pkg/types"
 	"github.com/rancher/steve/pkg/attributes"
@@ -9,9 +12,14 @@ import (
 	"k8s.io/apimachinery/pkg/util/sets"
 )
 
+// AccessSet is the access granted to a user. It must not be modified with Add or Merge once it is shared, since the
+// methods reading it may be called concurrently.
 type AccessSet struct {
 	ID  string
 	set map[key]resourceAccessSet
+	// namespaces memoizes the result of Namespaces, it is reset when the access set is modified. The access set must
+	// not be copied once Namespaces was called, so its methods have pointer receivers.
+	namespaces atomic.Pointer[[]string]
 }
 
 type resourceAccessSet map[Access]bool
@@ -21,7 +29,18 @@ type key struct {
 	gr   schema.GroupResource
 }
 
-func (a *AccessSet) Namespaces() (result []string) {
+// Namespaces returns the sorted namespaces in which the access set grants get or list on a resource. The result is
+// computed once and shared by every caller, so it must not be modified.
+func (a *AccessSet) Namespaces() []string {
+	if namespaces := a.namespaces.Load(); namespaces != nil {
+		return *namespaces
+	}
+	namespaces := a.computeNamespaces()
+	a.namespaces.Store(&namespaces)
+	return namespaces
+}
+
+func (a *AccessSet) computeNamespaces() (result []string) {
 	set := map[string]bool{}
 	for k, as := range a.set {
 		if k.verb != "get" && k.verb != "list" {
@@ -41,7 +60,22 @@ func (a *AccessSet) Namespaces() (result []string) {
 	return
 }
 
+// IsClusterAdmin returns true if the access set grants every verb on every resource in all namespaces.
+func (a *AccessSet) IsClusterAdmin() bool {
+	if a == nil {
+		return false
+	}
+	return a.set[key{
+		verb: All,
+		gr: schema.GroupResource{
+			Group:    All,
+			Resource: All,
+		},
+	}][Access{Namespace: All, ResourceName: All}]
+}
+
 func (a *AccessSet) Merge(right *AccessSet) {
+	a.namespaces.Store(nil)
 	for k, accessMap := range right.set {
 		m, ok := a.set[k]
 		if !ok {
@@ -58,10 +92,46 @@ func (a *AccessSet) Merge(right *AccessSet) {
 	}
 }
 
-func (a AccessSet) Grants(verb string, gr schema.GroupResource, namespace, name string) bool {
+// ChangedGroupResources returns the group resources whose access differs between the two sets. If the changed access
+// was granted through a wildcard group, resource or subresource, any group resource may be affected and all is true.
+func (a *AccessSet) ChangedGroupResources(other *AccessSet) (changed map[schema.GroupResource]bool, all bool) {
+	if other == nil {
+		return nil, true
+	}
+
+	changed = map[schema.GroupResource]bool{}
+	compare := func(left, right *AccessSet) {
+		for k, accessMap := range left.set {
+			if equalAccessMaps(accessMap, right.set[k]) {
+				continue
+			}
+			if k.gr.Group == All || strings.Contains(k.gr.Resource, All) {
+				all = true
+			}
+			changed[k.gr] = true
+		}
+	}
+	compare(a, other)
+	compare(other, a)
+	return changed, all
+}
+
+func equalAccessMaps(left, right resourceAccessSet) bool {
+	if len(left) != len(right) {
+		return false
+	}
+	for k := range left {
+		if !right[k] {
+			return false
+		}
+	}
+	return true
+}
+
+func (a *AccessSet) Grants(verb string, gr schema.GroupResource, namespace, name string) bool {
 	for _, v := range []string{All, verb} {
 		for _, g := range []string{All, gr.Group} {
-			for _, r := range []string{All, gr.Resource} {
+			for _, r := range resourceCandidates(gr.Resource) {
 				for k := range a.set[key{
 					verb: v,
 					gr: schema.GroupResource{
@@ -80,11 +150,11 @@ func (a AccessSet) Grants(verb string, gr schema.GroupResource, namespace, name
 	return false
 }
 
-func (a AccessSet) AccessListFor(verb string, gr schema.GroupResource) (result AccessList) {
+func (a *AccessSet) AccessListFor(verb string, gr schema.GroupResource) (result AccessList) {
 	dedup := map[Access]bool{}
 	for _, v := range []string{All, verb} {
 		for _, g := range []string{All, gr.Group} {
-			for _, r := range []string{All, gr.Resource} {
+			for _, r := range resourceCandidates(gr.Resource) {
 				for k := range a.set[key{
 					verb: v,
 					gr: schema.GroupResource{
@@ -105,7 +175,62 @@ func (a AccessSet) AccessListFor(verb string, gr schema.GroupResource) (result A
 	return
 }
 
+// Can returns whether the access set grants the verb on the object of the group resource with the name, in the
+// namespace. The namespace is empty for cluster scoped objects and for every namespace, and the name is empty for
+// every object, so that Can("list", gr, "", "") asks whether every object of gr can be listed. A nil access set
+// grants nothing.
+func (a *AccessSet) Can(verb string, gr schema.GroupResource, namespace, name string) bool {
+	if a == nil {
+		return false
+	}
+	return a.Grants(verb, gr, namespace, name)
+}
+
+// AccessListsFor returns the access granted on the group resource for every verb the access set has rules for,
+// including the access granted through wildcard verbs, groups and resources. The access granted for every verb is
+// also listed under All. The access lists are sorted by namespace and name.
+func (a *AccessSet) AccessListsFor(gr schema.GroupResource) AccessListByVerb {
+	result := AccessListByVerb{}
+	if a == nil {
+		return result
+	}
+	candidates := resourceCandidates(gr.Resource)
+	for k := range a.set {
+		if k.gr.Group != All && k.gr.Group != gr.Group {
+			continue
+		}
+		for _, r := range candidates {
+			if k.gr.Resource == r {
+				result[k.verb] = nil
+				break
+			}
+		}
+	}
+	for verb := range result {
+		list := a.AccessListFor(verb, gr)
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].Namespace != list[j].Namespace {
+				return list[i].Namespace < list[j].Namespace
+			}
+			return list[i].ResourceName < list[j].ResourceName
+		})
+		result[verb] = list
+	}
+	return result
+}
+
+// resourceCandidates returns the resources whose rules apply to the given resource. A subresource such as pods/log
+// is matched by rules on pods/log, pods/* and */log, but not by rules on pods.
+func resourceCandidates(resource string) []string {
+	parent, sub, ok := strings.Cut(resource, "/")
+	if !ok {
+		return []string{All, resource}
+	}
+	return []string{All, resource, parent + "/" + All, All + "/" + sub}
+}
+
 func (a *AccessSet) Add(verb string, gr schema.GroupResource, access Access) {
+	a.namespaces.Store(nil)
 	if a.set == nil {
 		a.set = map[key]resourceAccessSet{}
 	}
@@ -208,6 +333,28 @@ func (a Access) nameOK(name string) bool {
 	return a.ResourceName == All || a.ResourceName == name
 }
 
+// AccessSetFromAPISchemas returns the access set the schemas were computed for, if any.
+func AccessSetFromAPISchemas(apiSchemas *types.APISchemas) (*AccessSet, bool) {
+	if apiSchemas == nil {
+		return nil, false
+	}
+	accessSet, ok := apiSchemas.Attributes["accessSet"].(*AccessSet)
+	return accessSet, ok && accessSet != nil
+}
+
+type accessSetContextKey struct{}
+
+// WithAccessSet returns a copy of the context carrying the access set of the user making the request.
+func WithAccessSet(ctx context.Context, accessSet *AccessSet) context.Context {
+	return context.WithValue(ctx, accessSetContextKey{}, accessSet)
+}
+
+// AccessSetFromContext returns the access set stored in the context by WithAccessSet, if any.
+func AccessSetFromContext(ctx context.Context) (*AccessSet, bool) {
+	accessSet, ok := ctx.Value(accessSetContextKey{}).(*AccessSet)
+	return accessSet, ok && accessSet != nil
+}
+
 func GetAccessListMap(s *types.APISchema) AccessListByVerb {
 	if s == nil {
 		return nil
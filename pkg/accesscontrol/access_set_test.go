@@ -0,0 +1,171 @@
+package accesscontrol
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestNamespaces(t *testing.T) {
+	pods := schema.GroupResource{Resource: "pods"}
+	a := &AccessSet{}
+	assert.Empty(t, a.Namespaces())
+
+	a.Add("get", pods, Access{Namespace: "ns2", ResourceName: All})
+	a.Add("list", pods, Access{Namespace: "ns1", ResourceName: All})
+	a.Add("update", pods, Access{Namespace: "ns3", ResourceName: All})
+	a.Add("get", pods, Access{Namespace: All, ResourceName: All})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Equal(t, []string{"ns1", "ns2"}, a.Namespaces())
+		}()
+	}
+	wg.Wait()
+
+	first := a.Namespaces()
+	assert.Same(t, &first[0], &a.Namespaces()[0], "expected the namespaces to be computed once")
+
+	a.Add("get", pods, Access{Namespace: "ns0", ResourceName: All})
+	assert.Equal(t, []string{"ns0", "ns1", "ns2"}, a.Namespaces(), "expected adding access to reset the namespaces")
+
+	other := &AccessSet{}
+	other.Add("list", pods, Access{Namespace: "ns4", ResourceName: All})
+	a.Merge(other)
+	assert.Equal(t, []string{"ns0", "ns1", "ns2", "ns4"}, a.Namespaces(), "expected merging access to reset the namespaces")
+}
+
+func TestConcurrentReads(t *testing.T) {
+	pods := schema.GroupResource{Resource: "pods"}
+	a := &AccessSet{}
+	a.Add("list", pods, Access{Namespace: "ns1", ResourceName: All})
+	a.Add("get", pods, Access{Namespace: "ns2", ResourceName: "web"})
+
+	// a shared access set is read from several goroutines, while Namespaces memoizes its result
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			assert.Equal(t, []string{"ns1", "ns2"}, a.Namespaces())
+		}()
+		go func() {
+			defer wg.Done()
+			assert.True(t, a.Grants("get", pods, "ns2", "web"))
+		}()
+		go func() {
+			defer wg.Done()
+			assert.Len(t, a.AccessListFor("list", pods), 1)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestGrantsSubresource(t *testing.T) {
+	a := &AccessSet{}
+	a.Add("get", schema.GroupResource{Resource: "pods"}, Access{Namespace: All, ResourceName: All})
+	a.Add("get", schema.GroupResource{Resource: "pods/log"}, Access{Namespace: "ns1", ResourceName: All})
+	a.Add("create", schema.GroupResource{Resource: "*/exec"}, Access{Namespace: "ns2", ResourceName: All})
+
+	assert.True(t, a.Grants("get", schema.GroupResource{Resource: "pods/log"}, "ns1", "a"))
+	assert.False(t, a.Grants("get", schema.GroupResource{Resource: "pods/log"}, "ns2", "a"), "expected access to pods to not grant access to pods/log")
+	assert.False(t, a.Grants("get", schema.GroupResource{Resource: "pods/exec"}, "ns1", "a"))
+	assert.True(t, a.Grants("create", schema.GroupResource{Resource: "pods/exec"}, "ns2", "a"))
+	assert.False(t, a.Grants("create", schema.GroupResource{Resource: "pods"}, "ns2", "a"))
+
+	other := &AccessSet{}
+	other.Add("get", schema.GroupResource{Resource: "pods"}, Access{Namespace: All, ResourceName: All})
+	other.Add("get", schema.GroupResource{Resource: "pods/log"}, Access{Namespace: "ns1", ResourceName: All})
+	changed, all := a.ChangedGroupResources(other)
+	assert.True(t, all, "expected a change to a wildcard subresource to affect all group resources")
+	assert.True(t, changed[schema.GroupResource{Resource: "*/exec"}])
+}
+
+func TestCan(t *testing.T) {
+	pods := schema.GroupResource{Resource: "pods"}
+	nodes := schema.GroupResource{Resource: "nodes"}
+	deployments := schema.GroupResource{Group: "apps", Resource: "deployments"}
+	a := &AccessSet{}
+	a.Add("get", pods, Access{Namespace: "ns1", ResourceName: All})
+	a.Add("update", pods, Access{Namespace: "ns1", ResourceName: "web"})
+	a.Add("list", pods, Access{Namespace: All, ResourceName: All})
+	a.Add("get", nodes, Access{Namespace: All, ResourceName: "node1"})
+	a.Add("watch", schema.GroupResource{Group: All, Resource: All}, Access{Namespace: "ns2", ResourceName: All})
+	a.Add(All, schema.GroupResource{Group: "apps", Resource: All}, Access{Namespace: "ns3", ResourceName: All})
+
+	tests := []struct {
+		name      string
+		verb      string
+		gr        schema.GroupResource
+		namespace string
+		objName   string
+		want      bool
+	}{
+		{name: "namespaced grant", verb: "get", gr: pods, namespace: "ns1", objName: "web", want: true},
+		{name: "namespaced grant in another namespace", verb: "get", gr: pods, namespace: "ns2", objName: "web"},
+		{name: "namespaced grant in every namespace", verb: "get", gr: pods},
+		{name: "grant in every namespace", verb: "list", gr: pods, want: true},
+		{name: "grant in every namespace for a namespace", verb: "list", gr: pods, namespace: "ns4", want: true},
+		{name: "named grant", verb: "update", gr: pods, namespace: "ns1", objName: "web", want: true},
+		{name: "named grant for another name", verb: "update", gr: pods, namespace: "ns1", objName: "db"},
+		{name: "named grant for every name", verb: "update", gr: pods, namespace: "ns1"},
+		{name: "cluster scoped grant", verb: "get", gr: nodes, objName: "node1", want: true},
+		{name: "cluster scoped grant for another name", verb: "get", gr: nodes, objName: "node2"},
+		{name: "namespaced grant for a cluster scoped resource", verb: "watch", gr: nodes},
+		{name: "wildcard group and resource", verb: "watch", gr: deployments, namespace: "ns2", want: true},
+		{name: "wildcard verb and resource", verb: "delete", gr: deployments, namespace: "ns3", objName: "web", want: true},
+		{name: "wildcard verb in another group", verb: "delete", gr: pods, namespace: "ns3", objName: "web"},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, a.Can(test.verb, test.gr, test.namespace, test.objName))
+		})
+	}
+
+	var none *AccessSet
+	assert.False(t, none.Can("get", pods, "ns1", "web"), "expected a nil access set to grant nothing")
+}
+
+func TestAccessListsFor(t *testing.T) {
+	pods := schema.GroupResource{Resource: "pods"}
+	a := &AccessSet{}
+	a.Add("get", pods, Access{Namespace: "ns2", ResourceName: All})
+	a.Add("get", pods, Access{Namespace: "ns1", ResourceName: "web"})
+	a.Add("list", pods, Access{Namespace: "ns1", ResourceName: All})
+	a.Add("get", schema.GroupResource{Resource: "pods/log"}, Access{Namespace: "ns3", ResourceName: All})
+	a.Add("delete", schema.GroupResource{Group: "apps", Resource: All}, Access{Namespace: "ns4", ResourceName: All})
+	a.Add(All, schema.GroupResource{Group: All, Resource: All}, Access{Namespace: "ns5", ResourceName: All})
+
+	assert.Equal(t, AccessListByVerb{
+		"get": {
+			{Namespace: "ns1", ResourceName: "web"},
+			{Namespace: "ns2", ResourceName: All},
+			{Namespace: "ns5", ResourceName: All},
+		},
+		"list": {
+			{Namespace: "ns1", ResourceName: All},
+			{Namespace: "ns5", ResourceName: All},
+		},
+		All: {
+			{Namespace: "ns5", ResourceName: All},
+		},
+	}, a.AccessListsFor(pods))
+	assert.Equal(t, AccessListByVerb{
+		"delete": {
+			{Namespace: "ns4", ResourceName: All},
+			{Namespace: "ns5", ResourceName: All},
+		},
+		All: {
+			{Namespace: "ns5", ResourceName: All},
+		},
+	}, a.AccessListsFor(schema.GroupResource{Group: "apps", Resource: "deployments"}))
+
+	var none *AccessSet
+	assert.Empty(t, none.AccessListsFor(pods))
+}
@@ -17,12 +17,14 @@ import (
 type AccessSetLookup interface {
 	AccessFor(user user.Info) *AccessSet
 	PurgeUserData(id string)
+	PurgeUserDataBulk(ids []string)
 }
 
 type AccessStore struct {
-	users  *policyRuleIndex
-	groups *policyRuleIndex
-	cache  *cache.LRUExpireCache
+	users    *policyRuleIndex
+	groups   *policyRuleIndex
+	bindings *bindingChangeIndex
+	cache    *cache.LRUExpireCache
 }
 
 type roleKey struct {
@@ -33,8 +35,9 @@ type roleKey struct {
 func NewAccessStore(ctx context.Context, cacheResults bool, rbac v1.Interface) *AccessStore {
 	revisions := newRoleRevision(ctx, rbac)
 	as := &AccessStore{
-		users:  newPolicyRuleIndex(true, revisions, rbac),
-		groups: newPolicyRuleIndex(false, revisions, rbac),
+		users:    newPolicyRuleIndex(true, revisions, rbac),
+		groups:   newPolicyRuleIndex(false, revisions, rbac),
+		bindings: newBindingChangeIndex(ctx, rbac),
 	}
 	if cacheResults {
 		as.cache = cache.NewLRUExpireCache(50)
@@ -70,19 +73,42 @@ func (l *AccessStore) PurgeUserData(id string) {
 	l.cache.Remove(id)
 }
 
-func (l *AccessStore) CacheKey(user user.Info) string {
-	d := sha256.New()
-
-	l.users.addRolesToHash(d, user.GetName())
+func (l *AccessStore) PurgeUserDataBulk(ids []string) {
+	if l.cache == nil {
+		return
+	}
+	for _, id := range ids {
+		l.cache.Remove(id)
+	}
+}
 
-	groupBase := user.GetGroups()
-	groups := make([]string, 0, len(groupBase))
-	copy(groups, groupBase)
+// OnUserBindingChange registers a callback that is called with the name of every user subject of a RoleBinding or
+// ClusterRoleBinding that was created or removed, or whose subjects or role changed. Group subjects are not reported,
+// since the users of a group are not known: the access set of a user whose group's bindings changed gets a new ID the
+// next time it is looked up, which is how caches keyed by it pick up the change.
+func (l *AccessStore) OnUserBindingChange(cb func(userName string)) {
+	l.bindings.onChange(cb)
+}
 
-	sort.Strings(groups)
+// CacheKey returns the ID of the user's access set. It only depends on the roles bound to the user and its groups,
+// not on the order the bindings or groups are listed in, so that identical access sets share an ID.
+func (l *AccessStore) CacheKey(user user.Info) string {
+	entries := l.users.roleEntries(user.GetName())
 	for _, group := range user.GetGroups() {
-		l.groups.addRolesToHash(d, group)
+		entries = append(entries, l.groups.roleEntries(group)...)
+	}
+	sort.Strings(entries)
+
+	d := sha256.New()
+	for i, entry := range entries {
+		if i > 0 && entries[i-1] == entry {
+			continue
+		}
+		d.Write([]byte(entry))
+		d.Write(null)
 	}
 
 	return hex.EncodeToString(d.Sum(nil))
 }
+
+var null = []byte{'\x00'}
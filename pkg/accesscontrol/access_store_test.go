@@ -0,0 +1,203 @@
+package accesscontrol
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/rancher/wrangler/pkg/generic/fake"
+	"github.com/stretchr/testify/assert"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/cache"
+	"k8s.io/apiserver/pkg/authentication/user"
+)
+
+func TestCacheKey(t *testing.T) {
+	clusterRoleBindings := map[string][]*rbacv1.ClusterRoleBinding{
+		"crbUser": {
+			makeClusterRoleBinding("a", "admin"),
+			makeClusterRoleBinding("b", "view"),
+		},
+		"crbGroup": {
+			makeClusterRoleBinding("c", "edit"),
+			makeClusterRoleBinding("d", "view"),
+		},
+	}
+	roleBindings := map[string][]*rbacv1.RoleBinding{
+		"rbUser": {
+			makeRoleBinding("1", "ns1", "Role", "reader"),
+			makeRoleBinding("2", "ns2", "ClusterRole", "edit"),
+		},
+		"rbGroup": {
+			makeRoleBinding("3", "ns1", "Role", "writer"),
+			makeRoleBinding("4", "ns3", "ClusterRole", "view"),
+		},
+	}
+	groups := []string{"group1", "group2", "group3"}
+
+	ctrl := gomock.NewController(t)
+	random := rand.New(rand.NewSource(1))
+	newIndex := func(kind string) *policyRuleIndex {
+		crbCache := fake.NewMockNonNamespacedCacheInterface[*rbacv1.ClusterRoleBinding](ctrl)
+		crbCache.EXPECT().GetByIndex("crb"+kind, gomock.Any()).DoAndReturn(func(_, _ string) ([]*rbacv1.ClusterRoleBinding, error) {
+			// the same roles are bound by bindings named differently every time
+			result := append([]*rbacv1.ClusterRoleBinding{}, clusterRoleBindings["crb"+kind]...)
+			random.Shuffle(len(result), func(i, j int) { result[i], result[j] = result[j], result[i] })
+			for i, crb := range result {
+				result[i] = makeClusterRoleBinding(string(rune('a'+i)), crb.RoleRef.Name)
+			}
+			return result, nil
+		}).AnyTimes()
+		rbCache := fake.NewMockCacheInterface[*rbacv1.RoleBinding](ctrl)
+		rbCache.EXPECT().GetByIndex("rb"+kind, gomock.Any()).DoAndReturn(func(_, _ string) ([]*rbacv1.RoleBinding, error) {
+			result := append([]*rbacv1.RoleBinding{}, roleBindings["rb"+kind]...)
+			random.Shuffle(len(result), func(i, j int) { result[i], result[j] = result[j], result[i] })
+			for i, rb := range result {
+				result[i] = makeRoleBinding(string(rune('1'+i)), rb.Namespace, rb.RoleRef.Kind, rb.RoleRef.Name)
+			}
+			return result, nil
+		}).AnyTimes()
+		return &policyRuleIndex{
+			crbCache:            crbCache,
+			rbCache:             rbCache,
+			revisions:           &roleRevisionIndex{},
+			kind:                kind,
+			roleIndexKey:        "rb" + kind,
+			clusterRoleIndexKey: "crb" + kind,
+		}
+	}
+	store := &AccessStore{
+		users:  newIndex("User"),
+		groups: newIndex("Group"),
+	}
+
+	newUser := func() user.Info {
+		shuffled := append([]string{}, groups...)
+		random.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+		return &user.DefaultInfo{
+			Name:   "testUser",
+			Groups: shuffled,
+		}
+	}
+
+	want := store.CacheKey(newUser())
+	for i := 0; i < 20; i++ {
+		assert.Equal(t, want, store.CacheKey(newUser()), "expected the ID to not depend on the order of bindings and groups")
+	}
+
+	store.groups = newIndex("None")
+	assert.NotEqual(t, want, store.CacheKey(newUser()), "expected a different ID for different roles")
+}
+
+func TestCacheKeyBindingNamespace(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	crbCache := fake.NewMockNonNamespacedCacheInterface[*rbacv1.ClusterRoleBinding](ctrl)
+	rbCache := fake.NewMockCacheInterface[*rbacv1.RoleBinding](ctrl)
+	index := &policyRuleIndex{
+		crbCache:            crbCache,
+		rbCache:             rbCache,
+		revisions:           &roleRevisionIndex{},
+		kind:                "User",
+		roleIndexKey:        "rbUser",
+		clusterRoleIndexKey: "crbUser",
+	}
+	store := &AccessStore{users: index, groups: index}
+	testUser := &user.DefaultInfo{Name: "testUser"}
+
+	crbCache.EXPECT().GetByIndex("crbUser", "testUser").Return([]*rbacv1.ClusterRoleBinding{makeClusterRoleBinding("a", "view")}, nil)
+	rbCache.EXPECT().GetByIndex("rbUser", "testUser").Return(nil, nil)
+	clusterWide := store.CacheKey(testUser)
+
+	crbCache.EXPECT().GetByIndex("crbUser", "testUser").Return(nil, nil)
+	rbCache.EXPECT().GetByIndex("rbUser", "testUser").Return([]*rbacv1.RoleBinding{makeRoleBinding("1", "ns1", "ClusterRole", "view")}, nil)
+	namespaced := store.CacheKey(testUser)
+
+	assert.NotEqual(t, clusterWide, namespaced, "expected a cluster role bound in a namespace to not share the ID of a cluster wide binding")
+}
+
+func TestAccessForAggregatedClusterRole(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	crCache := fake.NewMockNonNamespacedCacheInterface[*rbacv1.ClusterRole](ctrl)
+	crbCache := fake.NewMockNonNamespacedCacheInterface[*rbacv1.ClusterRoleBinding](ctrl)
+	rbCache := fake.NewMockCacheInterface[*rbacv1.RoleBinding](ctrl)
+	revisions := &roleRevisionIndex{}
+	index := &policyRuleIndex{
+		crCache:             crCache,
+		crbCache:            crbCache,
+		rbCache:             rbCache,
+		revisions:           revisions,
+		kind:                "User",
+		roleIndexKey:        "rbUser",
+		clusterRoleIndexKey: "crbUser",
+	}
+	store := &AccessStore{users: index, groups: index, cache: cache.NewLRUExpireCache(10)}
+	testUser := &user.DefaultInfo{Name: "testUser"}
+
+	podRule := rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get", "list"}}
+	deploymentRule := rbacv1.PolicyRule{APIGroups: []string{"apps"}, Resources: []string{"deployments"}, Verbs: []string{"get", "list"}}
+	aggregate := &rbacv1.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{Name: "view", ResourceVersion: "1"},
+		AggregationRule: &rbacv1.AggregationRule{
+			ClusterRoleSelectors: []metav1.LabelSelector{{MatchLabels: map[string]string{"aggregate-to-view": "true"}}},
+		},
+		Rules: []rbacv1.PolicyRule{podRule},
+	}
+	crCache.EXPECT().Get("view").DoAndReturn(func(string) (*rbacv1.ClusterRole, error) {
+		return aggregate, nil
+	}).AnyTimes()
+	crbCache.EXPECT().GetByIndex("crbUser", "testUser").Return([]*rbacv1.ClusterRoleBinding{makeClusterRoleBinding("a", "view")}, nil).AnyTimes()
+	rbCache.EXPECT().GetByIndex("rbUser", "testUser").Return(nil, nil).AnyTimes()
+	revisions.onClusterRoleChanged(aggregate.Name, aggregate)
+
+	deployments := schema.GroupResource{Group: "apps", Resource: "deployments"}
+	before := store.AccessFor(testUser)
+	assert.True(t, before.Grants("list", schema.GroupResource{Resource: "pods"}, "ns1", ""))
+	assert.False(t, before.Grants("list", deployments, "ns1", ""))
+
+	// a cluster role matching the aggregation rule only grants access once it is aggregated into the rules of the
+	// aggregated role, which is done by the Kubernetes controller manager
+	member := &rbacv1.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{Name: "view-deployments", ResourceVersion: "2", Labels: map[string]string{"aggregate-to-view": "true"}},
+		Rules:      []rbacv1.PolicyRule{deploymentRule},
+	}
+	revisions.onClusterRoleChanged(member.Name, member)
+	assert.Same(t, before, store.AccessFor(testUser), "expected the access set to be unchanged until the rules are aggregated")
+
+	aggregate = aggregate.DeepCopy()
+	aggregate.ResourceVersion = "3"
+	aggregate.Rules = append(aggregate.Rules, deploymentRule)
+	revisions.onClusterRoleChanged(aggregate.Name, aggregate)
+
+	after := store.AccessFor(testUser)
+	assert.True(t, after.Grants("list", deployments, "ns1", ""), "expected the access set to have the aggregated rules")
+	assert.NotEqual(t, before.ID, after.ID, "expected the access set ID, which keys the schema cache, to change")
+}
+
+func makeClusterRoleBinding(name, roleName string) *rbacv1.ClusterRoleBinding {
+	return &rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		RoleRef: rbacv1.RoleRef{
+			Kind: "ClusterRole",
+			Name: roleName,
+		},
+	}
+}
+
+func makeRoleBinding(uid, namespace, kind, roleName string) *rbacv1.RoleBinding {
+	return &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "binding-" + uid,
+			Namespace: namespace,
+			UID:       types.UID(uid),
+		},
+		RoleRef: rbacv1.RoleRef{
+			Kind: kind,
+			Name: roleName,
+		},
+	}
+}
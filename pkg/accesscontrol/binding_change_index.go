@@ -0,0 +1,120 @@
+package accesscontrol
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	rbac "github.com/rancher/wrangler/pkg/generated/controllers/rbac/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
+type bindingChangeIndex struct {
+	lock     sync.RWMutex
+	handlers []func(userName string)
+	bindings sync.Map
+}
+
+// bindingState is what was last seen of a binding: the user names of its subjects and the role it binds them to.
+type bindingState struct {
+	users   []string
+	roleRef rbacv1.RoleRef
+}
+
+func newBindingChangeIndex(ctx context.Context, rbac rbac.Interface) *bindingChangeIndex {
+	b := &bindingChangeIndex{}
+	rbac.RoleBinding().OnChange(ctx, "binding-change-indexer", b.onRoleBindingChanged)
+	rbac.ClusterRoleBinding().OnChange(ctx, "binding-change-indexer", b.onClusterRoleBindingChanged)
+	return b
+}
+
+func (b *bindingChangeIndex) onChange(handler func(userName string)) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.handlers = append(b.handlers, handler)
+}
+
+func (b *bindingChangeIndex) onRoleBindingChanged(key string, rb *rbacv1.RoleBinding) (*rbacv1.RoleBinding, error) {
+	if rb == nil {
+		b.notify("rb:"+key, nil)
+		return rb, nil
+	}
+	b.notify("rb:"+key, &bindingState{users: userSubjectNames(rb.Subjects), roleRef: rb.RoleRef})
+	return rb, nil
+}
+
+func (b *bindingChangeIndex) onClusterRoleBindingChanged(key string, crb *rbacv1.ClusterRoleBinding) (*rbacv1.ClusterRoleBinding, error) {
+	if crb == nil {
+		b.notify("crb:"+key, nil)
+		return crb, nil
+	}
+	b.notify("crb:"+key, &bindingState{users: userSubjectNames(crb.Subjects), roleRef: crb.RoleRef})
+	return crb, nil
+}
+
+// notify calls the registered handlers for every user referenced by either the current or the last seen version of
+// the binding, so that users removed from a binding are notified as well. A nil state means the binding was removed.
+// Nothing is notified when neither the users nor the role of the binding changed, such as on resyncs.
+func (b *bindingChangeIndex) notify(key string, current *bindingState) {
+	var previous *bindingState
+	if val, ok := b.bindings.Load(key); ok {
+		previous = val.(*bindingState)
+	}
+	if current != nil {
+		b.bindings.Store(key, current)
+	} else {
+		b.bindings.Delete(key)
+	}
+	if previous.equal(current) {
+		return
+	}
+
+	users := map[string]bool{}
+	for _, state := range []*bindingState{previous, current} {
+		if state == nil {
+			continue
+		}
+		for _, name := range state.users {
+			users[name] = true
+		}
+	}
+
+	b.lock.RLock()
+	handlers := b.handlers
+	b.lock.RUnlock()
+
+	for name := range users {
+		for _, handler := range handlers {
+			handler(name)
+		}
+	}
+}
+
+func (s *bindingState) equal(other *bindingState) bool {
+	if s == nil || other == nil {
+		return s == other
+	}
+	if s.roleRef != other.roleRef || len(s.users) != len(other.users) {
+		return false
+	}
+	for i := range s.users {
+		if s.users[i] != other.users[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// userSubjectNames returns the sorted user names of the subjects, using the same naming as the user policyRuleIndex.
+func userSubjectNames(subjects []rbacv1.Subject) (result []string) {
+	for _, subject := range subjects {
+		if subject.APIGroup == rbacGroup && subject.Kind == "User" {
+			result = append(result, subject.Name)
+		} else if subject.APIGroup == "" && subject.Kind == "ServiceAccount" && subject.Namespace != "" {
+			result = append(result, fmt.Sprintf("serviceaccount:%s:%s", subject.Namespace, subject.Name))
+		}
+	}
+	sort.Strings(result)
+	return
+}
@@ -0,0 +1,65 @@
+package accesscontrol
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestBindingChangeNotify(t *testing.T) {
+	b := &bindingChangeIndex{}
+	var notified []string
+	b.onChange(func(userName string) {
+		notified = append(notified, userName)
+	})
+	changes := func() []string {
+		defer func() { notified = nil }()
+		sort.Strings(notified)
+		return notified
+	}
+
+	binding := &rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: "binding"},
+		Subjects: []rbacv1.Subject{
+			{APIGroup: rbacGroup, Kind: "User", Name: "alice"},
+			{APIGroup: rbacGroup, Kind: "Group", Name: "devs"},
+		},
+		RoleRef: rbacv1.RoleRef{APIGroup: rbacGroup, Kind: "ClusterRole", Name: "view"},
+	}
+	_, err := b.onClusterRoleBindingChanged("binding", binding)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"alice"}, changes(), "expected the users of a new binding to be notified")
+
+	_, err = b.onClusterRoleBindingChanged("binding", binding.DeepCopy())
+	assert.NoError(t, err)
+	assert.Empty(t, changes(), "expected a resync to not notify")
+
+	updated := binding.DeepCopy()
+	updated.Labels = map[string]string{"updated": "true"}
+	updated.Subjects = append([]rbacv1.Subject{{APIGroup: rbacGroup, Kind: "Group", Name: "ops"}}, updated.Subjects...)
+	_, err = b.onClusterRoleBindingChanged("binding", updated)
+	assert.NoError(t, err)
+	assert.Empty(t, changes(), "expected changes to other than the users and role to not notify")
+
+	updated = updated.DeepCopy()
+	updated.Subjects = append(updated.Subjects, rbacv1.Subject{APIGroup: rbacGroup, Kind: "User", Name: "bob"})
+	_, err = b.onClusterRoleBindingChanged("binding", updated)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"alice", "bob"}, changes(), "expected all the users of a changed binding to be notified")
+
+	updated = updated.DeepCopy()
+	updated.RoleRef.Name = "edit"
+	_, err = b.onClusterRoleBindingChanged("binding", updated)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"alice", "bob"}, changes(), "expected a new role to notify the users")
+
+	_, err = b.onClusterRoleBindingChanged("binding", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"alice", "bob"}, changes(), "expected the users of a removed binding to be notified")
+	_, err = b.onClusterRoleBindingChanged("binding", nil)
+	assert.NoError(t, err)
+	assert.Empty(t, changes())
+}
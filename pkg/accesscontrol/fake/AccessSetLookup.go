@@ -60,3 +60,15 @@ func (mr *MockAccessSetLookupMockRecorder) PurgeUserData(arg0 interface{}) *gomo
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PurgeUserData", reflect.TypeOf((*MockAccessSetLookup)(nil).PurgeUserData), arg0)
 }
+
+// PurgeUserDataBulk mocks base method.
+func (m *MockAccessSetLookup) PurgeUserDataBulk(arg0 []string) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "PurgeUserDataBulk", arg0)
+}
+
+// PurgeUserDataBulk indicates an expected call of PurgeUserDataBulk.
+func (mr *MockAccessSetLookupMockRecorder) PurgeUserDataBulk(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PurgeUserDataBulk", reflect.TypeOf((*MockAccessSetLookup)(nil).PurgeUserDataBulk), arg0)
+}
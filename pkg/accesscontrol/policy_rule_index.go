@@ -2,8 +2,8 @@ package accesscontrol
 
 import (
 	"fmt"
-	"hash"
 	"sort"
+	"strings"
 
 	v1 "github.com/rancher/wrangler/pkg/generated/controllers/rbac/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
@@ -72,28 +72,26 @@ func (p *policyRuleIndex) roleBindingBySubject(rb *rbacv1.RoleBinding) (result [
 	return
 }
 
-var null = []byte{'\x00'}
-
-func (p *policyRuleIndex) addRolesToHash(digest hash.Hash, subjectName string) {
+// roleEntries returns an entry for every role bound to the subject, identifying the role, its revision and the
+// namespace it is granted in. The entries are used to compute the ID of the subject's access set.
+func (p *policyRuleIndex) roleEntries(subjectName string) (result []string) {
 	for _, crb := range p.getClusterRoleBindings(subjectName) {
-		digest.Write([]byte(crb.RoleRef.Name))
-		digest.Write([]byte(p.revisions.roleRevision("", crb.RoleRef.Name)))
-		digest.Write(null)
+		result = append(result, roleEntry("ClusterRole", All, crb.RoleRef.Name, p.revisions.roleRevision("", crb.RoleRef.Name)))
 	}
 
 	for _, rb := range p.getRoleBindings(subjectName) {
 		switch rb.RoleRef.Kind {
 		case "Role":
-			digest.Write([]byte(rb.RoleRef.Name))
-			digest.Write([]byte(rb.Namespace))
-			digest.Write([]byte(p.revisions.roleRevision(rb.Namespace, rb.RoleRef.Name)))
-			digest.Write(null)
+			result = append(result, roleEntry("Role", rb.Namespace, rb.RoleRef.Name, p.revisions.roleRevision(rb.Namespace, rb.RoleRef.Name)))
 		case "ClusterRole":
-			digest.Write([]byte(rb.RoleRef.Name))
-			digest.Write([]byte(p.revisions.roleRevision("", rb.RoleRef.Name)))
-			digest.Write(null)
+			result = append(result, roleEntry("ClusterRole", rb.Namespace, rb.RoleRef.Name, p.revisions.roleRevision("", rb.RoleRef.Name)))
 		}
 	}
+	return
+}
+
+func roleEntry(kind, namespace, name, revision string) string {
+	return strings.Join([]string{kind, namespace, name, revision}, "\x00")
 }
 
 func (p *policyRuleIndex) get(subjectName string) *AccessSet {
@@ -135,6 +133,10 @@ func (p *policyRuleIndex) addAccess(accessSet *AccessSet, namespace string, role
 	}
 }
 
+// getRules returns the rules of the role. The rules of an aggregated cluster role are the ones aggregated into it by
+// the Kubernetes controller manager, rather than resolved from its aggregation rule, so that access matches what
+// Kubernetes authorizes. Aggregating rules updates the role, which changes its revision and so the ID of the access
+// sets it grants.
 func (p *policyRuleIndex) getRules(namespace string, roleRef rbacv1.RoleRef) []rbacv1.PolicyRule {
 	switch roleRef.Kind {
 	case "ClusterRole":
@@ -146,6 +146,37 @@ func DisallowMethods(s *types.APISchema) map[string]bool {
 	return data
 }
 
+func SetBlockedMethodReasons(s *types.APISchema, reasons map[string]string) {
+	setVal(s, "blockedMethodReasons", reasons)
+}
+
+func BlockedMethodReasons(s *types.APISchema) map[string]string {
+	data, ok := s.Attributes["blockedMethodReasons"].(map[string]string)
+	if !ok {
+		return nil
+	}
+	return data
+}
+
+// Priority is used to order schemas when they are listed, higher priorities first.
+func Priority(s *types.APISchema) int {
+	priority, _ := convert.ToNumber(s.Attributes["priority"])
+	return int(priority)
+}
+
+func SetPriority(s *types.APISchema, priority int) {
+	setVal(s, "priority", priority)
+}
+
+// CanWatch is whether the user a schema was rendered for is allowed to watch its resource.
+func CanWatch(s *types.APISchema) bool {
+	return convert.ToBool(s.Attributes["canWatch"])
+}
+
+func SetCanWatch(s *types.APISchema, value bool) {
+	setVal(s, "canWatch", value)
+}
+
 func SetAPIResource(s *types.APISchema, resource v1.APIResource) {
 	SetResource(s, resource.Name)
 	SetVerbs(s, resource.Verbs)
@@ -184,3 +215,78 @@ func SetPreferredGroup(s *types.APISchema, ver string) {
 	}
 	s.Attributes["preferredGroup"] = ver
 }
+
+// Conditions is whether the resources of a schema report their status with the standard status.conditions, so that
+// their state can be computed from the Ready, Reconciling and Stalled conditions.
+func Conditions(s *types.APISchema) bool {
+	return convert.ToBool(s.Attributes["conditions"])
+}
+
+func SetConditions(s *types.APISchema, value bool) {
+	setVal(s, "conditions", value)
+}
+
+// DefaultProjection is the projection applied to the objects of list responses for the schema when the request does
+// not have a projection parameter, using the . notation of the parameter, e.g. "status.phase".
+func DefaultProjection(s *types.APISchema) []string {
+	return convert.ToStringSlice(s.Attributes["defaultProjection"])
+}
+
+func SetDefaultProjection(s *types.APISchema, fields []string) {
+	setVal(s, "defaultProjection", fields)
+}
+
+// Joins are the joins of the schema's listed objects with related objects, declared by its templates as a
+// []listprocessor.Join.
+func Joins(s *types.APISchema) interface{} {
+	return s.Attributes["joins"]
+}
+
+func SetJoins(s *types.APISchema, joins interface{}) {
+	setVal(s, "joins", joins)
+}
+
+// Sensitive is whether accesses to the schema's objects are audited, such as for secrets.
+func Sensitive(s *types.APISchema) bool {
+	return convert.ToBool(s.Attributes["sensitive"])
+}
+
+func SetSensitive(s *types.APISchema, value bool) {
+	setVal(s, "sensitive", value)
+}
+
+// Validation is how the bodies of the creates and updates of the schema's objects are validated against its fields
+// before they are sent to Kubernetes: not at all if empty, ValidationLenient or ValidationStrict.
+func Validation(s *types.APISchema) string {
+	return convert.ToString(s.Attributes["validation"])
+}
+
+func SetValidation(s *types.APISchema, mode string) {
+	setVal(s, "validation", mode)
+}
+
+const (
+	// ValidationLenient validates the types and the required fields of the objects, allowing unknown fields.
+	ValidationLenient = "lenient"
+	// ValidationStrict also rejects the fields the schema does not have.
+	ValidationStrict = "strict"
+)
+
+// opaque holds an attribute value which is not rendered when the schema is serialized, such as a function. Its field
+// is unexported so it is rendered as an empty object.
+type opaque struct {
+	value interface{}
+}
+
+// Authorizer decides which of the schema's objects the user of a request may see, beyond the RBAC access to them,
+// declared by its templates as a listprocessor.Authorizer.
+func Authorizer(s *types.APISchema) interface{} {
+	if o, ok := s.Attributes["authorizer"].(*opaque); ok {
+		return o.value
+	}
+	return nil
+}
+
+func SetAuthorizer(s *types.APISchema, authorizer interface{}) {
+	setVal(s, "authorizer", &opaque{value: authorizer})
+}
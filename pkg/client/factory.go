@@ -18,6 +18,7 @@ type Factory struct {
 	impersonate         bool
 	tableClientCfg      *rest.Config
 	tableWatchClientCfg *rest.Config
+	metadataClientCfg   *rest.Config
 	clientCfg           *rest.Config
 	watchClientCfg      *rest.Config
 	metadata            metadata.Interface
@@ -40,6 +41,20 @@ func (a *addQuery) RoundTrip(req *http.Request) (*http.Response, error) {
 	return a.next.RoundTrip(req)
 }
 
+// partialMetadataAccept asks Kubernetes for lists of the metadata of the objects only, falling back to the full
+// objects for the resources that do not support it.
+const partialMetadataAccept = "application/json;as=PartialObjectMetadataList;v=v1;g=meta.k8s.io,application/json"
+
+type setAccept struct {
+	accept string
+	next   http.RoundTripper
+}
+
+func (s *setAccept) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.Header.Set("Accept", s.accept)
+	return s.next.RoundTrip(req)
+}
+
 func NewFactory(cfg *rest.Config, impersonate bool) (*Factory, error) {
 	clientCfg := rest.CopyConfig(cfg)
 	clientCfg.QPS = 10000
@@ -64,6 +79,15 @@ func NewFactory(cfg *rest.Config, impersonate bool) (*Factory, error) {
 	tableWatchClientCfg.Wrap(setTable)
 	tableWatchClientCfg.AcceptContentTypes = "application/json;as=Table;v=v1;g=meta.k8s.io,application/json;as=Table;v=v1beta1;g=meta.k8s.io"
 
+	metadataClientCfg := rest.CopyConfig(clientCfg)
+	metadataClientCfg.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return &setAccept{
+			accept: partialMetadataAccept,
+			next:   rt,
+		}
+	})
+	metadataClientCfg.AcceptContentTypes = partialMetadataAccept
+
 	md, err := metadata.NewForConfig(cfg)
 	if err != nil {
 		return nil, err
@@ -80,6 +104,7 @@ func NewFactory(cfg *rest.Config, impersonate bool) (*Factory, error) {
 		impersonate:         impersonate,
 		tableClientCfg:      tableClientCfg,
 		tableWatchClientCfg: tableWatchClientCfg,
+		metadataClientCfg:   metadataClientCfg,
 		clientCfg:           clientCfg,
 		watchClientCfg:      watchClientCfg,
 		Config:              watchClientCfg,
@@ -159,13 +184,21 @@ func (p *Factory) TableAdminClientForWatch(ctx *types.APIRequest, s *types.APISc
 	return p.AdminClientForWatch(ctx, s, namespace, warningHandler)
 }
 
+// PartialMetadataClient returns a client of the user of the request whose lists have the metadata of the objects
+// only, as PartialObjectMetadata objects. Resources which do not support it are listed in full.
+func (p *Factory) PartialMetadataClient(ctx *types.APIRequest, s *types.APISchema, namespace string, warningHandler rest.WarningHandler) (dynamic.ResourceInterface, error) {
+	return newClient(ctx, p.metadataClientCfg, s, namespace, p.impersonate, warningHandler)
+}
+
+// setupConfig returns a copy of the config, which impersonates the user of the request with its name, groups and
+// extras if impersonate is set, so that Kubernetes enforces the user's RBAC.
 func setupConfig(ctx *types.APIRequest, cfg *rest.Config, impersonate bool) (*rest.Config, error) {
+	cfg = rest.CopyConfig(cfg)
 	if impersonate {
 		user, ok := request.UserFrom(ctx.Context())
 		if !ok {
 			return nil, fmt.Errorf("user not found for impersonation")
 		}
-		cfg = rest.CopyConfig(cfg)
 		cfg.Impersonate.UserName = user.GetName()
 		cfg.Impersonate.Groups = user.GetGroups()
 		cfg.Impersonate.Extra = user.GetExtra()
@@ -175,10 +208,10 @@ func setupConfig(ctx *types.APIRequest, cfg *rest.Config, impersonate bool) (*re
 
 func newDynamicClient(ctx *types.APIRequest, cfg *rest.Config, impersonate bool, warningHandler rest.WarningHandler) (dynamic.Interface, error) {
 	cfg, err := setupConfig(ctx, cfg, impersonate)
-	cfg.WarningHandler = warningHandler
 	if err != nil {
 		return nil, err
 	}
+	cfg.WarningHandler = warningHandler
 
 	return dynamic.NewForConfig(cfg)
 }
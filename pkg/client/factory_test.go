@@ -0,0 +1,69 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rancher/apiserver/pkg/types"
+	"github.com/rancher/steve/pkg/attributes"
+	"github.com/rancher/wrangler/pkg/schemas"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/client-go/rest"
+)
+
+func TestImpersonationHeaders(t *testing.T) {
+	var headers http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header.Clone()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"apiVersion":"v1","kind":"ConfigMapList","items":[]}`))
+	}))
+	defer server.Close()
+
+	s := &types.APISchema{Schema: &schemas.Schema{ID: "configmap"}}
+	attributes.SetGVR(s, schema.GroupVersionResource{Version: "v1", Resource: "configmaps"})
+	u := &user.DefaultInfo{
+		Name:   "user1",
+		Groups: []string{"group1", "group2", "system:authenticated"},
+		Extra: map[string][]string{
+			"scopes":               {"view", "edit"},
+			"principalid":          {"local://user1"},
+			"example.com/tenantid": {"tenant1"},
+		},
+	}
+	apiOp := &types.APIRequest{Request: (&http.Request{}).WithContext(request.WithUser(context.Background(), u))}
+
+	list := func(impersonate bool) http.Header {
+		factory, err := NewFactory(&rest.Config{Host: server.URL}, impersonate)
+		require.NoError(t, err)
+		client, err := factory.Client(apiOp, s, "", nil)
+		require.NoError(t, err)
+		_, err = client.List(context.Background(), metav1.ListOptions{})
+		require.NoError(t, err)
+		return headers
+	}
+
+	got := list(true)
+	assert.Equal(t, "user1", got.Get("Impersonate-User"))
+	assert.Equal(t, []string{"group1", "group2", "system:authenticated"}, got.Values("Impersonate-Group"))
+	assert.Equal(t, []string{"view", "edit"}, got.Values("Impersonate-Extra-Scopes"))
+	assert.Equal(t, []string{"local://user1"}, got.Values("Impersonate-Extra-Principalid"))
+	assert.Equal(t, []string{"tenant1"}, got.Values("Impersonate-Extra-Example.com%2ftenantid"), "expected the extra keys to be escaped")
+
+	got = list(false)
+	for key := range got {
+		assert.NotContains(t, key, "Impersonate", "expected no impersonation headers when not impersonating")
+	}
+
+	factory, err := NewFactory(&rest.Config{Host: server.URL}, true)
+	require.NoError(t, err)
+	_, err = factory.Client(&types.APIRequest{Request: &http.Request{}}, s, "", nil)
+	assert.Error(t, err, "expected impersonating without a user to fail")
+}
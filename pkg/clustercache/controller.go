@@ -2,11 +2,13 @@ package clustercache
 
 import (
 	"context"
+	"fmt"
 	"sync"
 	"time"
 
 	"github.com/rancher/apiserver/pkg/types"
 	"github.com/rancher/steve/pkg/attributes"
+	"github.com/rancher/steve/pkg/metrics"
 	"github.com/rancher/steve/pkg/schema"
 	"github.com/rancher/wrangler/pkg/merr"
 	"github.com/rancher/wrangler/pkg/summary/client"
@@ -31,6 +33,8 @@ type ClusterCache interface {
 	OnRemove(ctx context.Context, handler Handler)
 	OnChange(ctx context.Context, handler ChangeHandler)
 	OnSchemas(schemas *schema.Collection) error
+	Pause(gvr schema2.GroupVersionResource) error
+	Resume(gvr schema2.GroupVersionResource) error
 }
 
 type event struct {
@@ -40,12 +44,53 @@ type event struct {
 	oldObj runtime.Object
 }
 
+// before returns the object as it was before the event, or nil if it did not exist.
+func (e event) before() runtime.Object {
+	switch {
+	case e.oldObj != nil:
+		return e.oldObj
+	case e.add:
+		return nil
+	default:
+		return e.obj
+	}
+}
+
 type watcher struct {
 	ctx      context.Context
 	cancel   func()
 	informer cache.SharedIndexInformer
 	gvk      schema2.GroupVersionKind
 	gvr      schema2.GroupVersionResource
+
+	pauseLock sync.Mutex
+	// frozen holds the objects of the informer by key while the watcher is paused, and is nil otherwise.
+	frozen map[string]interface{}
+	// pending holds, by key, the objects whose events were dropped while the watcher is paused, as the handlers last
+	// saw them, or nil for the objects the handlers have not seen.
+	pending map[string]runtime.Object
+}
+
+// DefaultResyncPeriod is the default value of Options.ResyncPeriod.
+var DefaultResyncPeriod = 2 * time.Hour
+
+// Options configures the informers of a ClusterCache.
+type Options struct {
+	// ResyncPeriod is how often the informers of the resources resync their objects, unless ResyncPeriods sets another
+	// period for the resource. It defaults to DefaultResyncPeriod.
+	ResyncPeriod time.Duration
+	// ResyncPeriods sets the resync periods of specific resources, such as a short period for resources which change
+	// often, or a long one for resources which rarely do, so that the API server is relisted less. A resource with an
+	// empty version applies to all of its versions, and an exact match takes precedence. Zero disables the resyncs of
+	// the resource.
+	ResyncPeriods map[schema2.GroupVersionResource]time.Duration
+}
+
+// newSummaryInformer creates the informer watching the summaries of the objects of a resource, it is replaced in
+// tests.
+var newSummaryInformer = func(summaryClient client.Interface, gvr schema2.GroupVersionResource, resync time.Duration) cache.SharedIndexInformer {
+	return informer.NewFilteredSummaryInformer(summaryClient, gvr, metav1.NamespaceAll, resync,
+		cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}, nil).Informer()
 }
 
 type clusterCache struct {
@@ -55,6 +100,7 @@ type clusterCache struct {
 	summaryClient client.Interface
 	watchers      map[schema2.GroupVersionKind]*watcher
 	workqueue     workqueue.DelayingInterface
+	opts          Options
 
 	addHandlers    cancelCollection
 	removeHandlers cancelCollection
@@ -62,16 +108,37 @@ type clusterCache struct {
 }
 
 func NewClusterCache(ctx context.Context, dynamicClient dynamic.Interface) ClusterCache {
+	return NewClusterCacheWithOptions(ctx, dynamicClient, Options{})
+}
+
+// NewClusterCacheWithOptions returns a cluster cache like NewClusterCache, whose informers are configured by opts.
+func NewClusterCacheWithOptions(ctx context.Context, dynamicClient dynamic.Interface, opts Options) ClusterCache {
+	if opts.ResyncPeriod == 0 {
+		opts.ResyncPeriod = DefaultResyncPeriod
+	}
 	c := &clusterCache{
 		ctx:           ctx,
 		summaryClient: client.NewForDynamicClient(dynamicClient),
 		watchers:      map[schema2.GroupVersionKind]*watcher{},
 		workqueue:     workqueue.NewNamedDelayingQueue("cluster-cache"),
+		opts:          opts,
 	}
 	go c.start()
 	return c
 }
 
+// resyncPeriod returns the resync period of the informer of the resource.
+func (h *clusterCache) resyncPeriod(gvr schema2.GroupVersionResource) time.Duration {
+	if period, ok := h.opts.ResyncPeriods[gvr]; ok {
+		return period
+	}
+	gvr.Version = ""
+	if period, ok := h.opts.ResyncPeriods[gvr]; ok {
+		return period
+	}
+	return h.opts.ResyncPeriod
+}
+
 func validSchema(schema *types.APISchema) bool {
 	canList := false
 	canWatch := false
@@ -91,39 +158,70 @@ func validSchema(schema *types.APISchema) bool {
 	return true
 }
 
-func (h *clusterCache) addResourceEventHandler(gvk schema2.GroupVersionKind, informer cache.SharedIndexInformer) {
-	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+func (h *clusterCache) addResourceEventHandler(w *watcher) {
+	resource := metricsResource(w.gvr)
+	w.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
+			metrics.RecordClusterCacheWrite(resource, metrics.OperationInsert)
 			if rObj, ok := obj.(runtime.Object); ok {
-				h.workqueue.Add(event{
+				h.handle(w, event{
 					add: true,
 					obj: rObj,
-					gvk: gvk,
+					gvk: w.gvk,
 				})
 			}
 		},
 		UpdateFunc: func(oldObj, newObj interface{}) {
 			if rObj, ok := newObj.(runtime.Object); ok {
 				if rOldObj, ok := oldObj.(runtime.Object); ok {
-					h.workqueue.Add(event{
+					// resyncs send the objects again without writing them
+					if resourceVersion(rObj) != resourceVersion(rOldObj) {
+						metrics.RecordClusterCacheWrite(resource, metrics.OperationUpdate)
+					}
+					h.handle(w, event{
 						obj:    rObj,
 						oldObj: rOldObj,
-						gvk:    gvk,
+						gvk:    w.gvk,
 					})
 				}
 			}
 		},
 		DeleteFunc: func(obj interface{}) {
+			metrics.RecordClusterCacheWrite(resource, metrics.OperationDelete)
 			if rObj, ok := obj.(runtime.Object); ok {
-				h.workqueue.Add(event{
+				h.handle(w, event{
 					obj: rObj,
-					gvk: gvk,
+					gvk: w.gvk,
 				})
 			}
 		},
 	})
 }
 
+// metricsResource returns the resource label of the metrics of a resource.
+func metricsResource(gvr schema2.GroupVersionResource) string {
+	if gvr.Group == "" {
+		return gvr.Version + "/" + gvr.Resource
+	}
+	return gvr.Group + "/" + gvr.Version + "/" + gvr.Resource
+}
+
+// handle queues the event for the handlers, or drops it if the watcher is paused, recording the object as the
+// handlers last saw it so that the change is reconciled on resume.
+func (h *clusterCache) handle(w *watcher, e event) {
+	w.pauseLock.Lock()
+	defer w.pauseLock.Unlock()
+
+	if w.frozen != nil {
+		key := toKey(e.obj)
+		if _, ok := w.pending[key]; !ok {
+			w.pending[key] = e.before()
+		}
+		return
+	}
+	h.workqueue.Add(e)
+}
+
 func (h *clusterCache) OnSchemas(schemas *schema.Collection) error {
 	h.Lock()
 	defer h.Unlock()
@@ -135,7 +233,8 @@ func (h *clusterCache) OnSchemas(schemas *schema.Collection) error {
 
 	for _, id := range schemas.IDs() {
 		schema := schemas.Schema(id)
-		if !validSchema(schema) {
+		// the schema may have been removed since the IDs were listed
+		if schema == nil || !validSchema(schema) {
 			continue
 		}
 
@@ -147,21 +246,19 @@ func (h *clusterCache) OnSchemas(schemas *schema.Collection) error {
 			continue
 		}
 
-		summaryInformer := informer.NewFilteredSummaryInformer(h.summaryClient, gvr, metav1.NamespaceAll, 2*time.Hour,
-			cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}, nil)
 		ctx, cancel := context.WithCancel(h.ctx)
 		w := &watcher{
 			ctx:      ctx,
 			cancel:   cancel,
 			gvk:      gvk,
 			gvr:      gvr,
-			informer: summaryInformer.Informer(),
+			informer: newSummaryInformer(h.summaryClient, gvr, h.resyncPeriod(gvr)),
 		}
 		h.watchers[gvk] = w
 		toWait = append(toWait, w)
 
 		logrus.Infof("Watching metadata for %s", w.gvk)
-		h.addResourceEventHandler(w.gvk, w.informer)
+		h.addResourceEventHandler(w)
 		go w.informer.Run(w.ctx.Done())
 	}
 
@@ -170,6 +267,7 @@ func (h *clusterCache) OnSchemas(schemas *schema.Collection) error {
 			logrus.Infof("Stopping metadata watch on %s", gvk)
 			w.cancel()
 			delete(h.watchers, gvk)
+			metrics.DeleteClusterCacheResource(metricsResource(w.gvr))
 		}
 	}
 
@@ -180,6 +278,7 @@ func (h *clusterCache) OnSchemas(schemas *schema.Collection) error {
 			cancel()
 			w.cancel()
 			delete(h.watchers, w.gvk)
+			metrics.DeleteClusterCacheResource(metricsResource(w.gvr))
 		}
 		cancel()
 	}
@@ -203,6 +302,12 @@ func (h *clusterCache) Get(gvk schema2.GroupVersionKind, namespace, name string)
 		key = namespace + "/" + name
 	}
 
+	w.pauseLock.Lock()
+	defer w.pauseLock.Unlock()
+	if w.frozen != nil {
+		obj, ok := w.frozen[key]
+		return obj, ok, nil
+	}
 	return w.informer.GetStore().GetByKey(key)
 }
 
@@ -215,9 +320,94 @@ func (h *clusterCache) List(gvk schema2.GroupVersionKind) []interface{} {
 		return nil
 	}
 
+	w.pauseLock.Lock()
+	defer w.pauseLock.Unlock()
+	if w.frozen != nil {
+		result := make([]interface{}, 0, len(w.frozen))
+		for _, obj := range w.frozen {
+			result = append(result, obj)
+		}
+		return result
+	}
 	return w.informer.GetStore().List()
 }
 
+// Pause freezes the objects of the resource: Get and List return them as they were when the resource was paused, and
+// the changes made to them are not sent to the handlers, until the resource is resumed. The informer of the resource
+// keeps watching it, so pausing and resuming does not relist the resource. The objects read while the resource is
+// paused are stale, and may no longer exist. Pausing a paused resource does nothing.
+func (h *clusterCache) Pause(gvr schema2.GroupVersionResource) error {
+	w, err := h.watcherFor(gvr)
+	if err != nil {
+		return err
+	}
+
+	w.pauseLock.Lock()
+	defer w.pauseLock.Unlock()
+	if w.frozen != nil {
+		return nil
+	}
+	frozen := map[string]interface{}{}
+	for _, obj := range w.informer.GetStore().List() {
+		if rObj, ok := obj.(runtime.Object); ok {
+			frozen[toKey(rObj)] = obj
+		}
+	}
+	w.frozen = frozen
+	w.pending = map[string]runtime.Object{}
+	logrus.Infof("Paused metadata watch on %s", w.gvk)
+	return nil
+}
+
+// Resume unfreezes the objects of a paused resource. The handlers are sent a single event for each object which was
+// changed while the resource was paused, from its state before the pause to its current state, so that they catch up
+// without replaying every change. Resuming a resource which is not paused does nothing.
+func (h *clusterCache) Resume(gvr schema2.GroupVersionResource) error {
+	w, err := h.watcherFor(gvr)
+	if err != nil {
+		return err
+	}
+
+	// the lock is held until the changes are queued, so that they are handled before the next events
+	w.pauseLock.Lock()
+	defer w.pauseLock.Unlock()
+	if w.frozen == nil {
+		return nil
+	}
+	store := w.informer.GetStore()
+	for key, before := range w.pending {
+		var after runtime.Object
+		if obj, exists, err := store.GetByKey(key); err == nil && exists {
+			after, _ = obj.(runtime.Object)
+		}
+		switch {
+		case before == nil && after != nil:
+			h.workqueue.Add(event{add: true, obj: after, gvk: w.gvk})
+		case before != nil && after == nil:
+			h.workqueue.Add(event{obj: before, gvk: w.gvk})
+		case before != nil && after != nil:
+			h.workqueue.Add(event{obj: after, oldObj: before, gvk: w.gvk})
+		}
+	}
+	w.frozen = nil
+	w.pending = nil
+	logrus.Infof("Resumed metadata watch on %s", w.gvk)
+	return nil
+}
+
+// watcherFor returns the watcher of the resource, or an error if the resource is not watched.
+func (h *clusterCache) watcherFor(gvr schema2.GroupVersionResource) (*watcher, error) {
+	h.RLock()
+	defer h.RUnlock()
+
+	for _, w := range h.watchers {
+		if w.gvr == gvr {
+			return w, nil
+		}
+	}
+	return nil, fmt.Errorf("resource %s is not watched", gvr)
+}
+
 func (h *clusterCache) start() {
 	defer h.workqueue.ShutDown()
 	for {
@@ -268,6 +458,14 @@ func toKey(obj runtime.Object) string {
 	return ns + "/" + meta.GetName()
 }
 
+func resourceVersion(obj runtime.Object) string {
+	meta, err := meta.Accessor(obj)
+	if err != nil {
+		return ""
+	}
+	return meta.GetResourceVersion()
+}
+
 func (h *clusterCache) OnAdd(ctx context.Context, handler Handler) {
 	h.addHandlers.Add(ctx, handler)
 }
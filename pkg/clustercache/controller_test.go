@@ -0,0 +1,281 @@
+package clustercache
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rancher/apiserver/pkg/types"
+	"github.com/rancher/steve/pkg/metrics"
+	"github.com/rancher/steve/pkg/schema"
+	"github.com/rancher/wrangler/pkg/schemas"
+	"github.com/rancher/wrangler/pkg/summary/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	schema2 "k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+var (
+	widgets = schema2.GroupVersionResource{Group: "test.cattle.io", Version: "v1", Resource: "widgets"}
+	gadgets = schema2.GroupVersionResource{Group: "test.cattle.io", Version: "v1", Resource: "gadgets"}
+	gizmos  = schema2.GroupVersionResource{Group: "test.cattle.io", Version: "v1", Resource: "gizmos"}
+)
+
+func watchedSchema(gvr schema2.GroupVersionResource, kind string) *types.APISchema {
+	return &types.APISchema{Schema: &schemas.Schema{
+		ID: gvr.Group + "." + gvr.Resource,
+		Attributes: map[string]interface{}{
+			"group":    gvr.Group,
+			"version":  gvr.Version,
+			"kind":     kind,
+			"resource": gvr.Resource,
+			"verbs":    []string{"list", "watch"},
+		},
+	}}
+}
+
+// schemasByID returns the schemas keyed by their IDs, as a collection expects them.
+func schemasByID(apiSchemas ...*types.APISchema) map[string]*types.APISchema {
+	result := make(map[string]*types.APISchema, len(apiSchemas))
+	for _, s := range apiSchemas {
+		result[s.ID] = s
+	}
+	return result
+}
+
+func TestResyncPeriods(t *testing.T) {
+	var (
+		lock    sync.Mutex
+		periods = map[schema2.GroupVersionResource]time.Duration{}
+	)
+	defaultInformer := newSummaryInformer
+	newSummaryInformer = func(summaryClient client.Interface, gvr schema2.GroupVersionResource, resync time.Duration) cache.SharedIndexInformer {
+		lock.Lock()
+		periods[gvr] = resync
+		lock.Unlock()
+		return defaultInformer(summaryClient, gvr, resync)
+	}
+	defer func() {
+		newSummaryInformer = defaultInformer
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dynamicClient := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema2.GroupVersionResource]string{
+		widgets: "WidgetList",
+		gadgets: "GadgetList",
+		gizmos:  "GizmoList",
+	})
+	cc := NewClusterCacheWithOptions(ctx, dynamicClient, Options{
+		ResyncPeriod: time.Hour,
+		ResyncPeriods: map[schema2.GroupVersionResource]time.Duration{
+			widgets: time.Minute,
+			// all the versions of gadgets, except for v1
+			{Group: "test.cattle.io", Resource: "gadgets"}: 0,
+			gadgets: 10 * time.Minute,
+			{Group: "test.cattle.io", Resource: "gizmos"}: 5 * time.Hour,
+		},
+	})
+
+	collection := schema.NewCollection(ctx, types.EmptyAPISchemas(), nil)
+	require.NoError(t, collection.Reset(schemasByID(
+		watchedSchema(widgets, "Widget"),
+		watchedSchema(gadgets, "Gadget"),
+		watchedSchema(gizmos, "Gizmo"),
+	)))
+	require.NoError(t, cc.OnSchemas(collection))
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, map[schema2.GroupVersionResource]time.Duration{
+		widgets: time.Minute,
+		gadgets: 10 * time.Minute,
+		gizmos:  5 * time.Hour,
+	}, periods, "expected the configured periods to be passed to the informers")
+}
+
+func TestResyncPeriodDefault(t *testing.T) {
+	h := NewClusterCacheWithOptions(context.Background(), fake.NewSimpleDynamicClient(runtime.NewScheme()), Options{
+		ResyncPeriods: map[schema2.GroupVersionResource]time.Duration{
+			{Group: "test.cattle.io", Resource: "gadgets"}: 0,
+		},
+	}).(*clusterCache)
+
+	assert.Equal(t, DefaultResyncPeriod, h.resyncPeriod(widgets), "expected resources without a period to use the default")
+	assert.Equal(t, time.Duration(0), h.resyncPeriod(gadgets), "expected a zero period to disable the resyncs")
+}
+
+func TestOnSchemasMissingSchema(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cc := NewClusterCache(ctx, fake.NewSimpleDynamicClient(runtime.NewScheme()))
+
+	// the ID of the schema is listed, but looking it up by the ID finds nothing
+	collection := schema.NewCollection(ctx, types.EmptyAPISchemas(), nil)
+	require.NoError(t, collection.Reset(map[string]*types.APISchema{
+		"widget": watchedSchema(widgets, "Widget"),
+	}))
+	require.Nil(t, collection.Schema(collection.ByGVR(widgets)))
+	assert.NoError(t, cc.OnSchemas(collection))
+}
+
+func TestPauseResume(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dynamicClient := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema2.GroupVersionResource]string{
+		widgets: "WidgetList",
+	}, widget("kept"), widget("removed"))
+	cc := NewClusterCache(ctx, dynamicClient)
+
+	var (
+		lock   sync.Mutex
+		events []string
+	)
+	record := func(change string) func(gvk schema2.GroupVersionKind, key string, obj runtime.Object) error {
+		return func(gvk schema2.GroupVersionKind, key string, obj runtime.Object) error {
+			lock.Lock()
+			defer lock.Unlock()
+			events = append(events, change+" "+key)
+			return nil
+		}
+	}
+	cc.OnAdd(ctx, record("add"))
+	cc.OnRemove(ctx, record("remove"))
+	cc.OnChange(ctx, func(gvk schema2.GroupVersionKind, key string, obj, oldObj runtime.Object) error {
+		return record("change")(gvk, key, obj)
+	})
+	recorded := func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]string{}, events...)
+	}
+
+	collection := schema.NewCollection(ctx, types.EmptyAPISchemas(), nil)
+	require.NoError(t, collection.Reset(schemasByID(watchedSchema(widgets, "Widget"))))
+	require.NoError(t, cc.OnSchemas(collection))
+	assert.Error(t, cc.Pause(gadgets), "expected pausing a resource which is not watched to fail")
+
+	gvk := schema2.GroupVersionKind{Group: widgets.Group, Version: widgets.Version, Kind: "Widget"}
+	names := func() []string {
+		var result []string
+		for _, obj := range cc.List(gvk) {
+			result = append(result, obj.(metav1.Object).GetName())
+		}
+		sort.Strings(result)
+		return result
+	}
+	require.Eventually(t, func() bool {
+		return len(recorded()) == 2
+	}, time.Second, 10*time.Millisecond)
+	assert.ElementsMatch(t, []string{"add ns/kept", "add ns/removed"}, recorded())
+
+	require.NoError(t, cc.Pause(widgets))
+	require.NoError(t, cc.Pause(widgets), "expected pausing twice to be allowed")
+
+	widgetClient := dynamicClient.Resource(widgets).Namespace("ns")
+	_, err := widgetClient.Create(ctx, widget("added"), metav1.CreateOptions{})
+	require.NoError(t, err)
+	require.NoError(t, widgetClient.Delete(ctx, "removed", metav1.DeleteOptions{}))
+	_, err = widgetClient.Create(ctx, widget("transient"), metav1.CreateOptions{})
+	require.NoError(t, err)
+	require.NoError(t, widgetClient.Delete(ctx, "transient", metav1.DeleteOptions{}))
+
+	// the informer keeps watching while the reads are frozen
+	require.Eventually(t, func() bool {
+		w, err := cc.(*clusterCache).watcherFor(widgets)
+		require.NoError(t, err)
+		_, exists, _ := w.informer.GetStore().GetByKey("ns/added")
+		return exists
+	}, time.Second, 10*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, []string{"kept", "removed"}, names(), "expected the list to be frozen while paused")
+	_, exists, err := cc.Get(gvk, "ns", "added")
+	require.NoError(t, err)
+	assert.False(t, exists, "expected objects added while paused to be hidden")
+	_, exists, err = cc.Get(gvk, "ns", "removed")
+	require.NoError(t, err)
+	assert.True(t, exists, "expected objects removed while paused to be kept")
+	assert.Len(t, recorded(), 2, "expected no events while paused")
+
+	require.NoError(t, cc.Resume(widgets))
+	assert.Equal(t, []string{"added", "kept"}, names(), "expected the list to catch up on resume")
+	require.Eventually(t, func() bool {
+		return len(recorded()) == 4
+	}, time.Second, 10*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	assert.ElementsMatch(t, []string{"add ns/kept", "add ns/removed", "add ns/added", "remove ns/removed"}, recorded(),
+		"expected a single event for each object changed while paused")
+	require.NoError(t, cc.Resume(widgets), "expected resuming twice to be allowed")
+}
+
+func widget(name string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("test.cattle.io/v1")
+	obj.SetKind("Widget")
+	obj.SetNamespace("ns")
+	obj.SetName(name)
+	return obj
+}
+
+func TestWriteMetrics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	existing := widget("existing")
+	existing.SetGroupVersionKind(schema2.GroupVersionKind{Group: gizmos.Group, Version: gizmos.Version, Kind: "Gizmo"})
+	dynamicClient := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema2.GroupVersionResource]string{
+		gizmos: "GizmoList",
+	}, existing)
+	cc := NewClusterCache(ctx, dynamicClient)
+
+	objects := metrics.ClusterCacheObjects.WithLabelValues("test.cattle.io/v1/gizmos")
+	writes := func(operation string) float64 {
+		return testutil.ToFloat64(metrics.ClusterCacheWrites.WithLabelValues("test.cattle.io/v1/gizmos", operation))
+	}
+	waitFor := func(operation string, want float64) {
+		t.Helper()
+		require.Eventually(t, func() bool {
+			return writes(operation) == want
+		}, time.Second, 10*time.Millisecond, "expected %v %s writes", want, operation)
+	}
+
+	collection := schema.NewCollection(ctx, types.EmptyAPISchemas(), nil)
+	require.NoError(t, collection.Reset(schemasByID(watchedSchema(gizmos, "Gizmo"))))
+	require.NoError(t, cc.OnSchemas(collection))
+	waitFor(metrics.OperationInsert, 1)
+	assert.Equal(t, float64(1), testutil.ToFloat64(objects))
+
+	gizmoClient := dynamicClient.Resource(gizmos).Namespace("ns")
+	added := existing.DeepCopy()
+	added.SetName("added")
+	added.SetResourceVersion("1")
+	_, err := gizmoClient.Create(ctx, added, metav1.CreateOptions{})
+	require.NoError(t, err)
+	waitFor(metrics.OperationInsert, 2)
+	assert.Equal(t, float64(2), testutil.ToFloat64(objects))
+
+	added.SetResourceVersion("2")
+	added.SetLabels(map[string]string{"updated": "true"})
+	_, err = gizmoClient.Update(ctx, added, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	waitFor(metrics.OperationUpdate, 1)
+	assert.Equal(t, float64(2), testutil.ToFloat64(objects))
+
+	require.NoError(t, gizmoClient.Delete(ctx, "existing", metav1.DeleteOptions{}))
+	waitFor(metrics.OperationDelete, 1)
+	assert.Equal(t, float64(1), testutil.ToFloat64(objects))
+	assert.Equal(t, float64(2), writes(metrics.OperationInsert))
+	assert.Equal(t, float64(1), writes(metrics.OperationUpdate))
+
+	// removing the schema stops caching the resource, and its metrics
+	require.NoError(t, collection.Reset(map[string]*types.APISchema{}))
+	require.NoError(t, cc.OnSchemas(collection))
+	assert.Equal(t, float64(0), writes(metrics.OperationInsert))
+}
@@ -185,7 +185,9 @@ func (h *handler) refreshAll(ctx context.Context) error {
 		return err
 	}
 
-	h.schemas.Reset(filteredSchemas)
+	if err := h.schemas.Reset(filteredSchemas); err != nil {
+		return err
+	}
 	if h.handler != nil {
 		return h.handler.OnSchemas(h.schemas)
 	}
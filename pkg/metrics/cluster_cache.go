@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	clusterCacheSubsystem = "cluster_cache"
+	operationLabel        = "operation"
+
+	// OperationInsert, OperationUpdate and OperationDelete are the operations of the writes to the cluster cache.
+	OperationInsert = "insert"
+	OperationUpdate = "update"
+	OperationDelete = "delete"
+)
+
+var (
+	ClusterCacheObjects = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "steve",
+			Subsystem: clusterCacheSubsystem,
+			Name:      "objects",
+			Help:      "Current number of objects held in the cluster cache, by resource",
+		},
+		[]string{resourceLabel},
+	)
+	ClusterCacheWrites = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "steve",
+			Subsystem: clusterCacheSubsystem,
+			Name:      "writes_total",
+			Help:      "Total count of the objects inserted, updated and deleted in the cluster cache, by resource",
+		},
+		[]string{resourceLabel, operationLabel},
+	)
+)
+
+// RegisterClusterCacheMetrics registers the cluster cache metrics with the given registerer.
+func RegisterClusterCacheMetrics(registerer prometheus.Registerer) error {
+	for _, collector := range []prometheus.Collector{ClusterCacheObjects, ClusterCacheWrites} {
+		if err := registerer.Register(collector); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecordClusterCacheWrite records a write of the operation to an object of the resource in the cluster cache, and
+// updates the number of objects of the resource accordingly.
+func RecordClusterCacheWrite(resource, operation string) {
+	ClusterCacheWrites.WithLabelValues(resource, operation).Inc()
+	switch operation {
+	case OperationInsert:
+		ClusterCacheObjects.WithLabelValues(resource).Inc()
+	case OperationDelete:
+		ClusterCacheObjects.WithLabelValues(resource).Dec()
+	}
+}
+
+// DeleteClusterCacheResource removes the metrics of a resource which is no longer cached.
+func DeleteClusterCacheResource(resource string) {
+	ClusterCacheObjects.DeleteLabelValues(resource)
+	for _, operation := range []string{OperationInsert, OperationUpdate, OperationDelete} {
+		ClusterCacheWrites.DeleteLabelValues(resource, operation)
+	}
+}
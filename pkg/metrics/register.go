@@ -14,5 +14,11 @@ func init() {
 		prometheus.MustRegister(ProxyTotalResponses)
 		prometheus.MustRegister(K8sClientResponseTime)
 		prometheus.MustRegister(ProxyStoreResponseTime)
+		if err := RegisterSchemaCacheMetrics(prometheus.DefaultRegisterer); err != nil {
+			panic(err)
+		}
+		if err := RegisterClusterCacheMetrics(prometheus.DefaultRegisterer); err != nil {
+			panic(err)
+		}
 	}
 }
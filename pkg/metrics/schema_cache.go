@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const schemaCacheSubsystem = "schema_cache"
+
+var (
+	SchemaCacheHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "steve",
+			Subsystem: schemaCacheSubsystem,
+			Name:      "hits_total",
+			Help:      "Total count of user schema lookups served from the schema cache",
+		},
+	)
+	SchemaCacheMisses = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "steve",
+			Subsystem: schemaCacheSubsystem,
+			Name:      "misses_total",
+			Help:      "Total count of user schema lookups that had to compute the schemas",
+		},
+	)
+	// SchemaCacheSize sums the sizes of the schema caches added with AddSchemaCacheSize each time it is collected, so
+	// that entries removed or expired since are never counted.
+	SchemaCacheSize = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Namespace: "steve",
+			Subsystem: schemaCacheSubsystem,
+			Name:      "entries",
+			Help:      "Current number of schema sets held in the schema cache",
+		},
+		schemaCacheSize,
+	)
+
+	schemaCacheSizesLock sync.Mutex
+	schemaCacheSizes     = map[int]func() int{}
+	schemaCacheSizesID   int
+)
+
+// RegisterSchemaCacheMetrics registers the schema cache metrics with the given registerer.
+func RegisterSchemaCacheMetrics(registerer prometheus.Registerer) error {
+	for _, collector := range []prometheus.Collector{SchemaCacheHits, SchemaCacheMisses, SchemaCacheSize} {
+		if err := registerer.Register(collector); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IncSchemaCacheHit records a schema lookup served from the cache.
+func IncSchemaCacheHit() {
+	SchemaCacheHits.Inc()
+}
+
+// IncSchemaCacheMiss records a schema lookup that was not found in the cache.
+func IncSchemaCacheMiss() {
+	SchemaCacheMisses.Inc()
+}
+
+// AddSchemaCacheSize adds a function returning the number of entries of a schema cache to SchemaCacheSize, until the
+// returned function is called.
+func AddSchemaCacheSize(size func() int) (remove func()) {
+	schemaCacheSizesLock.Lock()
+	defer schemaCacheSizesLock.Unlock()
+	id := schemaCacheSizesID
+	schemaCacheSizesID++
+	schemaCacheSizes[id] = size
+	return func() {
+		schemaCacheSizesLock.Lock()
+		defer schemaCacheSizesLock.Unlock()
+		delete(schemaCacheSizes, id)
+	}
+}
+
+func schemaCacheSize() float64 {
+	schemaCacheSizesLock.Lock()
+	defer schemaCacheSizesLock.Unlock()
+	total := 0
+	for _, size := range schemaCacheSizes {
+		total += size()
+	}
+	return float64(total)
+}
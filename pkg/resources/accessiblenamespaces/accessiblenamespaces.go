@@ -0,0 +1,157 @@
+// Package accessiblenamespaces implements a schema for listing the names of the namespaces a user can access, without
+// listing the namespaces themselves.
+package accessiblenamespaces
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/rancher/apiserver/pkg/apierror"
+	"github.com/rancher/apiserver/pkg/types"
+	"github.com/rancher/steve/pkg/accesscontrol"
+	"github.com/rancher/steve/pkg/attributes"
+	corecontrollers "github.com/rancher/wrangler/pkg/generated/controllers/core/v1"
+	"github.com/rancher/wrangler/pkg/schemas/validation"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// includeParam is the query parameter requesting more than the names of the namespaces, as a comma separated list
+	// of includeProject and includeLabels.
+	includeParam   = "include"
+	includeProject = "project"
+	includeLabels  = "labels"
+
+	projectIDLabel = "field.cattle.io/projectId"
+)
+
+var namespacesGR = schema.GroupResource{Resource: "namespaces"}
+
+// AccessibleNamespace is a namespace the user can access.
+type AccessibleNamespace struct {
+	ID      string            `json:"id,omitempty"`
+	Project string            `json:"project,omitempty"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+// Register registers the accessibleNamespace schema. Listing it returns the namespaces the user can access, derived
+// from the user's access set. The namespaces are only read from the cache when the user can access all of them, or
+// when their project or labels are requested.
+func Register(schemas *types.APISchemas, namespaceCache corecontrollers.NamespaceCache) {
+	schemas.MustImportAndCustomize(AccessibleNamespace{}, func(schema *types.APISchema) {
+		schema.CollectionMethods = []string{http.MethodGet}
+		schema.ResourceMethods = []string{}
+		schema.ListHandler = func(apiOp *types.APIRequest) (types.APIObjectList, error) {
+			return list(apiOp, namespaceCache)
+		}
+	})
+}
+
+func list(apiOp *types.APIRequest, namespaceCache corecontrollers.NamespaceCache) (types.APIObjectList, error) {
+	accessSet, ok := accesscontrol.AccessSetFromAPISchemas(apiOp.Schemas)
+	if !ok {
+		return types.APIObjectList{}, apierror.NewAPIError(validation.PermissionDenied, "no access set for the request")
+	}
+
+	names, all := Names(accessSet, apiOp.Schemas)
+	if all {
+		namespaces, err := namespaceCache.List(labels.Everything())
+		if err != nil {
+			return types.APIObjectList{}, err
+		}
+		names = make([]string, 0, len(namespaces))
+		for _, ns := range namespaces {
+			names = append(names, ns.Name)
+		}
+		sort.Strings(names)
+	}
+
+	project, withLabels := includes(apiOp)
+	result := types.APIObjectList{
+		Objects: make([]types.APIObject, 0, len(names)),
+	}
+	for _, name := range names {
+		namespace := AccessibleNamespace{ID: name}
+		if project || withLabels {
+			// namespaces granted by name may not exist, they are still listed without their labels
+			if ns, err := namespaceCache.Get(name); err == nil && ns != nil {
+				if project {
+					namespace.Project = ns.Labels[projectIDLabel]
+				}
+				if withLabels {
+					namespace.Labels = ns.Labels
+				}
+			}
+		}
+		result.Objects = append(result.Objects, types.APIObject{
+			Type:   "accessibleNamespace",
+			ID:     name,
+			Object: namespace,
+		})
+	}
+	return result, nil
+}
+
+// Names returns the sorted names of the namespaces the access set grants access to, or true if it grants access to
+// all namespaces. A namespace is accessible when the user can get or list it, or can get or list a resource in it.
+// The user can access all namespaces when it can get or list any namespace, or any namespaced resource of the schemas
+// in all namespaces.
+func Names(accessSet *accesscontrol.AccessSet, schemas *types.APISchemas) ([]string, bool) {
+	if accessSet.IsClusterAdmin() {
+		return nil, true
+	}
+
+	set := map[string]bool{}
+	for _, verb := range []string{"get", "list"} {
+		for _, access := range accessSet.AccessListFor(verb, namespacesGR) {
+			if access.Namespace != accesscontrol.All {
+				// a binding in a namespace can only grant that namespace, which Namespaces already returns
+				continue
+			}
+			if access.ResourceName == accesscontrol.All {
+				return nil, true
+			}
+			set[access.ResourceName] = true
+		}
+	}
+
+	for _, s := range schemas.Schemas {
+		gr := attributes.GR(s)
+		if gr.Resource == "" || !attributes.Namespaced(s) {
+			continue
+		}
+		for _, verb := range []string{"get", "list"} {
+			for _, access := range accessSet.AccessListFor(verb, gr) {
+				if access.Namespace == accesscontrol.All {
+					return nil, true
+				}
+			}
+		}
+	}
+
+	for _, ns := range accessSet.Namespaces() {
+		set[ns] = true
+	}
+	result := make([]string, 0, len(set))
+	for name := range set {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result, false
+}
+
+func includes(apiOp *types.APIRequest) (project, withLabels bool) {
+	for _, include := range apiOp.Request.URL.Query()[includeParam] {
+		for _, v := range strings.Split(include, ",") {
+			switch strings.TrimSpace(v) {
+			case includeProject:
+				project = true
+			case includeLabels:
+				withLabels = true
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,223 @@
+package accessiblenamespaces_test
+
+import (
+	"net/http"
+	"sort"
+	"testing"
+
+	"github.com/rancher/apiserver/pkg/types"
+	"github.com/rancher/steve/pkg/accesscontrol"
+	"github.com/rancher/steve/pkg/resources/accessiblenamespaces"
+	"github.com/rancher/wrangler/pkg/generic"
+	"github.com/rancher/wrangler/pkg/schemas"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var (
+	podsGR  = schema.GroupResource{Resource: "pods"}
+	nodesGR = schema.GroupResource{Resource: "nodes"}
+)
+
+func TestNames(t *testing.T) {
+	tests := []struct {
+		name      string
+		grant     func(accessSet *accesscontrol.AccessSet)
+		wantNames []string
+		wantAll   bool
+	}{
+		{
+			name: "enumerated namespaces",
+			grant: func(accessSet *accesscontrol.AccessSet) {
+				accessSet.Add("list", podsGR, accesscontrol.Access{Namespace: "b", ResourceName: "*"})
+				accessSet.Add("get", podsGR, accesscontrol.Access{Namespace: "a", ResourceName: "web"})
+				accessSet.Add("get", schema.GroupResource{Resource: "namespaces"}, accesscontrol.Access{Namespace: "*", ResourceName: "c"})
+				// watch alone does not grant access to a namespace
+				accessSet.Add("watch", podsGR, accesscontrol.Access{Namespace: "d", ResourceName: "*"})
+			},
+			wantNames: []string{"a", "b", "c"},
+		},
+		{
+			name: "cluster-wide access to a cluster scoped resource",
+			grant: func(accessSet *accesscontrol.AccessSet) {
+				accessSet.Add("list", nodesGR, accesscontrol.Access{Namespace: "*", ResourceName: "*"})
+				accessSet.Add("list", podsGR, accesscontrol.Access{Namespace: "a", ResourceName: "*"})
+			},
+			wantNames: []string{"a"},
+		},
+		{
+			name: "no access",
+			grant: func(accessSet *accesscontrol.AccessSet) {
+			},
+			wantNames: []string{},
+		},
+		{
+			name: "wildcard namespaces access",
+			grant: func(accessSet *accesscontrol.AccessSet) {
+				accessSet.Add("list", schema.GroupResource{Resource: "namespaces"}, accesscontrol.Access{Namespace: "*", ResourceName: "*"})
+			},
+			wantAll: true,
+		},
+		{
+			name: "cluster-wide access to a namespaced resource",
+			grant: func(accessSet *accesscontrol.AccessSet) {
+				accessSet.Add("get", podsGR, accesscontrol.Access{Namespace: "*", ResourceName: "*"})
+			},
+			wantAll: true,
+		},
+		{
+			name: "cluster admin",
+			grant: func(accessSet *accesscontrol.AccessSet) {
+				accessSet.Add("*", schema.GroupResource{Group: "*", Resource: "*"}, accesscontrol.Access{Namespace: "*", ResourceName: "*"})
+			},
+			wantAll: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			accessSet := &accesscontrol.AccessSet{}
+			test.grant(accessSet)
+			names, all := accessiblenamespaces.Names(accessSet, testSchemas(accessSet))
+			assert.Equal(t, test.wantAll, all)
+			if !test.wantAll {
+				assert.Equal(t, test.wantNames, names)
+			}
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	cache := namespaceCache{
+		"a": {ObjectMeta: metav1.ObjectMeta{Name: "a", Labels: map[string]string{"field.cattle.io/projectId": "p1", "team": "x"}}},
+		"b": {ObjectMeta: metav1.ObjectMeta{Name: "b"}},
+		"c": {ObjectMeta: metav1.ObjectMeta{Name: "c", Labels: map[string]string{"team": "y"}}},
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		grant func(accessSet *accesscontrol.AccessSet)
+		want  []accessiblenamespaces.AccessibleNamespace
+	}{
+		{
+			name: "enumerated namespaces are not read from the cache",
+			grant: func(accessSet *accesscontrol.AccessSet) {
+				accessSet.Add("list", podsGR, accesscontrol.Access{Namespace: "c", ResourceName: "*"})
+				accessSet.Add("list", podsGR, accesscontrol.Access{Namespace: "missing", ResourceName: "*"})
+			},
+			want: []accessiblenamespaces.AccessibleNamespace{{ID: "c"}, {ID: "missing"}},
+		},
+		{
+			name:  "enumerated namespaces with their project and labels",
+			query: "include=project,labels",
+			grant: func(accessSet *accesscontrol.AccessSet) {
+				accessSet.Add("list", podsGR, accesscontrol.Access{Namespace: "a", ResourceName: "*"})
+				accessSet.Add("list", podsGR, accesscontrol.Access{Namespace: "missing", ResourceName: "*"})
+			},
+			want: []accessiblenamespaces.AccessibleNamespace{
+				{ID: "a", Project: "p1", Labels: map[string]string{"field.cattle.io/projectId": "p1", "team": "x"}},
+				{ID: "missing"},
+			},
+		},
+		{
+			name:  "wildcard access lists the cached namespaces",
+			query: "include=project",
+			grant: func(accessSet *accesscontrol.AccessSet) {
+				accessSet.Add("list", podsGR, accesscontrol.Access{Namespace: "*", ResourceName: "*"})
+			},
+			want: []accessiblenamespaces.AccessibleNamespace{{ID: "a", Project: "p1"}, {ID: "b"}, {ID: "c"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			accessSet := &accesscontrol.AccessSet{}
+			test.grant(accessSet)
+			apiSchemas := testSchemas(accessSet)
+			accessiblenamespaces.Register(apiSchemas, cache)
+
+			req, err := http.NewRequest(http.MethodGet, "/v1/accessibleNamespaces?"+test.query, nil)
+			require.NoError(t, err)
+			list, err := apiSchemas.LookupSchema("accessibleNamespace").ListHandler(&types.APIRequest{
+				Schemas: apiSchemas,
+				Request: req,
+			})
+			require.NoError(t, err)
+
+			var got []accessiblenamespaces.AccessibleNamespace
+			for _, obj := range list.Objects {
+				assert.Equal(t, "accessibleNamespace", obj.Type)
+				got = append(got, obj.Object.(accessiblenamespaces.AccessibleNamespace))
+			}
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestListWithoutAccessSet(t *testing.T) {
+	apiSchemas := types.EmptyAPISchemas()
+	accessiblenamespaces.Register(apiSchemas, namespaceCache{})
+
+	req, err := http.NewRequest(http.MethodGet, "/v1/accessibleNamespaces", nil)
+	require.NoError(t, err)
+	_, err = apiSchemas.LookupSchema("accessibleNamespace").ListHandler(&types.APIRequest{
+		Schemas: apiSchemas,
+		Request: req,
+	})
+	assert.Error(t, err)
+}
+
+// testSchemas returns the schemas of a user with the access set, with a namespaced pod schema and a cluster scoped
+// node schema.
+func testSchemas(accessSet *accesscontrol.AccessSet) *types.APISchemas {
+	apiSchemas := types.EmptyAPISchemas()
+	apiSchemas.MustAddSchema(types.APISchema{Schema: &schemas.Schema{
+		ID: "pod",
+		Attributes: map[string]interface{}{
+			"version":    "v1",
+			"kind":       "Pod",
+			"resource":   "pods",
+			"namespaced": true,
+		},
+	}})
+	apiSchemas.MustAddSchema(types.APISchema{Schema: &schemas.Schema{
+		ID: "node",
+		Attributes: map[string]interface{}{
+			"version":  "v1",
+			"kind":     "Node",
+			"resource": "nodes",
+		},
+	}})
+	apiSchemas.Attributes = map[string]interface{}{
+		"accessSet": accessSet,
+	}
+	return apiSchemas
+}
+
+type namespaceCache map[string]*corev1.Namespace
+
+func (n namespaceCache) Get(name string) (*corev1.Namespace, error) {
+	return n[name], nil
+}
+
+func (n namespaceCache) List(selector labels.Selector) ([]*corev1.Namespace, error) {
+	var result []*corev1.Namespace
+	for _, ns := range n {
+		result = append(result, ns)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result, nil
+}
+
+func (n namespaceCache) AddIndexer(indexName string, indexer generic.Indexer[*corev1.Namespace]) {
+	panic("not implemented")
+}
+
+func (n namespaceCache) GetByIndex(indexName, key string) ([]*corev1.Namespace, error) {
+	panic("not implemented")
+}
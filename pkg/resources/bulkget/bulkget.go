@@ -0,0 +1,118 @@
+// Package bulkget implements a schema for getting several resources in a single request.
+package bulkget
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/rancher/apiserver/pkg/apierror"
+	"github.com/rancher/apiserver/pkg/types"
+	"github.com/rancher/steve/pkg/accesscontrol"
+	"github.com/rancher/steve/pkg/attributes"
+	"github.com/rancher/wrangler/pkg/schemas/validation"
+)
+
+// maxItems is the number of resources a single bulk get can request.
+const maxItems = 100
+
+// BulkGet is the response of a bulk get, with a result for each requested resource in the order they were requested.
+type BulkGet struct {
+	ID    string   `json:"id,omitempty"`
+	Items []Result `json:"items"`
+}
+
+// Input is the body of a bulk get request.
+type Input struct {
+	Items []Item `json:"items"`
+}
+
+// Item identifies a resource to get.
+type Item struct {
+	Type      string `json:"type"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+}
+
+// Result is the outcome of getting a single resource. Failing to get a resource sets its status and error without
+// failing the rest of the request.
+type Result struct {
+	Item
+	Status int                    `json:"status"`
+	Error  string                 `json:"error,omitempty"`
+	Object map[string]interface{} `json:"object,omitempty"`
+}
+
+// Register registers the bulkget schema. Resources are requested by posting an Input to the collection, and the
+// response holds a Result for each of them.
+func Register(schemas *types.APISchemas) {
+	schemas.MustImportAndCustomize(BulkGet{}, func(schema *types.APISchema) {
+		schema.CollectionMethods = []string{http.MethodPost}
+		schema.ResourceMethods = []string{}
+		schema.CreateHandler = create
+	})
+}
+
+func create(apiOp *types.APIRequest) (types.APIObject, error) {
+	var input Input
+	if err := json.NewDecoder(apiOp.Request.Body).Decode(&input); err != nil {
+		return types.APIObject{}, apierror.NewAPIError(validation.InvalidBodyContent, err.Error())
+	}
+	if len(input.Items) > maxItems {
+		return types.APIObject{}, apierror.NewAPIError(validation.MaxLimitExceeded, fmt.Sprintf("can not get more than %d resources at once", maxItems))
+	}
+
+	result := BulkGet{
+		Items: make([]Result, 0, len(input.Items)),
+	}
+	for _, item := range input.Items {
+		result.Items = append(result.Items, get(apiOp, item))
+	}
+
+	// respond directly, since a create would be answered with 201 Created
+	apiOp.WriteResponse(http.StatusOK, types.APIObject{
+		Type:   "bulkget",
+		Object: result,
+	})
+	return types.APIObject{}, validation.ErrComplete
+}
+
+// get gets a single resource, checking that the access of the user grants getting it first.
+func get(apiOp *types.APIRequest, item Item) Result {
+	result := Result{
+		Item: item,
+	}
+
+	schema := apiOp.Schemas.LookupSchema(item.Type)
+	if schema == nil || schema.Store == nil || attributes.GVK(schema).Kind == "" {
+		result.Status = http.StatusNotFound
+		result.Error = fmt.Sprintf("type %s not found", item.Type)
+		return result
+	}
+	if !accesscontrol.GetAccessListMap(schema).Grants("get", item.Namespace, item.Name) {
+		result.Status = http.StatusForbidden
+		result.Error = fmt.Sprintf("can not get %s %s/%s", item.Type, item.Namespace, item.Name)
+		return result
+	}
+
+	op := apiOp.Clone()
+	op.Namespace = item.Namespace
+	obj, err := schema.Store.ByID(op, schema, item.Name)
+	if err != nil {
+		result.Status = errorStatus(err)
+		result.Error = err.Error()
+		return result
+	}
+	result.Status = http.StatusOK
+	result.Object = obj.Data()
+	return result
+}
+
+func errorStatus(err error) int {
+	var apiErr *apierror.APIError
+	if errors.As(err, &apiErr) && apiErr.Code.Status != 0 {
+		return apiErr.Code.Status
+	}
+	return http.StatusInternalServerError
+}
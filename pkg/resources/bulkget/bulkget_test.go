@@ -0,0 +1,118 @@
+package bulkget_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/rancher/apiserver/pkg/apierror"
+	"github.com/rancher/apiserver/pkg/store/empty"
+	"github.com/rancher/apiserver/pkg/types"
+	"github.com/rancher/steve/pkg/accesscontrol"
+	"github.com/rancher/steve/pkg/resources/bulkget"
+	"github.com/rancher/wrangler/pkg/schemas"
+	"github.com/rancher/wrangler/pkg/schemas/validation"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestBulkGet(t *testing.T) {
+	testSchemas := types.EmptyAPISchemas()
+	bulkget.Register(testSchemas)
+	testSchemas.MustAddSchema(*makeSchema("pod", accesscontrol.AccessListByVerb{
+		"get": {
+			{Namespace: "ns1", ResourceName: "*"},
+			{Namespace: "ns2", ResourceName: "b"},
+		},
+	}))
+	testSchemas.MustAddSchema(*makeSchema("node", accesscontrol.AccessListByVerb{
+		"list": {{Namespace: "*", ResourceName: "*"}},
+	}))
+
+	body := `{"items": [
+		{"type": "pod", "namespace": "ns1", "name": "a"},
+		{"type": "pod", "namespace": "ns1", "name": "missing"},
+		{"type": "pod", "namespace": "ns2", "name": "a"},
+		{"type": "pod", "namespace": "ns2", "name": "b"},
+		{"type": "node", "name": "n1"},
+		{"type": "unknown", "name": "x"}
+	]}`
+	req, err := http.NewRequest(http.MethodPost, "/v1/bulkget", strings.NewReader(body))
+	require.NoError(t, err)
+	writer := &responseWriter{}
+	apiOp := &types.APIRequest{
+		Schemas:        testSchemas,
+		Request:        req,
+		ResponseWriter: writer,
+	}
+
+	_, err = testSchemas.LookupSchema("bulkget").CreateHandler(apiOp)
+	assert.Equal(t, validation.ErrComplete, err)
+	assert.Equal(t, http.StatusOK, writer.code)
+
+	result := writer.obj.Object.(bulkget.BulkGet)
+	require.Len(t, result.Items, 6)
+	var statuses []int
+	for _, item := range result.Items {
+		statuses = append(statuses, item.Status)
+	}
+	assert.Equal(t, []int{http.StatusOK, http.StatusNotFound, http.StatusForbidden, http.StatusOK, http.StatusForbidden, http.StatusNotFound}, statuses)
+	assert.Equal(t, "a", result.Items[0].Object["metadata"].(map[string]interface{})["name"])
+	assert.Equal(t, "ns2", result.Items[3].Object["metadata"].(map[string]interface{})["namespace"])
+	assert.Nil(t, result.Items[2].Object)
+	assert.NotEmpty(t, result.Items[2].Error)
+}
+
+func TestBulkGetInvalidBody(t *testing.T) {
+	testSchemas := types.EmptyAPISchemas()
+	bulkget.Register(testSchemas)
+
+	req, err := http.NewRequest(http.MethodPost, "/v1/bulkget", strings.NewReader("{"))
+	require.NoError(t, err)
+	_, err = testSchemas.LookupSchema("bulkget").CreateHandler(&types.APIRequest{Schemas: testSchemas, Request: req})
+	var apiErr *apierror.APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, validation.InvalidBodyContent, apiErr.Code)
+}
+
+func makeSchema(id string, access accesscontrol.AccessListByVerb) *types.APISchema {
+	return &types.APISchema{
+		Schema: &schemas.Schema{
+			ID: id,
+			Attributes: map[string]interface{}{
+				"version": "v1",
+				"kind":    id,
+				"access":  access,
+			},
+		},
+		Store: &store{},
+	}
+}
+
+// store returns the objects named a and b in any namespace, and not found errors for any other name.
+type store struct {
+	empty.Store
+}
+
+func (s *store) ByID(apiOp *types.APIRequest, schema *types.APISchema, id string) (types.APIObject, error) {
+	if id != "a" && id != "b" {
+		return types.APIObject{}, apierror.NewAPIError(validation.NotFound, id+" not found")
+	}
+	obj := &unstructured.Unstructured{}
+	obj.SetName(id)
+	obj.SetNamespace(apiOp.Namespace)
+	return types.APIObject{Type: schema.ID, ID: id, Object: obj}, nil
+}
+
+type responseWriter struct {
+	code int
+	obj  types.APIObject
+}
+
+func (r *responseWriter) Write(apiOp *types.APIRequest, code int, obj types.APIObject) {
+	r.code = code
+	r.obj = obj
+}
+
+func (r *responseWriter) WriteList(apiOp *types.APIRequest, code int, list types.APIObjectList) {}
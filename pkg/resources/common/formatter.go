@@ -6,6 +6,7 @@ import (
 	"github.com/rancher/apiserver/pkg/types"
 	"github.com/rancher/steve/pkg/accesscontrol"
 	"github.com/rancher/steve/pkg/attributes"
+	"github.com/rancher/steve/pkg/resources/formatters"
 	"github.com/rancher/steve/pkg/schema"
 	metricsStore "github.com/rancher/steve/pkg/stores/metrics"
 	"github.com/rancher/steve/pkg/stores/proxy"
@@ -104,6 +105,10 @@ func formatter(summarycache *summarycache.SummaryCache) types.Formatter {
 				"message":       strings.Join(s.Message, ":"),
 			}, "metadata", "state")
 			data.PutValue(unstr.Object, rel, "metadata", "relationships")
+			// custom resources following the conditions convention are summarized from their conditions only
+			if attributes.Conditions(resource.Schema) {
+				formatters.KStatus(request, resource)
+			}
 
 			summary.NormalizeConditions(unstr)
 
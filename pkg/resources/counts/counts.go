@@ -3,20 +3,33 @@ package counts
 import (
 	"net/http"
 	"strconv"
+	"strings"
 	"sync"
 
+	"github.com/rancher/apiserver/pkg/apierror"
 	"github.com/rancher/apiserver/pkg/store/empty"
 	"github.com/rancher/apiserver/pkg/types"
 	"github.com/rancher/steve/pkg/accesscontrol"
 	"github.com/rancher/steve/pkg/attributes"
 	"github.com/rancher/steve/pkg/clustercache"
+	"github.com/rancher/wrangler/pkg/schemas/validation"
 	"github.com/rancher/wrangler/pkg/summary"
 	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	schema2 "k8s.io/apimachinery/pkg/runtime/schema"
 )
 
+const (
+	// groupByParam is the query parameter requesting the counts of each resource grouped by the value of a field.
+	groupByParam = "groupby"
+	// labelSelectorParam is the query parameter restricting the counts to the resources matching a label selector.
+	labelSelectorParam = "labelSelector"
+)
+
 var (
+	invalidSelector = validation.ErrorCode{Code: "InvalidSelector", Status: http.StatusBadRequest}
+
 	ignore = map[string]bool{
 		"count":   true,
 		"schema":  true,
@@ -69,7 +82,10 @@ func (s *Summary) DeepCopy() *Summary {
 type ItemCount struct {
 	Summary    Summary            `json:"summary,omitempty"`
 	Namespaces map[string]Summary `json:"namespaces,omitempty"`
-	Revision   int                `json:"-"`
+	// Groups counts the resources by the value of the field requested with the groupby query parameter. Resources
+	// without the field are not counted.
+	Groups   map[string]int `json:"groups,omitempty"`
+	Revision int            `json:"-"`
 }
 
 func (i *ItemCount) DeepCopy() *ItemCount {
@@ -81,6 +97,12 @@ func (i *ItemCount) DeepCopy() *ItemCount {
 			r.Namespaces[k] = *v.DeepCopy()
 		}
 	}
+	if r.Groups != nil {
+		r.Groups = map[string]int{}
+		for k, v := range i.Groups {
+			r.Groups[k] = v
+		}
+	}
 	return &r
 }
 
@@ -98,12 +120,20 @@ func toAPIObject(c Count) types.APIObject {
 }
 
 func (s *Store) ByID(apiOp *types.APIRequest, schema *types.APISchema, id string) (types.APIObject, error) {
-	c := s.getCount(apiOp)
+	selector, err := labelSelector(apiOp)
+	if err != nil {
+		return types.APIObject{}, err
+	}
+	c := s.getCount(apiOp, groupBy(apiOp), selector)
 	return toAPIObject(c), nil
 }
 
 func (s *Store) List(apiOp *types.APIRequest, schema *types.APISchema) (types.APIObjectList, error) {
-	c := s.getCount(apiOp)
+	selector, err := labelSelector(apiOp)
+	if err != nil {
+		return types.APIObjectList{}, err
+	}
+	c := s.getCount(apiOp, groupBy(apiOp), selector)
 	return types.APIObjectList{
 		Objects: []types.APIObject{
 			toAPIObject(c),
@@ -113,6 +143,11 @@ func (s *Store) List(apiOp *types.APIRequest, schema *types.APISchema) (types.AP
 
 // Watch creates a watch for the Counts schema. This returns only the counts which have changed since the watch was established
 func (s *Store) Watch(apiOp *types.APIRequest, schema *types.APISchema, w types.WatchRequest) (chan types.APIEvent, error) {
+	selector, err := labelSelector(apiOp)
+	if err != nil {
+		return nil, err
+	}
+
 	var (
 		result      = make(chan Count, 100)
 		counts      map[string]ItemCount
@@ -128,7 +163,8 @@ func (s *Store) Watch(apiOp *types.APIRequest, schema *types.APISchema, w types.
 		countLock.Unlock()
 	}()
 
-	counts = s.getCount(apiOp).Counts
+	// groups are not kept up to date by the watch, so they are never requested
+	counts = s.getCount(apiOp, "", selector).Counts
 	for id := range counts {
 		schema := apiOp.Schemas.LookupSchema(id)
 		if schema == nil {
@@ -161,6 +197,22 @@ func (s *Store) Watch(apiOp *types.APIRequest, schema *types.APISchema, w types.
 			return nil
 		}
 
+		// an object changing its labels enters or leaves the selection, so it is counted as added or removed
+		if oldObj != nil && !matches(selector, oldObj) {
+			oldObj = nil
+			add = true
+		}
+		if !matches(selector, obj) {
+			if oldObj == nil {
+				return nil
+			}
+			obj, oldObj, add = oldObj, nil, false
+			_, namespace, _, summary, ok = getInfo(obj)
+			if !ok {
+				return nil
+			}
+		}
+
 		if oldObj != nil {
 			if _, _, _, oldSummary, ok := getInfo(oldObj); ok {
 				if oldSummary.Transitioning == summary.Transitioning &&
@@ -230,6 +282,73 @@ func (s *Store) schemasToWatch(apiOp *types.APIRequest) (result []*types.APISche
 	return
 }
 
+// groupBy returns the field requested with the groupby query parameter, if any.
+func groupBy(apiOp *types.APIRequest) string {
+	if apiOp.Request == nil || apiOp.Request.URL == nil {
+		return ""
+	}
+	return apiOp.Request.URL.Query().Get(groupByParam)
+}
+
+// labelSelector returns the selector requested with the labelSelector query parameter. Every resource matches the
+// selector when the parameter is not set.
+func labelSelector(apiOp *types.APIRequest) (labels.Selector, error) {
+	if apiOp.Request == nil || apiOp.Request.URL == nil {
+		return labels.Everything(), nil
+	}
+	selector, err := labels.Parse(apiOp.Request.URL.Query().Get(labelSelectorParam))
+	if err != nil {
+		return nil, apierror.NewAPIError(invalidSelector, err.Error())
+	}
+	return selector, nil
+}
+
+// matches returns whether the labels of the object match the selector.
+func matches(selector labels.Selector, obj interface{}) bool {
+	if selector.Empty() {
+		return true
+	}
+	r, ok := obj.(runtime.Object)
+	if !ok {
+		return false
+	}
+	meta, err := meta.Accessor(r)
+	if err != nil {
+		return false
+	}
+	return selector.Matches(labels.Set(meta.GetLabels()))
+}
+
+// groupValue returns the value of the field of the object to group it by. The cluster cache only holds the metadata
+// and summary of objects, so the supported fields are "state", "metadata.namespace", and the labels and annotations
+// of the object, such as "metadata.labels.app".
+func groupValue(obj interface{}, field string) (string, bool) {
+	if field == "state" {
+		_, _, _, summary, ok := getInfo(obj)
+		return summary.State, ok
+	}
+	r, ok := obj.(runtime.Object)
+	if !ok {
+		return "", false
+	}
+	meta, err := meta.Accessor(r)
+	if err != nil {
+		return "", false
+	}
+	if field == "metadata.namespace" {
+		return meta.GetNamespace(), true
+	}
+	if key, ok := strings.CutPrefix(field, "metadata.labels."); ok {
+		value, ok := meta.GetLabels()[key]
+		return value, ok
+	}
+	if key, ok := strings.CutPrefix(field, "metadata.annotations."); ok {
+		value, ok := meta.GetAnnotations()[key]
+		return value, ok
+	}
+	return "", false
+}
+
 func getInfo(obj interface{}) (name string, namespace string, revision int, summaryResult summary.Summary, ok bool) {
 	r, ok := obj.(runtime.Object)
 	if !ok {
@@ -309,7 +428,9 @@ func simpleState(summary summary.Summary) string {
 	return ""
 }
 
-func (s *Store) getCount(apiOp *types.APIRequest) Count {
+// getCount counts the resources the user has access to which match the selector, grouping them by the field if it is
+// set.
+func (s *Store) getCount(apiOp *types.APIRequest, groupBy string, selector labels.Selector) Count {
 	counts := map[string]ItemCount{}
 
 	for _, schema := range s.schemasToWatch(apiOp) {
@@ -333,11 +454,23 @@ func (s *Store) getCount(apiOp *types.APIRequest) Count {
 				continue
 			}
 
+			if !matches(selector, obj) {
+				continue
+			}
+
 			if revision > rev {
 				rev = revision
 			}
 
 			itemCount = addCounts(itemCount, ns, summary)
+			if groupBy != "" {
+				if value, ok := groupValue(obj, groupBy); ok {
+					if itemCount.Groups == nil {
+						itemCount.Groups = map[string]int{}
+					}
+					itemCount.Groups[value]++
+				}
+			}
 		}
 
 		itemCount.Revision = rev
@@ -4,9 +4,11 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"net/url"
 	"testing"
 	"time"
 
+	"github.com/rancher/apiserver/pkg/apierror"
 	"github.com/rancher/apiserver/pkg/server"
 	"github.com/rancher/apiserver/pkg/store/empty"
 	"github.com/rancher/apiserver/pkg/types"
@@ -19,6 +21,7 @@ import (
 	"github.com/rancher/wrangler/pkg/summary"
 	"github.com/stretchr/testify/assert"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	schema2 "k8s.io/apimachinery/pkg/runtime/schema"
 )
 
@@ -171,6 +174,277 @@ func TestWatch(t *testing.T) {
 	}
 }
 
+func TestGroupBy(t *testing.T) {
+	testSchema := makeSchema(testResource)
+	addGenericPermissionsToSchema(testSchema, "list")
+	// only grant access to the resources in ns1 and to a single resource in ns2
+	testSchema.Attributes["access"] = accesscontrol.AccessListByVerb{
+		"list": []accesscontrol.Access{{Namespace: "ns1", ResourceName: "*"}},
+		"get":  []accesscontrol.Access{{Namespace: "ns2", ResourceName: "b"}},
+	}
+	testSchemas := types.EmptyAPISchemas()
+	testSchemas.MustAddSchema(*testSchema)
+
+	fakeCache := NewFakeClusterCache()
+	gvk := attributes.GVK(testSchema)
+	for i, obj := range []struct {
+		name, namespace, app, state string
+	}{
+		{name: "a", namespace: "ns1", app: "web", state: "active"},
+		{name: "b", namespace: "ns1", app: "web", state: "pending"},
+		{name: "c", namespace: "ns1", app: "db", state: "active"},
+		{name: "d", namespace: "ns1", state: "active"},
+		{name: "a", namespace: "ns2", app: "web", state: "active"},
+		{name: "b", namespace: "ns2", app: "db", state: "error"},
+		{name: "c", namespace: "ns3", app: "web", state: "active"},
+	} {
+		summarizedObject := makeSummarizedObject(gvk, obj.name, obj.namespace, fmt.Sprint(i+1))
+		summarizedObject.State = obj.state
+		if obj.app != "" {
+			summarizedObject.Labels = map[string]string{"app": obj.app}
+		}
+		fakeCache.AddSummaryObj(summarizedObject)
+	}
+	counts.Register(testSchemas, fakeCache)
+	countSchema := testSchemas.LookupSchema("count")
+
+	tests := []struct {
+		name    string
+		groupBy string
+		want    map[string]int
+	}{
+		{
+			name: "no grouping",
+		},
+		{
+			name:    "group by label",
+			groupBy: "metadata.labels.app",
+			want:    map[string]int{"web": 2, "db": 2},
+		},
+		{
+			name:    "group by namespace",
+			groupBy: "metadata.namespace",
+			want:    map[string]int{"ns1": 4, "ns2": 1},
+		},
+		{
+			name:    "group by state",
+			groupBy: "state",
+			want:    map[string]int{"active": 3, "pending": 1, "error": 1},
+		},
+		{
+			name:    "group by unsupported field",
+			groupBy: "spec.nodeName",
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "/v1/counts?groupby="+test.groupBy, nil)
+			assert.NoError(t, err)
+			testOp := &types.APIRequest{
+				Schemas:       testSchemas,
+				AccessControl: &server.SchemaBasedAccess{},
+				Request:       req,
+			}
+			obj, err := countSchema.Store.ByID(testOp, countSchema, "count")
+			assert.NoError(t, err)
+			itemCount := obj.Object.(counts.Count).Counts[testResource]
+			assert.Equal(t, 5, itemCount.Summary.Count)
+			assert.Equal(t, test.want, itemCount.Groups)
+		})
+	}
+}
+
+func TestLabelSelector(t *testing.T) {
+	testSchema := makeSchema(testResource)
+	addGenericPermissionsToSchema(testSchema, "list")
+	// only grant access to the resources in ns1 and to a single resource in ns2
+	testSchema.Attributes["access"] = accesscontrol.AccessListByVerb{
+		"list": []accesscontrol.Access{{Namespace: "ns1", ResourceName: "*"}},
+		"get":  []accesscontrol.Access{{Namespace: "ns2", ResourceName: "b"}},
+	}
+	testSchemas := types.EmptyAPISchemas()
+	testSchemas.MustAddSchema(*testSchema)
+
+	fakeCache := NewFakeClusterCache()
+	gvk := attributes.GVK(testSchema)
+	for i, obj := range []struct {
+		name, namespace, app string
+	}{
+		{name: "a", namespace: "ns1", app: "nginx"},
+		{name: "b", namespace: "ns1", app: "nginx"},
+		{name: "c", namespace: "ns1", app: "db"},
+		{name: "d", namespace: "ns1"},
+		{name: "a", namespace: "ns2", app: "nginx"},
+		{name: "b", namespace: "ns2", app: "nginx"},
+		{name: "c", namespace: "ns3", app: "nginx"},
+	} {
+		summarizedObject := makeSummarizedObject(gvk, obj.name, obj.namespace, fmt.Sprint(i+1))
+		if obj.app != "" {
+			summarizedObject.Labels = map[string]string{"app": obj.app}
+		}
+		fakeCache.AddSummaryObj(summarizedObject)
+	}
+	counts.Register(testSchemas, fakeCache)
+	countSchema := testSchemas.LookupSchema("count")
+
+	// filteredList returns the number of resources the user can list which match the selector
+	access := testSchema.Attributes["access"].(accesscontrol.AccessListByVerb)
+	filteredList := func(selector string) int {
+		parsed, err := labels.Parse(selector)
+		assert.NoError(t, err)
+		count := 0
+		for _, obj := range fakeCache.List(gvk) {
+			summarizedObject := obj.(*summary.SummarizedObject)
+			if !access.Grants("list", summarizedObject.Namespace, summarizedObject.Name) && !access.Grants("get", summarizedObject.Namespace, summarizedObject.Name) {
+				continue
+			}
+			if parsed.Matches(labels.Set(summarizedObject.Labels)) {
+				count++
+			}
+		}
+		return count
+	}
+
+	newOp := func(selector string) *types.APIRequest {
+		req, err := http.NewRequest(http.MethodGet, "/v1/counts?labelSelector="+url.QueryEscape(selector), nil)
+		assert.NoError(t, err)
+		return &types.APIRequest{
+			Schemas:       testSchemas,
+			AccessControl: &server.SchemaBasedAccess{},
+			Request:       req,
+		}
+	}
+
+	tests := []struct {
+		name        string
+		selector    string
+		wantCount   int
+		wantNSCount map[string]int
+	}{
+		{
+			name:        "no selector",
+			wantCount:   5,
+			wantNSCount: map[string]int{"ns1": 4, "ns2": 1},
+		},
+		{
+			name:        "equality",
+			selector:    "app=nginx",
+			wantCount:   3,
+			wantNSCount: map[string]int{"ns1": 2, "ns2": 1},
+		},
+		{
+			name:        "set",
+			selector:    "app in (nginx,db)",
+			wantCount:   4,
+			wantNSCount: map[string]int{"ns1": 3, "ns2": 1},
+		},
+		{
+			name:        "missing label",
+			selector:    "!app",
+			wantCount:   1,
+			wantNSCount: map[string]int{"ns1": 1},
+		},
+		{
+			name:        "no match",
+			selector:    "app=redis",
+			wantNSCount: map[string]int{},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			obj, err := countSchema.Store.ByID(newOp(test.selector), countSchema, "count")
+			assert.NoError(t, err)
+			itemCount := obj.Object.(counts.Count).Counts[testResource]
+			assert.Equal(t, test.wantCount, itemCount.Summary.Count)
+			assert.Equal(t, filteredList(test.selector), itemCount.Summary.Count, "expected the count to match the length of the filtered list")
+			nsCounts := map[string]int{}
+			for ns, summary := range itemCount.Namespaces {
+				if summary.Count > 0 {
+					nsCounts[ns] = summary.Count
+				}
+			}
+			assert.Equal(t, test.wantNSCount, nsCounts)
+
+			list, err := countSchema.Store.List(newOp(test.selector), countSchema)
+			assert.NoError(t, err)
+			assert.Len(t, list.Objects, 1)
+			assert.Equal(t, test.wantCount, list.Objects[0].Object.(counts.Count).Counts[testResource].Summary.Count)
+		})
+	}
+
+	t.Run("malformed selector", func(t *testing.T) {
+		_, err := countSchema.Store.ByID(newOp("app in (nginx"), countSchema, "count")
+		var apiErr *apierror.APIError
+		if assert.ErrorAs(t, err, &apiErr) {
+			assert.Equal(t, http.StatusBadRequest, apiErr.Code.Status)
+		}
+		_, err = countSchema.Store.List(newOp("!=nginx"), countSchema)
+		if assert.ErrorAs(t, err, &apiErr) {
+			assert.Equal(t, http.StatusBadRequest, apiErr.Code.Status)
+		}
+		_, err = countSchema.Store.Watch(newOp("=nginx"), countSchema, types.WatchRequest{})
+		if assert.ErrorAs(t, err, &apiErr) {
+			assert.Equal(t, http.StatusBadRequest, apiErr.Code.Status)
+		}
+	})
+}
+
+func TestWatchLabelSelector(t *testing.T) {
+	testSchema := makeSchema(testResource)
+	addGenericPermissionsToSchema(testSchema, "list")
+	testSchemas := types.EmptyAPISchemas()
+	testSchemas.MustAddSchema(*testSchema)
+	fakeCache := NewFakeClusterCache()
+	gvk := attributes.GVK(testSchema)
+	withApp := func(obj *summary.SummarizedObject, app string) *summary.SummarizedObject {
+		obj.Labels = map[string]string{"app": app}
+		return obj
+	}
+	fakeCache.AddSummaryObj(withApp(makeSummarizedObject(gvk, "a", "ns1", "1"), "nginx"))
+	fakeCache.AddSummaryObj(withApp(makeSummarizedObject(gvk, "b", "ns1", "2"), "db"))
+	counts.Register(testSchemas, fakeCache)
+	countSchema := testSchemas.LookupSchema("count")
+
+	req, err := http.NewRequest(http.MethodGet, "/v1/counts?labelSelector=app%3Dnginx", nil)
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	testOp := &types.APIRequest{
+		Schemas:       testSchemas,
+		AccessControl: &server.SchemaBasedAccess{},
+		Request:       req.WithContext(ctx),
+	}
+	resChannel, err := countSchema.Store.Watch(testOp, nil, types.WatchRequest{})
+	assert.NoError(t, err)
+
+	receiveCount := func() int {
+		event, err := receiveWithTimeout(resChannel, 2*time.Second)
+		if !assert.NoError(t, err) {
+			return -1
+		}
+		return event.Object.Object.(counts.Count).Counts[testResource].Summary.Count
+	}
+
+	// an object which does not match is not counted
+	assert.NoError(t, fakeCache.addHandler(gvk, "n/a", withApp(makeSummarizedObject(gvk, "c", "ns1", "3"), "db")))
+	_, err = receiveWithTimeout(resChannel, 100*time.Millisecond)
+	assert.Error(t, err, "expected no counts for an object which does not match the selector")
+
+	// an object which matches is counted
+	assert.NoError(t, fakeCache.addHandler(gvk, "n/a", withApp(makeSummarizedObject(gvk, "d", "ns1", "4"), "nginx")))
+	assert.Equal(t, 2, receiveCount())
+
+	// an object which gets the label is counted
+	assert.NoError(t, fakeCache.changeHandler(gvk, "n/a", withApp(makeSummarizedObject(gvk, "b", "ns1", "5"), "nginx"), withApp(makeSummarizedObject(gvk, "b", "ns1", "2"), "db")))
+	assert.Equal(t, 3, receiveCount())
+
+	// an object which loses the label is not counted anymore
+	assert.NoError(t, fakeCache.changeHandler(gvk, "n/a", withApp(makeSummarizedObject(gvk, "a", "ns1", "6"), "db"), withApp(makeSummarizedObject(gvk, "a", "ns1", "1"), "nginx")))
+	assert.Equal(t, 2, receiveCount())
+}
+
 // receiveWithTimeout tries to get a value from input within duration. Returns an error if no input was received during that period
 func receiveWithTimeout(input chan types.APIEvent, duration time.Duration) (*types.APIEvent, error) {
 	select {
@@ -277,6 +551,14 @@ func (f *fakeClusterCache) OnSchemas(schemas *schema.Collection) error {
 	return nil
 }
 
+func (f *fakeClusterCache) Pause(gvr schema2.GroupVersionResource) error {
+	return nil
+}
+
+func (f *fakeClusterCache) Resume(gvr schema2.GroupVersionResource) error {
+	return nil
+}
+
 func (f *fakeClusterCache) AddSummaryObj(summaryObj *summary.SummarizedObject) {
 	f.summarizedObjects = append(f.summarizedObjects, summaryObj)
 }
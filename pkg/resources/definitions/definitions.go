@@ -0,0 +1,156 @@
+// Package definitions implements a schema for getting the field definitions of a resource, to generate clients or
+// forms from.
+package definitions
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/rancher/apiserver/pkg/apierror"
+	"github.com/rancher/apiserver/pkg/types"
+	"github.com/rancher/steve/pkg/attributes"
+	"github.com/rancher/wrangler/pkg/schemas"
+	"github.com/rancher/wrangler/pkg/schemas/validation"
+)
+
+// SchemaDefinition is the definition of the type of a resource and of the types of its nested objects, by type.
+type SchemaDefinition struct {
+	ID             string                `json:"id,omitempty"`
+	DefinitionType string                `json:"definitionType"`
+	Definitions    map[string]Definition `json:"definitions"`
+}
+
+// Definition is the definition of an object type.
+type Definition struct {
+	Type           string           `json:"type"`
+	Description    string           `json:"description,omitempty"`
+	ResourceFields map[string]Field `json:"resourceFields"`
+}
+
+// Field is the definition of a field of an object type. The type of an array or a map is array or map, and the type
+// of its values is the subtype. Fields whose type or subtype is an object type have its definition in the same
+// SchemaDefinition.
+type Field struct {
+	Type        string      `json:"type"`
+	SubType     string      `json:"subtype,omitempty"`
+	Description string      `json:"description,omitempty"`
+	Required    bool        `json:"required,omitempty"`
+	Default     interface{} `json:"default,omitempty"`
+}
+
+// Register registers the schemaDefinition schema. The definition of a resource is got by the ID of its schema, from
+// /v1/schemaDefinitions/{id}, for the resources the user can access.
+func Register(schemas *types.APISchemas) {
+	schemas.MustImportAndCustomize(SchemaDefinition{}, func(schema *types.APISchema) {
+		schema.CollectionMethods = []string{}
+		schema.ResourceMethods = []string{http.MethodGet}
+		schema.ByIDHandler = byID
+	})
+}
+
+func byID(apiOp *types.APIRequest) (types.APIObject, error) {
+	// the schemas of the request only have the resources the user can access
+	schema := apiOp.Schemas.LookupSchema(apiOp.Name)
+	if schema == nil || attributes.GVK(schema).Kind == "" {
+		return types.APIObject{}, apierror.NewAPIError(validation.NotFound, fmt.Sprintf("schema %s not found", apiOp.Name))
+	}
+
+	definition := Build(apiOp.Schemas, schema)
+	definition.ID = apiOp.Name
+	// the definitions change with the definitions of the resources, so they are tagged by their content
+	if notModified(apiOp, definition) {
+		return types.APIObject{}, validation.ErrComplete
+	}
+	return types.APIObject{
+		Type:   "schemaDefinition",
+		ID:     apiOp.Name,
+		Object: definition,
+	}, nil
+}
+
+// Build returns the definition of the schema, with the definitions of the object types of its fields from the schemas.
+func Build(apiSchemas *types.APISchemas, schema *types.APISchema) SchemaDefinition {
+	result := SchemaDefinition{
+		DefinitionType: schema.ID,
+		Definitions:    map[string]Definition{},
+	}
+	addDefinition(apiSchemas, schema.Schema, result.Definitions)
+	return result
+}
+
+// addDefinition adds the definition of the schema and of the object types of its fields to the definitions.
+func addDefinition(apiSchemas *types.APISchemas, schema *schemas.Schema, definitions map[string]Definition) {
+	if _, ok := definitions[schema.ID]; ok {
+		return
+	}
+	definition := Definition{
+		Type:           schema.ID,
+		Description:    schema.Description,
+		ResourceFields: make(map[string]Field, len(schema.ResourceFields)),
+	}
+	definitions[schema.ID] = definition
+
+	names := make([]string, 0, len(schema.ResourceFields))
+	for name := range schema.ResourceFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		field := schema.ResourceFields[name]
+		fieldType, subType := splitType(field.Type)
+		definition.ResourceFields[name] = Field{
+			Type:        fieldType,
+			SubType:     subType,
+			Description: field.Description,
+			Required:    field.Required,
+			Default:     field.Default,
+		}
+		objectType := fieldType
+		if subType != "" {
+			objectType = subType
+		}
+		if nested := apiSchemas.Schemas[objectType]; nested != nil && nested.ResourceFields != nil {
+			addDefinition(apiSchemas, nested.Schema, definitions)
+		}
+	}
+}
+
+// splitType splits the types of arrays and maps, such as array[string], into array or map and the type of their
+// values. Other types have no subtype.
+func splitType(fieldType string) (string, string) {
+	for _, prefix := range []string{"array", "map"} {
+		if strings.HasPrefix(fieldType, prefix+"[") && strings.HasSuffix(fieldType, "]") {
+			return prefix, fieldType[len(prefix)+1 : len(fieldType)-1]
+		}
+	}
+	return fieldType, ""
+}
+
+// notModified sets the ETag header of the response to the tag of the definition, and writes a 304 Not Modified
+// response if the If-None-Match header of the request has the same tag, in which case it returns true.
+func notModified(apiOp *types.APIRequest, definition SchemaDefinition) bool {
+	if apiOp.Response == nil {
+		return false
+	}
+	data, err := json.Marshal(definition)
+	if err != nil {
+		return false
+	}
+	hash := sha256.Sum256(data)
+	tag := `"` + base64.RawURLEncoding.EncodeToString(hash[:]) + `"`
+	// the definitions depend on the access of the user, so they are only cached by the user's client
+	apiOp.Response.Header().Set("Cache-Control", "private, no-cache")
+	apiOp.Response.Header().Set("ETag", tag)
+	for _, t := range strings.Split(apiOp.Request.Header.Get("If-None-Match"), ",") {
+		if t = strings.TrimPrefix(strings.TrimSpace(t), "W/"); t == tag || t == "*" {
+			apiOp.Response.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
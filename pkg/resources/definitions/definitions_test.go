@@ -0,0 +1,189 @@
+package definitions_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/rancher/apiserver/pkg/apierror"
+	"github.com/rancher/apiserver/pkg/types"
+	"github.com/rancher/steve/pkg/attributes"
+	"github.com/rancher/steve/pkg/resources/definitions"
+	"github.com/rancher/steve/pkg/schema/converter"
+	"github.com/rancher/wrangler/pkg/generic/fake"
+	"github.com/rancher/wrangler/pkg/schemas"
+	"github.com/rancher/wrangler/pkg/schemas/validation"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestSchemaDefinition(t *testing.T) {
+	testSchemas := newCRDSchemas(t)
+	definitions.Register(testSchemas)
+
+	rec := httptest.NewRecorder()
+	apiOp := newRequest(testSchemas, "example.com.v1.widget", rec)
+	obj, err := testSchemas.LookupSchema("schemaDefinition").ByIDHandler(apiOp)
+	require.NoError(t, err)
+
+	result := obj.Object.(definitions.SchemaDefinition)
+	assert.Equal(t, "example.com.v1.widget", result.DefinitionType)
+	assert.Equal(t, definitions.Definition{
+		Type:        "example.com.v1.widget.spec",
+		Description: "the desired state of the widget",
+		ResourceFields: map[string]definitions.Field{
+			"replicas": {Type: "integer", Description: "the number of replicas", Default: float64(1)},
+			"image":    {Type: "string", Description: "the image of the widget", Required: true},
+			"ports":    {Type: "array", SubType: "example.com.v1.widget.spec.ports"},
+			"labels":   {Type: "map", SubType: "string"},
+		},
+	}, result.Definitions["example.com.v1.widget.spec"])
+	assert.Equal(t, definitions.Definition{
+		Type: "example.com.v1.widget.spec.ports",
+		ResourceFields: map[string]definitions.Field{
+			"port": {Type: "integer", Required: true},
+			"name": {Type: "string"},
+		},
+	}, result.Definitions["example.com.v1.widget.spec.ports"])
+	spec := result.Definitions["example.com.v1.widget"].ResourceFields["spec"]
+	assert.Equal(t, "example.com.v1.widget.spec", spec.Type)
+	assert.True(t, spec.Required)
+	assert.Contains(t, result.Definitions["example.com.v1.widget"].ResourceFields, "metadata")
+	assert.NotContains(t, result.Definitions, "example.com.v1beta1.widget.spec", "expected only the definitions of the requested version")
+	assert.NotEmpty(t, rec.Header().Get("ETag"))
+}
+
+func TestSchemaDefinitionNotModified(t *testing.T) {
+	testSchemas := newCRDSchemas(t)
+	definitions.Register(testSchemas)
+	handler := testSchemas.LookupSchema("schemaDefinition").ByIDHandler
+
+	rec := httptest.NewRecorder()
+	_, err := handler(newRequest(testSchemas, "example.com.v1.widget", rec))
+	require.NoError(t, err)
+	tag := rec.Header().Get("ETag")
+
+	rec = httptest.NewRecorder()
+	apiOp := newRequest(testSchemas, "example.com.v1.widget", rec)
+	apiOp.Request.Header.Set("If-None-Match", tag)
+	_, err = handler(apiOp)
+	assert.Equal(t, validation.ErrComplete, err)
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+
+	rec = httptest.NewRecorder()
+	apiOp = newRequest(testSchemas, "example.com.v1beta1.widget", rec)
+	apiOp.Request.Header.Set("If-None-Match", tag)
+	_, err = handler(apiOp)
+	assert.NoError(t, err, "expected the definitions of another resource to have another tag")
+}
+
+func TestSchemaDefinitionNotFound(t *testing.T) {
+	testSchemas := newCRDSchemas(t)
+	definitions.Register(testSchemas)
+
+	// the schemas of a request only have the resources the user can access, and the object types of their fields
+	for _, id := range []string{"example.com.v1.gadget", "example.com.v1.widget.spec"} {
+		_, err := testSchemas.LookupSchema("schemaDefinition").ByIDHandler(newRequest(testSchemas, id, httptest.NewRecorder()))
+		var apiErr *apierror.APIError
+		require.ErrorAs(t, err, &apiErr, id)
+		assert.Equal(t, validation.NotFound, apiErr.Code, id)
+	}
+}
+
+func newRequest(testSchemas *types.APISchemas, id string, rec *httptest.ResponseRecorder) *types.APIRequest {
+	req := httptest.NewRequest(http.MethodGet, "/v1/schemaDefinitions/"+id, nil)
+	return &types.APIRequest{
+		Schemas:  testSchemas,
+		Name:     id,
+		Request:  req,
+		Response: rec,
+	}
+}
+
+// newCRDSchemas returns the schemas of the versions of a custom resource definition, as the schema collection has
+// them.
+func newCRDSchemas(t *testing.T) *types.APISchemas {
+	spec := func(properties map[string]v1.JSONSchemaProps, required ...string) *v1.CustomResourceValidation {
+		return &v1.CustomResourceValidation{
+			OpenAPIV3Schema: &v1.JSONSchemaProps{
+				Required: []string{"spec"},
+				Properties: map[string]v1.JSONSchemaProps{
+					"spec": {
+						Type:        "object",
+						Description: "the desired state of the widget",
+						Required:    required,
+						Properties:  properties,
+					},
+				},
+			},
+		}
+	}
+	crd := v1.CustomResourceDefinition{
+		Spec: v1.CustomResourceDefinitionSpec{
+			Group: "example.com",
+			Versions: []v1.CustomResourceDefinitionVersion{
+				{
+					Name:    "v1",
+					Served:  true,
+					Storage: true,
+					Schema: spec(map[string]v1.JSONSchemaProps{
+						"replicas": {Type: "integer", Description: "the number of replicas", Default: &v1.JSON{Raw: []byte("1")}},
+						"image":    {Type: "string", Description: "the image of the widget"},
+						"ports": {Type: "array", Items: &v1.JSONSchemaPropsOrArray{Schema: &v1.JSONSchemaProps{
+							Type:     "object",
+							Required: []string{"port"},
+							Properties: map[string]v1.JSONSchemaProps{
+								"port": {Type: "integer"},
+								"name": {Type: "string"},
+							},
+						}}},
+						"labels": {Type: "object", AdditionalProperties: &v1.JSONSchemaPropsOrBool{Schema: &v1.JSONSchemaProps{Type: "string"}}},
+					}, "image"),
+				},
+				{
+					Name:   "v1beta1",
+					Served: true,
+					Schema: spec(map[string]v1.JSONSchemaProps{
+						"size": {Type: "integer"},
+					}),
+				},
+			},
+		},
+		Status: v1.CustomResourceDefinitionStatus{
+			AcceptedNames: v1.CustomResourceDefinitionNames{
+				Kind:   "Widget",
+				Plural: "widgets",
+			},
+		},
+	}
+	ctrl := gomock.NewController(t)
+	crdClient := fake.NewMockNonNamespacedClientInterface[*v1.CustomResourceDefinition, *v1.CustomResourceDefinitionList](ctrl)
+	crdClient.EXPECT().List(gomock.Any()).Return(&v1.CustomResourceDefinitionList{Items: []v1.CustomResourceDefinition{crd}}, nil)
+
+	schemasMap := map[string]*types.APISchema{
+		"io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta": {Schema: &schemas.Schema{
+			ID: "io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta",
+			ResourceFields: map[string]schemas.Field{
+				"name": {Type: "string"},
+			},
+		}},
+	}
+	for _, version := range []string{"v1", "v1beta1"} {
+		gvk := schema.GroupVersionKind{Group: "example.com", Version: version, Kind: "Widget"}
+		s := &types.APISchema{Schema: &schemas.Schema{ID: converter.GVKToVersionedSchemaID(gvk)}}
+		attributes.SetGVK(s, gvk)
+		attributes.SetAPIResource(s, metav1.APIResource{Name: "widgets", Namespaced: true, Verbs: []string{"get", "list"}})
+		schemasMap[s.ID] = s
+	}
+	require.NoError(t, converter.AddCustomResources(crdClient, schemasMap))
+
+	result := types.EmptyAPISchemas()
+	for _, s := range schemasMap {
+		result.MustAddSchema(*s)
+	}
+	return result
+}
@@ -0,0 +1,68 @@
+package formatters
+
+import (
+	"github.com/rancher/apiserver/pkg/types"
+	"github.com/rancher/norman/types/convert"
+	"github.com/rancher/wrangler/pkg/data"
+)
+
+const (
+	stateActive     = "active"
+	stateInProgress = "in-progress"
+	stateError      = "error"
+	stateRemoving   = "removing"
+)
+
+// KStatus sets metadata.state from the standard status.conditions of the resource, following the kstatus conventions:
+//   - a resource being deleted is removing
+//   - a resource whose latest generation was not observed by its controller yet is in progress
+//   - a resource with a true Stalled condition is in error
+//   - a resource with a true Reconciling condition, or a Ready condition which is not true, is in progress
+//   - any other resource is active
+//
+// Resources without status.conditions are left as they are.
+func KStatus(request *types.APIRequest, resource *types.RawResource) {
+	obj := resource.APIObject.Data()
+	if _, ok := obj.Map("status")["conditions"].([]interface{}); !ok {
+		return
+	}
+
+	name, message := kstatus(obj)
+	obj.SetNested(map[string]interface{}{
+		"name":          name,
+		"error":         name == stateError,
+		"transitioning": name == stateInProgress || name == stateRemoving,
+		"message":       message,
+	}, "metadata", "state")
+}
+
+func kstatus(obj data.Object) (string, string) {
+	if obj.String("metadata", "deletionTimestamp") != "" {
+		return stateRemoving, "Resource is being deleted"
+	}
+
+	if observed, ok := obj.Map("status")["observedGeneration"]; ok {
+		generation, _ := convert.ToNumber(obj.Map("metadata")["generation"])
+		if observed, _ := convert.ToNumber(observed); observed < generation {
+			return stateInProgress, "Waiting for the controller to observe the latest generation"
+		}
+	}
+
+	conditions := map[string]data.Object{}
+	for _, condition := range obj.Slice("status", "conditions") {
+		conditions[condition.String("type")] = condition
+	}
+	if stalled, ok := conditions["Stalled"]; ok && stalled.String("status") == "True" {
+		return stateError, stalled.String("message")
+	}
+	if reconciling, ok := conditions["Reconciling"]; ok && reconciling.String("status") == "True" {
+		return stateInProgress, reconciling.String("message")
+	}
+	if ready, ok := conditions["Ready"]; ok {
+		if ready.String("status") != "True" {
+			return stateInProgress, ready.String("message")
+		}
+		return stateActive, ready.String("message")
+	}
+	return stateActive, ""
+}
@@ -0,0 +1,118 @@
+package formatters
+
+import (
+	"testing"
+
+	"github.com/rancher/apiserver/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestKStatus(t *testing.T) {
+	condition := func(conditionType, status, message string) interface{} {
+		return map[string]interface{}{"type": conditionType, "status": status, "message": message}
+	}
+	tests := []struct {
+		name      string
+		metadata  map[string]interface{}
+		status    map[string]interface{}
+		wantState map[string]interface{}
+	}{
+		{
+			name: "ready",
+			status: map[string]interface{}{
+				"conditions": []interface{}{condition("Ready", "True", "all good")},
+			},
+			wantState: map[string]interface{}{"name": "active", "error": false, "transitioning": false, "message": "all good"},
+		},
+		{
+			name: "no conditions of the convention",
+			status: map[string]interface{}{
+				"conditions": []interface{}{condition("Synced", "True", "")},
+			},
+			wantState: map[string]interface{}{"name": "active", "error": false, "transitioning": false, "message": ""},
+		},
+		{
+			name: "not ready",
+			status: map[string]interface{}{
+				"conditions": []interface{}{condition("Ready", "False", "waiting for pods")},
+			},
+			wantState: map[string]interface{}{"name": "in-progress", "error": false, "transitioning": true, "message": "waiting for pods"},
+		},
+		{
+			name: "reconciling",
+			status: map[string]interface{}{
+				"conditions": []interface{}{
+					condition("Ready", "True", ""),
+					condition("Reconciling", "True", "scaling up"),
+				},
+			},
+			wantState: map[string]interface{}{"name": "in-progress", "error": false, "transitioning": true, "message": "scaling up"},
+		},
+		{
+			name: "stalled",
+			status: map[string]interface{}{
+				"conditions": []interface{}{
+					condition("Reconciling", "True", "scaling up"),
+					condition("Stalled", "True", "quota exceeded"),
+				},
+			},
+			wantState: map[string]interface{}{"name": "error", "error": true, "transitioning": false, "message": "quota exceeded"},
+		},
+		{
+			name:     "generation not observed",
+			metadata: map[string]interface{}{"generation": int64(3)},
+			status: map[string]interface{}{
+				"observedGeneration": int64(2),
+				"conditions":         []interface{}{condition("Ready", "True", "")},
+			},
+			wantState: map[string]interface{}{"name": "in-progress", "error": false, "transitioning": true, "message": "Waiting for the controller to observe the latest generation"},
+		},
+		{
+			name:     "generation observed",
+			metadata: map[string]interface{}{"generation": int64(3)},
+			status: map[string]interface{}{
+				"observedGeneration": int64(3),
+				"conditions":         []interface{}{condition("Ready", "True", "")},
+			},
+			wantState: map[string]interface{}{"name": "active", "error": false, "transitioning": false, "message": ""},
+		},
+		{
+			name:     "deleting",
+			metadata: map[string]interface{}{"deletionTimestamp": "2023-01-01T00:00:00Z"},
+			status: map[string]interface{}{
+				"conditions": []interface{}{condition("Ready", "True", "")},
+			},
+			wantState: map[string]interface{}{"name": "removing", "error": false, "transitioning": true, "message": "Resource is being deleted"},
+		},
+		{
+			name:   "without conditions",
+			status: map[string]interface{}{"phase": "Running"},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			metadata := map[string]interface{}{
+				"name": "test",
+				"state": map[string]interface{}{
+					"name": "unchanged",
+				},
+			}
+			for k, v := range test.metadata {
+				metadata[k] = v
+			}
+			obj := &unstructured.Unstructured{Object: map[string]interface{}{
+				"metadata": metadata,
+				"status":   test.status,
+			}}
+			KStatus(&types.APIRequest{}, &types.RawResource{APIObject: types.APIObject{Object: obj}})
+
+			want := test.wantState
+			if want == nil {
+				want = map[string]interface{}{"name": "unchanged"}
+			}
+			assert.Equal(t, want, obj.Object["metadata"].(map[string]interface{})["state"])
+		})
+	}
+}
@@ -10,13 +10,16 @@ import (
 	"github.com/rancher/steve/pkg/client"
 	"github.com/rancher/steve/pkg/clustercache"
 	"github.com/rancher/steve/pkg/resources/apigroups"
+	"github.com/rancher/steve/pkg/resources/bulkget"
 	"github.com/rancher/steve/pkg/resources/cluster"
 	"github.com/rancher/steve/pkg/resources/common"
 	"github.com/rancher/steve/pkg/resources/counts"
+	"github.com/rancher/steve/pkg/resources/definitions"
 	"github.com/rancher/steve/pkg/resources/formatters"
 	"github.com/rancher/steve/pkg/resources/userpreferences"
 	"github.com/rancher/steve/pkg/schema"
 	steveschema "github.com/rancher/steve/pkg/schema"
+	"github.com/rancher/steve/pkg/stores/partition/listprocessor"
 	"github.com/rancher/steve/pkg/stores/proxy"
 	"github.com/rancher/steve/pkg/summarycache"
 	corecontrollers "github.com/rancher/wrangler/pkg/generated/controllers/core/v1"
@@ -40,6 +43,8 @@ func DefaultSchemas(ctx context.Context, baseSchema *types.APISchemas, ccache cl
 	apiroot.Register(baseSchema, []string{"v1"}, "proxy:/apis")
 	cluster.Register(ctx, baseSchema, cg, schemaFactory)
 	userpreferences.Register(baseSchema)
+	bulkget.Register(baseSchema)
+	definitions.Register(baseSchema)
 	return nil
 }
 
@@ -59,10 +64,18 @@ func DefaultSchemaTemplates(cf *client.Factory,
 		{
 			ID:        "secret",
 			Formatter: formatters.DropHelmData,
+			Sensitive: true,
+		},
+		{
+			ID:        "serviceaccount",
+			Sensitive: true,
 		},
 		{
 			ID:        "pod",
 			Formatter: formatters.Pod,
+			Joins: []listprocessor.Join{
+				{Name: "node", Field: "spec.nodeName", Schema: "node"},
+			},
 		},
 		{
 			ID: "management.cattle.io.cluster",
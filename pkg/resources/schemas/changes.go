@@ -0,0 +1,168 @@
+package schemas
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/rancher/apiserver/pkg/store/empty"
+	"github.com/rancher/apiserver/pkg/types"
+	"github.com/rancher/steve/pkg/schema"
+	"github.com/rancher/wrangler/pkg/schemas/validation"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/endpoints/request"
+)
+
+// SchemaChange is the object of the events of the schemaChange subscription. It names the schema that changed, which
+// is empty when any schema may have changed, and how it changed, so that a client can fetch its schemas again.
+type SchemaChange struct {
+	SchemaID   string `json:"schemaId,omitempty"`
+	ChangeType string `json:"changeType"`
+}
+
+// SchemaEventSource sends the changes to the schemas of a collection, such as schema.Collection.
+type SchemaEventSource interface {
+	OnSchemaEvent(ctx context.Context, cb func(schema.SchemaEvent))
+}
+
+// SetupChangeWatcher registers the schemaChange schema, whose watch sends an event for each change to the schemas the
+// user can see, such as when a CRD is installed or removed.
+func SetupChangeWatcher(schemas *types.APISchemas, factory schema.Factory, source SchemaEventSource) {
+	schemas.MustImportAndCustomize(SchemaChange{}, func(schema *types.APISchema) {
+		schema.CollectionMethods = []string{http.MethodGet}
+		schema.ResourceMethods = []string{}
+		schema.Store = &ChangeStore{
+			sf:     factory,
+			source: source,
+		}
+	})
+}
+
+// ChangeStore streams the changes to the schemas of a user.
+type ChangeStore struct {
+	empty.Store
+
+	sf     schema.Factory
+	source SchemaEventSource
+}
+
+// List returns no changes, since they are only streamed by Watch.
+func (s *ChangeStore) List(_ *types.APIRequest, _ *types.APISchema) (types.APIObjectList, error) {
+	return types.APIObjectList{}, nil
+}
+
+// Watch returns a channel of the changes to the schemas of the user making the request, until the request is done.
+// A schema is reported as created when the user starts seeing it and as removed when the user stops seeing it, so
+// schemas the user has no access to are never reported. If the client falls behind, the watch is stopped.
+func (s *ChangeStore) Watch(apiOp *types.APIRequest, _ *types.APISchema, _ types.WatchRequest) (chan types.APIEvent, error) {
+	user, ok := request.UserFrom(apiOp.Context())
+	if !ok {
+		return nil, validation.Unauthorized
+	}
+
+	schemas, err := s.sf.Schemas(user)
+	if err != nil {
+		return nil, err
+	}
+	visible := schemaIDs(schemas)
+
+	ctx, cancel := context.WithCancel(apiOp.Context())
+	// the callback may still be called after ctx is done, so the channel is never closed
+	events := make(chan schema.SchemaEvent, 100)
+	s.source.OnSchemaEvent(ctx, func(event schema.SchemaEvent) {
+		select {
+		case events <- event:
+		default:
+			logrus.Debugf("schema change watch of %s fell behind, stopping it", user.GetName())
+			cancel()
+		}
+	})
+
+	result := make(chan types.APIEvent)
+	go func() {
+		defer close(result)
+		defer cancel()
+
+		for {
+			var event schema.SchemaEvent
+			select {
+			case <-ctx.Done():
+				return
+			case event = <-events:
+			}
+
+			var change SchemaChange
+			change, visible, ok = s.userChange(user, event, visible)
+			if !ok {
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case result <- toAPIEvent(change):
+			}
+		}
+	}()
+
+	return result, nil
+}
+
+// userChange returns the change the event makes to the schemas the user can see, and the IDs of the schemas the user
+// can see afterwards. It returns false if the user sees no change. Only the visibility of the event's schema is
+// updated, since the events of a batch are sent one by one while the user's schemas already reflect the whole batch:
+// the schemas of the events that follow must still be compared with what the user saw before them.
+func (s *ChangeStore) userChange(user user.Info, event schema.SchemaEvent, visible map[string]bool) (SchemaChange, map[string]bool, bool) {
+	schemas, err := s.sf.Schemas(user)
+	if err != nil {
+		logrus.Errorf("failed to get schemas for %v: %v", user, err)
+		return SchemaChange{}, visible, false
+	}
+
+	if event.Type == schema.SchemaEventRefreshed {
+		return SchemaChange{ChangeType: string(event.Type)}, schemaIDs(schemas), true
+	}
+
+	wasVisible := visible[event.ID]
+	isVisible := schemas.Schemas[event.ID] != nil
+	if isVisible {
+		visible[event.ID] = true
+	} else {
+		delete(visible, event.ID)
+	}
+
+	var changeType schema.SchemaEventType
+	switch {
+	case !wasVisible && !isVisible:
+		return SchemaChange{}, visible, false
+	case !wasVisible:
+		changeType = schema.SchemaEventCreated
+	case !isVisible:
+		changeType = schema.SchemaEventRemoved
+	default:
+		changeType = schema.SchemaEventUpdated
+	}
+	return SchemaChange{
+		SchemaID:   event.ID,
+		ChangeType: string(changeType),
+	}, visible, true
+}
+
+func schemaIDs(schemas *types.APISchemas) map[string]bool {
+	result := make(map[string]bool, len(schemas.Schemas))
+	for id := range schemas.Schemas {
+		result[id] = true
+	}
+	return result
+}
+
+func toAPIEvent(change SchemaChange) types.APIEvent {
+	return types.APIEvent{
+		Name:         types.ChangeAPIEvent,
+		ResourceType: "schemaChange",
+		Object: types.APIObject{
+			Type:   "schemaChange",
+			ID:     change.SchemaID,
+			Object: change,
+		},
+	}
+}
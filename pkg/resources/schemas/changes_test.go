@@ -0,0 +1,123 @@
+package schemas_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/rancher/apiserver/pkg/types"
+	"github.com/rancher/steve/pkg/accesscontrol"
+	acfake "github.com/rancher/steve/pkg/accesscontrol/fake"
+	"github.com/rancher/steve/pkg/resources/schemas"
+	"github.com/rancher/steve/pkg/schema"
+	v1schema "github.com/rancher/wrangler/pkg/schemas"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	k8sschema "k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/endpoints/request"
+)
+
+func crdSchema(resource string) *types.APISchema {
+	return &types.APISchema{
+		Schema: &v1schema.Schema{
+			ID:                "test.cattle.io." + resource,
+			PluralName:        resource + "s",
+			CollectionMethods: []string{},
+			ResourceMethods:   []string{},
+			Attributes: map[string]interface{}{
+				"group":      "test.cattle.io",
+				"version":    "v1",
+				"kind":       resource,
+				"resource":   resource + "s",
+				"namespaced": true,
+				"verbs":      []string{"get", "list", "watch"},
+			},
+		},
+	}
+}
+
+func Test_WatchSchemaChanges(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	asl := acfake.NewMockAccessSetLookup(ctrl)
+	userInfo := &user.DefaultInfo{Name: "test", UID: "test"}
+
+	// the user can see widgets, but not gadgets
+	accessSet := &accesscontrol.AccessSet{ID: "widgets"}
+	for _, verb := range []string{"get", "list", "watch"} {
+		accessSet.Add(verb, k8sschema.GroupResource{Group: "test.cattle.io", Resource: "widgets"}, accesscontrol.Access{
+			Namespace:    accesscontrol.All,
+			ResourceName: accesscontrol.All,
+		})
+	}
+	asl.EXPECT().AccessFor(gomock.Any()).Return(accessSet).AnyTimes()
+	asl.EXPECT().PurgeUserData(gomock.Any()).AnyTimes()
+	asl.EXPECT().PurgeUserDataBulk(gomock.Any()).AnyTimes()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	collection := schema.NewCollection(ctx, types.EmptyAPISchemas(), asl)
+	require.NoError(t, collection.Reset(map[string]*types.APISchema{}))
+
+	baseSchemas := types.EmptyAPISchemas()
+	schemas.SetupChangeWatcher(baseSchemas, collection, collection)
+	changeSchema := baseSchemas.LookupSchema("schemaChange")
+	require.NotNil(t, changeSchema)
+
+	req := httptest.NewRequest("GET", "/", nil).WithContext(request.WithUser(ctx, userInfo))
+	apiOp := &types.APIRequest{Request: req}
+	events, err := changeSchema.Store.Watch(apiOp, changeSchema, types.WatchRequest{})
+	require.NoError(t, err)
+
+	next := func() schemas.SchemaChange {
+		t.Helper()
+		select {
+		case event := <-events:
+			assert.Equal(t, "schemaChange", event.ResourceType)
+			return event.Object.Object.(schemas.SchemaChange)
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for a schema change")
+			return schemas.SchemaChange{}
+		}
+	}
+
+	// installing the CRDs is only reported for the one the user can see
+	widgets, gadgets := crdSchema("widget"), crdSchema("gadget")
+	require.NoError(t, collection.Reset(map[string]*types.APISchema{
+		widgets.ID: widgets,
+		gadgets.ID: gadgets,
+	}))
+	assert.Equal(t, schemas.SchemaChange{SchemaID: widgets.ID, ChangeType: "created"}, next())
+
+	// changing a CRD is reported as an update
+	updated := crdSchema("widget")
+	updated.Description = "widgets"
+	require.NoError(t, collection.Reset(map[string]*types.APISchema{
+		updated.ID: updated,
+		gadgets.ID: gadgets,
+	}))
+	assert.Equal(t, schemas.SchemaChange{SchemaID: widgets.ID, ChangeType: "updated"}, next())
+
+	// resetting the same schemas is not a change
+	require.NoError(t, collection.Reset(map[string]*types.APISchema{
+		crdSchema("widget").ID: updated,
+		gadgets.ID:             gadgets,
+	}))
+
+	// removing the CRDs is only reported for the one the user could see
+	require.NoError(t, collection.Reset(map[string]*types.APISchema{}))
+	assert.Equal(t, schemas.SchemaChange{SchemaID: widgets.ID, ChangeType: "removed"}, next())
+
+	collection.Refresh()
+	assert.Equal(t, schemas.SchemaChange{ChangeType: "refreshed"}, next())
+
+	cancel()
+	select {
+	case _, ok := <-events:
+		assert.False(t, ok, "expected the watch to end with the request")
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the watch to end")
+	}
+}
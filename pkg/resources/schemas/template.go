@@ -4,6 +4,7 @@ package schemas
 import (
 	"context"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
@@ -45,6 +46,23 @@ type Store struct {
 	schemaChangeNotify func(context.Context) (chan interface{}, error)
 }
 
+// List returns the schemas of the request sorted by descending priority, then by ID, so the output is stable.
+func (s *Store) List(apiOp *types.APIRequest, apiSchema *types.APISchema) (types.APIObjectList, error) {
+	list, err := s.Store.List(apiOp, apiSchema)
+	if err != nil {
+		return list, err
+	}
+	sort.SliceStable(list.Objects, func(i, j int) bool {
+		left, lOk := list.Objects[i].Object.(*types.APISchema)
+		right, rOk := list.Objects[j].Object.(*types.APISchema)
+		if !lOk || !rOk {
+			return list.Objects[i].ID < list.Objects[j].ID
+		}
+		return schema.SchemaLess(left, right)
+	})
+	return list, nil
+}
+
 // Watch will return a APIevent channel that tracks changes to schemas for a user in a given APIRequest.
 // Changes will be returned until Done is closed on the context in the given APIRequest.
 func (s *Store) Watch(apiOp *types.APIRequest, _ *types.APISchema, _ types.WatchRequest) (chan types.APIEvent, error) {
@@ -456,6 +456,43 @@ func Test_AccessSetAndChangeSignal(t *testing.T) {
 
 }
 
+func Test_ListSorted(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	asl := acfake.NewMockAccessSetLookup(ctrl)
+	factory := schemafake.NewMockFactory(ctrl)
+	factory.EXPECT().OnChange(gomock.Any(), gomock.Any())
+
+	userSchemas := types.EmptyAPISchemas()
+	for _, id := range []string{"secret", "configmap", "pod", "node", "service"} {
+		userSchemas.MustAddSchema(types.APISchema{
+			Schema: &v1schema.Schema{
+				ID:                id,
+				PluralName:        id + "s",
+				CollectionMethods: []string{"GET"},
+				ResourceMethods:   []string{"GET"},
+				Attributes:        map[string]interface{}{},
+			},
+		})
+	}
+	attributes.SetPriority(userSchemas.LookupSchema("pod"), 10)
+	attributes.SetPriority(userSchemas.LookupSchema("service"), 5)
+
+	watcherSchema := types.EmptyAPISchemas()
+	schemas.SetupWatcher(context.Background(), watcherSchema, asl, factory)
+	schema := watcherSchema.LookupSchema(resourceType)
+
+	for i := 0; i < 10; i++ {
+		list, err := schema.Store.List(&types.APIRequest{Schemas: userSchemas}, schema)
+		assert.NoError(t, err)
+		var ids []string
+		for _, obj := range list.Objects {
+			ids = append(ids, obj.ID)
+		}
+		assert.Equal(t, []string{"pod", "service", "configmap", "node", "secret"}, ids)
+	}
+}
+
 // hasExpectedResults verifies the list of expected apiEvents are all received from the provided channel.
 func hasExpectedResults(t *testing.T, expectedEvents []types.APIEvent, resultChan chan types.APIEvent, timeout time.Duration) {
 	t.Helper()
@@ -0,0 +1,129 @@
+package schema
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Cache is a cache whose entries expire, implemented by cache.LRUExpireCache. A Collection created with
+// NewCollectionWithOptions can be given its own implementations, to observe or control how it caches.
+type Cache interface {
+	Add(key interface{}, value interface{}, ttl time.Duration)
+	Get(key interface{}) (interface{}, bool)
+	Remove(key interface{})
+	// Keys returns all unexpired keys in the cache, ordered from least recently used to most recently used.
+	Keys() []interface{}
+}
+
+// evictingCache is an LRU cache whose entries expire, like cache.LRUExpireCache, that calls onEvict for every entry
+// dropped to make room for a new one. onEvict is called once the cache's lock is released, so it may use the cache.
+type evictingCache struct {
+	lock         sync.Mutex
+	maxSize      int
+	evictionList list.List
+	entries      map[interface{}]*list.Element
+	onEvict      func(key, value interface{})
+}
+
+type evictingCacheEntry struct {
+	key        interface{}
+	value      interface{}
+	expireTime time.Time
+}
+
+func newEvictingCache(maxSize int, onEvict func(key, value interface{})) *evictingCache {
+	if maxSize <= 0 {
+		panic("maxSize must be > 0")
+	}
+	return &evictingCache{
+		maxSize: maxSize,
+		entries: map[interface{}]*list.Element{},
+		onEvict: onEvict,
+	}
+}
+
+// Add adds the value to the cache at key with the specified maximum duration.
+func (c *evictingCache) Add(key interface{}, value interface{}, ttl time.Duration) {
+	evicted := c.add(key, value, ttl)
+	if evicted != nil && c.onEvict != nil {
+		c.onEvict(evicted.key, evicted.value)
+	}
+}
+
+func (c *evictingCache) add(key interface{}, value interface{}, ttl time.Duration) *evictingCacheEntry {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.evictionList.MoveToFront(element)
+		element.Value.(*evictingCacheEntry).value = value
+		element.Value.(*evictingCacheEntry).expireTime = time.Now().Add(ttl)
+		return nil
+	}
+
+	var evicted *evictingCacheEntry
+	if c.evictionList.Len() >= c.maxSize {
+		toEvict := c.evictionList.Back()
+		c.evictionList.Remove(toEvict)
+		evicted = toEvict.Value.(*evictingCacheEntry)
+		delete(c.entries, evicted.key)
+	}
+
+	element := c.evictionList.PushFront(&evictingCacheEntry{
+		key:        key,
+		value:      value,
+		expireTime: time.Now().Add(ttl),
+	})
+	c.entries[key] = element
+	return evicted
+}
+
+// Get returns the value at the specified key from the cache if it exists and is not expired, or returns false.
+func (c *evictingCache) Get(key interface{}) (interface{}, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	if time.Now().After(element.Value.(*evictingCacheEntry).expireTime) {
+		c.evictionList.Remove(element)
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	c.evictionList.MoveToFront(element)
+	return element.Value.(*evictingCacheEntry).value, true
+}
+
+// Remove removes the specified key from the cache if it exists. It does not call onEvict.
+func (c *evictingCache) Remove(key interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return
+	}
+
+	c.evictionList.Remove(element)
+	delete(c.entries, key)
+}
+
+// Keys returns all unexpired keys in the cache, ordered from least recently used to most recently used.
+func (c *evictingCache) Keys() []interface{} {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := time.Now()
+	val := make([]interface{}, 0, c.evictionList.Len())
+	for element := c.evictionList.Back(); element != nil; element = element.Prev() {
+		if !now.After(element.Value.(*evictingCacheEntry).expireTime) {
+			val = append(val, element.Value.(*evictingCacheEntry).key)
+		}
+	}
+	return val
+}
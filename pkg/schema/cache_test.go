@@ -0,0 +1,41 @@
+package schema
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvictingCache(t *testing.T) {
+	var evicted []interface{}
+	var c *evictingCache
+	c = newEvictingCache(2, func(key, value interface{}) {
+		// the callback must be able to use the cache without deadlocking
+		assert.NotContains(t, c.Keys(), key)
+		evicted = append(evicted, key)
+	})
+
+	c.Add("a", 1, time.Hour)
+	c.Add("b", 2, time.Hour)
+	c.Add("a", 3, time.Hour)
+	assert.Empty(t, evicted, "expected no eviction while under the size limit")
+
+	c.Add("c", 4, time.Hour)
+	assert.Equal(t, []interface{}{"b"}, evicted, "expected the least recently used key to be evicted")
+	assert.Equal(t, []interface{}{"a", "c"}, c.Keys())
+	val, ok := c.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 3, val)
+
+	c.Remove("a")
+	assert.Equal(t, []interface{}{"b"}, evicted, "expected Remove to not call the eviction callback")
+	_, ok = c.Get("a")
+	assert.False(t, ok)
+
+	c.Add("d", 5, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	_, ok = c.Get("d")
+	assert.False(t, ok, "expected entry to expire")
+	assert.Equal(t, []interface{}{"c"}, c.Keys())
+}
@@ -2,48 +2,259 @@ package schema
 
 import (
 	"context"
+	"fmt"
 	"net/http"
+	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	apiserver "github.com/rancher/apiserver/pkg/server"
 	"github.com/rancher/apiserver/pkg/types"
 	"github.com/rancher/steve/pkg/accesscontrol"
 	"github.com/rancher/steve/pkg/attributes"
+	"github.com/rancher/steve/pkg/metrics"
+	"github.com/rancher/steve/pkg/stores/partition/listprocessor"
 	"github.com/rancher/wrangler/pkg/name"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/cache"
+	"k8s.io/apiserver/pkg/authentication/user"
 	"k8s.io/apiserver/pkg/endpoints/request"
 )
 
+// cacheTimeoutEnv sets the default duration a user's schemas are cached for. It accepts a Go duration such as "720h",
+// a number of days such as "30d", or a bare integer number of hours.
+const cacheTimeoutEnv = "CATTLE_CACHE_TIMEOUT"
+
+// CacheTimeout is the default value of Collection.CacheTimeout for new collections.
+var CacheTimeout = defaultCacheTimeout()
+
+func defaultCacheTimeout() time.Duration {
+	timeout := 24 * time.Hour
+	if v := os.Getenv(cacheTimeoutEnv); v != "" {
+		parsed, err := parseCacheTimeout(v)
+		if err != nil {
+			logrus.Errorf("could not parse %s environment variable, error: %v", cacheTimeoutEnv, err)
+		} else {
+			timeout = parsed
+		}
+	}
+	return timeout
+}
+
+func parseCacheTimeout(v string) (time.Duration, error) {
+	var (
+		timeout time.Duration
+		err     error
+	)
+	if hours, atoiErr := strconv.Atoi(v); atoiErr == nil {
+		timeout = time.Duration(hours) * time.Hour
+	} else if days, found := strings.CutSuffix(v, "d"); found {
+		var n int
+		n, err = strconv.Atoi(days)
+		timeout = time.Duration(n) * 24 * time.Hour
+	} else {
+		timeout, err = time.ParseDuration(v)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if timeout <= 0 {
+		return 0, fmt.Errorf("cache timeout must be positive, got %q", v)
+	}
+	return timeout, nil
+}
+
+// PurgeBatchWindow is the default value of Collection.PurgeBatchWindow for new collections.
+var PurgeBatchWindow = time.Second
+
+// NamespaceListPolicy controls how access to the namespaces resource is derived for users that have no grants on
+// namespaces themselves.
+type NamespaceListPolicy int
+
+const (
+	// NamespaceListEnumerate grants get and watch on each namespace in which the user has access to resources.
+	NamespaceListEnumerate NamespaceListPolicy = iota
+	// NamespaceListClusterWide grants get and watch on all namespaces when the user has get or list on a namespaced
+	// resource in all namespaces, instead of enumerating them. Otherwise it behaves like NamespaceListEnumerate.
+	NamespaceListClusterWide
+)
+
+// CacheEventType is the kind of change a CacheEvent reports.
+type CacheEventType string
+
+const (
+	// CacheEventAdded is sent when the schemas computed for an access set are added to the cache.
+	CacheEventAdded CacheEventType = "Added"
+	// CacheEventPurged is sent when the schemas of an access set are removed because they are no longer valid.
+	CacheEventPurged CacheEventType = "Purged"
+	// CacheEventEvicted is sent when the schemas of an access set are removed to make room for others.
+	CacheEventEvicted CacheEventType = "Evicted"
+)
+
+// CacheEvent describes a change to the schemas cached for an access set.
+type CacheEvent struct {
+	AccessID string
+	Type     CacheEventType
+	Time     time.Time
+}
+
+// SchemaEventType is the kind of change a SchemaEvent reports.
+type SchemaEventType string
+
+const (
+	// SchemaEventCreated is sent when a schema is added to the collection, such as when a CRD is installed.
+	SchemaEventCreated SchemaEventType = "created"
+	// SchemaEventUpdated is sent when a schema of the collection is replaced by a different one.
+	SchemaEventUpdated SchemaEventType = "updated"
+	// SchemaEventRemoved is sent when a schema is removed from the collection, such as when a CRD is deleted.
+	SchemaEventRemoved SchemaEventType = "removed"
+	// SchemaEventRefreshed is sent without a schema ID when the schemas cached for all users are discarded with
+	// Refresh, since any schema may then be rendered differently.
+	SchemaEventRefreshed SchemaEventType = "refreshed"
+)
+
+// SchemaEvent describes a change to the schemas of the collection.
+type SchemaEvent struct {
+	ID   string
+	Type SchemaEventType
+	Time time.Time
+}
+
 type Collection struct {
-	toSync     int32
-	baseSchema *types.APISchemas
-	schemas    map[string]*types.APISchema
-	templates  map[string][]*Template
-	notifiers  map[int]func()
-	notifierID int
-	byGVR      map[schema.GroupVersionResource]string
-	byGVK      map[schema.GroupVersionKind]string
-	cache      *cache.LRUExpireCache
-	userCache  *cache.LRUExpireCache
-	lock       sync.RWMutex
+	// CacheTimeout is how long the schemas computed for a user are cached for. It defaults to CacheTimeout.
+	CacheTimeout time.Duration
+	// StaleGracePeriod is how long the schemas of a user are kept past CacheTimeout. A request for schemas that are
+	// within the grace period gets them right away while they are computed again in the background, and the fresh
+	// schemas replace them once computed. Zero disables the grace period, so that the request computing the expired
+	// schemas waits for them.
+	StaleGracePeriod time.Duration
+	// NamespaceListPolicy is the policy used to derive namespace access. It defaults to NamespaceListEnumerate.
+	NamespaceListPolicy NamespaceListPolicy
+	// AlwaysAllowNamespaceList renders the namespaces collection as listable for users who have access to no
+	// namespaces, so that they get an empty list instead of an error. It defaults to true.
+	AlwaysAllowNamespaceList bool
+	// MaxAccessEntries caps the number of access entries rendered per verb on a user's schema. Longer lists are
+	// collapsed to a single wildcard entry when the user has access to all namespaces and names, and are truncated
+	// otherwise. Zero means no cap.
+	MaxAccessEntries int
+	// OnCacheEvent, if set, is called whenever schemas are added to or removed from the cache. It is never called
+	// while a lock of the collection is held.
+	OnCacheEvent func(event CacheEvent)
+	// DefaultStoreKeys are the template keys searched, in order, for the store passed to the store factories of the
+	// templates: a schema ID, a "group/kind" pair, or "" for the global templates. The first store set on a template
+	// registered under one of the keys is used. It defaults to the global templates only, and is ignored once a store
+	// is set with SetDefaultStore.
+	DefaultStoreKeys []string
+	// CacheKeySalt, if set, returns a salt appended to the ID of the user's access set to form the key its schemas
+	// are cached under, so that users sharing an access set can be kept from sharing cached schemas, for example when
+	// the request is impersonated on behalf of another principal. Users are only isolated from each other if their
+	// salts differ. An empty salt leaves the key unchanged.
+	CacheKeySalt func(user user.Info) string
+	// PurgeBatchWindow is how long the access set IDs whose schemas were purged or evicted are collected for before
+	// their data is purged from the access set lookup with a single call to PurgeUserDataBulk. The schemas themselves
+	// are removed immediately, but the lookup may keep the data of a purged access set for up to the window. Zero
+	// purges the data of each access set as soon as its schemas are removed. It defaults to PurgeBatchWindow.
+	PurgeBatchWindow time.Duration
+
+	toSync         int32
+	baseSchema     *types.APISchemas
+	builtinSchemas *types.APISchemas
+	schemas        map[string]*types.APISchema
+	templates      map[string][]*Template
+	predicates     []templatePredicate
+	notifiers      map[int]func()
+	eventNotifiers map[int]func(SchemaEvent)
+	notifierID     int
+	synced         bool
+	onSync         []func()
+	byGVR          map[schema.GroupVersionResource]string
+	byGVK          map[schema.GroupVersionKind]string
+	cache          Cache
+	userCache      Cache
+	sharedCache    SharedCache
+	lock           sync.RWMutex
+
+	schemasGroup  singleflight.Group
+	idLocks       [64]sync.Mutex
+	methodBlocker MethodBlocker
+	defaultStore  types.Store
+	hidden        map[schema.GroupVersionResource]bool
+	purges        purgeBatch
+
+	// generation is incremented whenever the cache is cleared, computing holds the generation each access ID whose
+	// schemas are being computed started in.
+	generation uint64
+	computing  sync.Map
 
 	ctx     context.Context
 	running map[string]func()
 	as      accesscontrol.AccessSetLookup
 }
 
+type templatePredicate struct {
+	matches  func(*types.APISchema) bool
+	template *Template
+}
+
 type Template struct {
-	Group        string
-	Kind         string
-	ID           string
-	Customize    func(*types.APISchema)
+	Group     string
+	Kind      string
+	ID        string
+	Customize func(*types.APISchema)
+	// CustomizeE is like Customize but can fail, in which case the error is returned by Collection.Reset. It is
+	// called after Customize when both are set.
+	CustomizeE func(*types.APISchema) error
+	// Formatter is chained with the formatters of the other templates matching the schema. The access set of the
+	// user making the request is available from accesscontrol.AccessSetFromContext(apiOp.Context()), so that a
+	// formatter can redact the fields the user may not see.
 	Formatter    types.Formatter
 	Store        types.Store
 	Start        func(ctx context.Context) error
 	StoreFactory func(types.Store) types.Store
+	// StoreFactoryWithSchema is like StoreFactory but also receives the schema the store is created for. It takes
+	// precedence over StoreFactory when both are set.
+	StoreFactoryWithSchema func(schema *types.APISchema, defaultStore types.Store) types.Store
+	// DefaultProjection is the set of fields kept in the objects of list responses when the client does not request
+	// a projection, such as "spec.replicas" or "status.conditions". Get responses always have the full objects.
+	DefaultProjection []string
+	// Joins are the related resources a list request can join to the listed objects with the join parameter, such as
+	// the node of a pod. The related objects are listed with the access of the user making the request.
+	Joins []listprocessor.Join
+	// Authorizer decides which objects the user making a request may see, beyond the RBAC access to the schema,
+	// such as by an ownership label. It filters the objects of lists, gets and watches, and the authorizers of all
+	// the templates matching the schema must allow an object for the user to see it.
+	Authorizer listprocessor.Authorizer
+	// Sensitive marks the schema's objects as sensitive, such as secrets, so that the proxy store logs an audit entry
+	// for each access to them, with the user, the verb, the object and the outcome of the access.
+	Sensitive bool
+	// Validation validates the bodies of the creates and updates of the schema's objects against the fields of the
+	// schema before the proxy store sends them to Kubernetes, so that invalid objects are rejected with the field at
+	// fault: attributes.ValidationLenient, or attributes.ValidationStrict to also reject unknown fields.
+	Validation string
+	// Weight orders the templates registered under the same key, which are applied by ascending weight. Templates of
+	// equal weight are applied in the order they were registered. Since every template's formatter runs before the
+	// formatters of the templates applied before it, a heavier template's formatter wraps a lighter one's.
+	Weight int
+}
+
+// storeFactory returns the function creating the store of the template, adapting StoreFactory if
+// StoreFactoryWithSchema is not set, or nil if the template has neither.
+func (t *Template) storeFactory() func(*types.APISchema, types.Store) types.Store {
+	if t.StoreFactoryWithSchema != nil {
+		return t.StoreFactoryWithSchema
+	}
+	if t.StoreFactory != nil {
+		return func(_ *types.APISchema, defaultStore types.Store) types.Store {
+			return t.StoreFactory(defaultStore)
+		}
+	}
+	return nil
 }
 
 func WrapServer(factory Factory, server *apiserver.Server) http.Handler {
@@ -53,12 +264,15 @@ func WrapServer(factory Factory, server *apiserver.Server) http.Handler {
 			return
 		}
 
-		schemas, err := factory.Schemas(user)
+		schemas, err := factory.SchemasContext(req.Context(), user)
 		if err != nil {
 			logrus.Errorf("failed to lookup schemas for user %v: %v", user, err)
 			http.Error(rw, "schemas failed", http.StatusInternalServerError)
 			return
 		}
+		if accessSet, ok := AccessSetFromSchemas(schemas); ok {
+			req = req.WithContext(accesscontrol.WithAccessSet(req.Context(), accessSet))
+		}
 
 		server.Handle(&types.APIRequest{
 			Request:  req,
@@ -68,20 +282,61 @@ func WrapServer(factory Factory, server *apiserver.Server) http.Handler {
 	})
 }
 
+// CollectionOptions replaces the caches of a Collection, mostly so that tests can observe and control them.
+type CollectionOptions struct {
+	// SchemaCache holds the schemas computed for each access set, keyed by cache key. It defaults to an LRU cache of
+	// 1000 entries. The data of entries it evicts is only purged from the access set lookup with the default cache.
+	SchemaCache Cache
+	// UserCache holds the cache key of the schemas last computed for each user, keyed by user name. It defaults to an
+	// LRU cache of 1000 entries.
+	UserCache Cache
+	// SharedCache, if set, holds the schemas computed for each access set across the replicas of a deployment. It is
+	// only read when SchemaCache misses.
+	SharedCache SharedCache
+}
+
 func NewCollection(ctx context.Context, baseSchema *types.APISchemas, access accesscontrol.AccessSetLookup) *Collection {
-	return &Collection{
-		baseSchema: baseSchema,
-		schemas:    map[string]*types.APISchema{},
-		templates:  map[string][]*Template{},
-		byGVR:      map[schema.GroupVersionResource]string{},
-		byGVK:      map[schema.GroupVersionKind]string{},
-		cache:      cache.NewLRUExpireCache(1000),
-		userCache:  cache.NewLRUExpireCache(1000),
-		notifiers:  map[int]func(){},
-		ctx:        ctx,
-		as:         access,
-		running:    map[string]func(){},
+	return NewCollectionWithOptions(ctx, baseSchema, access, CollectionOptions{})
+}
+
+// NewCollectionWithOptions returns a collection like NewCollection, using the caches set in opts.
+func NewCollectionWithOptions(ctx context.Context, baseSchema *types.APISchemas, access accesscontrol.AccessSetLookup, opts CollectionOptions) *Collection {
+	c := &Collection{
+		CacheTimeout:             CacheTimeout,
+		PurgeBatchWindow:         PurgeBatchWindow,
+		AlwaysAllowNamespaceList: true,
+		DefaultStoreKeys:         []string{""},
+		baseSchema:               baseSchema,
+		builtinSchemas:           newBuiltinSchemas(),
+		schemas:                  map[string]*types.APISchema{},
+		templates:                map[string][]*Template{},
+		byGVR:                    map[schema.GroupVersionResource]string{},
+		byGVK:                    map[schema.GroupVersionKind]string{},
+		cache:                    opts.SchemaCache,
+		userCache:                opts.UserCache,
+		sharedCache:              opts.SharedCache,
+		notifiers:                map[int]func(){},
+		eventNotifiers:           map[int]func(SchemaEvent){},
+		ctx:                      ctx,
+		as:                       access,
+		running:                  map[string]func(){},
 	}
+	if c.cache == nil {
+		c.cache = newEvictingCache(1000, c.onCacheEvict)
+	}
+	if c.userCache == nil {
+		c.userCache = cache.NewLRUExpireCache(1000)
+	}
+	removeSize := metrics.AddSchemaCacheSize(func() int {
+		return len(c.cache.Keys())
+	})
+	if done := ctx.Done(); done != nil {
+		go func() {
+			<-done
+			removeSize()
+		}()
+	}
+	return c
 }
 
 func (c *Collection) OnChange(ctx context.Context, cb func()) {
@@ -99,7 +354,79 @@ func (c *Collection) OnChange(ctx context.Context, cb func()) {
 	}()
 }
 
-func (c *Collection) Reset(schemas map[string]*types.APISchema) {
+// OnSchemaEvent registers a callback that is called with each change to the schemas of the collection, until ctx is
+// done. The events of a Reset are sent after the cached schemas are discarded and the callbacks registered with
+// OnChange are called, so that the schemas of a user fetched on an event already reflect the change.
+func (c *Collection) OnSchemaEvent(ctx context.Context, cb func(SchemaEvent)) {
+	c.lock.Lock()
+	id := c.notifierID
+	c.notifierID++
+	c.eventNotifiers[id] = cb
+	c.lock.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		c.lock.Lock()
+		delete(c.eventNotifiers, id)
+		c.lock.Unlock()
+	}()
+}
+
+// sendSchemaEvents calls the callbacks registered with OnSchemaEvent with each of the events.
+func (c *Collection) sendSchemaEvents(events []SchemaEvent) {
+	if len(events) == 0 {
+		return
+	}
+	c.lock.RLock()
+	notifiers := make([]func(SchemaEvent), 0, len(c.eventNotifiers))
+	for _, f := range c.eventNotifiers {
+		notifiers = append(notifiers, f)
+	}
+	c.lock.RUnlock()
+	for _, event := range events {
+		for _, f := range notifiers {
+			f(event)
+		}
+	}
+}
+
+// schemaEvents returns the events turning the previous schemas into the current ones, ordered by schema ID.
+func schemaEvents(previous, current map[string]*types.APISchema) []SchemaEvent {
+	var events []SchemaEvent
+	now := time.Now()
+	for id, s := range current {
+		previousSchema, ok := previous[id]
+		switch {
+		case !ok:
+			events = append(events, SchemaEvent{ID: id, Type: SchemaEventCreated, Time: now})
+		case !sameSchema(previousSchema, s):
+			events = append(events, SchemaEvent{ID: id, Type: SchemaEventUpdated, Time: now})
+		}
+	}
+	for id := range previous {
+		if _, ok := current[id]; !ok {
+			events = append(events, SchemaEvent{ID: id, Type: SchemaEventRemoved, Time: now})
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].ID < events[j].ID
+	})
+	return events
+}
+
+// sameSchema returns whether the schemas describe the same resource, ignoring their mappers, which cannot be compared.
+func sameSchema(left, right *types.APISchema) bool {
+	if left.Schema == nil || right.Schema == nil {
+		return left.Schema == right.Schema
+	}
+	leftCopy, rightCopy := left.Schema.DeepCopy(), right.Schema.DeepCopy()
+	leftCopy.Mapper, rightCopy.Mapper = nil, nil
+	return equality.Semantic.DeepEqual(leftCopy, rightCopy)
+}
+
+// Reset replaces the schemas of the collection after applying the templates to them. If a template fails to customize
+// a schema the error is returned and the current schemas are kept.
+func (c *Collection) Reset(schemas map[string]*types.APISchema) error {
 	byGVK := map[schema.GroupVersionKind]string{}
 	byGVR := map[schema.GroupVersionResource]string{}
 
@@ -113,16 +440,26 @@ func (c *Collection) Reset(schemas map[string]*types.APISchema) {
 			byGVK[gvk] = s.ID
 		}
 
-		c.applyTemplates(s)
+		if err := c.applyTemplates(s); err != nil {
+			return err
+		}
 	}
 
 	c.lock.Lock()
+	var events []SchemaEvent
+	if len(c.eventNotifiers) > 0 {
+		events = schemaEvents(c.schemas, schemas)
+	}
 	c.startStopTemplate(schemas)
 	c.schemas = schemas
 	c.byGVR = byGVR
 	c.byGVK = byGVK
-	for _, k := range c.cache.Keys() {
-		c.cache.Remove(k)
+	c.clearCache()
+	var onSync []func()
+	if !c.synced && len(schemas) > 0 {
+		c.synced = true
+		onSync = c.onSync
+		c.onSync = nil
 	}
 	c.lock.Unlock()
 	c.lock.RLock()
@@ -130,6 +467,47 @@ func (c *Collection) Reset(schemas map[string]*types.APISchema) {
 		f()
 	}
 	c.lock.RUnlock()
+	for _, f := range onSync {
+		f()
+	}
+	c.sendSchemaEvents(events)
+	return nil
+}
+
+// HasSynced returns true once the collection has been populated with schemas.
+func (c *Collection) HasSynced() bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.synced
+}
+
+// OnSync registers a callback that is called once, the first time the collection is populated with schemas. If it
+// already is, the callback is called right away.
+func (c *Collection) OnSync(cb func()) {
+	c.lock.Lock()
+	if !c.synced {
+		c.onSync = append(c.onSync, cb)
+		c.lock.Unlock()
+		return
+	}
+	c.lock.Unlock()
+	cb()
+}
+
+// Refresh discards the schemas cached for all users, so the next call to Schemas recomputes them from the current
+// schemas. The schemas that are being computed are returned to the calls waiting for them, but are not cached, and
+// later calls compute them again. Since nothing is cached afterwards, every active user pays the full cost of
+// computing their schemas again, which causes a burst of load right after a refresh. A SchemaEventRefreshed event is
+// sent once the cached schemas are discarded.
+func (c *Collection) Refresh() {
+	c.lock.Lock()
+	c.clearCache()
+	for _, k := range c.userCache.Keys() {
+		c.userCache.Remove(k)
+	}
+	c.lock.Unlock()
+
+	c.sendSchemaEvents([]SchemaEvent{{Type: SchemaEventRefreshed, Time: time.Now()}})
 }
 
 func start(ctx context.Context, templates []*Template) error {
@@ -216,6 +594,58 @@ func (c *Collection) ByGVK(gvk schema.GroupVersionKind) string {
 	return c.byGVK[gvk]
 }
 
+// AddTemplatePredicate registers a template that applies to every schema for which matches returns true. Templates
+// registered by ID, group and kind or globally with AddTemplate are applied first, followed by the matching predicate
+// templates in the order they were registered, ordered by Weight. The Group, Kind, ID and Start fields of the template are ignored.
+func (c *Collection) AddTemplatePredicate(matches func(*types.APISchema) bool, template *Template) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.predicates = append(c.predicates, templatePredicate{
+		matches:  matches,
+		template: template,
+	})
+}
+
+// TemplateInfo describes a registered template without exposing its functions or stores.
+type TemplateInfo struct {
+	Group           string
+	Kind            string
+	ID              string
+	Weight          int
+	HasFormatter    bool
+	HasStore        bool
+	HasStoreFactory bool
+	HasCustomize    bool
+	HasStart        bool
+}
+
+// Templates returns a snapshot of the templates registered with AddTemplate by key, which is the schema ID, the
+// "group/kind" pair, or "" for the global templates. Templates registered with AddTemplatePredicate are not keyed
+// and are not included.
+func (c *Collection) Templates() map[string][]TemplateInfo {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	result := make(map[string][]TemplateInfo, len(c.templates))
+	for key, templates := range c.templates {
+		for _, t := range templates {
+			result[key] = append(result[key], TemplateInfo{
+				Group:           t.Group,
+				Kind:            t.Kind,
+				ID:              t.ID,
+				Weight:          t.Weight,
+				HasFormatter:    t.Formatter != nil,
+				HasStore:        t.Store != nil,
+				HasStoreFactory: t.StoreFactory != nil || t.StoreFactoryWithSchema != nil,
+				HasCustomize:    t.Customize != nil || t.CustomizeE != nil,
+				HasStart:        t.Start != nil,
+			})
+		}
+	}
+	return result
+}
+
 func (c *Collection) AddTemplate(templates ...Template) {
 	c.lock.RLock()
 	defer c.lock.RUnlock()
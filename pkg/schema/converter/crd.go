@@ -41,21 +41,23 @@ func AddCustomResources(crd apiextv1.CustomResourceDefinitionClient, schemas map
 		group, kind := crd.Spec.Group, crd.Status.AcceptedNames.Kind
 
 		for _, version := range crd.Spec.Versions {
-			forVersion(&crd, group, kind, version, schemas)
+			forVersion(group, kind, version, schemas)
 		}
 	}
 
 	return nil
 }
 
-func forVersion(crd *v1.CustomResourceDefinition, group, kind string, version v1.CustomResourceDefinitionVersion, schemasMap map[string]*types.APISchema) {
+func forVersion(group, kind string, version v1.CustomResourceDefinitionVersion, schemasMap map[string]*types.APISchema) {
 	var versionColumns []table.Column
 	for _, col := range version.AdditionalPrinterColumns {
 		versionColumns = append(versionColumns, table.Column{
-			Name:   col.Name,
-			Field:  col.JSONPath,
-			Type:   col.Type,
-			Format: col.Format,
+			Name:        col.Name,
+			Field:       col.JSONPath,
+			Type:        col.Type,
+			Format:      col.Format,
+			Description: col.Description,
+			Priority:    int(col.Priority),
 		})
 	}
 
@@ -73,7 +75,10 @@ func forVersion(crd *v1.CustomResourceDefinition, group, kind string, version v1
 		attributes.SetColumns(schema, versionColumns)
 	}
 	if version.Schema != nil && version.Schema.OpenAPIV3Schema != nil {
-		if fieldsSchema := modelV3ToSchema(id, crd.Spec.Versions[0].Schema.OpenAPIV3Schema, schemasMap); fieldsSchema != nil {
+		if hasConditions(version.Schema.OpenAPIV3Schema) {
+			attributes.SetConditions(schema, true)
+		}
+		if fieldsSchema := modelV3ToSchema(id, version.Schema.OpenAPIV3Schema, schemasMap); fieldsSchema != nil {
 			for k, v := range staticFields {
 				fieldsSchema.ResourceFields[k] = v
 			}
@@ -88,3 +93,8 @@ func forVersion(crd *v1.CustomResourceDefinition, group, kind string, version v1
 		}
 	}
 }
+
+// hasConditions returns whether the schema of a custom resource has a status.conditions list.
+func hasConditions(schema *v1.JSONSchemaProps) bool {
+	return schema.Properties["status"].Properties["conditions"].Type == "array"
+}
@@ -0,0 +1,116 @@
+package converter
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/rancher/apiserver/pkg/types"
+	"github.com/rancher/steve/pkg/attributes"
+	"github.com/rancher/steve/pkg/schema/table"
+	"github.com/rancher/wrangler/pkg/generic/fake"
+	wschemas "github.com/rancher/wrangler/pkg/schemas"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestAddCustomResources(t *testing.T) {
+	crd := v1.CustomResourceDefinition{
+		Spec: v1.CustomResourceDefinitionSpec{
+			Group: "example.com",
+			Versions: []v1.CustomResourceDefinitionVersion{
+				{
+					Name:    "v1",
+					Served:  true,
+					Storage: true,
+					Schema: &v1.CustomResourceValidation{
+						OpenAPIV3Schema: &v1.JSONSchemaProps{
+							Properties: map[string]v1.JSONSchemaProps{
+								"spec": {Type: "object", Properties: map[string]v1.JSONSchemaProps{
+									"replicas": {Type: "integer"},
+								}},
+								"status": {Type: "object", Properties: map[string]v1.JSONSchemaProps{
+									"conditions": {Type: "array"},
+								}},
+							},
+						},
+					},
+					AdditionalPrinterColumns: []v1.CustomResourceColumnDefinition{
+						{Name: "Replicas", Type: "integer", JSONPath: ".spec.replicas", Description: "desired replicas"},
+						{Name: "Ready", Type: "string", JSONPath: `.status.conditions[?(@.type=="Ready")].status`},
+						{Name: "Image", Type: "string", JSONPath: ".spec.image", Priority: 1},
+						{Name: "Age", Type: "date", Format: "date-time", JSONPath: ".metadata.creationTimestamp"},
+					},
+				},
+				{
+					Name:   "v1beta1",
+					Served: true,
+					Schema: &v1.CustomResourceValidation{
+						OpenAPIV3Schema: &v1.JSONSchemaProps{
+							Properties: map[string]v1.JSONSchemaProps{
+								"spec": {Type: "object", Properties: map[string]v1.JSONSchemaProps{
+									"size": {Type: "integer"},
+								}},
+							},
+						},
+					},
+					AdditionalPrinterColumns: []v1.CustomResourceColumnDefinition{
+						{Name: "Size", Type: "integer", JSONPath: ".spec.size"},
+					},
+				},
+				{
+					Name:   "v1alpha1",
+					Served: true,
+				},
+			},
+		},
+		Status: v1.CustomResourceDefinitionStatus{
+			AcceptedNames: v1.CustomResourceDefinitionNames{
+				Kind:   "Widget",
+				Plural: "widgets",
+			},
+		},
+	}
+	ctrl := gomock.NewController(t)
+	crdClient := fake.NewMockNonNamespacedClientInterface[*v1.CustomResourceDefinition, *v1.CustomResourceDefinitionList](ctrl)
+	crdClient.EXPECT().List(gomock.Any()).Return(&v1.CustomResourceDefinitionList{Items: []v1.CustomResourceDefinition{crd}}, nil)
+
+	// the schemas of the served versions come from discovery
+	schemas := map[string]*types.APISchema{}
+	for _, version := range []string{"v1", "v1beta1", "v1alpha1"} {
+		gvk := schema.GroupVersionKind{Group: "example.com", Version: version, Kind: "Widget"}
+		s := &types.APISchema{
+			Schema: &wschemas.Schema{
+				ID: GVKToVersionedSchemaID(gvk),
+			},
+		}
+		attributes.SetGVK(s, gvk)
+		attributes.SetAPIResource(s, metav1.APIResource{Name: "widgets", Namespaced: true, Verbs: []string{"get", "list"}})
+		schemas[s.ID] = s
+	}
+	require.NoError(t, AddCustomResources(crdClient, schemas))
+
+	v1Schema := schemas["example.com.v1.widget"]
+	assert.Equal(t, []table.Column{
+		{Name: "Replicas", Field: ".spec.replicas", Type: "integer", Description: "desired replicas"},
+		{Name: "Ready", Field: `.status.conditions[?(@.type=="Ready")].status`, Type: "string"},
+		{Name: "Image", Field: ".spec.image", Type: "string", Priority: 1},
+		{Name: "Age", Field: ".metadata.creationTimestamp", Type: "date", Format: "date-time"},
+	}, attributes.Columns(v1Schema))
+	assert.Contains(t, v1Schema.ResourceFields, "spec")
+	assert.True(t, attributes.Conditions(v1Schema), "expected the v1 schema to have conditions")
+	assert.Equal(t, "widgets", attributes.Resource(v1Schema), "expected the discovery attributes to be kept")
+
+	v1beta1Schema := schemas["example.com.v1beta1.widget"]
+	assert.Equal(t, []table.Column{
+		{Name: "Size", Field: ".spec.size", Type: "integer"},
+	}, attributes.Columns(v1beta1Schema), "expected each version to have its own columns")
+	assert.False(t, attributes.Conditions(v1beta1Schema), "expected the v1beta1 schema to have no conditions")
+	assert.Contains(t, schemas, "example.com.v1beta1.widget.spec")
+	assert.Contains(t, schemas["example.com.v1beta1.widget.spec"].ResourceFields, "size", "expected the fields of the v1beta1 schema")
+	assert.NotContains(t, schemas["example.com.v1beta1.widget.spec"].ResourceFields, "replicas")
+
+	assert.Nil(t, attributes.Columns(schemas["example.com.v1alpha1.widget"]), "expected no columns for a version without printer columns")
+}
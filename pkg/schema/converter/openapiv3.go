@@ -1,6 +1,8 @@
 package converter
 
 import (
+	"encoding/json"
+
 	"github.com/rancher/apiserver/pkg/types"
 	"github.com/rancher/wrangler/pkg/schemas"
 	v1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
@@ -88,5 +90,12 @@ func toResourceField(name string, schema v1.JSONSchemaProps, schemasMap map[stri
 		f.Type = "json"
 	}
 
+	if schema.Default != nil {
+		var value interface{}
+		if err := json.Unmarshal(schema.Default.Raw, &value); err == nil {
+			f.Default = value
+		}
+	}
+
 	return f
 }
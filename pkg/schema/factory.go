@@ -3,81 +3,548 @@ package schema
 //go:generate mockgen --build_flags=--mod=mod -package fake -destination fake/factory.go "github.com/rancher/steve/pkg/schema" Factory
 import (
 	"context"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"net/http"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/rancher/apiserver/pkg/builtin"
 	"github.com/rancher/apiserver/pkg/types"
 	"github.com/rancher/steve/pkg/accesscontrol"
 	"github.com/rancher/steve/pkg/attributes"
+	"github.com/rancher/steve/pkg/metrics"
+	"github.com/rancher/steve/pkg/stores/partition/listprocessor"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/semaphore"
+	"golang.org/x/sync/singleflight"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apiserver/pkg/authentication/user"
 )
 
 type Factory interface {
 	Schemas(user user.Info) (*types.APISchemas, error)
+	SchemasContext(ctx context.Context, user user.Info) (*types.APISchemas, error)
 	ByGVR(gvr schema.GroupVersionResource) string
 	ByGVK(gvr schema.GroupVersionKind) string
 	OnChange(ctx context.Context, cb func())
 	AddTemplate(template ...Template)
 }
 
-func newSchemas() (*types.APISchemas, error) {
-	apiSchemas := types.EmptyAPISchemas()
-	if err := apiSchemas.AddSchemas(builtin.Schemas); err != nil {
-		return nil, err
-	}
+// newBuiltinSchemas returns the builtin schemas with their defaults set up, to be cloned for every user.
+func newBuiltinSchemas() *types.APISchemas {
+	return types.EmptyAPISchemas().MustAddSchemas(builtin.Schemas)
+}
 
-	return apiSchemas, nil
+// cloneSchemas copies the schemas into a new APISchemas. The method slices are clipped to their length so that
+// methods appended to the schemas of one user never write into memory shared with the others, and the attributes
+// are copied, so per-user changes do not bleed across users.
+func cloneSchemas(apiSchemas *types.APISchemas) (*types.APISchemas, error) {
+	result := types.EmptyAPISchemas()
+	for _, s := range apiSchemas.Schemas {
+		// AddSchema stores a copy of the schema, so only its slices and maps need to be separated afterwards
+		if err := result.AddSchema(*s); err != nil {
+			return nil, err
+		}
+		clone := result.Schemas[s.ID]
+		clone.CollectionMethods = s.CollectionMethods[:len(s.CollectionMethods):len(s.CollectionMethods)]
+		clone.ResourceMethods = s.ResourceMethods[:len(s.ResourceMethods):len(s.ResourceMethods)]
+		if s.Attributes != nil {
+			clone.Attributes = make(map[string]interface{}, len(s.Attributes))
+			for k, v := range s.Attributes {
+				clone.Attributes[k] = v
+			}
+		}
+	}
+	return result, nil
 }
 
 func (c *Collection) Schemas(user user.Info) (*types.APISchemas, error) {
+	return c.SchemasContext(context.Background(), user)
+}
+
+// SchemasForCluster returns the schemas the user has access to in the cluster with the ID, which are cached apart from
+// the user's schemas in the other clusters.
+func (c *Collection) SchemasForCluster(user user.Info, clusterID string) (*types.APISchemas, error) {
+	return c.SchemasContext(WithClusterID(context.Background(), clusterID), user)
+}
+
+type clusterIDKey struct{}
+
+// WithClusterID returns a copy of the context for the cluster with the ID. In a deployment serving several clusters, a
+// user can have the same access set in two clusters without having the same access, so SchemasContext caches the
+// schemas of each cluster separately.
+func WithClusterID(ctx context.Context, clusterID string) context.Context {
+	return context.WithValue(ctx, clusterIDKey{}, clusterID)
+}
+
+// ClusterIDFrom returns the ID of the cluster of the context, or an empty ID if the context has none.
+func ClusterIDFrom(ctx context.Context) string {
+	clusterID, _ := ctx.Value(clusterIDKey{}).(string)
+	return clusterID
+}
+
+// SchemasContext returns the schemas the user has access to, in the cluster of the context if it has one. Computing
+// them stops early with an error wrapping ctx.Err() if the context is done.
+func (c *Collection) SchemasContext(ctx context.Context, user user.Info) (*types.APISchemas, error) {
 	access := c.as.AccessFor(user)
-	c.removeOldRecords(access, user)
-	val, ok := c.cache.Get(access.ID)
+	clusterID := ClusterIDFrom(ctx)
+	id := c.cacheKey(access, user, clusterID)
+	userKey := userCacheKey(user.GetName(), clusterID)
+	previous := c.removeOldRecords(id, userKey)
+	val, ok := c.cache.Get(id)
 	if ok {
+		metrics.IncSchemaCacheHit()
 		schemas, _ := val.(*types.APISchemas)
+		if c.isStale(schemas) {
+			c.revalidate(id, access)
+		}
 		return schemas, nil
 	}
+	metrics.IncSchemaCacheMiss()
+	// user names are intentionally not logged, the access ID is enough to correlate entries
+	logrus.WithField("accessID", access.ID).Debug("schema cache miss, computing schemas")
+	if logrus.IsLevelEnabled(logrus.TraceLevel) {
+		logrus.WithField("accessIDs", c.cache.Keys()).Trace("schema cache contents")
+	}
+
+	for {
+		// concurrent misses for the same access set share a single computation
+		resultCh := c.computeAndCache(ctx, id, access, previous)
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("failed to compute schemas: %w", ctx.Err())
+		case result := <-resultCh:
+			if result.Err != nil {
+				if ctx.Err() == nil && isContextError(result.Err) {
+					// the computation was abandoned by the caller that started it, but this caller still wants it
+					continue
+				}
+				return nil, result.Err
+			}
+			c.addUserToCache(id, userKey)
+			schemas, _ := result.Val.(*types.APISchemas)
+			return schemas, nil
+		}
+	}
+}
+
+// computedAtAttribute is the attribute of a user's schemas holding the time they were computed at.
+const computedAtAttribute = "computedAt"
 
-	schemas, err := c.schemasForSubject(access)
+// computeAndCache computes the schemas of the access set and adds them to the cache under id, unless the cache was
+// cleared by Refresh or Reset in the meantime. Concurrent computations for the same id share a single one, whose
+// result is sent on the returned channel.
+func (c *Collection) computeAndCache(ctx context.Context, id string, access *accesscontrol.AccessSet, previous *types.APISchemas) <-chan singleflight.Result {
+	return c.schemasGroup.DoChan(id, func() (interface{}, error) {
+		c.lock.RLock()
+		generation := c.generation
+		c.lock.RUnlock()
+		c.computing.Store(id, generation)
+		defer c.computing.CompareAndDelete(id, generation)
+
+		schemas, err := c.computeSchemas(ctx, id, access, previous)
+		if err != nil {
+			return nil, err
+		}
+		schemas.Attributes[computedAtAttribute] = time.Now()
+		if !c.addToCache(id, schemas, generation) {
+			logrus.WithField("accessID", access.ID).Debug("discarding schemas computed before the cache was cleared")
+			return schemas, nil
+		}
+		return schemas, nil
+	})
+}
+
+// revalidate computes the schemas of the access set again in the background, replacing the stale schemas cached under
+// id once they are computed. Stale hits while they are computed share a single computation.
+func (c *Collection) revalidate(id string, access *accesscontrol.AccessSet) {
+	logrus.WithField("accessID", access.ID).Debug("schemas are stale, computing them in the background")
+	resultCh := c.computeAndCache(c.ctx, id, access, nil)
+	go func() {
+		if result := <-resultCh; result.Err != nil {
+			// the stale schemas are served until they expire, or until a later hit computes them successfully
+			logrus.WithField("accessID", access.ID).Warnf("failed to compute stale schemas: %v", result.Err)
+		}
+	}()
+}
+
+// isStale returns whether the cached schemas are older than CacheTimeout, which they can only be within the
+// StaleGracePeriod.
+func (c *Collection) isStale(schemas *types.APISchemas) bool {
+	if c.StaleGracePeriod <= 0 || schemas == nil {
+		return false
+	}
+	computedAt, ok := schemas.Attributes[computedAtAttribute].(time.Time)
+	return ok && time.Since(computedAt) > c.CacheTimeout
+}
+
+// cacheTTL returns how long the schemas of a user are kept in the cache, including the StaleGracePeriod.
+func (c *Collection) cacheTTL() time.Duration {
+	if c.StaleGracePeriod <= 0 {
+		return c.CacheTimeout
+	}
+	return c.CacheTimeout + c.StaleGracePeriod
+}
+
+// computeSchemas returns the schemas of the access set from the shared cache if it has them, or computes them and
+// stores them in it.
+func (c *Collection) computeSchemas(ctx context.Context, id string, access *accesscontrol.AccessSet, previous *types.APISchemas) (*types.APISchemas, error) {
+	if c.sharedCache == nil {
+		return c.schemasForSubjectDelta(ctx, access, previous)
+	}
+	key, digest := c.sharedKey(id)
+	if schemas := c.loadShared(ctx, key, digest, access); schemas != nil {
+		return schemas, nil
+	}
+	schemas, err := c.schemasForSubjectDelta(ctx, access, previous)
 	if err != nil {
 		return nil, err
 	}
-	c.addToCache(access, user, schemas)
+	c.storeShared(ctx, key, digest, access, schemas)
 	return schemas, nil
 }
 
-func (c *Collection) removeOldRecords(access *accesscontrol.AccessSet, user user.Info) {
-	current, ok := c.userCache.Get(user.GetName())
+// warmConcurrency is the number of users whose schemas are computed at the same time by Warm.
+const warmConcurrency = 10
+
+// Warm computes and caches the schemas of the users ahead of their first request. Users that share an access set
+// share a single computation, and access sets beyond the capacity of the cache are not warmed since they would only
+// evict others. Warm returns the errors encountered by user name; a failure for one user does not stop the others.
+func (c *Collection) Warm(users []user.Info) map[string]error {
+	var (
+		lock sync.Mutex
+		wg   sync.WaitGroup
+		errs = map[string]error{}
+		ids  = map[string]bool{}
+		sem  = semaphore.NewWeighted(warmConcurrency)
+	)
+
+	for _, u := range users {
+		id := c.cacheKey(c.as.AccessFor(u), u, "")
+		if capacity := c.cacheCapacity(); capacity > 0 && !ids[id] && len(ids) >= capacity {
+			lock.Lock()
+			errs[u.GetName()] = fmt.Errorf("schema cache capacity of %d access sets reached", capacity)
+			lock.Unlock()
+			continue
+		}
+		ids[id] = true
+
+		if err := sem.Acquire(c.ctx, 1); err != nil {
+			lock.Lock()
+			errs[u.GetName()] = fmt.Errorf("failed to warm schemas: %w", err)
+			lock.Unlock()
+			continue
+		}
+		wg.Add(1)
+		go func(u user.Info) {
+			defer wg.Done()
+			defer sem.Release(1)
+			if _, err := c.SchemasContext(c.ctx, u); err != nil {
+				lock.Lock()
+				errs[u.GetName()] = err
+				lock.Unlock()
+			}
+		}(u)
+	}
+
+	wg.Wait()
+	return errs
+}
+
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// removeOldRecords purges the cached schemas of the user, by its key in the user cache, if they were computed for a
+// different access set, and returns them so they can be used as the base for computing the schemas of the new access
+// set.
+func (c *Collection) removeOldRecords(id string, userKey string) *types.APISchemas {
+	var previous *types.APISchemas
+	current, ok := c.userCache.Get(userKey)
 	if ok {
 		currentID, cOk := current.(string)
-		if cOk && currentID != access.ID {
+		if cOk && currentID != id {
+			if val, ok := c.cache.Get(currentID); ok {
+				previous, _ = val.(*types.APISchemas)
+			}
 			// we only want to keep around one record per user. If our current access record is invalid, purge the
 			//record of it from the cache, so we don't keep duplicates
 			c.purgeUserRecords(currentID)
-			c.userCache.Remove(user.GetName())
+			c.userCache.Remove(userKey)
 		}
 	}
+	return previous
 }
 
-func (c *Collection) addToCache(access *accesscontrol.AccessSet, user user.Info, schemas *types.APISchemas) {
-	c.cache.Add(access.ID, schemas, 24*time.Hour)
-	c.userCache.Add(user.GetName(), access.ID, 24*time.Hour)
+// addToCache adds the schemas computed under the generation of the cache to it, and returns true, unless the cache was
+// cleared since.
+func (c *Collection) addToCache(id string, schemas *types.APISchemas, generation uint64) bool {
+	unlock := c.lockID(id)
+	// c.lock is held while adding, so that the cache can't be cleared between checking the generation and adding
+	c.lock.RLock()
+	if c.generation != generation {
+		c.lock.RUnlock()
+		unlock()
+		return false
+	}
+	var evicted *evictingCacheEntry
+	if ec, ok := c.cache.(*evictingCache); ok {
+		evicted = ec.add(id, schemas, c.cacheTTL())
+	} else {
+		c.cache.Add(id, schemas, c.cacheTTL())
+	}
+	c.lock.RUnlock()
+	unlock()
+	// the evicted entry is cleaned up once the lock is released, since it may share it
+	if evicted != nil {
+		c.onCacheEvict(evicted.key, evicted.value)
+	}
+	c.sendCacheEvent(id, CacheEventAdded)
+	return true
+}
+
+// clearCache removes all the schemas from the cache and starts a new generation of it, so that the schemas being
+// computed are not added to it, and forgets their computations, so that they are not shared with later misses. The
+// caller must hold c.lock.
+func (c *Collection) clearCache() {
+	c.generation++
+	c.computing.Range(func(id, _ interface{}) bool {
+		c.schemasGroup.Forget(id.(string))
+		return true
+	})
+	for _, k := range c.cache.Keys() {
+		c.cache.Remove(k)
+	}
+}
+
+// cacheCapacity returns the number of access sets whose schemas fit in the cache, or zero if it is not known.
+func (c *Collection) cacheCapacity() int {
+	if ec, ok := c.cache.(*evictingCache); ok {
+		return ec.maxSize
+	}
+	return 0
+}
+
+// addUserToCache records the access ID of the user, by its key in the user cache, unless its schemas were purged since
+// they were added.
+func (c *Collection) addUserToCache(id string, userKey string) {
+	unlock := c.lockID(id)
+	defer unlock()
+	if _, ok := c.cache.Get(id); !ok {
+		return
+	}
+	c.userCache.Add(userKey, id, c.cacheTTL())
+}
+
+// cacheKeySeparator separates the access ID from the salt and the cluster ID in a cache key, and the user name from
+// the cluster ID in a key of the user cache. Access IDs are hex encoded hashes, so it never appears in them.
+const cacheKeySeparator = "\x00"
+
+// cacheKey returns the key the schemas of the user are cached under: the ID of the access set, followed by the salt
+// returned by CacheKeySalt if any. The key of the schemas of a cluster is always followed by the salt, even if it is
+// empty, and by the ID of the cluster.
+func (c *Collection) cacheKey(access *accesscontrol.AccessSet, user user.Info, clusterID string) string {
+	var salt string
+	if c.CacheKeySalt != nil {
+		salt = c.CacheKeySalt(user)
+	}
+	if clusterID != "" {
+		return access.ID + cacheKeySeparator + salt + cacheKeySeparator + clusterID
+	}
+	if salt == "" {
+		return access.ID
+	}
+	return access.ID + cacheKeySeparator + salt
+}
+
+// userCacheKey returns the key of the access ID of the user in the cluster in the user cache.
+func userCacheKey(userName, clusterID string) string {
+	if clusterID == "" {
+		return userName
+	}
+	return userName + cacheKeySeparator + clusterID
+}
+
+// accessIDFromCacheKey returns the ID of the access set a cache key was formed from.
+func accessIDFromCacheKey(key string) string {
+	id, _, _ := strings.Cut(key, cacheKeySeparator)
+	return id
+}
+
+// lockID locks the shard of c.idLocks the access ID belongs to, serializing the cache updates for the ID, and
+// returns the function unlocking it.
+func (c *Collection) lockID(id string) func() {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	lock := &c.idLocks[h.Sum32()%uint32(len(c.idLocks))]
+	lock.Lock()
+	return lock.Unlock
+}
+
+func (c *Collection) sendCacheEvent(id string, eventType CacheEventType) {
+	if c.OnCacheEvent == nil {
+		return
+	}
+	c.OnCacheEvent(CacheEvent{
+		AccessID: id,
+		Type:     eventType,
+		Time:     time.Now(),
+	})
+}
+
+// CachedAccessIDs returns the keys of the cached schemas, from least to most recently used. A key is the ID of the
+// access set the schemas were computed for, followed by its salt if CacheKeySalt is set, and by the ID of their
+// cluster if they were computed for one.
+func (c *Collection) CachedAccessIDs() []string {
+	return keysToStrings(c.cache.Keys())
+}
+
+// CachedUsers returns the names of the users whose access set ID is cached, in any cluster, from least to most
+// recently used.
+func (c *Collection) CachedUsers() []string {
+	var (
+		result []string
+		seen   = map[string]bool{}
+	)
+	for _, key := range keysToStrings(c.userCache.Keys()) {
+		name, _, _ := strings.Cut(key, cacheKeySeparator)
+		if !seen[name] {
+			seen[name] = true
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+func keysToStrings(keys []interface{}) []string {
+	result := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if s, ok := key.(string); ok {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// InvalidateUser removes the cached schemas of the given user in every cluster, so they are rebuilt on the next call to
+// Schemas.
+func (c *Collection) InvalidateUser(userName string) {
+	for _, key := range keysToStrings(c.userCache.Keys()) {
+		if key != userName && !strings.HasPrefix(key, userName+cacheKeySeparator) {
+			continue
+		}
+		current, ok := c.userCache.Get(key)
+		if !ok {
+			continue
+		}
+		if currentID, cOk := current.(string); cOk {
+			c.purgeUserRecords(currentID)
+		}
+		c.userCache.Remove(key)
+	}
+}
+
+// onCacheEvict cleans up the records of an access ID whose schemas were evicted from the cache to make room for others,
+// which would otherwise be kept until they expire.
+func (c *Collection) onCacheEvict(key, _ interface{}) {
+	id, ok := key.(string)
+	if !ok {
+		return
+	}
+	unlock := c.lockID(id)
+	for _, userName := range c.userCache.Keys() {
+		if current, ok := c.userCache.Get(userName); ok && current == id {
+			c.userCache.Remove(userName)
+		}
+	}
+	c.purgeUnusedAccessSetData(id)
+	unlock()
+	c.sendCacheEvent(id, CacheEventEvicted)
 }
 
 // PurgeUserRecords removes a record from the backing LRU cache before expiry
 func (c *Collection) purgeUserRecords(id string) {
+	unlock := c.lockID(id)
 	c.cache.Remove(id)
-	c.as.PurgeUserData(id)
+	c.purgeUnusedAccessSetData(id)
+	unlock()
+	c.sendCacheEvent(id, CacheEventPurged)
+}
+
+// purgeUnusedAccessSetData purges the data of the access set of a cache key which was removed, unless the schemas of
+// another salt or cluster are still cached for the same access set, since they share its data.
+func (c *Collection) purgeUnusedAccessSetData(key string) {
+	accessID := accessIDFromCacheKey(key)
+	for _, other := range keysToStrings(c.cache.Keys()) {
+		if other != key && accessIDFromCacheKey(other) == accessID {
+			return
+		}
+	}
+	c.purgeAccessSetData(accessID)
+}
+
+// purgeBatch collects the access set IDs whose data is waiting to be purged from the access set lookup.
+type purgeBatch struct {
+	lock  sync.Mutex
+	ids   map[string]bool
+	timer *time.Timer
+}
+
+// purgeAccessSetData purges the data of the access set ID from the access set lookup. Unless PurgeBatchWindow is
+// zero, the ID is added to the pending batch, which is purged at once when the window started by its first ID
+// elapses. The window is not extended by later IDs, so data is never kept for longer than the window.
+func (c *Collection) purgeAccessSetData(id string) {
+	if c.PurgeBatchWindow <= 0 {
+		c.as.PurgeUserData(id)
+		return
+	}
+
+	c.purges.lock.Lock()
+	defer c.purges.lock.Unlock()
+	if c.purges.ids == nil {
+		c.purges.ids = map[string]bool{}
+	}
+	c.purges.ids[id] = true
+	if c.purges.timer == nil {
+		c.purges.timer = time.AfterFunc(c.PurgeBatchWindow, c.flushAccessSetPurges)
+	}
+}
+
+// flushAccessSetPurges purges the data of the pending batch of access set IDs from the access set lookup.
+func (c *Collection) flushAccessSetPurges() {
+	c.purges.lock.Lock()
+	ids := make([]string, 0, len(c.purges.ids))
+	for id := range c.purges.ids {
+		ids = append(ids, id)
+	}
+	c.purges.ids = nil
+	c.purges.timer = nil
+	c.purges.lock.Unlock()
+
+	if len(ids) == 0 {
+		return
+	}
+	sort.Strings(ids)
+	c.as.PurgeUserDataBulk(ids)
 }
 
-func (c *Collection) schemasForSubject(access *accesscontrol.AccessSet) (*types.APISchemas, error) {
+func (c *Collection) schemasForSubject(ctx context.Context, access *accesscontrol.AccessSet) (*types.APISchemas, error) {
+	return c.schemasForSubjectDelta(ctx, access, nil)
+}
+
+// schemasForSubjectDelta computes the schemas for the access set. If previous is set, the schemas it holds for the
+// group resources whose access did not change since the access set it was computed for are reused instead of being
+// rendered again. The namespaces schema is always rendered since it is derived from the access to every resource.
+func (c *Collection) schemasForSubjectDelta(ctx context.Context, access *accesscontrol.AccessSet, previous *types.APISchemas) (*types.APISchemas, error) {
 	c.lock.RLock()
 	defer c.lock.RUnlock()
 
-	result, err := newSchemas()
+	result, err := cloneSchemas(c.builtinSchemas)
 	if err != nil {
 		return nil, err
 	}
@@ -86,7 +553,39 @@ func (c *Collection) schemasForSubject(access *accesscontrol.AccessSet) (*types.
 		return nil, err
 	}
 
-	for _, s := range c.schemas {
+	blocker := c.methodBlocker
+	if blocker == nil {
+		blocker = DefaultMethodBlocker
+	}
+	clusterAdmin := access.IsClusterAdmin()
+
+	var changed map[schema.GroupResource]bool
+	reuse := false
+	if previous != nil {
+		if previousAccess, ok := AccessSetFromSchemas(previous); ok {
+			var all bool
+			changed, all = access.ChangedGroupResources(previousAccess)
+			reuse = !all
+		}
+	}
+
+	// iterate in a stable order so schemas sharing a plural name are always indexed the same way
+	ids := make([]string, 0, len(c.schemas))
+	for id := range c.schemas {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		s := c.schemas[id]
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("failed to compute schemas: %w", err)
+		}
+
+		if c.isHidden(s) {
+			continue
+		}
+
 		gr := attributes.GR(s)
 
 		if gr.Resource == "" {
@@ -96,92 +595,342 @@ func (c *Collection) schemasForSubject(access *accesscontrol.AccessSet) (*types.
 			continue
 		}
 
-		verbs := attributes.Verbs(s)
-		verbAccess := accesscontrol.AccessListByVerb{}
-
-		for _, verb := range verbs {
-			a := access.AccessListFor(verb, gr)
-			if !attributes.Namespaced(s) {
-				// trim out bad data where we are granted namespaced access to cluster scoped object
-				result := accesscontrol.AccessList{}
-				for _, access := range a {
-					if access.Namespace == accesscontrol.All {
-						result = append(result, access)
-					}
+		if reuse && !changed[gr] && !isNamespaces(gr) {
+			if previousSchema, ok := previous.Schemas[s.ID]; ok {
+				if err := result.AddSchema(*previousSchema); err != nil {
+					return nil, err
 				}
-				a = result
 			}
-			if len(a) > 0 {
-				verbAccess[verb] = a
+			continue
+		}
+
+		s = c.schemaForSubject(access, s, blocker, clusterAdmin)
+		if s == nil {
+			continue
+		}
+
+		if err := result.AddSchema(*s); err != nil {
+			return nil, err
+		}
+	}
+
+	result.Attributes = map[string]interface{}{
+		"accessSet": access,
+	}
+	return result, nil
+}
+
+// AccessSetFromSchemas returns the access set a user's schemas were computed for. It returns false for schemas that
+// were not computed for a user, such as the builtin schemas.
+func AccessSetFromSchemas(apiSchemas *types.APISchemas) (*accesscontrol.AccessSet, bool) {
+	return accesscontrol.AccessSetFromAPISchemas(apiSchemas)
+}
+
+// SchemaLess orders schemas by descending priority, then by ID.
+func SchemaLess(left, right *types.APISchema) bool {
+	leftPriority, rightPriority := attributes.Priority(left), attributes.Priority(right)
+	if leftPriority != rightPriority {
+		return leftPriority > rightPriority
+	}
+	return left.ID < right.ID
+}
+
+func isNamespaces(gr schema.GroupResource) bool {
+	return gr.Group == "" && gr.Resource == "namespaces"
+}
+
+// schemaForSubject renders a copy of the schema with the methods the access set grants, or returns nil if it grants
+// none. If clusterAdmin is set the access set is known to grant everything, so every verb of the schema is granted
+// in all namespaces without looking up the access set. The caller must hold c.lock.
+func (c *Collection) schemaForSubject(access *accesscontrol.AccessSet, s *types.APISchema, blocker MethodBlocker, clusterAdmin bool) *types.APISchema {
+	gr := attributes.GR(s)
+	verbs := attributes.Verbs(s)
+	verbAccess := accesscontrol.AccessListByVerb{}
+
+	for _, verb := range verbs {
+		if clusterAdmin {
+			verbAccess[verb] = accesscontrol.AccessList{{
+				Namespace:    accesscontrol.All,
+				ResourceName: accesscontrol.All,
+			}}
+			continue
+		}
+		a := access.AccessListFor(verb, gr)
+		if !attributes.Namespaced(s) {
+			// trim out bad data where we are granted namespaced access to cluster scoped object
+			result := accesscontrol.AccessList{}
+			for _, access := range a {
+				if access.Namespace == accesscontrol.All {
+					result = append(result, access)
+				}
 			}
+			a = result
+		}
+		if len(a) > 0 {
+			verbAccess[verb] = c.capAccessList(s, verb, a)
 		}
+	}
 
-		if len(verbAccess) == 0 {
-			if gr.Group == "" && gr.Resource == "namespaces" {
-				var accessList accesscontrol.AccessList
+	alwaysAllowList := false
+	if len(verbAccess) == 0 {
+		if isNamespaces(gr) {
+			var accessList accesscontrol.AccessList
+			if c.NamespaceListPolicy == NamespaceListClusterWide && c.hasClusterWideNamespacedAccess(access) {
+				accessList = accesscontrol.AccessList{{
+					Namespace:    accesscontrol.All,
+					ResourceName: accesscontrol.All,
+				}}
+			} else {
 				for _, ns := range access.Namespaces() {
 					accessList = append(accessList, accesscontrol.Access{
 						Namespace:    accesscontrol.All,
 						ResourceName: ns,
 					})
 				}
-				verbAccess["get"] = accessList
-				verbAccess["watch"] = accessList
-				if len(accessList) == 0 {
-					// always allow list
-					s.CollectionMethods = append(s.CollectionMethods, http.MethodGet)
-				}
 			}
+			accessList = c.capAccessList(s, "get", accessList)
+			verbAccess["get"] = accessList
+			verbAccess["watch"] = accessList
+			// always allow list
+			alwaysAllowList = c.AlwaysAllowNamespaceList && len(accessList) == 0
 		}
+	}
 
-		allowed := func(method string) string {
-			if attributes.DisallowMethods(s)[method] {
-				return "blocked-" + method
-			}
-			return method
+	blockedReasons := map[string]string{}
+	allowed := func(method string) string {
+		rendered, reason := blocker(s, method)
+		if reason != "" {
+			blockedReasons[method] = reason
 		}
+		return rendered
+	}
 
-		s = s.DeepCopy()
-		attributes.SetAccess(s, verbAccess)
-		if verbAccess.AnyVerb("list", "get") {
-			s.ResourceMethods = append(s.ResourceMethods, allowed(http.MethodGet))
+	s = s.DeepCopy()
+	delete(s.Attributes, appliedTemplatesAttribute)
+	attributes.SetAccess(s, verbAccess)
+	if verbAccess.AnyVerb("watch") {
+		attributes.SetCanWatch(s, true)
+	}
+	if alwaysAllowList {
+		s.CollectionMethods = append(s.CollectionMethods, http.MethodGet)
+	}
+	if verbAccess.AnyVerb("list", "get") {
+		s.ResourceMethods = append(s.ResourceMethods, allowed(http.MethodGet))
+		if listable(s, verbAccess) {
 			s.CollectionMethods = append(s.CollectionMethods, allowed(http.MethodGet))
 		}
-		if verbAccess.AnyVerb("delete") {
-			s.ResourceMethods = append(s.ResourceMethods, allowed(http.MethodDelete))
+	}
+	if verbAccess.AnyVerb("delete") {
+		s.ResourceMethods = append(s.ResourceMethods, allowed(http.MethodDelete))
+	}
+	if verbAccess.AnyVerb("deletecollection") {
+		s.CollectionMethods = append(s.CollectionMethods, allowed(http.MethodDelete))
+	}
+	if verbAccess.AnyVerb("update") {
+		s.ResourceMethods = append(s.ResourceMethods, allowed(http.MethodPut))
+		s.ResourceMethods = append(s.ResourceMethods, allowed(http.MethodPatch))
+	}
+	if verbAccess.AnyVerb("patch") {
+		s.ResourceMethods = append(s.ResourceMethods, allowed(http.MethodPatch))
+	}
+	if verbAccess.AnyVerb("create") {
+		s.CollectionMethods = append(s.CollectionMethods, allowed(http.MethodPost))
+	}
+
+	if len(s.CollectionMethods) == 0 && len(s.ResourceMethods) == 0 {
+		return nil
+	}
+	if len(blockedReasons) > 0 {
+		attributes.SetBlockedMethodReasons(s, blockedReasons)
+	}
+	s.ResourceMethods = dedupMethods(s.ResourceMethods)
+	s.CollectionMethods = dedupMethods(s.CollectionMethods)
+	return s
+}
+
+// listable returns whether the collection methods of the schema include listing it with the access. Besides the
+// objects granted by list, a list returns the objects granted by name by get, except for namespaced objects granted
+// by name in every namespace, which are not looked up. Listing is not rendered when those are the only objects
+// granted, since the list would always be empty.
+func listable(s *types.APISchema, verbAccess accesscontrol.AccessListByVerb) bool {
+	if len(verbAccess["list"]) > 0 || !attributes.Namespaced(s) {
+		return true
+	}
+	for _, access := range verbAccess["get"] {
+		if access.ResourceName == accesscontrol.All || access.Namespace != accesscontrol.All {
+			return true
 		}
-		if verbAccess.AnyVerb("update") {
-			s.ResourceMethods = append(s.ResourceMethods, allowed(http.MethodPut))
-			s.ResourceMethods = append(s.ResourceMethods, allowed(http.MethodPatch))
+	}
+	return false
+}
+
+// capAccessList enforces c.MaxAccessEntries on the access list rendered for a verb. A list that grants all namespaces
+// and names is collapsed to that single entry, any other list is truncated, which can only drop access.
+func (c *Collection) capAccessList(s *types.APISchema, verb string, a accesscontrol.AccessList) accesscontrol.AccessList {
+	if c.MaxAccessEntries <= 0 || len(a) <= c.MaxAccessEntries {
+		return a
+	}
+	wildcard := accesscontrol.Access{
+		Namespace:    accesscontrol.All,
+		ResourceName: accesscontrol.All,
+	}
+	for _, access := range a {
+		if access == wildcard {
+			return accesscontrol.AccessList{wildcard}
 		}
-		if verbAccess.AnyVerb("create") {
-			s.CollectionMethods = append(s.CollectionMethods, allowed(http.MethodPost))
+	}
+	logrus.Warnf("truncating %d access entries for verb %s on schema %s to %d", len(a), verb, s.ID, c.MaxAccessEntries)
+	return a[:c.MaxAccessEntries:c.MaxAccessEntries]
+}
+
+// MethodBlocker returns how an allowed method is rendered on a user's schema, along with an optional reason that is
+// added to the schema's blockedMethodReasons attribute when the method is blocked.
+type MethodBlocker func(schema *types.APISchema, method string) (rendered string, reason string)
+
+// DefaultMethodBlocker prefixes the methods disallowed on the schema with "blocked-" without giving a reason.
+func DefaultMethodBlocker(schema *types.APISchema, method string) (string, string) {
+	if attributes.DisallowMethods(schema)[method] {
+		return "blocked-" + method, ""
+	}
+	return method, ""
+}
+
+// SetMethodBlocker replaces the function used to render methods the user is granted and discards the cached
+// schemas so they are rendered with it. A nil blocker restores DefaultMethodBlocker.
+func (c *Collection) SetMethodBlocker(blocker MethodBlocker) {
+	c.lock.Lock()
+	c.methodBlocker = blocker
+	c.lock.Unlock()
+	c.Refresh()
+}
+
+// HideSchemas hides the schemas of the resources from every user, regardless of the access they are granted. A
+// resource with an empty version is hidden in all of its versions. The schemas cached for all users are discarded, as
+// with Refresh.
+func (c *Collection) HideSchemas(gvrs ...schema.GroupVersionResource) {
+	c.lock.Lock()
+	if c.hidden == nil {
+		c.hidden = map[schema.GroupVersionResource]bool{}
+	}
+	for _, gvr := range gvrs {
+		c.hidden[gvr] = true
+	}
+	c.lock.Unlock()
+	c.Refresh()
+}
+
+// isHidden returns whether the schema was hidden with HideSchemas. The caller must hold c.lock.
+func (c *Collection) isHidden(s *types.APISchema) bool {
+	if len(c.hidden) == 0 {
+		return false
+	}
+	gvr := attributes.GVR(s)
+	if gvr.Resource == "" {
+		return false
+	}
+	if c.hidden[gvr] {
+		return true
+	}
+	gvr.Version = ""
+	return c.hidden[gvr]
+}
+
+// dedupMethods removes repeated methods while keeping the order in which they first appear.
+func dedupMethods(methods []string) []string {
+	seen := make(map[string]bool, len(methods))
+	result := make([]string, 0, len(methods))
+	for _, method := range methods {
+		if seen[method] {
+			continue
 		}
+		seen[method] = true
+		result = append(result, method)
+	}
+	return result
+}
 
-		if len(s.CollectionMethods) == 0 && len(s.ResourceMethods) == 0 {
+// hasClusterWideNamespacedAccess returns true if the access set grants get or list on a namespaced resource in all
+// namespaces. The caller must hold c.lock.
+func (c *Collection) hasClusterWideNamespacedAccess(access *accesscontrol.AccessSet) bool {
+	for _, s := range c.schemas {
+		gr := attributes.GR(s)
+		if gr.Resource == "" || !attributes.Namespaced(s) {
 			continue
 		}
+		for _, verb := range []string{"get", "list"} {
+			for _, a := range access.AccessListFor(verb, gr) {
+				if a.Namespace == accesscontrol.All {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
 
-		if err := result.AddSchema(*s); err != nil {
-			return nil, err
+// SetDefaultStore sets the store passed to the store factories of the templates, instead of looking it up from the
+// templates registered under c.DefaultStoreKeys. A nil store restores the lookup.
+func (c *Collection) SetDefaultStore(store types.Store) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.defaultStore = store
+}
+
+// getDefaultStore returns the store passed to the store factories of the templates, which is nil if no template
+// under c.DefaultStoreKeys has a store. The caller must hold c.lock.
+func (c *Collection) getDefaultStore() types.Store {
+	if c.defaultStore != nil {
+		return c.defaultStore
+	}
+	for _, key := range c.DefaultStoreKeys {
+		for _, t := range sortedByWeight(c.templates[key]) {
+			if t.Store != nil {
+				return t.Store
+			}
 		}
 	}
+	return nil
+}
 
-	result.Attributes = map[string]interface{}{
-		"accessSet": access,
+// sortedByWeight returns a copy of the templates sorted by ascending weight, keeping the order of equal weights.
+func sortedByWeight(templates []*Template) []*Template {
+	result := make([]*Template, 0, len(templates))
+	for _, t := range templates {
+		if t != nil {
+			result = append(result, t)
+		}
 	}
-	return result, nil
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Weight < result[j].Weight
+	})
+	return result
 }
 
-func (c *Collection) defaultStore() types.Store {
-	templates := c.templates[""]
-	if len(templates) > 0 {
-		return templates[0].Store
+// appliedTemplatesAttribute is the attribute recording the templates applied to a schema.
+const appliedTemplatesAttribute = "appliedTemplates"
+
+// appliedTemplates is the set of templates applied to a schema. Its fields are unexported so it is rendered as an
+// empty object if the schema is serialized.
+type appliedTemplates struct {
+	set map[*Template]bool
+}
+
+// appliedTemplatesOf returns the templates applied to the schema, adding the attribute recording them if needed.
+func appliedTemplatesOf(schema *types.APISchema) *appliedTemplates {
+	if applied, ok := schema.Attributes[appliedTemplatesAttribute].(*appliedTemplates); ok {
+		return applied
 	}
-	return nil
+	applied := &appliedTemplates{set: map[*Template]bool{}}
+	if schema.Attributes == nil {
+		schema.Attributes = map[string]interface{}{}
+	}
+	schema.Attributes[appliedTemplatesAttribute] = applied
+	return applied
 }
 
-func (c *Collection) applyTemplates(schema *types.APISchema) {
+// applyTemplates applies the templates matching the schema to it. Templates already applied to the schema are
+// skipped, so applying them again does not chain their formatters twice.
+func (c *Collection) applyTemplates(schema *types.APISchema) error {
 	c.lock.RLock()
 	defer c.lock.RUnlock()
 
@@ -190,27 +939,61 @@ func (c *Collection) applyTemplates(schema *types.APISchema) {
 		c.templates[fmt.Sprintf("%s/%s", attributes.Group(schema), attributes.Kind(schema))],
 		c.templates[""],
 	}
+	var matched []*Template
+	for _, p := range c.predicates {
+		if p.matches(schema) {
+			matched = append(matched, p.template)
+		}
+	}
+	templates = append(templates, matched)
 
+	applied := appliedTemplatesOf(schema)
 	for _, templates := range templates {
-		for _, t := range templates {
-			if t == nil {
+		for _, t := range sortedByWeight(templates) {
+			if applied.set[t] {
 				continue
 			}
+			applied.set[t] = true
 			if schema.Formatter == nil {
 				schema.Formatter = t.Formatter
 			} else if t.Formatter != nil {
 				schema.Formatter = types.FormatterChain(t.Formatter, schema.Formatter)
 			}
 			if schema.Store == nil {
-				if t.StoreFactory == nil {
+				if factory := t.storeFactory(); factory == nil {
 					schema.Store = t.Store
 				} else {
-					schema.Store = t.StoreFactory(c.defaultStore())
+					schema.Store = factory(schema, c.getDefaultStore())
+				}
+			}
+			if len(t.DefaultProjection) > 0 {
+				attributes.SetDefaultProjection(schema, t.DefaultProjection)
+			}
+			if len(t.Joins) > 0 {
+				attributes.SetJoins(schema, t.Joins)
+			}
+			if t.Authorizer != nil {
+				authorizer := t.Authorizer
+				if existing, ok := attributes.Authorizer(schema).(listprocessor.Authorizer); ok {
+					authorizer = existing.And(authorizer)
 				}
+				attributes.SetAuthorizer(schema, authorizer)
+			}
+			if t.Sensitive {
+				attributes.SetSensitive(schema, true)
+			}
+			if t.Validation != "" {
+				attributes.SetValidation(schema, t.Validation)
 			}
 			if t.Customize != nil {
 				t.Customize(schema)
 			}
+			if t.CustomizeE != nil {
+				if err := t.CustomizeE(schema); err != nil {
+					return fmt.Errorf("failed to customize schema %s: %w", schema.ID, err)
+				}
+			}
 		}
 	}
+	return nil
 }
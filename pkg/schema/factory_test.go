@@ -2,11 +2,32 @@ package schema
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/golang/mock/gomock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rancher/apiserver/pkg/builtin"
+	"github.com/rancher/apiserver/pkg/store/empty"
 	"github.com/rancher/apiserver/pkg/types"
+	"github.com/rancher/steve/pkg/accesscontrol"
+	acfake "github.com/rancher/steve/pkg/accesscontrol/fake"
+	"github.com/rancher/steve/pkg/attributes"
+	"github.com/rancher/steve/pkg/metrics"
+	"github.com/rancher/steve/pkg/stores/partition/listprocessor"
 	"github.com/rancher/wrangler/pkg/schemas"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	k8sSchema "k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apiserver/pkg/authentication/user"
 )
@@ -37,6 +58,42 @@ func TestSchemas(t *testing.T) {
 				errDesired:             false,
 			},
 		},
+		{
+			name: "deletecollection only",
+			config: schemaTestConfig{
+				permissionVerbs:        []string{"deletecollection"},
+				desiredResourceVerbs:   []string{},
+				desiredCollectionVerbs: []string{"DELETE"},
+				errDesired:             false,
+			},
+		},
+		{
+			name: "deletecollection and list",
+			config: schemaTestConfig{
+				permissionVerbs:        []string{"list", "deletecollection"},
+				desiredResourceVerbs:   []string{"GET"},
+				desiredCollectionVerbs: []string{"GET", "DELETE"},
+				errDesired:             false,
+			},
+		},
+		{
+			name: "patch only",
+			config: schemaTestConfig{
+				permissionVerbs:        []string{"patch"},
+				desiredResourceVerbs:   []string{"PATCH"},
+				desiredCollectionVerbs: []string{},
+				errDesired:             false,
+			},
+		},
+		{
+			name: "update and patch",
+			config: schemaTestConfig{
+				permissionVerbs:        []string{"update", "patch"},
+				desiredResourceVerbs:   []string{"PUT", "PATCH"},
+				desiredCollectionVerbs: []string{},
+				errDesired:             false,
+			},
+		},
 	}
 	for _, test := range tests {
 		test := test
@@ -58,6 +115,71 @@ func TestSchemas(t *testing.T) {
 		})
 	}
 }
+func TestCanWatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		verbs    []string
+		canWatch bool
+	}{
+		{name: "get only", verbs: []string{"get", "list"}},
+		{name: "get and watch", verbs: []string{"get", "list", "watch"}, canWatch: true},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			mockLookup := newMockAccessSetLookup()
+			testUser := &user.DefaultInfo{
+				Name:   "testUser",
+				UID:    "testUser",
+				Groups: []string{},
+				Extra:  map[string][]string{},
+			}
+			for _, verb := range test.verbs {
+				mockLookup.AddAccessForUser(testUser, verb, k8sSchema.GroupResource{Group: testGroup, Resource: "testCRD"}, "*", "*")
+			}
+			collection := NewCollection(context.TODO(), types.EmptyAPISchemas(), mockLookup)
+			collection.schemas = map[string]*types.APISchema{"testCRD": makeSchema("testCRD")}
+
+			userSchemas, err := collection.Schemas(testUser)
+			assert.NoError(t, err)
+			s := userSchemas.LookupSchema("testCRD")
+			if assert.NotNil(t, s) {
+				assert.Equal(t, test.canWatch, attributes.CanWatch(s))
+			}
+			assert.False(t, attributes.CanWatch(collection.schemas["testCRD"]), "expected the base schema to not be modified")
+		})
+	}
+}
+
+func TestResourceNamesMethods(t *testing.T) {
+	tests := []struct {
+		name                   string
+		namespace              string
+		desiredCollectionVerbs []string
+	}{
+		{name: "named get in a namespace", namespace: "ns1", desiredCollectionVerbs: []string{"GET"}},
+		{name: "named get in every namespace", namespace: "*", desiredCollectionVerbs: []string{}},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			mockLookup := newMockAccessSetLookup()
+			testUser := &user.DefaultInfo{Name: "testUser", UID: "testUser"}
+			mockLookup.AddAccessForUser(testUser, "get", k8sSchema.GroupResource{Resource: "secrets"}, test.namespace, "secret1")
+			collection := NewCollection(context.TODO(), types.EmptyAPISchemas(), mockLookup)
+			collection.schemas = map[string]*types.APISchema{"secret": makeCoreSchema("secret", "secrets", true)}
+
+			userSchemas, err := collection.Schemas(testUser)
+			assert.NoError(t, err)
+			s := userSchemas.LookupSchema("secret")
+			if assert.NotNil(t, s) {
+				assert.Equal(t, []string{"GET"}, s.ResourceMethods)
+				assert.Equal(t, test.desiredCollectionVerbs, s.CollectionMethods)
+			}
+		})
+	}
+}
+
 func TestSchemaCache(t *testing.T) {
 	// Schemas are a frequently used resource. It's important that the cache doesn't have a leak given size/frequency of resource
 	tests := []struct {
@@ -120,30 +242,1928 @@ func TestSchemaCache(t *testing.T) {
 	}
 }
 
-func runSchemaTest(t *testing.T, config schemaTestConfig, lookup *mockAccessSetLookup, collection *Collection, testUser user.Info) {
-	for _, verb := range config.permissionVerbs {
-		lookup.AddAccessForUser(testUser, verb, k8sSchema.GroupResource{Group: testGroup, Resource: "testCRD"}, "*", "*")
+func TestInvalidateUser(t *testing.T) {
+	mockLookup := newMockAccessSetLookup()
+	testUser := &user.DefaultInfo{
+		Name:   "testUser",
+		UID:    "testUser",
+		Groups: []string{},
+		Extra:  map[string][]string{},
+	}
+	otherUser := &user.DefaultInfo{
+		Name:   "otherUser",
+		UID:    "otherUser",
+		Groups: []string{},
+		Extra:  map[string][]string{},
+	}
+	collection := NewCollection(context.TODO(), types.EmptyAPISchemas(), mockLookup)
+	config := schemaTestConfig{
+		permissionVerbs:        []string{"get"},
+		desiredResourceVerbs:   []string{"GET"},
+		desiredCollectionVerbs: []string{"GET"},
+	}
+	runSchemaTest(t, config, mockLookup, collection, testUser)
+	mockLookup.AddAccessForUser(otherUser, "create", k8sSchema.GroupResource{Group: testGroup, Resource: "testCRD"}, "*", "*")
+	_, err := collection.Schemas(otherUser)
+	assert.NoError(t, err)
+	assert.Len(t, collection.cache.Keys(), 2, "expected cache to be size 2")
+
+	collection.InvalidateUser(testUser.GetName())
+	assert.Len(t, collection.cache.Keys(), 1, "expected cache to be size 1 after invalidation")
+	_, ok := collection.userCache.Get(testUser.GetName())
+	assert.False(t, ok, "expected user record to be removed")
+	_, ok = collection.userCache.Get(otherUser.GetName())
+	assert.True(t, ok, "expected other user record to be kept")
+
+	// invalidating a user that isn't cached is a no-op
+	collection.InvalidateUser("unknownUser")
+	assert.Len(t, collection.cache.Keys(), 1, "expected cache to be size 1")
+
+	runSchemaTest(t, config, mockLookup, collection, testUser)
+	assert.Len(t, collection.cache.Keys(), 2, "expected cache to be size 2 after rebuild")
+}
+
+func TestCacheKeySalt(t *testing.T) {
+	newUser := func(name, impersonator string) *user.DefaultInfo {
+		return &user.DefaultInfo{
+			Name:   name,
+			UID:    name,
+			Groups: []string{},
+			Extra:  map[string][]string{"impersonator": {impersonator}},
+		}
+	}
+	first := newUser("impersonated", "alice")
+	second := newUser("impersonatedToo", "bob")
+	newCollection := func() *Collection {
+		mockLookup := newMockAccessSetLookup()
+		for _, u := range []user.Info{first, second} {
+			mockLookup.AddAccessForUser(u, "get", k8sSchema.GroupResource{Group: testGroup, Resource: "testCRD"}, "*", "*")
+		}
+		assert.Equal(t, mockLookup.AccessFor(first).ID, mockLookup.AccessFor(second).ID)
+		collection := NewCollection(context.TODO(), types.EmptyAPISchemas(), mockLookup)
+		collection.schemas = map[string]*types.APISchema{"testCRD": makeSchema("testCRD")}
+		return collection
+	}
+
+	collection := newCollection()
+	firstSchemas, err := collection.Schemas(first)
+	assert.NoError(t, err)
+	secondSchemas, err := collection.Schemas(second)
+	assert.NoError(t, err)
+	assert.Same(t, firstSchemas, secondSchemas, "expected users with the same access to share schemas without a salt")
+	assert.Len(t, collection.CachedAccessIDs(), 1)
+
+	collection = newCollection()
+	collection.CacheKeySalt = func(u user.Info) string {
+		return strings.Join(u.GetExtra()["impersonator"], ",")
+	}
+	firstSchemas, err = collection.Schemas(first)
+	assert.NoError(t, err)
+	secondSchemas, err = collection.Schemas(second)
+	assert.NoError(t, err)
+	assert.NotSame(t, firstSchemas, secondSchemas, "expected users with different salts to not share schemas")
+	assert.Len(t, collection.CachedAccessIDs(), 2)
+	again, err := collection.Schemas(first)
+	assert.NoError(t, err)
+	assert.Same(t, firstSchemas, again, "expected the salted schemas to be cached")
+
+	access := collection.as.AccessFor(first)
+	accessID := access.ID
+	for _, key := range collection.CachedAccessIDs() {
+		assert.Equal(t, accessID, accessIDFromCacheKey(key))
+	}
+	collection.InvalidateUser(first.GetName())
+	assert.Len(t, collection.CachedAccessIDs(), 1, "expected invalidating a user to only purge its own salted schemas")
+
+	collection.CacheKeySalt = func(user.Info) string { return "" }
+	assert.Equal(t, accessID, collection.cacheKey(access, first, ""), "expected an empty salt to leave the key unchanged")
+}
+
+func TestSchemasForCluster(t *testing.T) {
+	testUser := &user.DefaultInfo{Name: "testUser", UID: "testUser", Groups: []string{}}
+	mockLookup := newMockAccessSetLookup()
+	mockLookup.AddAccessForUser(testUser, "get", k8sSchema.GroupResource{Group: testGroup, Resource: "testCRD"}, "*", "*")
+	collection := NewCollection(context.TODO(), types.EmptyAPISchemas(), mockLookup)
+	collection.PurgeBatchWindow = 0
+	collection.schemas = map[string]*types.APISchema{"testCRD": makeSchema("testCRD")}
+
+	first, err := collection.SchemasForCluster(testUser, "c-first")
+	assert.NoError(t, err)
+	second, err := collection.SchemasForCluster(testUser, "c-second")
+	assert.NoError(t, err)
+	assert.NotSame(t, first, second, "expected the same access in two clusters to not share schemas")
+	local, err := collection.Schemas(testUser)
+	assert.NoError(t, err)
+	assert.NotSame(t, first, local, "expected the schemas of a cluster to not be shared with those without one")
+	assert.Len(t, collection.CachedAccessIDs(), 3)
+	assert.Equal(t, []string{testUser.GetName()}, collection.CachedUsers())
+
+	again, err := collection.SchemasContext(WithClusterID(context.Background(), "c-first"), testUser)
+	assert.NoError(t, err)
+	assert.Same(t, first, again, "expected the schemas of each cluster to be cached")
+
+	accessID := mockLookup.AccessFor(testUser).ID
+	firstKey := collection.cacheKey(mockLookup.AccessFor(testUser), testUser, "c-first")
+	collection.purgeUserRecords(firstKey)
+	assert.Len(t, collection.CachedAccessIDs(), 2, "expected purging the schemas of a cluster to keep the others")
+	assert.NotNil(t, mockLookup.AccessFor(testUser), "expected the access set data to be kept while other clusters use it")
+	again, err = collection.SchemasForCluster(testUser, "c-second")
+	assert.NoError(t, err)
+	assert.Same(t, second, again)
+
+	collection.InvalidateUser(testUser.GetName())
+	assert.Empty(t, collection.CachedAccessIDs(), "expected invalidating a user to purge its schemas in every cluster")
+	assert.Empty(t, collection.CachedUsers())
+	assert.Nil(t, mockLookup.AccessFor(testUser), "expected the data of access set %s to be purged once unused", accessID)
+}
+
+func TestCacheEviction(t *testing.T) {
+	mockLookup := newMockAccessSetLookup()
+	testUser := &user.DefaultInfo{
+		Name:   "testUser",
+		UID:    "testUser",
+		Groups: []string{},
+		Extra:  map[string][]string{},
+	}
+	otherUser := &user.DefaultInfo{
+		Name:   "otherUser",
+		UID:    "otherUser",
+		Groups: []string{},
+		Extra:  map[string][]string{},
+	}
+	mockLookup.AddAccessForUser(testUser, "get", k8sSchema.GroupResource{Group: testGroup, Resource: "testCRD"}, "*", "*")
+	mockLookup.AddAccessForUser(otherUser, "create", k8sSchema.GroupResource{Group: testGroup, Resource: "testCRD"}, "*", "*")
+	collection := NewCollection(context.TODO(), types.EmptyAPISchemas(), mockLookup)
+	collection.cache = newEvictingCache(1, collection.onCacheEvict)
+	collection.PurgeBatchWindow = 10 * time.Millisecond
+	collection.schemas = map[string]*types.APISchema{"testCRD": makeSchema("testCRD")}
+
+	_, err := collection.Schemas(testUser)
+	assert.NoError(t, err)
+	_, err = collection.Schemas(otherUser)
+	assert.NoError(t, err)
+
+	assert.Len(t, collection.cache.Keys(), 1, "expected cache to be size 1")
+	_, ok := collection.userCache.Get(testUser.GetName())
+	assert.False(t, ok, "expected the evicted user's record to be removed")
+	_, ok = collection.userCache.Get(otherUser.GetName())
+	assert.True(t, ok, "expected the other user's record to be kept")
+	assert.Eventually(t, func() bool {
+		return mockLookup.AccessFor(testUser) == nil
+	}, time.Second, time.Millisecond, "expected the evicted access set to be purged")
+}
+
+func TestPurgeBatch(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	lookup := acfake.NewMockAccessSetLookup(ctrl)
+	collection := NewCollection(context.TODO(), types.EmptyAPISchemas(), lookup)
+
+	collection.PurgeBatchWindow = 0
+	lookup.EXPECT().PurgeUserData("a")
+	collection.purgeAccessSetData("a")
+
+	collection.PurgeBatchWindow = 50 * time.Millisecond
+	done := make(chan struct{})
+	lookup.EXPECT().PurgeUserDataBulk([]string{"a", "b", "c"}).Do(func([]string) { close(done) })
+	for _, id := range []string{"b", "a", "c", "a"} {
+		collection.purgeAccessSetData(id)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the batch to be purged once the window elapsed")
+	}
+
+	// a new batch is started after the previous one is purged
+	done = make(chan struct{})
+	lookup.EXPECT().PurgeUserDataBulk([]string{"d"}).Do(func([]string) { close(done) })
+	collection.purgeAccessSetData("d")
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the second batch to be purged once the window elapsed")
 	}
+}
+
+func TestCacheEvents(t *testing.T) {
+	mockLookup := newMockAccessSetLookup()
+	testUser := &user.DefaultInfo{
+		Name:   "testUser",
+		UID:    "testUser",
+		Groups: []string{},
+		Extra:  map[string][]string{},
+	}
+	otherUser := &user.DefaultInfo{
+		Name:   "otherUser",
+		UID:    "otherUser",
+		Groups: []string{},
+		Extra:  map[string][]string{},
+	}
+	mockLookup.AddAccessForUser(testUser, "get", k8sSchema.GroupResource{Group: testGroup, Resource: "testCRD"}, "*", "*")
+	mockLookup.AddAccessForUser(otherUser, "create", k8sSchema.GroupResource{Group: testGroup, Resource: "testCRD"}, "*", "*")
+	testID := mockLookup.AccessFor(testUser).ID
+	otherID := mockLookup.AccessFor(otherUser).ID
+
+	collection := NewCollection(context.TODO(), types.EmptyAPISchemas(), mockLookup)
+	collection.cache = newEvictingCache(1, collection.onCacheEvict)
+	collection.schemas = map[string]*types.APISchema{"testCRD": makeSchema("testCRD")}
+
+	var events []CacheEvent
+	collection.OnCacheEvent = func(event CacheEvent) {
+		// taking the locks would deadlock if they were held while the callback is invoked
+		collection.lock.Lock()
+		collection.lock.Unlock()
+		collection.cache.Keys()
+		events = append(events, event)
+	}
+	before := time.Now()
+	_, err := collection.Schemas(testUser)
+	assert.NoError(t, err)
+	_, err = collection.Schemas(otherUser)
+	assert.NoError(t, err)
+	collection.InvalidateUser(otherUser.GetName())
+
+	want := []CacheEvent{
+		{AccessID: testID, Type: CacheEventAdded},
+		{AccessID: testID, Type: CacheEventEvicted},
+		{AccessID: otherID, Type: CacheEventAdded},
+		{AccessID: otherID, Type: CacheEventPurged},
+	}
+	assert.Len(t, events, len(want))
+	for i := range events {
+		assert.Equal(t, want[i].AccessID, events[i].AccessID)
+		assert.Equal(t, want[i].Type, events[i].Type)
+		assert.False(t, events[i].Time.Before(before), "expected event time to be set")
+	}
+}
+
+func TestWarm(t *testing.T) {
+	mockLookup := newMockAccessSetLookup()
+	var users []user.Info
+	for i, verb := range []string{"get", "get", "create", "delete"} {
+		u := &user.DefaultInfo{
+			Name:   fmt.Sprintf("user%d", i),
+			UID:    fmt.Sprintf("user%d", i),
+			Groups: []string{},
+			Extra:  map[string][]string{},
+		}
+		mockLookup.AddAccessForUser(u, verb, k8sSchema.GroupResource{Group: testGroup, Resource: "testCRD"}, "*", "*")
+		users = append(users, u)
+	}
+	collection := NewCollection(context.TODO(), types.EmptyAPISchemas(), mockLookup)
+	collection.cache = newEvictingCache(2, collection.onCacheEvict)
+	collection.schemas = map[string]*types.APISchema{"testCRD": makeSchema("testCRD")}
 
+	errs := collection.Warm(users)
+	assert.Len(t, errs, 1, "expected only the access set beyond the cache capacity to fail")
+	assert.Error(t, errs["user3"])
+	assert.ElementsMatch(t, []interface{}{mockLookup.AccessFor(users[0]).ID, mockLookup.AccessFor(users[2]).ID}, collection.cache.Keys())
+
+	schemas, err := collection.Schemas(users[2])
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"POST"}, schemas.LookupSchema("testCRD").CollectionMethods)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cancelled := NewCollection(ctx, types.EmptyAPISchemas(), mockLookup)
+	cancelled.schemas = map[string]*types.APISchema{"testCRD": makeSchema("testCRD")}
+	errs = cancelled.Warm(users[:2])
+	assert.Len(t, errs, 2, "expected every user to fail when the collection's context is done")
+	assert.Empty(t, cancelled.cache.Keys())
+}
+
+func TestConcurrentAddAndPurge(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	lookup := acfake.NewMockAccessSetLookup(ctrl)
+	lookup.EXPECT().PurgeUserData(gomock.Any()).AnyTimes()
+	collection := NewCollection(context.TODO(), types.EmptyAPISchemas(), lookup)
+	collection.PurgeBatchWindow = 0
+	access := &accesscontrol.AccessSet{ID: "testID"}
+	testUser := &user.DefaultInfo{Name: "testUser"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				collection.addToCache(access.ID, types.EmptyAPISchemas(), 0)
+				collection.addUserToCache(access.ID, testUser.GetName())
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				// purge the records the way InvalidateUser does
+				collection.purgeUserRecords(access.ID)
+				collection.userCache.Remove(testUser.GetName())
+			}
+		}()
+	}
+	wg.Wait()
+
+	_, userCached := collection.userCache.Get(testUser.GetName())
+	_, schemasCached := collection.cache.Get(access.ID)
+	if userCached {
+		assert.True(t, schemasCached, "expected the user's record to only be kept with its schemas")
+	}
+	collection.purgeUserRecords(access.ID)
+	collection.userCache.Remove(testUser.GetName())
+	collection.addUserToCache(access.ID, testUser.GetName())
+	_, userCached = collection.userCache.Get(testUser.GetName())
+	assert.False(t, userCached, "expected the user's record to not be added after its schemas were purged")
+}
+
+func TestCollectionWithOptions(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	lookup := acfake.NewMockAccessSetLookup(ctrl)
+	schemaCache := newRecordingCache()
+	userCache := newRecordingCache()
+	collection := NewCollectionWithOptions(context.TODO(), types.EmptyAPISchemas(), lookup, CollectionOptions{
+		SchemaCache: schemaCache,
+		UserCache:   userCache,
+	})
 	collection.schemas = map[string]*types.APISchema{"testCRD": makeSchema("testCRD")}
+	testUser := &user.DefaultInfo{Name: "testUser"}
+	access := &accesscontrol.AccessSet{ID: "testID"}
+	access.Add("get", k8sSchema.GroupResource{Group: testGroup, Resource: "testCRD"}, accesscontrol.Access{Namespace: "*", ResourceName: "*"})
+
+	// a hit returns the cached schemas as they are, without computing them
+	cached := types.EmptyAPISchemas()
+	schemaCache.Add(access.ID, cached, time.Hour)
+	userCache.Add(testUser.GetName(), access.ID, time.Hour)
+	lookup.EXPECT().AccessFor(testUser).Return(access)
 	userSchemas, err := collection.Schemas(testUser)
-	if config.errDesired {
-		assert.Error(t, err, "expected error but none was found")
+	assert.NoError(t, err)
+	assert.Same(t, cached, userSchemas)
+	assert.Nil(t, userSchemas.LookupSchema("testCRD"), "expected the cached schemas to not be computed again")
+	assert.Len(t, schemaCache.added, 1, "expected a hit to not add to the cache")
+
+	// a miss computes the schemas and caches them in the injected caches
+	schemaCache.Remove(access.ID)
+	lookup.EXPECT().AccessFor(testUser).Return(access)
+	userSchemas, err = collection.Schemas(testUser)
+	assert.NoError(t, err)
+	assert.NotNil(t, userSchemas.LookupSchema("testCRD"))
+	assert.Equal(t, []interface{}{access.ID, access.ID}, schemaCache.added)
+	id, ok := userCache.Get(testUser.GetName())
+	assert.True(t, ok)
+	assert.Equal(t, access.ID, id)
+	assert.Equal(t, []string{access.ID}, collection.CachedAccessIDs())
+}
+
+func TestCachedAccessIDsAndUsers(t *testing.T) {
+	mockLookup := newMockAccessSetLookup()
+	collection := NewCollection(context.TODO(), types.EmptyAPISchemas(), mockLookup)
+	collection.schemas = map[string]*types.APISchema{"testCRD": makeSchema("testCRD")}
+	assert.Empty(t, collection.CachedAccessIDs())
+	assert.Empty(t, collection.CachedUsers())
+
+	var wantIDs []string
+	for i, verb := range []string{"get", "create"} {
+		u := &user.DefaultInfo{
+			Name:   fmt.Sprintf("user%d", i),
+			UID:    fmt.Sprintf("user%d", i),
+			Groups: []string{},
+			Extra:  map[string][]string{},
+		}
+		mockLookup.AddAccessForUser(u, verb, k8sSchema.GroupResource{Group: testGroup, Resource: "testCRD"}, "*", "*")
+		wantIDs = append(wantIDs, mockLookup.AccessFor(u).ID)
+		_, err := collection.Schemas(u)
+		assert.NoError(t, err)
 	}
-	var testSchema *types.APISchema
-	for schemaName, userSchema := range userSchemas.Schemas {
-		if schemaName == "testCRD" {
-			testSchema = userSchema
+
+	ids := collection.CachedAccessIDs()
+	assert.Equal(t, wantIDs, ids)
+	assert.Equal(t, []string{"user0", "user1"}, collection.CachedUsers())
+
+	ids[0] = "changed"
+	assert.Equal(t, wantIDs, collection.CachedAccessIDs(), "expected a copy of the cached IDs")
+
+	collection.InvalidateUser("user0")
+	assert.Equal(t, wantIDs[1:], collection.CachedAccessIDs())
+	assert.Equal(t, []string{"user1"}, collection.CachedUsers())
+}
+
+func TestFormatterAccessSet(t *testing.T) {
+	mockLookup := newMockAccessSetLookup()
+	readOnly := &user.DefaultInfo{Name: "readOnly"}
+	editor := &user.DefaultInfo{Name: "editor"}
+	gr := k8sSchema.GroupResource{Group: testGroup, Resource: "testCRD"}
+	mockLookup.AddAccessForUser(readOnly, "get", gr, "*", "*")
+	mockLookup.AddAccessForUser(editor, "get", gr, "*", "*")
+	mockLookup.AddAccessForUser(editor, "update", gr, "*", "*")
+
+	collection := NewCollection(context.TODO(), types.EmptyAPISchemas(), mockLookup)
+	collection.AddTemplate(Template{
+		ID: "testCRD",
+		Formatter: func(apiOp *types.APIRequest, resource *types.RawResource) {
+			accessSet, ok := accesscontrol.AccessSetFromContext(apiOp.Context())
+			if !ok || !accessSet.Grants("update", gr, resource.APIObject.Namespace(), resource.APIObject.Name()) {
+				delete(resource.APIObject.Data().Map("spec"), "secret")
+			}
+		},
+	})
+	collection.Reset(map[string]*types.APISchema{"testCRD": makeSchema("testCRD")})
+
+	tests := []struct {
+		name       string
+		user       user.Info
+		wantSecret bool
+	}{
+		{
+			name: "read only user",
+			user: readOnly,
+		},
+		{
+			name:       "user allowed to update",
+			user:       editor,
+			wantSecret: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			userSchemas, err := collection.Schemas(test.user)
+			assert.NoError(t, err)
+			accessSet, ok := AccessSetFromSchemas(userSchemas)
+			assert.True(t, ok)
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req = req.WithContext(accesscontrol.WithAccessSet(req.Context(), accessSet))
+
+			resource := &types.RawResource{
+				APIObject: types.APIObject{
+					Object: map[string]interface{}{
+						"metadata": map[string]interface{}{"name": "test", "namespace": "ns1"},
+						"spec":     map[string]interface{}{"secret": "value", "other": "value"},
+					},
+				},
+			}
+			userSchemas.LookupSchema("testCRD").Formatter(&types.APIRequest{Request: req, Schemas: userSchemas}, resource)
+			spec := resource.APIObject.Data().Map("spec")
+			assert.Equal(t, "value", spec["other"])
+			if test.wantSecret {
+				assert.Equal(t, "value", spec["secret"])
+			} else {
+				assert.NotContains(t, spec, "secret")
+			}
+		})
+	}
+}
+
+type namedStore struct {
+	empty.Store
+	name string
+}
+
+func TestStoreFactory(t *testing.T) {
+	collection := NewCollection(context.TODO(), types.EmptyAPISchemas(), newMockAccessSetLookup())
+	defaultStore := &namedStore{name: "default"}
+	collection.AddTemplate(
+		Template{
+			Store: defaultStore,
+		},
+		Template{
+			ID: "legacy",
+			StoreFactory: func(store types.Store) types.Store {
+				return &namedStore{name: "legacy/" + store.(*namedStore).name}
+			},
+		},
+		Template{
+			ID: "withSchema",
+			StoreFactoryWithSchema: func(schema *types.APISchema, store types.Store) types.Store {
+				return &namedStore{name: schema.ID + "/" + store.(*namedStore).name}
+			},
+		},
+		Template{
+			ID: "both",
+			StoreFactory: func(store types.Store) types.Store {
+				return &namedStore{name: "legacy"}
+			},
+			StoreFactoryWithSchema: func(schema *types.APISchema, store types.Store) types.Store {
+				return &namedStore{name: schema.ID}
+			},
+		},
+	)
+	collection.Reset(map[string]*types.APISchema{
+		"legacy":     makeSchema("legacy"),
+		"withSchema": makeSchema("withSchema"),
+		"both":       makeSchema("both"),
+		"other":      makeSchema("other"),
+	})
+
+	assert.Equal(t, "legacy/default", collection.Schema("legacy").Store.(*namedStore).name)
+	assert.Equal(t, "withSchema/default", collection.Schema("withSchema").Store.(*namedStore).name)
+	assert.Equal(t, "both", collection.Schema("both").Store.(*namedStore).name)
+	assert.Same(t, defaultStore, collection.Schema("other").Store)
+}
+
+func TestDefaultStore(t *testing.T) {
+	var received []types.Store
+	factory := func(store types.Store) types.Store {
+		received = append(received, store)
+		return &namedStore{name: "created"}
+	}
+	baseStore := &namedStore{name: "base"}
+	globalStore := &namedStore{name: "global"}
+	override := &namedStore{name: "override"}
+
+	tests := []struct {
+		name      string
+		templates []Template
+		keys      []string
+		override  types.Store
+		want      types.Store
+	}{
+		{
+			name: "no store registered",
+			templates: []Template{
+				{Customize: func(*types.APISchema) {}},
+			},
+		},
+		{
+			name: "first global template without store",
+			templates: []Template{
+				{Customize: func(*types.APISchema) {}},
+				{Store: globalStore},
+			},
+			want: globalStore,
+		},
+		{
+			name: "fallback to a specific template",
+			templates: []Template{
+				{Customize: func(*types.APISchema) {}},
+				{ID: "base", Store: baseStore},
+			},
+			keys: []string{"", "base"},
+			want: baseStore,
+		},
+		{
+			name: "keys searched in order",
+			templates: []Template{
+				{Store: globalStore},
+				{ID: "base", Store: baseStore},
+			},
+			keys: []string{"base", ""},
+			want: baseStore,
+		},
+		{
+			name: "default store set",
+			templates: []Template{
+				{Store: globalStore},
+			},
+			override: override,
+			want:     override,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			received = nil
+			collection := NewCollection(context.TODO(), types.EmptyAPISchemas(), newMockAccessSetLookup())
+			if test.keys != nil {
+				collection.DefaultStoreKeys = test.keys
+			}
+			collection.AddTemplate(test.templates...)
+			collection.AddTemplate(Template{ID: "testCRD", StoreFactory: factory})
+			collection.SetDefaultStore(test.override)
+
+			assert.NoError(t, collection.Reset(map[string]*types.APISchema{"testCRD": makeSchema("testCRD")}))
+			assert.Equal(t, []types.Store{test.want}, received)
+			assert.Equal(t, "created", collection.Schema("testCRD").Store.(*namedStore).name)
+		})
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	collection := NewCollection(context.TODO(), types.EmptyAPISchemas(), newMockAccessSetLookup())
+	assert.Empty(t, collection.Templates())
+
+	collection.AddTemplate(
+		Template{Store: &namedStore{}},
+		Template{ID: "testCRD", Formatter: func(*types.APIRequest, *types.RawResource) {}, Weight: 1},
+		Template{Group: testGroup, Kind: "TestCRD", StoreFactory: func(store types.Store) types.Store { return store }},
+		Template{ID: "testCRD", CustomizeE: func(*types.APISchema) error { return nil }, Start: func(context.Context) error { return nil }},
+	)
+	collection.AddTemplatePredicate(func(*types.APISchema) bool { return true }, &Template{})
+
+	templates := collection.Templates()
+	assert.Equal(t, map[string][]TemplateInfo{
+		"": {
+			{HasStore: true},
+		},
+		"testCRD": {
+			{ID: "testCRD", Weight: 1, HasFormatter: true},
+			{ID: "testCRD", HasCustomize: true, HasStart: true},
+		},
+		testGroup + "/TestCRD": {
+			{Group: testGroup, Kind: "TestCRD", HasStoreFactory: true},
+		},
+	}, templates)
+
+	templates["testCRD"][0].ID = "changed"
+	delete(templates, "")
+	assert.Equal(t, "testCRD", collection.Templates()["testCRD"][0].ID, "expected a snapshot of the templates")
+	assert.Len(t, collection.Templates()[""], 1)
+}
+
+func TestAddTemplatePredicate(t *testing.T) {
+	collection := NewCollection(context.TODO(), types.EmptyAPISchemas(), newMockAccessSetLookup())
+	var applied []string
+	customize := func(name string) func(*types.APISchema) {
+		return func(s *types.APISchema) {
+			applied = append(applied, s.ID+":"+name)
 		}
 	}
-	assert.NotNil(t, testSchema, "expected a test schema, but was nil")
-	assert.Len(t, testSchema.ResourceMethods, len(config.desiredResourceVerbs), "did not get as many verbs as expected for resource methods")
-	assert.Len(t, testSchema.CollectionMethods, len(config.desiredCollectionVerbs), "did not get as many verbs as expected for resource methods")
-	for _, verb := range config.desiredResourceVerbs {
-		assert.Contains(t, testSchema.ResourceMethods, verb, "did not find %s in resource methods %v", verb, testSchema.ResourceMethods)
+	inGroup := func(s *types.APISchema) bool {
+		return attributes.Group(s) == testGroup
 	}
-	for _, verb := range config.desiredCollectionVerbs {
-		assert.Contains(t, testSchema.CollectionMethods, verb, "did not find %s in resource methods %v", verb, testSchema.CollectionMethods)
+	collection.AddTemplatePredicate(inGroup, &Template{
+		Customize: customize("first predicate"),
+		Store:     &namedStore{name: "first predicate"},
+	})
+	collection.AddTemplate(Template{
+		ID:        "keyed",
+		Customize: customize("keyed"),
+		Store:     &namedStore{name: "keyed"},
+	})
+	collection.AddTemplatePredicate(func(s *types.APISchema) bool {
+		return s.ID != "keyed"
+	}, &Template{
+		Customize: customize("second predicate"),
+		Store:     &namedStore{name: "second predicate"},
+	})
+	collection.AddTemplatePredicate(func(*types.APISchema) bool {
+		return false
+	}, &Template{
+		Customize: customize("not matching"),
+	})
+	collection.AddTemplate(Template{
+		Customize: customize("global"),
+	})
+
+	other := makeSchema("other")
+	attributes.SetGroup(other, "other.k8s.io")
+	collection.Reset(map[string]*types.APISchema{
+		"keyed":   makeSchema("keyed"),
+		"grouped": makeSchema("grouped"),
+		"other":   other,
+	})
+
+	sort.SliceStable(applied, func(i, j int) bool {
+		return strings.Split(applied[i], ":")[0] < strings.Split(applied[j], ":")[0]
+	})
+	assert.Equal(t, []string{
+		"grouped:global",
+		"grouped:first predicate",
+		"grouped:second predicate",
+		"keyed:keyed",
+		"keyed:global",
+		"keyed:first predicate",
+		"other:global",
+		"other:second predicate",
+	}, applied)
+	assert.Equal(t, "keyed", collection.Schema("keyed").Store.(*namedStore).name)
+	assert.Equal(t, "first predicate", collection.Schema("grouped").Store.(*namedStore).name)
+	assert.Equal(t, "second predicate", collection.Schema("other").Store.(*namedStore).name)
+}
+
+func TestApplyTemplatesTwice(t *testing.T) {
+	collection := NewCollection(context.TODO(), types.EmptyAPISchemas(), newMockAccessSetLookup())
+	calls := map[string]int{}
+	formatter := func(name string) types.Formatter {
+		return func(*types.APIRequest, *types.RawResource) {
+			calls[name]++
+		}
+	}
+	collection.AddTemplate(
+		Template{ID: "testCRD", Formatter: formatter("keyed")},
+		Template{Formatter: formatter("global")},
+	)
+	collection.AddTemplatePredicate(func(*types.APISchema) bool { return true }, &Template{Formatter: formatter("predicate")})
+
+	s := makeSchema("testCRD")
+	collection.applyTemplates(s)
+	collection.applyTemplates(s)
+	s.Formatter(&types.APIRequest{}, &types.RawResource{})
+	assert.Equal(t, map[string]int{"keyed": 1, "global": 1, "predicate": 1}, calls)
+
+	// templates added afterwards are still applied
+	collection.AddTemplate(Template{ID: "testCRD", Formatter: formatter("added")})
+	collection.applyTemplates(s)
+	calls = map[string]int{}
+	s.Formatter(&types.APIRequest{}, &types.RawResource{})
+	assert.Equal(t, map[string]int{"keyed": 1, "global": 1, "predicate": 1, "added": 1}, calls)
+
+	_, err := json.Marshal(s.Attributes)
+	assert.NoError(t, err, "expected the applied templates marker to be serializable")
+}
+
+func TestTemplateDefaultProjection(t *testing.T) {
+	collection := NewCollection(context.TODO(), types.EmptyAPISchemas(), newMockAccessSetLookup())
+	collection.AddTemplate(Template{ID: "testCRD", DefaultProjection: []string{"spec.replicas", "status.conditions"}})
+
+	s := makeSchema("testCRD")
+	assert.NoError(t, collection.applyTemplates(s))
+	assert.Equal(t, []string{"spec.replicas", "status.conditions"}, attributes.DefaultProjection(s))
+
+	other := makeSchema("otherCRD")
+	assert.NoError(t, collection.applyTemplates(other))
+	assert.Empty(t, attributes.DefaultProjection(other))
+}
+
+func TestTemplateJoins(t *testing.T) {
+	collection := NewCollection(context.TODO(), types.EmptyAPISchemas(), newMockAccessSetLookup())
+	joins := []listprocessor.Join{{Name: "node", Field: "spec.nodeName", Schema: "node"}}
+	collection.AddTemplate(Template{ID: "pod", Joins: joins})
+
+	s := makeSchema("pod")
+	assert.NoError(t, collection.applyTemplates(s))
+	assert.Equal(t, joins, attributes.Joins(s))
+
+	other := makeSchema("node")
+	assert.NoError(t, collection.applyTemplates(other))
+	assert.Nil(t, attributes.Joins(other))
+}
+
+func TestTemplateAuthorizer(t *testing.T) {
+	collection := NewCollection(context.TODO(), types.EmptyAPISchemas(), newMockAccessSetLookup())
+	byLabel := func(label string) listprocessor.Authorizer {
+		return listprocessor.AuthorizeEach(func(user user.Info, obj *unstructured.Unstructured) bool {
+			return obj.GetLabels()[label] == user.GetName()
+		})
+	}
+	collection.AddTemplate(Template{ID: "testCRD", Authorizer: byLabel("owner")}, Template{ID: "testCRD", Authorizer: byLabel("team")})
+
+	s := makeSchema("testCRD")
+	assert.NoError(t, collection.applyTemplates(s))
+	authorizer, ok := attributes.Authorizer(s).(listprocessor.Authorizer)
+	if assert.True(t, ok) {
+		obj := func(owner, team string) unstructured.Unstructured {
+			obj := unstructured.Unstructured{Object: map[string]interface{}{}}
+			obj.SetLabels(map[string]string{"owner": owner, "team": team})
+			return obj
+		}
+		allowed := authorizer(&user.DefaultInfo{Name: "user1"}, []unstructured.Unstructured{obj("user1", "user1"), obj("user1", "user2")})
+		assert.Equal(t, []bool{true, false}, allowed, "expected the authorizers of every template to allow the objects")
+	}
+	_, err := json.Marshal(s)
+	assert.NoError(t, err, "expected a schema with an authorizer to be serializable")
+
+	other := makeSchema("node")
+	assert.NoError(t, collection.applyTemplates(other))
+	assert.Nil(t, attributes.Authorizer(other))
+}
+
+func TestResetCustomizeError(t *testing.T) {
+	collection := NewCollection(context.TODO(), types.EmptyAPISchemas(), newMockAccessSetLookup())
+	var customized []string
+	collection.AddTemplate(
+		Template{
+			ID: "testCRD",
+			Customize: func(s *types.APISchema) {
+				customized = append(customized, "Customize")
+			},
+			CustomizeE: func(s *types.APISchema) error {
+				customized = append(customized, "CustomizeE")
+				if attributes.Kind(s) == "" {
+					return fmt.Errorf("kind is required")
+				}
+				return nil
+			},
+		},
+	)
+
+	valid := makeSchema("testCRD")
+	attributes.SetKind(valid, "TestCRD")
+	assert.NoError(t, collection.Reset(map[string]*types.APISchema{"testCRD": valid}))
+	assert.Equal(t, []string{"Customize", "CustomizeE"}, customized)
+	assert.Same(t, valid, collection.Schema("testCRD"))
+
+	err := collection.Reset(map[string]*types.APISchema{"testCRD": makeSchema("testCRD")})
+	assert.ErrorContains(t, err, "kind is required")
+	assert.Same(t, valid, collection.Schema("testCRD"), "expected the schemas to be kept when a template fails")
+}
+
+func TestTemplateWeight(t *testing.T) {
+	collection := NewCollection(context.TODO(), types.EmptyAPISchemas(), newMockAccessSetLookup())
+	var calls []string
+	formatter := func(name string) types.Formatter {
+		return func(*types.APIRequest, *types.RawResource) {
+			calls = append(calls, name)
+		}
+	}
+	collection.AddTemplate(
+		Template{Formatter: formatter("heavy"), Weight: 10},
+		Template{Formatter: formatter("first unweighted")},
+		Template{Formatter: formatter("light"), Weight: -10},
+		Template{Formatter: formatter("second unweighted")},
+	)
+
+	s := makeSchema("testCRD")
+	assert.NoError(t, collection.applyTemplates(s))
+	s.Formatter(&types.APIRequest{}, &types.RawResource{})
+	assert.Equal(t, []string{"heavy", "second unweighted", "first unweighted", "light"}, calls,
+		"expected the heavier formatters to wrap the lighter ones and equal weights to keep their order")
+}
+
+func TestHasSynced(t *testing.T) {
+	collection := NewCollection(context.TODO(), types.EmptyAPISchemas(), newMockAccessSetLookup())
+	var calls []string
+	collection.OnSync(func() {
+		assert.True(t, collection.HasSynced(), "expected the collection to be synced in the callback")
+		calls = append(calls, "before")
+	})
+	assert.False(t, collection.HasSynced())
+
+	assert.NoError(t, collection.Reset(map[string]*types.APISchema{}))
+	assert.False(t, collection.HasSynced(), "expected empty schemas to not sync the collection")
+	assert.Empty(t, calls)
+
+	assert.NoError(t, collection.Reset(map[string]*types.APISchema{"testCRD": makeSchema("testCRD")}))
+	assert.True(t, collection.HasSynced())
+	assert.Equal(t, []string{"before"}, calls)
+
+	collection.OnSync(func() {
+		calls = append(calls, "after")
+	})
+	assert.NoError(t, collection.Reset(map[string]*types.APISchema{}))
+	assert.NoError(t, collection.Reset(map[string]*types.APISchema{"testCRD": makeSchema("testCRD")}))
+	assert.True(t, collection.HasSynced())
+	assert.Equal(t, []string{"before", "after"}, calls, "expected the callbacks to be called once")
+}
+
+func TestRefresh(t *testing.T) {
+	mockLookup := newMockAccessSetLookup()
+	testUser := &user.DefaultInfo{
+		Name:   "testUser",
+		UID:    "testUser",
+		Groups: []string{},
+		Extra:  map[string][]string{},
+	}
+	config := schemaTestConfig{
+		permissionVerbs:        []string{"get"},
+		desiredResourceVerbs:   []string{"GET"},
+		desiredCollectionVerbs: []string{"GET"},
+	}
+	collection := NewCollection(context.TODO(), types.EmptyAPISchemas(), mockLookup)
+	runSchemaTest(t, config, mockLookup, collection, testUser)
+	assert.Len(t, collection.cache.Keys(), 1, "expected cache to be size 1")
+	assert.Len(t, collection.userCache.Keys(), 1, "expected user cache to be size 1")
+
+	collection.Refresh()
+	assert.Len(t, collection.cache.Keys(), 0, "expected cache to be empty after refresh")
+	assert.Len(t, collection.userCache.Keys(), 0, "expected user cache to be empty after refresh")
+
+	collection.schemas["newCRD"] = makeSchema("newCRD")
+	mockLookup.AddAccessForUser(testUser, "get", k8sSchema.GroupResource{Group: testGroup, Resource: "newCRD"}, "*", "*")
+	userSchemas, err := collection.Schemas(testUser)
+	assert.NoError(t, err)
+	assert.NotNil(t, userSchemas.LookupSchema("newCRD"), "expected new schema to be present after refresh")
+}
+
+func TestRefreshDuringComputation(t *testing.T) {
+	mockLookup := newMockAccessSetLookup()
+	testUser := &user.DefaultInfo{Name: "testUser", UID: "testUser", Groups: []string{}}
+	mockLookup.AddAccessForUser(testUser, "get", k8sSchema.GroupResource{Group: testGroup, Resource: "testCRD"}, "*", "*")
+	// the first lookup of the shared cache holds the computation until it is released, without holding a lock
+	shared := &blockingSharedCache{
+		fakeSharedCache: newFakeSharedCache(),
+		started:         make(chan struct{}),
+		release:         make(chan struct{}),
+	}
+	collection := NewCollectionWithOptions(context.TODO(), types.EmptyAPISchemas(), mockLookup, CollectionOptions{
+		SharedCache: shared,
+	})
+	collection.schemas = map[string]*types.APISchema{"testCRD": makeSchema("testCRD")}
+	id := mockLookup.AccessFor(testUser).ID
+
+	firstCh := make(chan *types.APISchemas)
+	go func() {
+		userSchemas, err := collection.Schemas(testUser)
+		assert.NoError(t, err)
+		firstCh <- userSchemas
+	}()
+	<-shared.started
+
+	collection.Refresh()
+	second, err := collection.Schemas(testUser)
+	require.NoError(t, err, "expected a computation started after the refresh to not wait for the one before")
+	close(shared.release)
+	first := <-firstCh
+	assert.NotNil(t, first.LookupSchema("testCRD"), "expected the schemas computed before the refresh to be returned")
+	assert.NotSame(t, first, second)
+
+	cached, ok := collection.cache.Get(id)
+	require.True(t, ok)
+	assert.Same(t, second, cached, "expected the schemas computed before the refresh to not be cached")
+}
+
+type blockingSharedCache struct {
+	*fakeSharedCache
+	calls   int32
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingSharedCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	if atomic.AddInt32(&b.calls, 1) == 1 {
+		close(b.started)
+		<-b.release
+	}
+	return b.fakeSharedCache.Get(ctx, key)
+}
+
+func TestOnSchemaEvent(t *testing.T) {
+	collection := NewCollection(context.TODO(), types.EmptyAPISchemas(), newMockAccessSetLookup())
+	ctx, cancel := context.WithCancel(context.Background())
+	var events []SchemaEvent
+	collection.OnSchemaEvent(ctx, func(event SchemaEvent) {
+		assert.Empty(t, collection.cache.Keys(), "expected the cache to be invalidated before the event is sent")
+		event.Time = time.Time{}
+		events = append(events, event)
+	})
+
+	assert.NoError(t, collection.Reset(map[string]*types.APISchema{
+		"testCRD":  makeSchema("testCRD"),
+		"otherCRD": makeSchema("otherCRD"),
+	}))
+	assert.Equal(t, []SchemaEvent{
+		{ID: "otherCRD", Type: SchemaEventCreated},
+		{ID: "testCRD", Type: SchemaEventCreated},
+	}, events)
+
+	events = nil
+	changed := makeSchema("testCRD")
+	changed.ResourceFields["extra"] = schemas.Field{Type: "string"}
+	assert.NoError(t, collection.Reset(map[string]*types.APISchema{
+		"testCRD":  changed,
+		"otherCRD": makeSchema("otherCRD"),
+	}))
+	assert.Equal(t, []SchemaEvent{{ID: "testCRD", Type: SchemaEventUpdated}}, events)
+
+	events = nil
+	assert.NoError(t, collection.Reset(map[string]*types.APISchema{"testCRD": changed}))
+	assert.Equal(t, []SchemaEvent{{ID: "otherCRD", Type: SchemaEventRemoved}}, events)
+
+	events = nil
+	collection.Refresh()
+	assert.Equal(t, []SchemaEvent{{Type: SchemaEventRefreshed}}, events)
+
+	cancel()
+	assert.Eventually(t, func() bool {
+		collection.lock.RLock()
+		defer collection.lock.RUnlock()
+		return len(collection.eventNotifiers) == 0
+	}, time.Second, 10*time.Millisecond, "expected the callback to be removed once its context is done")
+}
+
+func TestSchemasConcurrentMisses(t *testing.T) {
+	mockLookup := newMockAccessSetLookup()
+	var users []user.Info
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("testUser%d", i)
+		testUser := &user.DefaultInfo{
+			Name:   name,
+			UID:    name,
+			Groups: []string{},
+			Extra:  map[string][]string{},
+		}
+		mockLookup.AddAccessForUser(testUser, "get", k8sSchema.GroupResource{Group: testGroup, Resource: "testCRD"}, "*", "*")
+		users = append(users, testUser)
+	}
+	collection := NewCollection(context.TODO(), types.EmptyAPISchemas(), mockLookup)
+	collection.schemas = map[string]*types.APISchema{"testCRD": makeSchema("testCRD")}
+
+	results := make([]*types.APISchemas, len(users))
+	var wg sync.WaitGroup
+	for i := range users {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			schemas, err := collection.Schemas(users[i])
+			assert.NoError(t, err)
+			results[i] = schemas
+		}()
+	}
+	wg.Wait()
+
+	assert.Len(t, collection.cache.Keys(), 1, "expected users sharing access to share a cache entry")
+	for _, result := range results {
+		assert.Same(t, results[0], result, "expected users sharing access to get the same schemas")
+	}
+}
+
+func TestSchemasContextCancelled(t *testing.T) {
+	mockLookup := newMockAccessSetLookup()
+	testUser := &user.DefaultInfo{
+		Name:   "testUser",
+		UID:    "testUser",
+		Groups: []string{},
+		Extra:  map[string][]string{},
+	}
+	mockLookup.AddAccessForUser(testUser, "get", k8sSchema.GroupResource{Group: testGroup, Resource: "testCRD"}, "*", "*")
+	collection := NewCollection(context.TODO(), types.EmptyAPISchemas(), mockLookup)
+	collection.schemas = map[string]*types.APISchema{"testCRD": makeSchema("testCRD")}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := collection.SchemasContext(ctx, testUser)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Len(t, collection.cache.Keys(), 0, "expected nothing to be cached for a cancelled request")
+
+	userSchemas, err := collection.SchemasContext(context.Background(), testUser)
+	assert.NoError(t, err)
+	assert.NotNil(t, userSchemas.LookupSchema("testCRD"))
+}
+
+func TestCacheTimeout(t *testing.T) {
+	mockLookup := newMockAccessSetLookup()
+	testUser := &user.DefaultInfo{
+		Name:   "testUser",
+		UID:    "testUser",
+		Groups: []string{},
+		Extra:  map[string][]string{},
+	}
+	config := schemaTestConfig{
+		permissionVerbs:        []string{"get"},
+		desiredResourceVerbs:   []string{"GET"},
+		desiredCollectionVerbs: []string{"GET"},
+	}
+
+	defaultCollection := NewCollection(context.TODO(), types.EmptyAPISchemas(), mockLookup)
+	assert.Equal(t, CacheTimeout, defaultCollection.CacheTimeout, "expected collection to default to the package timeout")
+
+	collection := NewCollection(context.TODO(), types.EmptyAPISchemas(), mockLookup)
+	collection.CacheTimeout = 10 * time.Millisecond
+	runSchemaTest(t, config, mockLookup, collection, testUser)
+	assert.Len(t, collection.cache.Keys(), 1, "expected cache to be size 1")
+	time.Sleep(20 * time.Millisecond)
+	_, ok := collection.cache.Get(mockLookup.AccessFor(testUser).ID)
+	assert.False(t, ok, "expected cache entry to expire after the collection timeout")
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	mockLookup := newMockAccessSetLookup()
+	testUser := &user.DefaultInfo{Name: "testUser", UID: "testUser", Groups: []string{}}
+	mockLookup.AddAccessForUser(testUser, "get", k8sSchema.GroupResource{Group: testGroup, Resource: "testCRD"}, "*", "*")
+	collection := NewCollection(context.TODO(), types.EmptyAPISchemas(), mockLookup)
+	assert.Zero(t, collection.StaleGracePeriod, "expected no grace period by default")
+	collection.CacheTimeout = 50 * time.Millisecond
+	collection.StaleGracePeriod = time.Hour
+	collection.schemas = map[string]*types.APISchema{"testCRD": makeSchema("testCRD")}
+
+	var (
+		blocking int32
+		started  = make(chan struct{}, 1)
+		release  = make(chan struct{})
+	)
+	collection.SetMethodBlocker(func(schema *types.APISchema, method string) (string, string) {
+		if atomic.LoadInt32(&blocking) == 1 {
+			select {
+			case started <- struct{}{}:
+			default:
+			}
+			<-release
+		}
+		return method, ""
+	})
+
+	first, err := collection.Schemas(testUser)
+	require.NoError(t, err)
+	time.Sleep(60 * time.Millisecond)
+
+	// the stale schemas are returned without waiting for them to be computed again
+	atomic.StoreInt32(&blocking, 1)
+	stale, err := collection.Schemas(testUser)
+	require.NoError(t, err)
+	assert.Same(t, first, stale, "expected the stale schemas to be served within the grace period")
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("expected a stale hit to compute the schemas in the background")
+	}
+	again, err := collection.Schemas(testUser)
+	require.NoError(t, err)
+	assert.Same(t, first, again, "expected the stale schemas to be served while they are computed")
+
+	atomic.StoreInt32(&blocking, 0)
+	close(release)
+	id := mockLookup.AccessFor(testUser).ID
+	require.Eventually(t, func() bool {
+		val, ok := collection.cache.Get(id)
+		return ok && val != first
+	}, time.Second, 10*time.Millisecond, "expected the fresh schemas to replace the stale ones")
+	fresh, err := collection.Schemas(testUser)
+	require.NoError(t, err)
+	assert.NotSame(t, first, fresh)
+	assert.NotNil(t, fresh.LookupSchema("testCRD"))
+	assert.False(t, collection.isStale(fresh))
+}
+
+func TestSchemaCacheMetrics(t *testing.T) {
+	mockLookup := newMockAccessSetLookup()
+	testUser := &user.DefaultInfo{
+		Name:   "testUser",
+		UID:    "testUser",
+		Groups: []string{},
+		Extra:  map[string][]string{},
+	}
+	config := schemaTestConfig{
+		permissionVerbs:        []string{"get"},
+		desiredResourceVerbs:   []string{"GET"},
+		desiredCollectionVerbs: []string{"GET"},
+	}
+	collection := NewCollection(context.TODO(), types.EmptyAPISchemas(), mockLookup)
+
+	hits := testutil.ToFloat64(metrics.SchemaCacheHits)
+	misses := testutil.ToFloat64(metrics.SchemaCacheMisses)
+	runSchemaTest(t, config, mockLookup, collection, testUser)
+	assert.Equal(t, misses+1, testutil.ToFloat64(metrics.SchemaCacheMisses), "expected first lookup to miss")
+	assert.Equal(t, hits, testutil.ToFloat64(metrics.SchemaCacheHits), "expected no hits yet")
+
+	_, err := collection.Schemas(testUser)
+	assert.NoError(t, err)
+	assert.Equal(t, misses+1, testutil.ToFloat64(metrics.SchemaCacheMisses), "expected second lookup to not miss")
+	assert.Equal(t, hits+1, testutil.ToFloat64(metrics.SchemaCacheHits), "expected second lookup to hit")
+}
+
+func TestSchemaCacheSizeMetric(t *testing.T) {
+	mockLookup := newMockAccessSetLookup()
+	testUser := &user.DefaultInfo{Name: "testUser", UID: "testUser", Groups: []string{}}
+	otherUser := &user.DefaultInfo{Name: "otherUser", UID: "otherUser", Groups: []string{}}
+	mockLookup.AddAccessForUser(testUser, "get", k8sSchema.GroupResource{Group: testGroup, Resource: "testCRD"}, "*", "*")
+	mockLookup.AddAccessForUser(otherUser, "create", k8sSchema.GroupResource{Group: testGroup, Resource: "testCRD"}, "*", "*")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	collection := NewCollection(ctx, types.EmptyAPISchemas(), mockLookup)
+	collection.cache = newEvictingCache(1, collection.onCacheEvict)
+	collection.schemas = map[string]*types.APISchema{"testCRD": makeSchema("testCRD")}
+
+	// the caches of the other tests' collections are counted as well, but are not changed by this test
+	base := testutil.ToFloat64(metrics.SchemaCacheSize)
+	size := func() float64 {
+		return testutil.ToFloat64(metrics.SchemaCacheSize) - base
+	}
+
+	_, err := collection.Schemas(testUser)
+	require.NoError(t, err)
+	assert.Equal(t, float64(1), size())
+	_, err = collection.Schemas(otherUser)
+	require.NoError(t, err)
+	assert.Equal(t, float64(1), size(), "expected the evicted schemas to not be counted")
+
+	collection.InvalidateUser(otherUser.GetName())
+	assert.Equal(t, float64(0), size(), "expected the purged schemas to not be counted")
+
+	_, err = collection.Schemas(testUser)
+	require.NoError(t, err)
+	collection.Refresh()
+	assert.Equal(t, float64(0), size(), "expected the refreshed schemas to not be counted")
+
+	_, err = collection.Schemas(testUser)
+	require.NoError(t, err)
+	assert.Equal(t, float64(1), size())
+	cancel()
+	assert.Eventually(t, func() bool {
+		return size() == 0
+	}, time.Second, 10*time.Millisecond, "expected the cache of a stopped collection to not be counted")
+}
+
+func TestSchemasDedupMethods(t *testing.T) {
+	tests := []struct {
+		name                   string
+		permissionVerbs        []string
+		presetMethods          []string
+		disallowMethods        []string
+		desiredResourceVerbs   []string
+		desiredCollectionVerbs []string
+	}{
+		{
+			name:                   "overlapping list and get",
+			permissionVerbs:        []string{"list", "get", "watch"},
+			desiredResourceVerbs:   []string{"GET"},
+			desiredCollectionVerbs: []string{"GET"},
+		},
+		{
+			name:                   "methods already set on the schema",
+			permissionVerbs:        []string{"list", "get", "create"},
+			presetMethods:          []string{"GET"},
+			desiredResourceVerbs:   []string{"GET"},
+			desiredCollectionVerbs: []string{"GET", "POST"},
+		},
+		{
+			name:                   "blocked methods are kept distinct",
+			permissionVerbs:        []string{"list", "get", "update"},
+			presetMethods:          []string{"GET"},
+			disallowMethods:        []string{"GET", "PUT"},
+			desiredResourceVerbs:   []string{"GET", "blocked-GET", "blocked-PUT", "PATCH"},
+			desiredCollectionVerbs: []string{"GET", "blocked-GET"},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			mockLookup := newMockAccessSetLookup()
+			testUser := &user.DefaultInfo{
+				Name:   "testUser",
+				UID:    "testUser",
+				Groups: []string{},
+				Extra:  map[string][]string{},
+			}
+			for _, verb := range test.permissionVerbs {
+				mockLookup.AddAccessForUser(testUser, verb, k8sSchema.GroupResource{Group: testGroup, Resource: "testCRD"}, "*", "*")
+			}
+			testSchema := makeSchema("testCRD")
+			testSchema.ResourceMethods = append(testSchema.ResourceMethods, test.presetMethods...)
+			testSchema.CollectionMethods = append(testSchema.CollectionMethods, test.presetMethods...)
+			if len(test.disallowMethods) > 0 {
+				attributes.AddDisallowMethods(testSchema, test.disallowMethods...)
+			}
+
+			collection := NewCollection(context.TODO(), types.EmptyAPISchemas(), mockLookup)
+			collection.schemas = map[string]*types.APISchema{"testCRD": testSchema}
+			userSchemas, err := collection.Schemas(testUser)
+			assert.NoError(t, err)
+			userSchema := userSchemas.LookupSchema("testCRD")
+			assert.NotNil(t, userSchema, "expected a test schema, but was nil")
+			assert.ElementsMatch(t, test.desiredResourceVerbs, userSchema.ResourceMethods)
+			assert.ElementsMatch(t, test.desiredCollectionVerbs, userSchema.CollectionMethods)
+		})
+	}
+}
+
+func TestSetMethodBlocker(t *testing.T) {
+	mockLookup := newMockAccessSetLookup()
+	testUser := &user.DefaultInfo{
+		Name:   "testUser",
+		UID:    "testUser",
+		Groups: []string{},
+		Extra:  map[string][]string{},
+	}
+	for _, verb := range []string{"get", "delete"} {
+		mockLookup.AddAccessForUser(testUser, verb, k8sSchema.GroupResource{Group: testGroup, Resource: "testCRD"}, "*", "*")
+	}
+	testSchema := makeSchema("testCRD")
+	attributes.AddDisallowMethods(testSchema, "DELETE")
+	collection := NewCollection(context.TODO(), types.EmptyAPISchemas(), mockLookup)
+	collection.schemas = map[string]*types.APISchema{"testCRD": testSchema}
+
+	userSchemas, err := collection.Schemas(testUser)
+	assert.NoError(t, err)
+	userSchema := userSchemas.LookupSchema("testCRD")
+	assert.ElementsMatch(t, []string{"GET", "blocked-DELETE"}, userSchema.ResourceMethods)
+	assert.Nil(t, attributes.BlockedMethodReasons(userSchema), "expected no reasons with the default blocker")
+
+	collection.SetMethodBlocker(func(schema *types.APISchema, method string) (string, string) {
+		if attributes.DisallowMethods(schema)[method] {
+			return "policy-blocked-" + method, "disabled by admin policy"
+		}
+		return method, ""
+	})
+	userSchemas, err = collection.Schemas(testUser)
+	assert.NoError(t, err)
+	userSchema = userSchemas.LookupSchema("testCRD")
+	assert.ElementsMatch(t, []string{"GET", "policy-blocked-DELETE"}, userSchema.ResourceMethods)
+	assert.Equal(t, map[string]string{"DELETE": "disabled by admin policy"}, attributes.BlockedMethodReasons(userSchema))
+
+	collection.SetMethodBlocker(nil)
+	userSchemas, err = collection.Schemas(testUser)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"GET", "blocked-DELETE"}, userSchemas.LookupSchema("testCRD").ResourceMethods)
+}
+
+func TestNamespaceListDoesNotMutateSchema(t *testing.T) {
+	mockLookup := newMockAccessSetLookup()
+	testUser := &user.DefaultInfo{
+		Name:   "testUser",
+		UID:    "testUser",
+		Groups: []string{},
+		Extra:  map[string][]string{},
+	}
+	mockLookup.AddAccessForUser(testUser, "get", k8sSchema.GroupResource{Group: testGroup, Resource: "testCRD"}, "*", "*")
+	nsSchema := makeCoreSchema("namespace", "namespaces", false)
+	collection := NewCollection(context.TODO(), types.EmptyAPISchemas(), mockLookup)
+	collection.schemas = map[string]*types.APISchema{"namespace": nsSchema}
+
+	for i := 0; i < 2; i++ {
+		collection.Refresh()
+		userSchemas, err := collection.Schemas(testUser)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"GET"}, userSchemas.LookupSchema("namespace").CollectionMethods)
+	}
+	assert.Empty(t, nsSchema.CollectionMethods, "expected the collection's schema to be left unchanged")
+}
+
+func TestNamespaceListPolicy(t *testing.T) {
+	tests := []struct {
+		name                string
+		policy              NamespaceListPolicy
+		podNamespace        string
+		wantAccess          accesscontrol.AccessList
+		wantResourceMethods []string
+	}{
+		{
+			name:                "enumerate with cluster wide access",
+			policy:              NamespaceListEnumerate,
+			podNamespace:        "*",
+			wantResourceMethods: []string{},
+		},
+		{
+			name:                "enumerate with namespaced access",
+			policy:              NamespaceListEnumerate,
+			podNamespace:        "ns1",
+			wantAccess:          accesscontrol.AccessList{{Namespace: "*", ResourceName: "ns1"}},
+			wantResourceMethods: []string{"GET"},
+		},
+		{
+			name:                "cluster wide with cluster wide access",
+			policy:              NamespaceListClusterWide,
+			podNamespace:        "*",
+			wantAccess:          accesscontrol.AccessList{{Namespace: "*", ResourceName: "*"}},
+			wantResourceMethods: []string{"GET"},
+		},
+		{
+			name:                "cluster wide with namespaced access",
+			policy:              NamespaceListClusterWide,
+			podNamespace:        "ns1",
+			wantAccess:          accesscontrol.AccessList{{Namespace: "*", ResourceName: "ns1"}},
+			wantResourceMethods: []string{"GET"},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			mockLookup := newMockAccessSetLookup()
+			testUser := &user.DefaultInfo{
+				Name:   "testUser",
+				UID:    "testUser",
+				Groups: []string{},
+				Extra:  map[string][]string{},
+			}
+			mockLookup.AddAccessForUser(testUser, "list", k8sSchema.GroupResource{Resource: "pods"}, test.podNamespace, "*")
+
+			collection := NewCollection(context.TODO(), types.EmptyAPISchemas(), mockLookup)
+			collection.NamespaceListPolicy = test.policy
+			collection.schemas = map[string]*types.APISchema{
+				"namespace": makeCoreSchema("namespace", "namespaces", false),
+				"pod":       makeCoreSchema("pod", "pods", true),
+			}
+			userSchemas, err := collection.Schemas(testUser)
+			assert.NoError(t, err)
+			nsSchema := userSchemas.LookupSchema("namespace")
+			assert.NotNil(t, nsSchema, "expected namespace schema to be present")
+			access := accesscontrol.GetAccessListMap(nsSchema)
+			assert.ElementsMatch(t, test.wantAccess, access["get"])
+			assert.ElementsMatch(t, test.wantAccess, access["watch"])
+			assert.ElementsMatch(t, test.wantResourceMethods, nsSchema.ResourceMethods)
+			assert.Contains(t, nsSchema.CollectionMethods, "GET")
+		})
+	}
+}
+
+func TestAlwaysAllowNamespaceList(t *testing.T) {
+	tests := []struct {
+		name        string
+		alwaysAllow bool
+		wantListed  bool
+	}{
+		{
+			name:        "allowed",
+			alwaysAllow: true,
+			wantListed:  true,
+		},
+		{
+			name:        "not allowed",
+			alwaysAllow: false,
+			wantListed:  false,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			mockLookup := newMockAccessSetLookup()
+			testUser := &user.DefaultInfo{
+				Name:   "testUser",
+				UID:    "testUser",
+				Groups: []string{},
+				Extra:  map[string][]string{},
+			}
+			mockLookup.AddAccessForUser(testUser, "get", k8sSchema.GroupResource{Group: testGroup, Resource: "testCRD"}, "*", "*")
+
+			collection := NewCollection(context.TODO(), types.EmptyAPISchemas(), mockLookup)
+			assert.True(t, collection.AlwaysAllowNamespaceList, "expected namespace list to be allowed by default")
+			collection.AlwaysAllowNamespaceList = test.alwaysAllow
+			collection.schemas = map[string]*types.APISchema{
+				"namespace": makeCoreSchema("namespace", "namespaces", false),
+				"testCRD":   makeSchema("testCRD"),
+			}
+			userSchemas, err := collection.Schemas(testUser)
+			assert.NoError(t, err)
+			nsSchema := userSchemas.LookupSchema("namespace")
+			if !test.wantListed {
+				assert.Nil(t, nsSchema, "expected namespace schema to be omitted")
+				return
+			}
+			assert.NotNil(t, nsSchema, "expected namespace schema to be present")
+			assert.Equal(t, []string{"GET"}, nsSchema.CollectionMethods)
+			assert.Empty(t, nsSchema.ResourceMethods)
+		})
+	}
+}
+
+func TestClusterAdminFastPath(t *testing.T) {
+	mockLookup := newMockAccessSetLookup()
+	adminUser := &user.DefaultInfo{
+		Name:   "adminUser",
+		UID:    "adminUser",
+		Groups: []string{},
+		Extra:  map[string][]string{},
+	}
+	otherUser := &user.DefaultInfo{
+		Name:   "otherUser",
+		UID:    "otherUser",
+		Groups: []string{},
+		Extra:  map[string][]string{},
+	}
+	mockLookup.AddAccessForUser(adminUser, "*", k8sSchema.GroupResource{Group: "*", Resource: "*"}, "*", "*")
+	mockLookup.AddAccessForUser(adminUser, "get", k8sSchema.GroupResource{Resource: "pods"}, "ns1", "*")
+	mockLookup.AddAccessForUser(otherUser, "*", k8sSchema.GroupResource{Group: "*", Resource: "*"}, "ns1", "*")
+	mockLookup.AddAccessForUser(otherUser, "get", k8sSchema.GroupResource{Group: "*", Resource: "*"}, "*", "*")
+	access := mockLookup.AccessFor(adminUser)
+	assert.True(t, access.IsClusterAdmin())
+	assert.False(t, mockLookup.AccessFor(otherUser).IsClusterAdmin())
+	assert.False(t, (*accesscontrol.AccessSet)(nil).IsClusterAdmin())
+
+	collection := NewCollection(context.TODO(), types.EmptyAPISchemas(), mockLookup)
+	collection.schemas = map[string]*types.APISchema{
+		"namespace": makeCoreSchema("namespace", "namespaces", false),
+		"node":      makeCoreSchema("node", "nodes", false),
+		"pod":       makeCoreSchema("pod", "pods", true),
+		"testCRD":   makeSchema("testCRD"),
+	}
+	attributes.AddDisallowMethods(collection.schemas["testCRD"], http.MethodPut)
+
+	userSchemas, err := collection.Schemas(adminUser)
+	assert.NoError(t, err)
+	assert.Len(t, accesscontrol.GetAccessListMap(userSchemas.LookupSchema("pod"))["get"], 1, "expected the fast path to render a single wildcard entry")
+	for id, s := range collection.schemas {
+		want := collection.schemaForSubject(access, s, DefaultMethodBlocker, false)
+		got := userSchemas.LookupSchema(id)
+		assert.NotNil(t, got, "expected schema %s to be present", id)
+		assert.Equal(t, want.ResourceMethods, got.ResourceMethods, "resource methods of %s", id)
+		assert.Equal(t, want.CollectionMethods, got.CollectionMethods, "collection methods of %s", id)
+		assert.Equal(t, attributes.BlockedMethodReasons(want), attributes.BlockedMethodReasons(got))
+
+		wantAccess := accesscontrol.GetAccessListMap(want)
+		gotAccess := accesscontrol.GetAccessListMap(got)
+		assert.Len(t, gotAccess, len(wantAccess), "access verbs of %s", id)
+		for verb := range wantAccess {
+			for _, probe := range []accesscontrol.Access{{Namespace: "*", ResourceName: "*"}, {Namespace: "ns1", ResourceName: "a"}, {Namespace: "ns2", ResourceName: "b"}} {
+				assert.Equal(t, wantAccess.Grants(verb, probe.Namespace, probe.ResourceName), gotAccess.Grants(verb, probe.Namespace, probe.ResourceName), "access to %v for %s on %s", probe, verb, id)
+			}
+		}
+	}
+}
+
+func TestHideSchemas(t *testing.T) {
+	mockLookup := newMockAccessSetLookup()
+	testUser := &user.DefaultInfo{
+		Name:   "testUser",
+		UID:    "testUser",
+		Groups: []string{},
+		Extra:  map[string][]string{},
+	}
+	mockLookup.AddAccessForUser(testUser, "*", k8sSchema.GroupResource{Group: "*", Resource: "*"}, "*", "*")
+	collection := NewCollection(context.TODO(), types.EmptyAPISchemas(), mockLookup)
+	collection.schemas = map[string]*types.APISchema{
+		"pod":     makeCoreSchema("pod", "pods", true),
+		"secret":  makeCoreSchema("secret", "secrets", true),
+		"testCRD": makeSchema("testCRD"),
+	}
+
+	userSchemas, err := collection.Schemas(testUser)
+	assert.NoError(t, err)
+	assert.NotNil(t, userSchemas.LookupSchema("secret"))
+
+	collection.HideSchemas(
+		k8sSchema.GroupVersionResource{Version: "v1", Resource: "secrets"},
+		k8sSchema.GroupVersionResource{Group: testGroup, Resource: "testCRD"},
+	)
+	userSchemas, err = collection.Schemas(testUser)
+	assert.NoError(t, err)
+	assert.Nil(t, userSchemas.LookupSchema("secret"), "expected a hidden schema to not be rendered despite full access")
+	assert.Nil(t, userSchemas.LookupSchema("testCRD"), "expected a schema hidden in all versions to not be rendered")
+	assert.NotNil(t, userSchemas.LookupSchema("pod"))
+	for _, s := range userSchemas.Schemas {
+		resource := attributes.Resource(s)
+		assert.NotContains(t, []string{"secrets", "testCRD"}, resource, "expected no hidden schema in the list")
+	}
+}
+
+func TestSubresourceSchemas(t *testing.T) {
+	mockLookup := newMockAccessSetLookup()
+	logUser := &user.DefaultInfo{
+		Name:   "logUser",
+		UID:    "logUser",
+		Groups: []string{},
+		Extra:  map[string][]string{},
+	}
+	podUser := &user.DefaultInfo{
+		Name:   "podUser",
+		UID:    "podUser",
+		Groups: []string{},
+		Extra:  map[string][]string{},
+	}
+	wildcardUser := &user.DefaultInfo{
+		Name:   "wildcardUser",
+		UID:    "wildcardUser",
+		Groups: []string{},
+		Extra:  map[string][]string{},
+	}
+	mockLookup.AddAccessForUser(logUser, "get", k8sSchema.GroupResource{Resource: "pods/log"}, "*", "*")
+	mockLookup.AddAccessForUser(podUser, "get", k8sSchema.GroupResource{Resource: "pods"}, "*", "*")
+	mockLookup.AddAccessForUser(wildcardUser, "get", k8sSchema.GroupResource{Resource: "pods/*"}, "ns1", "*")
+	mockLookup.AddAccessForUser(wildcardUser, "create", k8sSchema.GroupResource{Resource: "*/exec"}, "ns1", "*")
+
+	collection := NewCollection(context.TODO(), types.EmptyAPISchemas(), mockLookup)
+	collection.schemas = map[string]*types.APISchema{
+		"pod":      makeCoreSchema("pod", "pods", true),
+		"pod.log":  makeCoreSchema("pod.log", "pods/log", true),
+		"pod.exec": makeCoreSchema("pod.exec", "pods/exec", true),
+	}
+
+	userSchemas, err := collection.Schemas(logUser)
+	assert.NoError(t, err)
+	assert.Nil(t, userSchemas.LookupSchema("pod"), "expected access to pods/log to not grant access to pods")
+	assert.Nil(t, userSchemas.LookupSchema("pod.exec"))
+	logSchema := userSchemas.LookupSchema("pod.log")
+	if assert.NotNil(t, logSchema) {
+		assert.Equal(t, []string{http.MethodGet}, logSchema.ResourceMethods)
+		assert.Equal(t, []string{http.MethodGet}, logSchema.CollectionMethods)
+	}
+
+	userSchemas, err = collection.Schemas(podUser)
+	assert.NoError(t, err)
+	assert.NotNil(t, userSchemas.LookupSchema("pod"))
+	assert.Nil(t, userSchemas.LookupSchema("pod.log"), "expected access to pods to not grant access to pods/log")
+
+	userSchemas, err = collection.Schemas(wildcardUser)
+	assert.NoError(t, err)
+	assert.Nil(t, userSchemas.LookupSchema("pod"))
+	logSchema = userSchemas.LookupSchema("pod.log")
+	if assert.NotNil(t, logSchema) {
+		assert.Equal(t, []string{http.MethodGet}, logSchema.ResourceMethods)
+		assert.True(t, accesscontrol.GetAccessListMap(logSchema).Grants("get", "ns1", "a"))
+		assert.False(t, accesscontrol.GetAccessListMap(logSchema).Grants("get", "ns2", "a"))
+	}
+	execSchema := userSchemas.LookupSchema("pod.exec")
+	if assert.NotNil(t, execSchema) {
+		assert.Equal(t, []string{http.MethodGet}, execSchema.ResourceMethods)
+		assert.Equal(t, []string{http.MethodGet, http.MethodPost}, execSchema.CollectionMethods)
+	}
+}
+
+func TestMaxAccessEntries(t *testing.T) {
+	tests := []struct {
+		name       string
+		namespaces []string
+		wildcard   bool
+		max        int
+		wantLen    int
+	}{
+		{
+			name:       "under the cap",
+			namespaces: []string{"ns1", "ns2"},
+			max:        3,
+			wantLen:    2,
+		},
+		{
+			name:       "no cap",
+			namespaces: []string{"ns1", "ns2", "ns3", "ns4"},
+			wantLen:    4,
+		},
+		{
+			name:       "truncated",
+			namespaces: []string{"ns1", "ns2", "ns3", "ns4"},
+			max:        2,
+			wantLen:    2,
+		},
+		{
+			name:       "collapsed to wildcard",
+			namespaces: []string{"ns1", "ns2", "ns3", "ns4"},
+			wildcard:   true,
+			max:        2,
+			wantLen:    1,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			mockLookup := newMockAccessSetLookup()
+			testUser := &user.DefaultInfo{
+				Name:   "testUser",
+				UID:    "testUser",
+				Groups: []string{},
+				Extra:  map[string][]string{},
+			}
+			gr := k8sSchema.GroupResource{Resource: "pods"}
+			for _, ns := range test.namespaces {
+				mockLookup.AddAccessForUser(testUser, "get", gr, ns, "*")
+			}
+			if test.wildcard {
+				mockLookup.AddAccessForUser(testUser, "get", gr, "*", "*")
+			}
+			access := mockLookup.AccessFor(testUser)
+
+			collection := NewCollection(context.TODO(), types.EmptyAPISchemas(), mockLookup)
+			collection.MaxAccessEntries = test.max
+			collection.schemas = map[string]*types.APISchema{
+				"pod": makeCoreSchema("pod", "pods", true),
+			}
+			userSchemas, err := collection.Schemas(testUser)
+			assert.NoError(t, err)
+			podSchema := userSchemas.LookupSchema("pod")
+			assert.NotNil(t, podSchema, "expected pod schema to be present")
+			rendered := accesscontrol.GetAccessListMap(podSchema)["get"]
+			assert.Len(t, rendered, test.wantLen)
+			for _, a := range rendered {
+				assert.True(t, access.Grants("get", gr, a.Namespace, a.ResourceName), "rendered access %v is not granted to the user", a)
+			}
+		})
+	}
+}
+
+func TestSchemasForSubjectDelta(t *testing.T) {
+	type grant struct {
+		verb      string
+		gr        k8sSchema.GroupResource
+		namespace string
+		name      string
+	}
+	resources := []k8sSchema.GroupResource{
+		{Group: testGroup, Resource: "testCRD"},
+		{Group: testGroup, Resource: "otherCRD"},
+		{Group: testGroup, Resource: "clusterCRD"},
+		{Resource: "pods"},
+		{Resource: "namespaces"},
+		{Group: testGroup, Resource: "*"},
+		{Group: "*", Resource: "*"},
+	}
+	verbs := []string{"get", "list", "watch", "create", "update", "delete", "*"}
+	namespaces := []string{"*", "ns1", "ns2"}
+	names := []string{"*", "name1"}
+	randomGrant := func(r *rand.Rand) grant {
+		return grant{
+			verb:      verbs[r.Intn(len(verbs))],
+			gr:        resources[r.Intn(len(resources))],
+			namespace: namespaces[r.Intn(len(namespaces))],
+			name:      names[r.Intn(len(names))],
+		}
+	}
+	toAccessSet := func(id string, grants []grant) *accesscontrol.AccessSet {
+		set := &accesscontrol.AccessSet{ID: id}
+		for _, g := range grants {
+			set.Add(g.verb, g.gr, accesscontrol.Access{Namespace: g.namespace, ResourceName: g.name})
+		}
+		return set
+	}
+
+	collection := NewCollection(context.TODO(), types.EmptyAPISchemas(), newMockAccessSetLookup())
+	clusterSchema := makeSchema("clusterCRD")
+	attributes.SetNamespaced(clusterSchema, false)
+	namespacedSchema := makeSchema("testCRD")
+	attributes.SetNamespaced(namespacedSchema, true)
+	collection.schemas = map[string]*types.APISchema{
+		"testCRD":    namespacedSchema,
+		"otherCRD":   makeSchema("otherCRD"),
+		"clusterCRD": clusterSchema,
+		"pod":        makeCoreSchema("pod", "pods", true),
+		"namespace":  makeCoreSchema("namespace", "namespaces", false),
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		var previousGrants []grant
+		for j := r.Intn(6); j > 0; j-- {
+			previousGrants = append(previousGrants, randomGrant(r))
+		}
+		var nextGrants []grant
+		for _, g := range previousGrants {
+			if r.Intn(4) != 0 {
+				nextGrants = append(nextGrants, g)
+			}
+		}
+		for j := r.Intn(3); j > 0; j-- {
+			nextGrants = append(nextGrants, randomGrant(r))
+		}
+
+		previous, err := collection.schemasForSubject(context.Background(), toAccessSet("previous", previousGrants))
+		assert.NoError(t, err)
+		next := toAccessSet("next", nextGrants)
+		full, err := collection.schemasForSubject(context.Background(), next)
+		assert.NoError(t, err)
+		delta, err := collection.schemasForSubjectDelta(context.Background(), next, previous)
+		assert.NoError(t, err)
+
+		assert.Len(t, delta.Schemas, len(full.Schemas), "iteration %d: expected the same schemas, previous %v next %v", i, previousGrants, nextGrants)
+		for id, fullSchema := range full.Schemas {
+			deltaSchema := delta.Schemas[id]
+			if !assert.NotNil(t, deltaSchema, "iteration %d: expected schema %s, previous %v next %v", i, id, previousGrants, nextGrants) {
+				continue
+			}
+			assert.ElementsMatch(t, fullSchema.ResourceMethods, deltaSchema.ResourceMethods, "iteration %d: resource methods of %s", i, id)
+			assert.ElementsMatch(t, fullSchema.CollectionMethods, deltaSchema.CollectionMethods, "iteration %d: collection methods of %s", i, id)
+			fullAccess := accesscontrol.GetAccessListMap(fullSchema)
+			deltaAccess := accesscontrol.GetAccessListMap(deltaSchema)
+			assert.Len(t, deltaAccess, len(fullAccess), "iteration %d: access of %s", i, id)
+			for verb, accessList := range fullAccess {
+				assert.ElementsMatch(t, accessList, deltaAccess[verb], "iteration %d: %s access of %s", i, verb, id)
+			}
+		}
+	}
+}
+
+func TestAccessSetFromSchemas(t *testing.T) {
+	mockLookup := newMockAccessSetLookup()
+	testUser := &user.DefaultInfo{
+		Name:   "testUser",
+		UID:    "testUser",
+		Groups: []string{},
+		Extra:  map[string][]string{},
+	}
+	mockLookup.AddAccessForUser(testUser, "get", k8sSchema.GroupResource{Group: testGroup, Resource: "testCRD"}, "*", "*")
+	collection := NewCollection(context.TODO(), types.EmptyAPISchemas(), mockLookup)
+	collection.schemas = map[string]*types.APISchema{"testCRD": makeSchema("testCRD")}
+
+	userSchemas, err := collection.Schemas(testUser)
+	assert.NoError(t, err)
+	accessSet, ok := AccessSetFromSchemas(userSchemas)
+	assert.True(t, ok)
+	assert.Same(t, mockLookup.AccessFor(testUser), accessSet)
+
+	_, ok = AccessSetFromSchemas(newBuiltinSchemas())
+	assert.False(t, ok, "expected no access set on builtin schemas")
+	_, ok = AccessSetFromSchemas(nil)
+	assert.False(t, ok, "expected no access set on nil schemas")
+}
+
+func TestCloneSchemas(t *testing.T) {
+	base := newBuiltinSchemas()
+	first, err := cloneSchemas(base)
+	assert.NoError(t, err)
+	second, err := cloneSchemas(base)
+	assert.NoError(t, err)
+
+	s := first.LookupSchema("schema")
+	s.CollectionMethods = append(s.CollectionMethods, http.MethodPost)
+	s.ResourceMethods = append(s.ResourceMethods, http.MethodDelete)
+	attributes.SetPriority(s, 10)
+
+	for _, apiSchemas := range []*types.APISchemas{base, second} {
+		s := apiSchemas.LookupSchema("schema")
+		assert.Equal(t, []string{http.MethodGet}, s.CollectionMethods)
+		assert.Equal(t, []string{http.MethodGet}, s.ResourceMethods)
+		assert.Equal(t, []string{http.MethodGet}, s.CollectionMethods[:cap(s.CollectionMethods)])
+		assert.Equal(t, 0, attributes.Priority(s))
+	}
+	assert.Len(t, second.Schemas, len(builtin.Schemas.Schemas))
+	assert.NotNil(t, second.LookupSchema("schemas"), "expected clone to be indexed by plural name")
+}
+
+func BenchmarkBuiltinSchemas(b *testing.B) {
+	b.Run("rebuild", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			apiSchemas := types.EmptyAPISchemas()
+			if err := apiSchemas.AddSchemas(builtin.Schemas); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("clone", func(b *testing.B) {
+		base := newBuiltinSchemas()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := cloneSchemas(base); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestSchemasStableOrder(t *testing.T) {
+	mockLookup := newMockAccessSetLookup()
+	testUser := &user.DefaultInfo{
+		Name:   "testUser",
+		UID:    "testUser",
+		Groups: []string{},
+		Extra:  map[string][]string{},
+	}
+	mockLookup.AddAccessForUser(testUser, "get", k8sSchema.GroupResource{Group: "*", Resource: "*"}, "*", "*")
+	collection := NewCollection(context.TODO(), types.EmptyAPISchemas(), mockLookup)
+	collection.schemas = map[string]*types.APISchema{}
+	for _, id := range []string{"a.widget", "b.widget", "c.widget", "d.widget"} {
+		s := makeSchema(id)
+		s.PluralName = "widgets"
+		collection.schemas[id] = s
+	}
+
+	for i := 0; i < 20; i++ {
+		collection.Refresh()
+		userSchemas, err := collection.Schemas(testUser)
+		assert.NoError(t, err)
+		assert.Equal(t, "d.widget", userSchemas.LookupSchema("widgets").ID, "expected schemas sharing a plural name to resolve the same way")
+	}
+}
+
+func TestSchemaLess(t *testing.T) {
+	low := makeSchema("a")
+	high := makeSchema("b")
+	attributes.SetPriority(high, 1)
+	other := makeSchema("c")
+
+	assert.True(t, SchemaLess(high, low), "expected higher priority first")
+	assert.False(t, SchemaLess(low, high))
+	assert.True(t, SchemaLess(low, other), "expected ID order for equal priorities")
+	assert.False(t, SchemaLess(other, low))
+}
+
+func TestParseCacheTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{name: "bare integer is hours", value: "720", want: 720 * time.Hour},
+		{name: "go duration", value: "90m", want: 90 * time.Minute},
+		{name: "go duration in hours", value: "720h", want: 720 * time.Hour},
+		{name: "days", value: "30d", want: 30 * 24 * time.Hour},
+		{name: "invalid", value: "forever", wantErr: true},
+		{name: "invalid days", value: "xd", wantErr: true},
+		{name: "zero", value: "0", wantErr: true},
+		{name: "negative", value: "-1h", wantErr: true},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := parseCacheTimeout(test.value)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func runSchemaTest(t *testing.T, config schemaTestConfig, lookup *mockAccessSetLookup, collection *Collection, testUser user.Info) {
+	for _, verb := range config.permissionVerbs {
+		lookup.AddAccessForUser(testUser, verb, k8sSchema.GroupResource{Group: testGroup, Resource: "testCRD"}, "*", "*")
+	}
+
+	collection.schemas = map[string]*types.APISchema{"testCRD": makeSchema("testCRD")}
+	userSchemas, err := collection.Schemas(testUser)
+	if config.errDesired {
+		assert.Error(t, err, "expected error but none was found")
+	}
+	var testSchema *types.APISchema
+	for schemaName, userSchema := range userSchemas.Schemas {
+		if schemaName == "testCRD" {
+			testSchema = userSchema
+		}
+	}
+	assert.NotNil(t, testSchema, "expected a test schema, but was nil")
+	assert.Len(t, testSchema.ResourceMethods, len(config.desiredResourceVerbs), "did not get as many verbs as expected for resource methods")
+	assert.Len(t, testSchema.CollectionMethods, len(config.desiredCollectionVerbs), "did not get as many verbs as expected for resource methods")
+	for _, verb := range config.desiredResourceVerbs {
+		assert.Contains(t, testSchema.ResourceMethods, verb, "did not find %s in resource methods %v", verb, testSchema.ResourceMethods)
+	}
+	for _, verb := range config.desiredCollectionVerbs {
+		assert.Contains(t, testSchema.CollectionMethods, verb, "did not find %s in resource methods %v", verb, testSchema.CollectionMethods)
+	}
+}
+
+func makeCoreSchema(id, resource string, namespaced bool) *types.APISchema {
+	return &types.APISchema{
+		Schema: &schemas.Schema{
+			ID:                id,
+			CollectionMethods: []string{},
+			ResourceMethods:   []string{},
+			Attributes: map[string]interface{}{
+				"group":      "",
+				"version":    "v1",
+				"resource":   resource,
+				"namespaced": namespaced,
+				"verbs":      []string{"get", "list", "watch", "delete", "update", "create"},
+			},
+		},
 	}
 }
 
@@ -161,7 +2181,7 @@ func makeSchema(resourceType string) *types.APISchema {
 				"group":    testGroup,
 				"version":  testVersion,
 				"resource": resourceType,
-				"verbs":    []string{"get", "list", "watch", "delete", "update", "create"},
+				"verbs":    []string{"get", "list", "watch", "delete", "deletecollection", "update", "patch", "create"},
 			},
 		},
 	}
@@ -108,3 +108,18 @@ func (mr *MockFactoryMockRecorder) Schemas(arg0 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Schemas", reflect.TypeOf((*MockFactory)(nil).Schemas), arg0)
 }
+
+// SchemasContext mocks base method.
+func (m *MockFactory) SchemasContext(arg0 context.Context, arg1 user.Info) (*types.APISchemas, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SchemasContext", arg0, arg1)
+	ret0, _ := ret[0].(*types.APISchemas)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SchemasContext indicates an expected call of SchemasContext.
+func (mr *MockFactoryMockRecorder) SchemasContext(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SchemasContext", reflect.TypeOf((*MockFactory)(nil).SchemasContext), arg0, arg1)
+}
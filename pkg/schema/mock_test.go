@@ -1,9 +1,12 @@
 package schema
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"hash"
+	"sync"
+	"time"
 
 	"github.com/rancher/steve/pkg/accesscontrol"
 	"k8s.io/apimachinery/pkg/runtime/schema"
@@ -16,6 +19,7 @@ const (
 )
 
 type mockAccessSetLookup struct {
+	lock        sync.Mutex
 	accessSets  map[string]*accesscontrol.AccessSet
 	currentHash map[string]hash.Hash
 }
@@ -28,6 +32,8 @@ func newMockAccessSetLookup() *mockAccessSetLookup {
 }
 
 func (m *mockAccessSetLookup) AccessFor(user user.Info) *accesscontrol.AccessSet {
+	m.lock.Lock()
+	defer m.lock.Unlock()
 	if set, ok := m.accessSets[user.GetName()]; ok {
 		return set
 	}
@@ -35,6 +41,8 @@ func (m *mockAccessSetLookup) AccessFor(user user.Info) *accesscontrol.AccessSet
 }
 
 func (m *mockAccessSetLookup) PurgeUserData(id string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
 	var foundKey string
 	for key, value := range m.accessSets {
 		if value.ID == id {
@@ -46,7 +54,15 @@ func (m *mockAccessSetLookup) PurgeUserData(id string) {
 	}
 }
 
+func (m *mockAccessSetLookup) PurgeUserDataBulk(ids []string) {
+	for _, id := range ids {
+		m.PurgeUserData(id)
+	}
+}
+
 func (m *mockAccessSetLookup) AddAccessForUser(user user.Info, verb string, gr schema.GroupResource, namespace string, name string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
 	currentAccessSet, ok := m.accessSets[user.GetName()]
 	var currentHash hash.Hash
 	if !ok {
@@ -63,6 +79,8 @@ func (m *mockAccessSetLookup) AddAccessForUser(user user.Info, verb string, gr s
 }
 
 func (m *mockAccessSetLookup) Clear() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
 	m.accessSets = map[string]*accesscontrol.AccessSet{}
 	m.currentHash = map[string]hash.Hash{}
 }
@@ -73,3 +91,81 @@ func calculateAccessSetID(digest hash.Hash, verb string, gr schema.GroupResource
 	digest.Write([]byte(namespace + insideSeparator))
 	digest.Write([]byte(name + outsideSeparator))
 }
+
+// recordingCache is a Cache that records the keys added to it and never expires its entries.
+type recordingCache struct {
+	lock    sync.Mutex
+	entries map[interface{}]interface{}
+	added   []interface{}
+}
+
+func newRecordingCache() *recordingCache {
+	return &recordingCache{entries: map[interface{}]interface{}{}}
+}
+
+func (r *recordingCache) Add(key interface{}, value interface{}, _ time.Duration) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.entries[key] = value
+	r.added = append(r.added, key)
+}
+
+func (r *recordingCache) Get(key interface{}) (interface{}, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	value, ok := r.entries[key]
+	return value, ok
+}
+
+func (r *recordingCache) Remove(key interface{}) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.entries, key)
+}
+
+func (r *recordingCache) Keys() []interface{} {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	keys := make([]interface{}, 0, len(r.entries))
+	for key := range r.entries {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// fakeSharedCache is an in-memory SharedCache, standing for a backend shared by several replicas.
+type fakeSharedCache struct {
+	lock    sync.Mutex
+	entries map[string][]byte
+	err     error
+}
+
+func newFakeSharedCache() *fakeSharedCache {
+	return &fakeSharedCache{entries: map[string][]byte{}}
+}
+
+func (f *fakeSharedCache) Get(_ context.Context, key string) ([]byte, bool, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.err != nil {
+		return nil, false, f.err
+	}
+	value, ok := f.entries[key]
+	return value, ok, nil
+}
+
+func (f *fakeSharedCache) Set(_ context.Context, key string, value []byte, _ time.Duration) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	f.entries[key] = value
+	return nil
+}
+
+func (f *fakeSharedCache) len() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return len(f.entries)
+}
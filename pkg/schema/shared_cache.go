@@ -0,0 +1,210 @@
+package schema
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/rancher/apiserver/pkg/types"
+	"github.com/rancher/steve/pkg/accesscontrol"
+	"github.com/rancher/steve/pkg/attributes"
+	"github.com/sirupsen/logrus"
+)
+
+// sharedKeyPrefix is the prefix of the keys the schemas are stored under in a SharedCache.
+const sharedKeyPrefix = "steve-schemas/"
+
+// SharedCache is a cache shared by the replicas of a deployment, such as Redis, so that the schemas computed for an
+// access set by one replica are reused by the others instead of being computed again. A Collection only uses it when
+// its in-memory cache misses, and keeps the schemas it gets from it in memory.
+//
+// The entries are keyed by the access set, and by a digest of the schemas of the collection, so replicas only share
+// the schemas of access sets computed from the same schemas, and entries computed from previous schemas are left to
+// expire. Refresh, InvalidateUser and SetMethodBlocker only discard the in-memory cache: replicas sharing a cache must
+// be configured with the same method blocker.
+type SharedCache interface {
+	// Get returns the value stored at the key, or false if there is none.
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set stores the value at the key for the ttl.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+// sharedSchemas is the encoding of a user's schemas in a SharedCache. Only what is rendered for the user is encoded,
+// by schema ID, since the schemas carry stores, formatters and attributes that can't be serialized: the rest is taken
+// from the schemas of the collection when they are decoded.
+type sharedSchemas struct {
+	Schemas map[string]sharedSchema `json:"schemas"`
+}
+
+type sharedSchema struct {
+	ResourceMethods      []string                       `json:"resourceMethods"`
+	CollectionMethods    []string                       `json:"collectionMethods"`
+	Access               accesscontrol.AccessListByVerb `json:"access"`
+	CanWatch             bool                           `json:"canWatch,omitempty"`
+	BlockedMethodReasons map[string]string              `json:"blockedMethodReasons,omitempty"`
+}
+
+// sharedKey returns the key the schemas cached under id are stored under in the shared cache, along with the digest
+// of the collection's schemas it includes.
+func (c *Collection) sharedKey(id string) (string, string) {
+	c.lock.RLock()
+	digest := c.sharedDigest()
+	c.lock.RUnlock()
+	// the ID is hashed since the salt and the cluster ID may hold anything
+	sum := sha256.Sum256([]byte(id))
+	return sharedKeyPrefix + digest + "/" + hex.EncodeToString(sum[:]), digest
+}
+
+// sharedDigest returns a digest of everything a user's schemas are rendered from besides the access set: the schemas
+// of the collection that are not hidden, and the options changing how they are rendered. The caller must hold c.lock.
+func (c *Collection) sharedDigest() string {
+	ids := make([]string, 0, len(c.schemas))
+	for id := range c.schemas {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%d %d %t\n", c.MaxAccessEntries, c.NamespaceListPolicy, c.AlwaysAllowNamespaceList)
+	for _, id := range ids {
+		s := c.schemas[id]
+		if c.isHidden(s) {
+			continue
+		}
+		fmt.Fprintf(h, "%s %s %v %t %v %v %v\n", id, attributes.GVR(s), attributes.Verbs(s), attributes.Namespaced(s),
+			s.ResourceMethods, s.CollectionMethods, attributes.DisallowMethods(s))
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// loadShared returns the schemas stored in the shared cache for the access set, or nil if there are none or they
+// can't be used. Errors of the shared cache are logged, the schemas are then computed as if it had none.
+func (c *Collection) loadShared(ctx context.Context, key, digest string, access *accesscontrol.AccessSet) *types.APISchemas {
+	data, ok, err := c.sharedCache.Get(ctx, key)
+	if err != nil {
+		logrus.WithField("accessID", access.ID).Warnf("failed to get schemas from the shared cache: %v", err)
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+	schemas, err := c.decodeShared(data, digest, access)
+	if err != nil {
+		logrus.WithField("accessID", access.ID).Warnf("failed to decode schemas from the shared cache: %v", err)
+		return nil
+	}
+	return schemas
+}
+
+// storeShared stores the schemas computed for the access set in the shared cache, unless the collection's schemas
+// changed since the key was computed.
+func (c *Collection) storeShared(ctx context.Context, key, digest string, access *accesscontrol.AccessSet, schemas *types.APISchemas) {
+	data, err := c.encodeShared(schemas, digest)
+	if err != nil {
+		logrus.WithField("accessID", access.ID).Warnf("failed to encode schemas for the shared cache: %v", err)
+		return
+	}
+	if data == nil {
+		return
+	}
+	if err := c.sharedCache.Set(ctx, key, data, c.CacheTimeout); err != nil {
+		logrus.WithField("accessID", access.ID).Warnf("failed to store schemas in the shared cache: %v", err)
+	}
+}
+
+// encodeShared encodes what was rendered for the user in the schemas, or returns nil if the collection's schemas no
+// longer match the digest.
+func (c *Collection) encodeShared(schemas *types.APISchemas, digest string) ([]byte, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.sharedDigest() != digest {
+		return nil, nil
+	}
+
+	shared := sharedSchemas{Schemas: map[string]sharedSchema{}}
+	for id, s := range schemas.Schemas {
+		base, ok := c.schemas[id]
+		if !ok || attributes.GR(base).Resource == "" {
+			continue
+		}
+		access, _ := attributes.Access(s).(accesscontrol.AccessListByVerb)
+		shared.Schemas[id] = sharedSchema{
+			ResourceMethods:      s.ResourceMethods,
+			CollectionMethods:    s.CollectionMethods,
+			Access:               access,
+			CanWatch:             attributes.CanWatch(s),
+			BlockedMethodReasons: attributes.BlockedMethodReasons(s),
+		}
+	}
+	return json.Marshal(shared)
+}
+
+// decodeShared rebuilds the schemas of the access set from the collection's schemas and what was rendered for them, in
+// the same order as schemasForSubjectDelta, and reattaches the access set, which is not encoded.
+func (c *Collection) decodeShared(data []byte, digest string, access *accesscontrol.AccessSet) (*types.APISchemas, error) {
+	var shared sharedSchemas
+	if err := json.Unmarshal(data, &shared); err != nil {
+		return nil, err
+	}
+
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.sharedDigest() != digest {
+		return nil, fmt.Errorf("schemas changed since key %s was computed", digest)
+	}
+
+	result, err := cloneSchemas(c.builtinSchemas)
+	if err != nil {
+		return nil, err
+	}
+	if err := result.AddSchemas(c.baseSchema); err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(c.schemas))
+	for id := range c.schemas {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		s := c.schemas[id]
+		if c.isHidden(s) {
+			continue
+		}
+		if attributes.GR(s).Resource == "" {
+			if err := result.AddSchema(*s); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		rendered, ok := shared.Schemas[id]
+		if !ok {
+			continue
+		}
+		s = s.DeepCopy()
+		delete(s.Attributes, appliedTemplatesAttribute)
+		attributes.SetAccess(s, rendered.Access)
+		if rendered.CanWatch {
+			attributes.SetCanWatch(s, true)
+		}
+		if len(rendered.BlockedMethodReasons) > 0 {
+			attributes.SetBlockedMethodReasons(s, rendered.BlockedMethodReasons)
+		}
+		s.ResourceMethods = rendered.ResourceMethods
+		s.CollectionMethods = rendered.CollectionMethods
+		if err := result.AddSchema(*s); err != nil {
+			return nil, err
+		}
+	}
+
+	result.Attributes = map[string]interface{}{
+		"accessSet": access,
+	}
+	return result, nil
+}
@@ -0,0 +1,123 @@
+package schema
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync/atomic"
+	"testing"
+
+	"github.com/rancher/apiserver/pkg/types"
+	"github.com/rancher/steve/pkg/accesscontrol"
+	"github.com/rancher/steve/pkg/attributes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	k8sSchema "k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/authentication/user"
+)
+
+func TestSharedCache(t *testing.T) {
+	testUser := &user.DefaultInfo{Name: "testUser", UID: "testUser", Groups: []string{}}
+	mockLookup := newMockAccessSetLookup()
+	for _, verb := range []string{"get", "watch", "delete"} {
+		mockLookup.AddAccessForUser(testUser, verb, k8sSchema.GroupResource{Group: testGroup, Resource: "testCRD"}, "*", "*")
+	}
+	shared := newFakeSharedCache()
+
+	// replica returns a collection sharing the cache, along with the number of methods it rendered itself
+	replica := func(ids ...string) (*Collection, *int32) {
+		collection := NewCollectionWithOptions(context.TODO(), types.EmptyAPISchemas(), mockLookup, CollectionOptions{
+			SharedCache: shared,
+		})
+		rendered := new(int32)
+		collection.SetMethodBlocker(func(schema *types.APISchema, method string) (string, string) {
+			atomic.AddInt32(rendered, 1)
+			if attributes.DisallowMethods(schema)[method] {
+				return "blocked-" + method, "disabled by admin policy"
+			}
+			return method, ""
+		})
+		collection.schemas = map[string]*types.APISchema{}
+		for _, id := range ids {
+			s := makeSchema(id)
+			attributes.AddDisallowMethods(s, "DELETE")
+			collection.schemas[id] = s
+		}
+		return collection, rendered
+	}
+
+	first, firstRendered := replica("testCRD", "otherCRD")
+	firstSchemas, err := first.Schemas(testUser)
+	require.NoError(t, err)
+	assert.NotZero(t, atomic.LoadInt32(firstRendered))
+	assert.Equal(t, 1, shared.len(), "expected the computed schemas to be shared")
+
+	second, secondRendered := replica("testCRD", "otherCRD")
+	secondSchemas, err := second.Schemas(testUser)
+	require.NoError(t, err)
+	assert.Zero(t, atomic.LoadInt32(secondRendered), "expected the shared schemas to not be computed again")
+	assert.Equal(t, schemaIDs(firstSchemas), schemaIDs(secondSchemas))
+	assert.Nil(t, secondSchemas.LookupSchema("otherCRD"), "expected schemas without access to not be shared")
+
+	want, got := firstSchemas.LookupSchema("testCRD"), secondSchemas.LookupSchema("testCRD")
+	require.NotNil(t, got)
+	assert.Equal(t, want.ResourceMethods, got.ResourceMethods)
+	assert.Equal(t, want.CollectionMethods, got.CollectionMethods)
+	assert.Equal(t, attributes.Access(want), attributes.Access(got))
+	assert.True(t, attributes.CanWatch(got))
+	assert.Equal(t, map[string]string{"DELETE": "disabled by admin policy"}, attributes.BlockedMethodReasons(got))
+	assert.Equal(t, want.ResourceFields, got.ResourceFields, "expected the rest of the schema to be the collection's")
+
+	accessSet, ok := AccessSetFromSchemas(secondSchemas)
+	require.True(t, ok, "expected the access set to be reattached to the shared schemas")
+	assert.Same(t, mockLookup.AccessFor(testUser), accessSet)
+
+	again, err := second.Schemas(testUser)
+	require.NoError(t, err)
+	assert.Same(t, secondSchemas, again, "expected the shared schemas to be cached in memory")
+
+	// a replica with other schemas computes its own
+	third, thirdRendered := replica("testCRD")
+	thirdSchemas, err := third.Schemas(testUser)
+	require.NoError(t, err)
+	assert.NotZero(t, atomic.LoadInt32(thirdRendered))
+	assert.NotNil(t, thirdSchemas.LookupSchema("testCRD"))
+	assert.Equal(t, 2, shared.len())
+}
+
+func TestSharedCacheErrors(t *testing.T) {
+	testUser := &user.DefaultInfo{Name: "testUser", UID: "testUser", Groups: []string{}}
+	mockLookup := newMockAccessSetLookup()
+	mockLookup.AddAccessForUser(testUser, "get", k8sSchema.GroupResource{Group: testGroup, Resource: "testCRD"}, "*", "*")
+	shared := newFakeSharedCache()
+	shared.err = errors.New("connection refused")
+	collection := NewCollectionWithOptions(context.TODO(), types.EmptyAPISchemas(), mockLookup, CollectionOptions{
+		SharedCache: shared,
+	})
+	collection.schemas = map[string]*types.APISchema{"testCRD": makeSchema("testCRD")}
+
+	userSchemas, err := collection.Schemas(testUser)
+	require.NoError(t, err, "expected the schemas to be computed when the shared cache fails")
+	assert.NotNil(t, userSchemas.LookupSchema("testCRD"))
+	assert.Equal(t, []string{mockLookup.AccessFor(testUser).ID}, collection.CachedAccessIDs())
+
+	// entries that can't be decoded are ignored
+	shared.err = nil
+	key, _ := collection.sharedKey(collection.cacheKey(mockLookup.AccessFor(testUser), testUser, ""))
+	shared.entries[key] = []byte("not json")
+	collection.Refresh()
+	userSchemas, err = collection.Schemas(testUser)
+	require.NoError(t, err)
+	assert.NotNil(t, userSchemas.LookupSchema("testCRD"))
+	assert.Equal(t, accesscontrol.AccessListByVerb{"get": {{Namespace: "*", ResourceName: "*"}}},
+		attributes.Access(userSchemas.LookupSchema("testCRD")))
+}
+
+func schemaIDs(apiSchemas *types.APISchemas) []string {
+	ids := make([]string, 0, len(apiSchemas.Schemas))
+	for id := range apiSchemas.Schemas {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
@@ -0,0 +1,190 @@
+package table
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/rancher/apiserver/pkg/types"
+	"github.com/rancher/apiserver/pkg/writer"
+	"github.com/rancher/steve/pkg/attributes"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+// Format is the response format of the requests for a table, which Writer writes.
+const Format = "table"
+
+// defaultColumns are the columns of the schemas which have none, as in the tables of Kubernetes.
+var defaultColumns = []Column{
+	{Name: "Name", Field: "$.metadata.name", Type: "string", Format: "name"},
+	{Name: "Created", Field: "$.metadata.creationTimestamp", Type: "date"},
+}
+
+// Requested returns whether the request asks for a table, either with the Accept header of the Table API of
+// Kubernetes, application/json;as=Table, or with the _format=table parameter.
+func Requested(req *http.Request) bool {
+	if req.URL.Query().Get("_format") == Format {
+		return true
+	}
+	for _, accept := range strings.Split(req.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(accept)
+		if err == nil && mediaType == "application/json" && params["as"] == "Table" {
+			return true
+		}
+	}
+	return false
+}
+
+// Writer writes the objects of responses as a table in the format of the Table API of Kubernetes, with a row for
+// each object and a cell for each of the columns of the schema. The cells are the values of the fields of the
+// columns, which are JSONPaths, in the objects as the JSON responses have them. Other responses, such as errors, are
+// written by Fallback.
+type Writer struct {
+	Fallback types.ResponseWriter
+}
+
+func (w *Writer) Write(apiOp *types.APIRequest, code int, obj types.APIObject) {
+	if apiOp.Schema == nil || obj.Type != apiOp.Schema.ID {
+		w.Fallback.Write(apiOp, code, obj)
+		return
+	}
+	w.write(apiOp, code, types.APIObjectList{Revision: obj.Data().String("metadata", "resourceVersion"), Objects: []types.APIObject{obj}})
+}
+
+func (w *Writer) WriteList(apiOp *types.APIRequest, code int, list types.APIObjectList) {
+	if apiOp.Schema == nil {
+		w.Fallback.WriteList(apiOp, code, list)
+		return
+	}
+	w.write(apiOp, code, list)
+}
+
+func (w *Writer) write(apiOp *types.APIRequest, code int, list types.APIObjectList) {
+	writer.AddCommonResponseHeader(apiOp)
+	apiOp.Response.Header().Set("content-type", "application/json")
+	apiOp.Response.WriteHeader(code)
+	if err := Encode(apiOp.Response, apiOp, list); err != nil {
+		logrus.Errorf("failed to write table of %s: %v", apiOp.Schema.ID, err)
+	}
+}
+
+// Encode writes the list of objects of the schema of the request as a table.
+func Encode(out io.Writer, apiOp *types.APIRequest, list types.APIObjectList) error {
+	columns := schemaColumns(apiOp.Schema)
+	paths := make([]*jsonpath.JSONPath, len(columns))
+	result := &metav1.Table{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Table",
+			APIVersion: metav1.SchemeGroupVersion.String(),
+		},
+		ListMeta: metav1.ListMeta{
+			ResourceVersion: list.Revision,
+			Continue:        list.Continue,
+		},
+		ColumnDefinitions: make([]metav1.TableColumnDefinition, len(columns)),
+		Rows:              []metav1.TableRow{},
+	}
+	for i, column := range columns {
+		columnType := column.Type
+		if columnType == "" {
+			columnType = "string"
+		}
+		result.ColumnDefinitions[i] = metav1.TableColumnDefinition{
+			Name:        column.Name,
+			Type:        columnType,
+			Format:      column.Format,
+			Description: column.Description,
+			Priority:    int32(column.Priority),
+		}
+		path := jsonpath.New(column.Name).AllowMissingKeys(true)
+		if err := path.Parse("{" + column.Field + "}"); err != nil {
+			// a column which can not be evaluated has empty cells, rather than failing the whole table
+			logrus.Debugf("invalid field %q of column %s of %s: %v", column.Field, column.Name, apiOp.Schema.ID, err)
+			path = nil
+		}
+		paths[i] = path
+	}
+
+	for _, obj := range list.Objects {
+		data := format(apiOp, obj)
+		row := metav1.TableRow{
+			Cells: make([]interface{}, len(columns)),
+		}
+		for i, path := range paths {
+			if path != nil {
+				row.Cells[i] = cell(path, data)
+			}
+		}
+		metadata, err := json.Marshal(map[string]interface{}{
+			"kind":       "PartialObjectMetadata",
+			"apiVersion": metav1.SchemeGroupVersion.String(),
+			"metadata":   data["metadata"],
+		})
+		if err != nil {
+			return err
+		}
+		row.Object = runtime.RawExtension{Raw: metadata}
+		result.Rows = append(result.Rows, row)
+	}
+	return json.NewEncoder(out).Encode(result)
+}
+
+// schemaColumns returns the columns of the schema, which are either Columns, from the additional printer columns of
+// custom resources, or the column definitions of the tables of Kubernetes, which share their JSON fields.
+func schemaColumns(schema *types.APISchema) []Column {
+	var columns []Column
+	if raw, err := json.Marshal(attributes.Columns(schema)); err == nil {
+		_ = json.Unmarshal(raw, &columns)
+	}
+	if len(columns) == 0 {
+		return defaultColumns
+	}
+	return columns
+}
+
+// format returns the data of the object formatted by the formatter of its schema, as the JSON responses have it.
+func format(apiOp *types.APIRequest, obj types.APIObject) map[string]interface{} {
+	if apiOp.Schema.Formatter != nil {
+		apiOp.Schema.Formatter(apiOp, &types.RawResource{
+			ID:        obj.ID,
+			Type:      apiOp.Schema.ID,
+			Schema:    apiOp.Schema,
+			Links:     map[string]string{},
+			Actions:   map[string]string{},
+			APIObject: obj,
+		})
+	}
+	return obj.Data()
+}
+
+// cell returns the value of the path in the data, which is nil if the data does not have it, and a list of values if
+// the path matches several.
+func cell(path *jsonpath.JSONPath, data map[string]interface{}) interface{} {
+	results, err := path.FindResults(data)
+	if err != nil {
+		return nil
+	}
+	var values []interface{}
+	for _, result := range results {
+		for _, value := range result {
+			if value.Kind() == reflect.Interface && value.IsNil() {
+				continue
+			}
+			values = append(values, value.Interface())
+		}
+	}
+	switch len(values) {
+	case 0:
+		return nil
+	case 1:
+		return values[0]
+	default:
+		return values
+	}
+}
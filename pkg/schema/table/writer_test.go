@@ -0,0 +1,224 @@
+package table
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rancher/apiserver/pkg/types"
+	"github.com/rancher/steve/pkg/attributes"
+	"github.com/rancher/wrangler/pkg/schemas"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestRequested(t *testing.T) {
+	tests := []struct {
+		name   string
+		url    string
+		accept string
+		want   bool
+	}{
+		{
+			name:   "table API of Kubernetes",
+			url:    "/v1/pods",
+			accept: "application/json;as=Table;v=v1;g=meta.k8s.io, application/json",
+			want:   true,
+		},
+		{
+			name: "format parameter",
+			url:  "/v1/pods?_format=table",
+			want: true,
+		},
+		{
+			name:   "json",
+			url:    "/v1/pods",
+			accept: "application/json",
+		},
+		{
+			name:   "other format parameter",
+			url:    "/v1/pods?_format=yaml",
+			accept: "application/json;as=PartialObjectMetadata",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, test.url, nil)
+			req.Header.Set("Accept", test.accept)
+			assert.Equal(t, test.want, Requested(req))
+		})
+	}
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name    string
+		columns interface{}
+		list    types.APIObjectList
+		want    metav1.Table
+	}{
+		{
+			name: "custom resource columns",
+			columns: []Column{
+				{Name: "Replicas", Field: ".spec.replicas", Type: "integer"},
+				{Name: "Image", Field: ".spec.image"},
+				{Name: "Ports", Field: ".spec.ports[*].port"},
+			},
+			list: types.APIObjectList{
+				Revision: "100",
+				Continue: "next",
+				Objects: []types.APIObject{
+					newObject("b", map[string]interface{}{"replicas": int64(2), "image": "nginx",
+						"ports": []interface{}{map[string]interface{}{"port": int64(80)}, map[string]interface{}{"port": int64(443)}}}),
+					newObject("a", map[string]interface{}{"replicas": int64(1)}),
+				},
+			},
+			want: metav1.Table{
+				ListMeta: metav1.ListMeta{ResourceVersion: "100", Continue: "next"},
+				ColumnDefinitions: []metav1.TableColumnDefinition{
+					{Name: "Replicas", Type: "integer"},
+					{Name: "Image", Type: "string"},
+					{Name: "Ports", Type: "string"},
+				},
+				Rows: []metav1.TableRow{
+					{Cells: []interface{}{float64(2), "nginx", []interface{}{float64(80), float64(443)}}},
+					{Cells: []interface{}{float64(1), nil, nil}},
+				},
+			},
+		},
+		{
+			name: "table columns of Kubernetes",
+			columns: []map[string]interface{}{
+				{"name": "Name", "type": "string", "format": "name", "field": "$.metadata.fields[0]"},
+				{"name": "Status", "type": "string", "field": "$.metadata.fields[1]", "priority": 1},
+			},
+			list: types.APIObjectList{
+				Objects: []types.APIObject{
+					newObject("a", nil, "a", "Running"),
+				},
+			},
+			want: metav1.Table{
+				ColumnDefinitions: []metav1.TableColumnDefinition{
+					{Name: "Name", Type: "string", Format: "name"},
+					{Name: "Status", Type: "string", Priority: 1},
+				},
+				Rows: []metav1.TableRow{
+					{Cells: []interface{}{"a", "Running"}},
+				},
+			},
+		},
+		{
+			name: "default columns",
+			list: types.APIObjectList{
+				Objects: []types.APIObject{
+					newObject("a", nil),
+				},
+			},
+			want: metav1.Table{
+				ColumnDefinitions: []metav1.TableColumnDefinition{
+					{Name: "Name", Type: "string", Format: "name"},
+					{Name: "Created", Type: "date"},
+				},
+				Rows: []metav1.TableRow{
+					{Cells: []interface{}{"a", "2023-01-01T00:00:00Z"}},
+				},
+			},
+		},
+		{
+			name: "empty list",
+			columns: []Column{
+				{Name: "Replicas", Field: ".spec.replicas", Type: "integer"},
+			},
+			want: metav1.Table{
+				ColumnDefinitions: []metav1.TableColumnDefinition{
+					{Name: "Replicas", Type: "integer"},
+				},
+				Rows: []metav1.TableRow{},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			schema := &types.APISchema{Schema: &schemas.Schema{ID: "test"}}
+			if test.columns != nil {
+				attributes.SetColumns(schema, test.columns)
+			}
+			apiOp := &types.APIRequest{Schema: schema}
+			var out bytes.Buffer
+			require.NoError(t, Encode(&out, apiOp, test.list))
+
+			var got metav1.Table
+			require.NoError(t, json.Unmarshal(out.Bytes(), &got))
+			assert.Equal(t, "Table", got.Kind)
+			assert.Equal(t, "meta.k8s.io/v1", got.APIVersion)
+			assert.Equal(t, test.want.ListMeta, got.ListMeta)
+			assert.Equal(t, test.want.ColumnDefinitions, got.ColumnDefinitions)
+			require.Len(t, got.Rows, len(test.want.Rows))
+			for i, row := range got.Rows {
+				assert.Equal(t, test.want.Rows[i].Cells, row.Cells)
+				var metadata metav1.PartialObjectMetadata
+				require.NoError(t, json.Unmarshal(row.Object.Raw, &metadata))
+				assert.Equal(t, "PartialObjectMetadata", metadata.Kind)
+				assert.Equal(t, test.list.Objects[i].Name(), metadata.Name)
+			}
+		})
+	}
+}
+
+func TestWriterFallback(t *testing.T) {
+	schema := &types.APISchema{Schema: &schemas.Schema{ID: "test"}}
+	fallback := &recordingWriter{}
+	w := &Writer{Fallback: fallback}
+	rec := httptest.NewRecorder()
+	apiOp := &types.APIRequest{Schema: schema, Response: rec, Schemas: types.EmptyAPISchemas()}
+
+	w.Write(apiOp, http.StatusNotFound, types.APIObject{Type: "error"})
+	assert.Equal(t, 1, fallback.writes)
+	assert.Empty(t, rec.Body.String())
+
+	w.Write(apiOp, http.StatusOK, newObject("a", nil))
+	assert.Equal(t, 1, fallback.writes)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var got metav1.Table
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	assert.Len(t, got.Rows, 1)
+}
+
+type recordingWriter struct {
+	writes int
+}
+
+func (r *recordingWriter) Write(apiOp *types.APIRequest, code int, obj types.APIObject) {
+	r.writes++
+}
+
+func (r *recordingWriter) WriteList(apiOp *types.APIRequest, code int, list types.APIObjectList) {
+	r.writes++
+}
+
+func newObject(name string, spec map[string]interface{}, fields ...interface{}) types.APIObject {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "example.com/v1",
+		"kind":       "Test",
+		"metadata": map[string]interface{}{
+			"name":              name,
+			"namespace":         "default",
+			"creationTimestamp": "2023-01-01T00:00:00Z",
+		},
+	}}
+	if spec != nil {
+		obj.Object["spec"] = spec
+	}
+	if fields != nil {
+		obj.Object["metadata"].(map[string]interface{})["fields"] = fields
+	}
+	return types.APIObject{
+		Type:   "test",
+		ID:     "default/" + name,
+		Object: obj,
+	}
+}
@@ -18,6 +18,7 @@ type Config struct {
 	HTTPSListenPort int
 	HTTPListenPort  int
 	UIPath          string
+	Impersonate     bool
 
 	WebhookConfig authcli.WebhookConfig
 }
@@ -51,6 +52,7 @@ func (c *Config) ToServer(ctx context.Context) (*server.Server, error) {
 	return server.New(ctx, restConfig, &server.Options{
 		AuthMiddleware: auth,
 		Next:           ui.New(c.UIPath),
+		Impersonate:    c.Impersonate,
 	})
 }
 
@@ -70,6 +72,11 @@ func Flags(config *Config) []cli.Flag {
 			Name:        "ui-path",
 			Destination: &config.UIPath,
 		},
+		cli.BoolFlag{
+			Name:        "impersonate",
+			EnvVar:      "IMPERSONATE",
+			Destination: &config.Impersonate,
+		},
 		cli.IntFlag{
 			Name:        "https-listen-port",
 			Value:       9443,
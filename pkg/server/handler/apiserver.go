@@ -6,10 +6,12 @@ import (
 	apiserver "github.com/rancher/apiserver/pkg/server"
 	"github.com/rancher/apiserver/pkg/types"
 	"github.com/rancher/apiserver/pkg/urlbuilder"
+	"github.com/rancher/apiserver/pkg/writer"
 	"github.com/rancher/steve/pkg/accesscontrol"
 	"github.com/rancher/steve/pkg/auth"
 	k8sproxy "github.com/rancher/steve/pkg/proxy"
 	"github.com/rancher/steve/pkg/schema"
+	"github.com/rancher/steve/pkg/schema/table"
 	"github.com/rancher/steve/pkg/server/router"
 	"github.com/sirupsen/logrus"
 	"k8s.io/apiserver/pkg/endpoints/request"
@@ -28,6 +30,14 @@ func New(cfg *rest.Config, sf schema.Factory, authMiddleware auth.Middleware, ne
 		server: apiserver.DefaultAPIServer(),
 	}
 	a.server.AccessControl = accesscontrol.NewAccessControl()
+	a.server.ResponseWriters[table.Format] = &writer.GzipWriter{
+		ResponseWriter: &table.Writer{
+			Fallback: &writer.EncodingResponseWriter{
+				ContentType: "application/json",
+				Encoder:     types.JSONEncoder,
+			},
+		},
+	}
 
 	if authMiddleware == nil {
 		proxy, err = k8sproxy.Handler("/", cfg)
@@ -40,11 +50,12 @@ func New(cfg *rest.Config, sf schema.Factory, authMiddleware auth.Middleware, ne
 	}
 
 	w := authMiddleware
+	minSize := compressionMinSize()
 	handlers := router.Handlers{
 		Next:        next,
-		K8sResource: w(a.apiHandler(k8sAPI)),
+		K8sResource: w(compress(minSize, a.apiHandler(k8sAPI))),
 		K8sProxy:    w(proxy),
-		APIRoot:     w(a.apiHandler(apiRoot)),
+		APIRoot:     w(compress(minSize, a.apiHandler(apiRoot))),
 	}
 	if routerFunc == nil {
 		return a.server, router.Routes(handlers), nil
@@ -63,13 +74,17 @@ func (a *apiServer) common(rw http.ResponseWriter, req *http.Request) (*types.AP
 		return nil, false
 	}
 
-	schemas, err := a.sf.Schemas(user)
+	schemas, err := a.sf.SchemasContext(req.Context(), user)
 	if err != nil {
 		logrus.Errorf("HTTP request failed: %v", err)
 		rw.Write([]byte(err.Error()))
 		rw.WriteHeader(http.StatusInternalServerError)
 	}
 
+	if accessSet, ok := schema.AccessSetFromSchemas(schemas); ok {
+		req = req.WithContext(accesscontrol.WithAccessSet(req.Context(), accessSet))
+	}
+
 	urlBuilder, err := urlbuilder.NewPrefixed(req, schemas, "v1")
 	if err != nil {
 		rw.Write([]byte(err.Error()))
@@ -77,12 +92,20 @@ func (a *apiServer) common(rw http.ResponseWriter, req *http.Request) (*types.AP
 		return nil, false
 	}
 
-	return &types.APIRequest{
+	apiOp := &types.APIRequest{
 		Schemas:    schemas,
 		Request:    req,
 		Response:   rw,
 		URLBuilder: urlBuilder,
-	}, true
+	}
+	normalizeYAMLContentType(req)
+	// the parser of the apiserver only knows its own formats, and keeps the format if it is already set
+	if table.Requested(req) {
+		apiOp.ResponseFormat = table.Format
+	} else if yamlAccepted(req) {
+		apiOp.ResponseFormat = "yaml"
+	}
+	return apiOp, true
 }
 
 type APIFunc func(schema.Factory, *types.APIRequest)
@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Smallest size in bytes of the API responses which are gzip compressed for the clients accepting it. The response
+// writers of the apiserver compress every response for these clients, however small, when it is not set or not
+// positive, which costs more CPU than it saves for small responses.
+// The watch streams of /v1/subscribe negotiate per-message deflate with the websocket clients supporting it instead.
+const compressionMinSizeEnv = "CATTLE_RESPONSE_COMPRESSION_MIN_SIZE_INT"
+
+// compressionMinSize returns the smallest size of the responses to compress, or 0 to compress every response.
+func compressionMinSize() int {
+	size, err := strconv.Atoi(os.Getenv(compressionMinSizeEnv))
+	if err != nil || size < 0 {
+		return 0
+	}
+	return size
+}
+
+// compress returns a handler which gzip compresses the responses of next of at least minSize bytes for the requests
+// accepting gzip, or next itself if minSize is 0. Next is given the requests without their Accept-Encoding header, so
+// that the response writers of the apiserver leave compression to the handler.
+func compress(minSize int, next http.Handler) http.Handler {
+	if minSize <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		// websockets are upgraded from the original connection and compress their own messages
+		if req.Method == http.MethodHead || req.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		gzipped := acceptsGzip(req)
+		req = req.Clone(req.Context())
+		req.Header.Del("Accept-Encoding")
+		rw.Header().Add("Vary", "Accept-Encoding")
+		if !gzipped {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		gz := &gzipResponseWriter{ResponseWriter: rw, minSize: minSize}
+		defer gz.Close()
+		next.ServeHTTP(gz, req)
+	})
+}
+
+// acceptsGzip returns whether the Accept-Encoding header of the request accepts gzip, by name or with "*".
+func acceptsGzip(req *http.Request) bool {
+	for _, header := range req.Header.Values("Accept-Encoding") {
+		for _, encoding := range strings.Split(header, ",") {
+			name, params, _ := strings.Cut(strings.TrimSpace(encoding), ";")
+			name = strings.TrimSpace(name)
+			if name != "gzip" && name != "*" {
+				continue
+			}
+			q, ok := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q=")
+			if !ok {
+				return true
+			}
+			if value, err := strconv.ParseFloat(q, 64); err == nil && value > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// gzipResponseWriter buffers a response until it reaches the minimum size, after which it is compressed. Responses
+// which end before reaching it are written as they are.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	minSize int
+	status  int
+	buffer  bytes.Buffer
+	gz      *gzip.Writer
+	// done is set once the response is written to the underlying writer, compressed or not
+	done bool
+}
+
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	if g.status == 0 {
+		g.status = status
+	}
+}
+
+func (g *gzipResponseWriter) Write(data []byte) (int, error) {
+	if g.status == 0 {
+		g.status = http.StatusOK
+	}
+	if g.gz != nil {
+		return g.gz.Write(data)
+	}
+	if g.done {
+		return g.ResponseWriter.Write(data)
+	}
+	g.buffer.Write(data)
+	if g.buffer.Len() < g.minSize || !compressible(g.status, g.Header()) {
+		if g.buffer.Len() >= g.minSize {
+			return len(data), g.flushUncompressed()
+		}
+		return len(data), nil
+	}
+
+	header := g.Header()
+	header.Set("Content-Encoding", "gzip")
+	header.Del("Content-Length")
+	g.ResponseWriter.WriteHeader(g.status)
+	g.done = true
+	g.gz = gzip.NewWriter(g.ResponseWriter)
+	if _, err := g.gz.Write(g.buffer.Bytes()); err != nil {
+		return 0, err
+	}
+	g.buffer.Reset()
+	return len(data), nil
+}
+
+// compressible returns whether a response with the status and headers can be compressed.
+func compressible(status int, header http.Header) bool {
+	return status != http.StatusNoContent && status != http.StatusNotModified && header.Get("Content-Encoding") == ""
+}
+
+// flushUncompressed writes the status and the buffered response as they are.
+func (g *gzipResponseWriter) flushUncompressed() error {
+	if g.done {
+		return nil
+	}
+	g.done = true
+	if g.status != 0 {
+		g.ResponseWriter.WriteHeader(g.status)
+	}
+	_, err := g.ResponseWriter.Write(g.buffer.Bytes())
+	g.buffer.Reset()
+	return err
+}
+
+// Flush writes what was written so far, compressed if the response is compressed, so that streamed responses are
+// not delayed by the buffer.
+func (g *gzipResponseWriter) Flush() {
+	if g.gz != nil {
+		_ = g.gz.Flush()
+	} else {
+		_ = g.flushUncompressed()
+	}
+	if flusher, ok := g.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack hijacks the connection of the underlying writer, if it supports it and nothing was written yet.
+func (g *gzipResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := g.ResponseWriter.(http.Hijacker)
+	if !ok || g.status != 0 {
+		return nil, nil, fmt.Errorf("the response can not be hijacked")
+	}
+	g.done = true
+	return hijacker.Hijack()
+}
+
+// Close ends the response, writing the buffered response if it was not compressed.
+func (g *gzipResponseWriter) Close() {
+	if g.gz != nil {
+		_ = g.gz.Close()
+		return
+	}
+	_ = g.flushUncompressed()
+}
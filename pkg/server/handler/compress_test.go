@@ -0,0 +1,139 @@
+package handler
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompress(t *testing.T) {
+	large := `{"type":"collection","data":[` + strings.Repeat(`{"id":"default/pod","type":"pod"},`, 100) + `{}]}`
+	small := `{"type":"collection","data":[]}`
+
+	tests := []struct {
+		name           string
+		minSize        int
+		acceptEncoding string
+		body           string
+		status         int
+		wantCompressed bool
+	}{
+		{
+			name:           "large response is compressed",
+			minSize:        1024,
+			acceptEncoding: "gzip, deflate, br",
+			body:           large,
+			wantCompressed: true,
+		},
+		{
+			name:           "response is compressed for any encoding",
+			minSize:        1024,
+			acceptEncoding: "*",
+			body:           large,
+			wantCompressed: true,
+		},
+		{
+			name:           "error response is compressed",
+			minSize:        1024,
+			acceptEncoding: "gzip",
+			body:           large,
+			status:         http.StatusNotFound,
+			wantCompressed: true,
+		},
+		{
+			name:           "small response is not compressed",
+			minSize:        1024,
+			acceptEncoding: "gzip",
+			body:           small,
+		},
+		{
+			name:    "response is not compressed without accepting gzip",
+			minSize: 1024,
+			body:    large,
+		},
+		{
+			name:           "response is not compressed when gzip is refused",
+			minSize:        1024,
+			acceptEncoding: "gzip;q=0, deflate",
+			body:           large,
+		},
+		{
+			name:           "response is left to the apiserver without a minimum size",
+			acceptEncoding: "gzip",
+			body:           large,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var acceptEncoding string
+			handler := compress(test.minSize, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				acceptEncoding = req.Header.Get("Accept-Encoding")
+				rw.Header().Set("Content-Type", "application/json")
+				if test.status != 0 {
+					rw.WriteHeader(test.status)
+				}
+				// written in chunks, so that the response reaches the minimum size across writes
+				for i := 0; i < len(test.body); i += 100 {
+					_, _ = io.WriteString(rw, test.body[i:min(i+100, len(test.body))])
+				}
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/v1/pods", nil)
+			if test.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", test.acceptEncoding)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if test.minSize > 0 {
+				assert.Empty(t, acceptEncoding, "the apiserver must not compress the response itself")
+			} else {
+				assert.Equal(t, test.acceptEncoding, acceptEncoding)
+			}
+			wantStatus := test.status
+			if wantStatus == 0 {
+				wantStatus = http.StatusOK
+			}
+			assert.Equal(t, wantStatus, rec.Code)
+			assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+			body := rec.Body.String()
+			if test.wantCompressed {
+				assert.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+				assert.Less(t, rec.Body.Len(), len(test.body))
+				reader, err := gzip.NewReader(rec.Body)
+				require.NoError(t, err)
+				data, err := io.ReadAll(reader)
+				require.NoError(t, err)
+				body = string(data)
+			} else {
+				assert.Empty(t, rec.Header().Get("Content-Encoding"))
+			}
+			assert.Equal(t, test.body, body)
+		})
+	}
+}
+
+func TestCompressWebsocket(t *testing.T) {
+	var original bool
+	handler := compress(1, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, original = rw.(*httptest.ResponseRecorder)
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/v1/subscribe", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.True(t, original, "websocket upgrades must be given the original response writer")
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
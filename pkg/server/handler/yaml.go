@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+)
+
+const yamlMediaType = "application/yaml"
+
+// yamlMediaTypes are the media types of YAML. The apiserver only reads the bodies of requests whose Content-Type is
+// exactly application/yaml as YAML, and only writes YAML to the requests accepting application/yaml.
+var yamlMediaTypes = map[string]bool{
+	yamlMediaType:        true,
+	"application/x-yaml": true,
+	"text/yaml":          true,
+	"text/x-yaml":        true,
+}
+
+// normalizeYAMLContentType sets the Content-Type of the requests with a YAML body to application/yaml, without
+// parameters such as the charset, so that the apiserver decodes their bodies from YAML to JSON and then as it decodes
+// JSON bodies.
+func normalizeYAMLContentType(req *http.Request) {
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err == nil && yamlMediaTypes[mediaType] {
+		req.Header.Set("Content-Type", yamlMediaType)
+	}
+}
+
+// yamlAccepted returns whether the request accepts YAML by one of the media types of YAML the apiserver does not know.
+func yamlAccepted(req *http.Request) bool {
+	for _, accept := range strings.Split(req.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(accept)
+		if err == nil && mediaType != yamlMediaType && yamlMediaTypes[mediaType] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	apiserver "github.com/rancher/apiserver/pkg/server"
+	"github.com/rancher/apiserver/pkg/store/empty"
+	"github.com/rancher/apiserver/pkg/types"
+	"github.com/rancher/steve/pkg/schema"
+	"github.com/rancher/steve/pkg/server/router"
+	"github.com/rancher/wrangler/pkg/schemas"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/endpoints/request"
+	"sigs.k8s.io/yaml"
+)
+
+func TestYAMLRoundTrip(t *testing.T) {
+	configMap := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: test
+data:
+  config.yaml: |
+    key: value
+  count: "3"
+`
+	tests := []struct {
+		name        string
+		contentType string
+		accept      string
+	}{
+		{
+			name:        "application/yaml",
+			contentType: "application/yaml",
+			accept:      "application/yaml",
+		},
+		{
+			name:        "with a charset",
+			contentType: "application/yaml; charset=utf-8",
+			accept:      "application/yaml",
+		},
+		{
+			name:        "other media types of YAML",
+			contentType: "application/x-yaml",
+			accept:      "text/yaml",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler := newTestAPIHandler(&memoryStore{objects: map[string]types.APIObject{}})
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/configmaps", strings.NewReader(configMap))
+			req.Header.Set("Content-Type", test.contentType)
+			req.Header.Set("Accept", test.accept)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+			assert.Equal(t, "application/yaml", rec.Header().Get("Content-Type"))
+			assertYAMLObject(t, configMap, rec.Body.String())
+
+			req = httptest.NewRequest(http.MethodGet, "/v1/configmaps/test", nil)
+			req.Header.Set("Accept", test.accept)
+			rec = httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+			assert.Equal(t, "application/yaml", rec.Header().Get("Content-Type"))
+			assertYAMLObject(t, configMap, rec.Body.String())
+		})
+	}
+}
+
+// assertYAMLObject asserts that the YAML response has the fields of the YAML object, besides the fields of the API.
+func assertYAMLObject(t *testing.T, want, got string) {
+	t.Helper()
+	var wantObj, gotObj map[string]interface{}
+	require.NoError(t, yaml.Unmarshal([]byte(want), &wantObj))
+	require.NoError(t, yaml.Unmarshal([]byte(got), &gotObj))
+	for key, value := range wantObj {
+		assert.Equal(t, value, gotObj[key], key)
+	}
+}
+
+// newTestAPIHandler returns the handler of the resources of the API, for a configmap schema backed by the store.
+func newTestAPIHandler(store types.Store) http.Handler {
+	apiSchemas := types.EmptyAPISchemas().MustAddSchema(types.APISchema{
+		Schema: &schemas.Schema{
+			ID:                "configmap",
+			PluralName:        "configmaps",
+			CollectionMethods: []string{http.MethodGet, http.MethodPost},
+			ResourceMethods:   []string{http.MethodGet, http.MethodPut},
+		},
+		Store: store,
+	})
+	a := &apiServer{
+		sf:     &staticFactory{schemas: apiSchemas},
+		server: apiserver.DefaultAPIServer(),
+	}
+	resources := a.apiHandler(k8sAPI)
+	return router.Routes(router.Handlers{
+		K8sResource: http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			ctx := request.WithUser(req.Context(), &user.DefaultInfo{Name: "admin"})
+			resources.ServeHTTP(rw, req.WithContext(ctx))
+		}),
+	})
+}
+
+type staticFactory struct {
+	schema.Factory
+	schemas *types.APISchemas
+}
+
+func (s *staticFactory) SchemasContext(ctx context.Context, user user.Info) (*types.APISchemas, error) {
+	return s.schemas, nil
+}
+
+type memoryStore struct {
+	empty.Store
+	objects map[string]types.APIObject
+}
+
+func (m *memoryStore) ByID(apiOp *types.APIRequest, schema *types.APISchema, id string) (types.APIObject, error) {
+	return m.objects[id], nil
+}
+
+func (m *memoryStore) Create(apiOp *types.APIRequest, schema *types.APISchema, data types.APIObject) (types.APIObject, error) {
+	obj := types.APIObject{
+		Type:   schema.ID,
+		ID:     data.Name(),
+		Object: &unstructured.Unstructured{Object: data.Data()},
+	}
+	m.objects[obj.ID] = obj
+	return obj, nil
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/rancher/steve/pkg/clustercache"
 	schemacontroller "github.com/rancher/steve/pkg/controllers/schema"
 	"github.com/rancher/steve/pkg/resources"
+	"github.com/rancher/steve/pkg/resources/accessiblenamespaces"
 	"github.com/rancher/steve/pkg/resources/common"
 	"github.com/rancher/steve/pkg/resources/schemas"
 	"github.com/rancher/steve/pkg/schema"
@@ -40,6 +41,7 @@ type Server struct {
 	Version         string
 
 	authMiddleware      auth.Middleware
+	impersonate         bool
 	controllers         *Controllers
 	needControllerStart bool
 	next                http.Handler
@@ -47,6 +49,7 @@ type Server struct {
 
 	aggregationSecretNamespace string
 	aggregationSecretName      string
+	clusterCacheOptions        clustercache.Options
 }
 
 type Options struct {
@@ -61,6 +64,12 @@ type Options struct {
 	AggregationSecretName      string
 	ClusterRegistry            string
 	ServerVersion              string
+	// Impersonate makes the requests to Kubernetes impersonate the user of the request, with the Impersonate-User,
+	// Impersonate-Group and Impersonate-Extra-* headers, so that Kubernetes enforces the user's RBAC. It is always
+	// enabled when AuthMiddleware is set. It is ignored when ClientFactory is set.
+	Impersonate bool
+	// ClusterCacheOptions configures the informers of the cluster cache, such as the resync periods of the resources.
+	ClusterCacheOptions clustercache.Options
 }
 
 func New(ctx context.Context, restConfig *rest.Config, opts *Options) (*Server, error) {
@@ -73,11 +82,13 @@ func New(ctx context.Context, restConfig *rest.Config, opts *Options) (*Server,
 		ClientFactory:              opts.ClientFactory,
 		AccessSetLookup:            opts.AccessSetLookup,
 		authMiddleware:             opts.AuthMiddleware,
+		impersonate:                opts.Impersonate || opts.AuthMiddleware != nil,
 		controllers:                opts.Controllers,
 		next:                       opts.Next,
 		router:                     opts.Router,
 		aggregationSecretNamespace: opts.AggregationSecretNamespace,
 		aggregationSecretName:      opts.AggregationSecretName,
+		clusterCacheOptions:        opts.ClusterCacheOptions,
 		ClusterRegistry:            opts.ClusterRegistry,
 		Version:                    opts.ServerVersion,
 	}
@@ -122,7 +133,7 @@ func setup(ctx context.Context, server *Server) error {
 
 	cf := server.ClientFactory
 	if cf == nil {
-		cf, err = client.NewFactory(server.RESTConfig, server.authMiddleware != nil)
+		cf, err = client.NewFactory(server.RESTConfig, server.impersonate)
 		if err != nil {
 			return err
 		}
@@ -134,13 +145,17 @@ func setup(ctx context.Context, server *Server) error {
 		asl = accesscontrol.NewAccessStore(ctx, true, server.controllers.RBAC)
 	}
 
-	ccache := clustercache.NewClusterCache(ctx, cf.AdminDynamicClient())
+	ccache := clustercache.NewClusterCacheWithOptions(ctx, cf.AdminDynamicClient(), server.clusterCacheOptions)
 	server.ClusterCache = ccache
 	sf := schema.NewCollection(ctx, server.BaseSchemas, asl)
+	if as, ok := asl.(*accesscontrol.AccessStore); ok {
+		as.OnUserBindingChange(sf.InvalidateUser)
+	}
 
 	if err = resources.DefaultSchemas(ctx, server.BaseSchemas, ccache, server.ClientFactory, sf, server.Version); err != nil {
 		return err
 	}
+	accessiblenamespaces.Register(server.BaseSchemas, server.controllers.Core.Namespace().Cache())
 
 	summaryCache := summarycache.New(sf, ccache)
 	summaryCache.Start(ctx)
@@ -155,6 +170,7 @@ func setup(ctx context.Context, server *Server) error {
 	}
 
 	schemas.SetupWatcher(ctx, server.BaseSchemas, asl, sf)
+	schemas.SetupChangeWatcher(server.BaseSchemas, sf, sf)
 
 	schemacontroller.Register(ctx,
 		cols,
@@ -0,0 +1,114 @@
+package partition
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rancher/apiserver/pkg/types"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+const (
+	// BookmarkAPIEvent is the name of the events carrying the revision a watch can be resumed from, sent to the
+	// clients watching with the allowWatchBookmarks parameter.
+	BookmarkAPIEvent = "resource.bookmark"
+	// allowWatchBookmarksParam is the parameter of the subscribe request opting in to bookmarks.
+	allowWatchBookmarksParam = "allowWatchBookmarks"
+)
+
+// WatchBookmarks returns whether the client of the watch request opted in to bookmarks.
+func WatchBookmarks(apiOp *types.APIRequest) bool {
+	if apiOp.Request == nil || apiOp.Request.URL == nil {
+		return false
+	}
+	return apiOp.Request.URL.Query().Get(allowWatchBookmarksParam) == "true"
+}
+
+// watchRevisions holds the latest revision of each partition of a watch, from its events and from the bookmarks of
+// Kubernetes. Revisions are compared as numbers, which the revisions of Kubernetes are in practice.
+type watchRevisions struct {
+	lock      sync.Mutex
+	revisions []uint64
+}
+
+// newWatchRevisions returns the revisions of the partitions of a watch started from the revision, if any.
+func newWatchRevisions(partitions int, revision string) *watchRevisions {
+	w := &watchRevisions{revisions: make([]uint64, partitions)}
+	if rev, err := strconv.ParseUint(revision, 10, 64); err == nil {
+		for i := range w.revisions {
+			w.revisions[i] = rev
+		}
+	}
+	return w
+}
+
+// observe records the revision of the event's object as the revision of the partition, if it is newer.
+func (w *watchRevisions) observe(partition int, event watch.Event) {
+	m, err := meta.Accessor(event.Object)
+	if err != nil {
+		return
+	}
+	rev, err := strconv.ParseUint(m.GetResourceVersion(), 10, 64)
+	if err != nil {
+		return
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if rev > w.revisions[partition] {
+		w.revisions[partition] = rev
+	}
+}
+
+// current returns the revision every partition has been watched up to, which the whole watch can be resumed from, or
+// 0 while the revision of a partition is unknown. It never decreases.
+func (w *watchRevisions) current() uint64 {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	var result uint64
+	for i, rev := range w.revisions {
+		if rev == 0 {
+			return 0
+		}
+		if i == 0 || rev < result {
+			result = rev
+		}
+	}
+	return result
+}
+
+// sendBookmarks sends a bookmark with the current revision of the watch of the schema every interval, until the
+// context is done. The revision is also the data of the bookmark, as the version is the data of pings.
+func sendBookmarks(ctx context.Context, apiOp *types.APIRequest, schema *types.APISchema, revisions *watchRevisions,
+	interval time.Duration, response chan<- types.APIEvent) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rev := revisions.current()
+			if rev == 0 {
+				continue
+			}
+			revision := strconv.FormatUint(rev, 10)
+			bookmark := types.APIEvent{
+				Name:         BookmarkAPIEvent,
+				ResourceType: schema.ID,
+				Namespace:    apiOp.Namespace,
+				Revision:     revision,
+				Object: types.APIObject{
+					Object: map[string]interface{}{"revision": revision},
+				},
+			}
+			select {
+			case response <- bookmark:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
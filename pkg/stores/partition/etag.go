@@ -0,0 +1,54 @@
+package partition
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	"github.com/rancher/apiserver/pkg/types"
+)
+
+// etag returns the entity tag of the response to the request for a user at a revision. The user is identified by the
+// ID of its access set, since the links and actions of the response depend on its access. The path and the query of
+// the request are part of the tag too, so that responses to requests with other filters, sorts, projections or pages
+// never match. The tag is weak since the encoding of the response can vary.
+func etag(accessID string, req *http.Request, revision string) string {
+	hash := sha256.New()
+	for _, s := range []string{accessID, req.URL.Path, req.URL.Query().Encode(), revision} {
+		hash.Write([]byte(s))
+		hash.Write([]byte{0})
+	}
+	return `W/"` + base64.RawURLEncoding.EncodeToString(hash.Sum(nil)) + `"`
+}
+
+// notModified sets the ETag header of the response to the request, and writes a 304 Not Modified response if the
+// If-None-Match header of the request has the same tag, in which case it returns true. Nothing is done for responses
+// without a revision.
+func notModified(apiOp *types.APIRequest, accessID, revision string) bool {
+	if apiOp.Response == nil || accessID == "" || revision == "" {
+		return false
+	}
+	tag := etag(accessID, apiOp.Request, revision)
+	apiOp.Response.Header().Set("ETag", tag)
+	if !etagMatches(apiOp.Request.Header.Get("If-None-Match"), tag) {
+		return false
+	}
+	apiOp.Response.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagMatches returns whether the tag is one of the tags of an If-None-Match header, using the weak comparison.
+func etagMatches(ifNoneMatch, tag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	tag = strings.TrimPrefix(tag, "W/")
+	for _, t := range strings.Split(ifNoneMatch, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == tag {
+			return true
+		}
+	}
+	return false
+}
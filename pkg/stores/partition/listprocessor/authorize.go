@@ -0,0 +1,50 @@
+package listprocessor
+
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apiserver/pkg/authentication/user"
+)
+
+// Authorizer decides which objects a user may see, beyond the RBAC access to their resource, such as by an ownership
+// label. It is given a batch of objects, so that it can make a single decision for many of them, and returns for each
+// object, by index, whether the user may see it. Objects without a decision are not seen.
+type Authorizer func(user user.Info, objs []unstructured.Unstructured) []bool
+
+// AuthorizeEach returns an Authorizer deciding for each object of a batch with authorize.
+func AuthorizeEach(authorize func(user user.Info, obj *unstructured.Unstructured) bool) Authorizer {
+	return func(user user.Info, objs []unstructured.Unstructured) []bool {
+		result := make([]bool, len(objs))
+		for i := range objs {
+			result[i] = authorize(user, &objs[i])
+		}
+		return result
+	}
+}
+
+// And returns an Authorizer allowing the objects both a and other allow the user to see.
+func (a Authorizer) And(other Authorizer) Authorizer {
+	return func(user user.Info, objs []unstructured.Unstructured) []bool {
+		result := a(user, objs)
+		allowed := other(user, objs)
+		for i := range result {
+			result[i] = result[i] && i < len(allowed) && allowed[i]
+		}
+		return result
+	}
+}
+
+// AuthorizeList returns the objects of the list the authorizer allows the user to see, in a new list so that the
+// list can be shared, such as by the list cache. No object is allowed without a user.
+func AuthorizeList(list []unstructured.Unstructured, user user.Info, authorizer Authorizer) []unstructured.Unstructured {
+	if user == nil || len(list) == 0 {
+		return nil
+	}
+	allowed := authorizer(user, list)
+	var result []unstructured.Unstructured
+	for i := range list {
+		if i < len(allowed) && allowed[i] {
+			result = append(result, list[i])
+		}
+	}
+	return result
+}
@@ -0,0 +1,58 @@
+package listprocessor
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apiserver/pkg/authentication/user"
+)
+
+func ownedBy(name, owner string) unstructured.Unstructured {
+	obj := unstructured.Unstructured{Object: map[string]interface{}{}}
+	obj.SetName(name)
+	if owner != "" {
+		obj.SetLabels(map[string]string{"owner": owner})
+	}
+	return obj
+}
+
+func names(list []unstructured.Unstructured) []string {
+	var result []string
+	for _, obj := range list {
+		result = append(result, obj.GetName())
+	}
+	return result
+}
+
+func TestAuthorizeList(t *testing.T) {
+	owner := AuthorizeEach(func(user user.Info, obj *unstructured.Unstructured) bool {
+		return obj.GetLabels()["owner"] == user.GetName()
+	})
+	list := []unstructured.Unstructured{
+		ownedBy("mine", "user1"),
+		ownedBy("theirs", "user2"),
+		ownedBy("unowned", ""),
+		ownedBy("also-mine", "user1"),
+	}
+	user1 := &user.DefaultInfo{Name: "user1"}
+
+	assert.Equal(t, []string{"mine", "also-mine"}, names(AuthorizeList(list, user1, owner)))
+	assert.Equal(t, []string{"theirs"}, names(AuthorizeList(list, &user.DefaultInfo{Name: "user2"}, owner)))
+	assert.Empty(t, AuthorizeList(list, nil, owner), "expected no object to be allowed without a user")
+	assert.Len(t, list, 4, "expected the list to not be modified")
+
+	batches := 0
+	short := Authorizer(func(user user.Info, objs []unstructured.Unstructured) []bool {
+		batches++
+		return []bool{true, true}
+	})
+	assert.Equal(t, []string{"mine", "theirs"}, names(AuthorizeList(list, user1, short)), "expected the objects without a decision to not be allowed")
+	assert.Equal(t, 1, batches, "expected the list to be authorized in a single batch")
+
+	notAlsoMine := AuthorizeEach(func(user user.Info, obj *unstructured.Unstructured) bool {
+		return obj.GetName() != "also-mine"
+	})
+	assert.Equal(t, []string{"mine"}, names(AuthorizeList(list, user1, owner.And(notAlsoMine))))
+	assert.Equal(t, []string{"mine"}, names(AuthorizeList(list, user1, notAlsoMine.And(short).And(owner))))
+}
@@ -0,0 +1,113 @@
+package listprocessor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rancher/apiserver/pkg/types"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/selection"
+)
+
+const fieldSelectorParam = "fieldSelector"
+
+// indexedFields are the fields Kubernetes can select every resource by. Selectors on other fields are only supported
+// by Kubernetes for some fields of some resources, so they are applied to the listed objects instead.
+var indexedFields = map[string]bool{
+	"metadata.name":      true,
+	"metadata.namespace": true,
+}
+
+// FieldSelector is a Kubernetes field selector, such as 'fieldSelector=status.phase=Running,spec.nodeName!=node1',
+// translated into filters. Unlike the filter parameter, values must be equal to the selector's, rather than contain it.
+type FieldSelector struct {
+	// Indexed is the part of the selector on the fields Kubernetes can select every resource by, which is sent along
+	// with the list requests so that Kubernetes returns fewer objects.
+	Indexed string
+	// Filters selects the listed objects by every field of the selector, including the indexed ones, so that they are
+	// part of the filters the list cache is keyed by.
+	Filters []OrFilter
+}
+
+// ParseFieldSelector parses the fieldSelector parameter of a request for the resources of the schema. A selector which
+// is malformed or which references a field the schema does not have is rejected.
+func ParseFieldSelector(apiOp *types.APIRequest, schema *types.APISchema) (FieldSelector, error) {
+	result := FieldSelector{}
+	query := apiOp.Request.URL.Query().Get(fieldSelectorParam)
+	if query == "" {
+		return result, nil
+	}
+	selector, err := fields.ParseSelector(query)
+	if err != nil {
+		return result, fmt.Errorf("invalid field selector %q: %w", query, err)
+	}
+
+	var indexed []string
+	for _, requirement := range selector.Requirements() {
+		field := strings.Split(requirement.Field, ".")
+		if !hasField(apiOp.Schemas, schema, field) {
+			return result, fmt.Errorf("invalid field selector %q: %s is not a field of %s", query, requirement.Field, schema.ID)
+		}
+		filter := Filter{field: field, match: requirement.Value, op: exactEq}
+		if requirement.Operator == selection.NotEquals {
+			filter.op = exactNotEq
+		}
+		result.Filters = append(result.Filters, OrFilter{filters: []Filter{filter}})
+		if indexedFields[requirement.Field] {
+			indexed = append(indexed, requirement.Field+string(requirement.Operator)+fields.EscapeValue(requirement.Value))
+		}
+	}
+	// sort the filters so they can be used as a cache key in the store
+	sort.Slice(result.Filters, func(i, j int) bool {
+		return result.Filters[i].String() < result.Filters[j].String()
+	})
+	result.Indexed = strings.Join(indexed, ",")
+	return result, nil
+}
+
+// hasField returns whether the objects of the schema can have the field. Fields of a map, of a json value or of a
+// schema which can not be found are assumed to exist, since their own fields are unknown.
+func hasField(schemas *types.APISchemas, schema *types.APISchema, field []string) bool {
+	if schema == nil || len(schema.ResourceFields) == 0 {
+		return true
+	}
+	if len(field) == 1 && (field[0] == "apiVersion" || field[0] == "kind") {
+		return true
+	}
+	resourceField, ok := schema.ResourceFields[field[0]]
+	if !ok {
+		// reserved field names are prefixed by the converters
+		resourceField, ok = schema.ResourceFields["_"+field[0]]
+	}
+	if !ok {
+		return false
+	}
+
+	fieldType := resourceField.Type
+	for rest := field[1:]; ; {
+		if len(rest) == 0 {
+			return true
+		}
+		switch {
+		case strings.HasPrefix(fieldType, "array[") && strings.HasSuffix(fieldType, "]"):
+			// a field of a list applies to every element of the list
+			fieldType = strings.TrimSuffix(strings.TrimPrefix(fieldType, "array["), "]")
+		case strings.HasPrefix(fieldType, "map[") && strings.HasSuffix(fieldType, "]"):
+			// any key can be in a map
+			fieldType = strings.TrimSuffix(strings.TrimPrefix(fieldType, "map["), "]")
+			rest = rest[1:]
+		case fieldType == "json":
+			return true
+		default:
+			if schemas == nil {
+				return true
+			}
+			sub := schemas.LookupSchema(fieldType)
+			if sub == nil {
+				return fieldType != "string" && fieldType != "int" && fieldType != "boolean" && fieldType != "date"
+			}
+			return hasField(schemas, sub, rest)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package listprocessor
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/rancher/apiserver/pkg/types"
+	"github.com/rancher/wrangler/pkg/schemas"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func fieldSelectorSchemas() (*types.APISchemas, *types.APISchema) {
+	apiSchemas := types.EmptyAPISchemas()
+	apiSchemas.MustAddSchema(types.APISchema{Schema: &schemas.Schema{
+		ID: "io.k8s.api.core.v1.PodStatus",
+		ResourceFields: map[string]schemas.Field{
+			"phase":      {Type: "string"},
+			"conditions": {Type: "array[io.k8s.api.core.v1.PodCondition]"},
+		},
+	}})
+	apiSchemas.MustAddSchema(types.APISchema{Schema: &schemas.Schema{
+		ID: "io.k8s.api.core.v1.PodCondition",
+		ResourceFields: map[string]schemas.Field{
+			"type":   {Type: "string"},
+			"status": {Type: "string"},
+		},
+	}})
+	pod := &types.APISchema{Schema: &schemas.Schema{
+		ID: "pod",
+		ResourceFields: map[string]schemas.Field{
+			"metadata": {Type: "io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"},
+			"spec":     {Type: "io.k8s.api.core.v1.PodSpec"},
+			"status":   {Type: "io.k8s.api.core.v1.PodStatus"},
+			"data":     {Type: "map[string]"},
+		},
+	}}
+	apiSchemas.MustAddSchema(*pod)
+	return apiSchemas, pod
+}
+
+func parseFieldSelector(t *testing.T, selector string) (FieldSelector, error) {
+	apiSchemas, pod := fieldSelectorSchemas()
+	req, err := http.NewRequest(http.MethodGet, "/?fieldSelector="+url.QueryEscape(selector), nil)
+	require.NoError(t, err)
+	return ParseFieldSelector(&types.APIRequest{Request: req, Schemas: apiSchemas}, pod)
+}
+
+func TestParseFieldSelector(t *testing.T) {
+	tests := []struct {
+		name        string
+		selector    string
+		wantFilters []OrFilter
+		wantIndexed string
+		wantErr     string
+	}{
+		{
+			name:     "no selector",
+			selector: "",
+		},
+		{
+			name:     "equality",
+			selector: "status.phase=Running",
+			wantFilters: []OrFilter{
+				{filters: []Filter{{field: []string{"status", "phase"}, match: "Running", op: exactEq}}},
+			},
+		},
+		{
+			name:     "double equality",
+			selector: "status.phase==Running",
+			wantFilters: []OrFilter{
+				{filters: []Filter{{field: []string{"status", "phase"}, match: "Running", op: exactEq}}},
+			},
+		},
+		{
+			name:     "inequality",
+			selector: "status.phase!=Running",
+			wantFilters: []OrFilter{
+				{filters: []Filter{{field: []string{"status", "phase"}, match: "Running", op: exactNotEq}}},
+			},
+		},
+		{
+			name:     "indexed fields",
+			selector: "metadata.namespace=ns1,metadata.name!=web,status.phase=Running",
+			wantFilters: []OrFilter{
+				{filters: []Filter{{field: []string{"metadata", "name"}, match: "web", op: exactNotEq}}},
+				{filters: []Filter{{field: []string{"metadata", "namespace"}, match: "ns1", op: exactEq}}},
+				{filters: []Filter{{field: []string{"status", "phase"}, match: "Running", op: exactEq}}},
+			},
+			wantIndexed: "metadata.name!=web,metadata.namespace=ns1",
+		},
+		{
+			name:     "fields of list elements and of maps",
+			selector: "status.conditions.type=Ready,data.color=pink",
+			wantFilters: []OrFilter{
+				{filters: []Filter{{field: []string{"data", "color"}, match: "pink", op: exactEq}}},
+				{filters: []Filter{{field: []string{"status", "conditions", "type"}, match: "Ready", op: exactEq}}},
+			},
+		},
+		{
+			name:     "field of a schema which is not found",
+			selector: "spec.nodeName=node1",
+			wantFilters: []OrFilter{
+				{filters: []Filter{{field: []string{"spec", "nodeName"}, match: "node1", op: exactEq}}},
+			},
+		},
+		{
+			name:     "unknown field",
+			selector: "status.podPhase=Running",
+			wantErr:  `invalid field selector "status.podPhase=Running": status.podPhase is not a field of pod`,
+		},
+		{
+			name:     "unknown top level field",
+			selector: "state=Running",
+			wantErr:  `invalid field selector "state=Running": state is not a field of pod`,
+		},
+		{
+			name:     "field of a string",
+			selector: "status.phase.name=Running",
+			wantErr:  `invalid field selector "status.phase.name=Running": status.phase.name is not a field of pod`,
+		},
+		{
+			name:     "malformed",
+			selector: "status.phase",
+			wantErr:  `invalid field selector "status.phase"`,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseFieldSelector(t, test.selector)
+			if test.wantErr != "" {
+				assert.ErrorContains(t, err, test.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.wantFilters, got.Filters)
+			assert.Equal(t, test.wantIndexed, got.Indexed)
+		})
+	}
+}
+
+func TestFilterListFieldSelector(t *testing.T) {
+	pod := func(name, phase string) unstructured.Unstructured {
+		obj := unstructured.Unstructured{Object: map[string]interface{}{
+			"metadata": map[string]interface{}{"name": name},
+		}}
+		if phase != "" {
+			obj.Object["status"] = map[string]interface{}{"phase": phase}
+		}
+		return obj
+	}
+	objects := []unstructured.Unstructured{
+		pod("running", "Running"),
+		pod("not-running", "NotRunning"),
+		pod("pending", "Pending"),
+		pod("unknown", ""),
+	}
+	names := func(list []unstructured.Unstructured) []string {
+		var result []string
+		for _, obj := range list {
+			result = append(result, obj.GetName())
+		}
+		return result
+	}
+	filter := func(selector string) []string {
+		fieldSelector, err := parseFieldSelector(t, selector)
+		require.NoError(t, err)
+		stream := make(chan []unstructured.Unstructured, 1)
+		stream <- objects
+		close(stream)
+		return names(FilterList(stream, fieldSelector.Filters))
+	}
+
+	assert.Equal(t, []string{"running"}, filter("status.phase=Running"), "expected equality to not match values containing the selector's")
+	assert.Equal(t, []string{"not-running", "pending", "unknown"}, filter("status.phase!=Running"), "expected inequality to match objects without the field")
+	assert.Equal(t, []string{"pending", "unknown"}, filter("status.phase!=Running,status.phase!=NotRunning"))
+	assert.Empty(t, filter("status.phase=Running,metadata.name=pending"))
+}
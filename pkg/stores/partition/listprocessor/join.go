@@ -0,0 +1,108 @@
+package listprocessor
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rancher/apiserver/pkg/types"
+	"github.com/rancher/wrangler/pkg/data"
+	"github.com/rancher/wrangler/pkg/data/convert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	joinParam = "join"
+	// joinedField is the field of the listed objects the related objects are set in, by join name.
+	joinedField         = "joined"
+	defaultRelatedField = "metadata.name"
+)
+
+// Join is a relationship, declared by the template of a schema, between the objects of the schema and the objects of
+// a related schema. A list request joins the related objects to the listed objects with the join parameter, e.g.
+// 'join=node', and each listed object has the related object whose RelatedField equals its Field set in
+// joined.<Name>, e.g. joined.node.
+type Join struct {
+	// Name identifies the join in the join parameter and in the joined field.
+	Name string
+	// Field is the field of the listed objects, in . notation, e.g. "spec.nodeName".
+	Field string
+	// Schema is the ID of the schema of the related objects, e.g. "node".
+	Schema string
+	// RelatedField is the field of the related objects equal to Field, in . notation. It defaults to "metadata.name".
+	RelatedField string
+}
+
+// ParseJoins returns the joins requested by the join parameter of the request, a comma separated list of the names of
+// the joins of the schema. Requesting a join the schema does not declare is an error.
+func ParseJoins(apiOp *types.APIRequest, joins []Join) ([]Join, error) {
+	var result []Join
+	for _, name := range strings.Split(apiOp.Request.URL.Query().Get(joinParam), ",") {
+		if name == "" {
+			continue
+		}
+		found := false
+		for _, join := range joins {
+			if join.Name == name {
+				result = append(result, join)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("invalid join %q: the resource has no such join", name)
+		}
+	}
+	return result, nil
+}
+
+// JoinIndex holds the related objects of a join by the value of their related field.
+type JoinIndex struct {
+	join    Join
+	related map[string]map[string]interface{}
+}
+
+// NewJoinIndex indexes the related objects of the join.
+func NewJoinIndex(join Join, related []map[string]interface{}) *JoinIndex {
+	relatedField := join.RelatedField
+	if relatedField == "" {
+		relatedField = defaultRelatedField
+	}
+	index := &JoinIndex{
+		join:    join,
+		related: map[string]map[string]interface{}{},
+	}
+	for _, obj := range related {
+		value, ok := joinValue(obj, relatedField)
+		if !ok {
+			continue
+		}
+		// the first related object with the value is joined, as with a lookup by name
+		if _, ok := index.related[value]; !ok {
+			index.related[value] = obj
+		}
+	}
+	return index
+}
+
+// Join sets the related object of the source object in the joined field of the target object, which can be a
+// projection of the source. Objects without a related object, including the ones related to objects the user may not
+// see, are left as they are.
+func (j *JoinIndex) Join(source, target *unstructured.Unstructured) {
+	value, ok := joinValue(source.Object, j.join.Field)
+	if !ok {
+		return
+	}
+	related, ok := j.related[value]
+	if !ok {
+		return
+	}
+	data.PutValue(target.Object, related, joinedField, j.join.Name)
+}
+
+func joinValue(obj map[string]interface{}, field string) (string, bool) {
+	value := data.GetValueN(obj, strings.Split(field, ".")...)
+	if value == nil {
+		return "", false
+	}
+	return convert.ToString(value), true
+}
@@ -0,0 +1,110 @@
+package listprocessor
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/rancher/apiserver/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+var (
+	nodeJoin = Join{Name: "node", Field: "spec.nodeName", Schema: "node"}
+	pvcJoin  = Join{Name: "claim", Field: "metadata.name", Schema: "persistentvolumeclaim", RelatedField: "spec.volumeName"}
+)
+
+func TestParseJoins(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		want    []Join
+		wantErr bool
+	}{
+		{
+			name: "no join",
+		},
+		{
+			name:  "one join",
+			query: "join=node",
+			want:  []Join{nodeJoin},
+		},
+		{
+			name:  "joins",
+			query: "join=claim,node",
+			want:  []Join{pvcJoin, nodeJoin},
+		},
+		{
+			name:    "undeclared join",
+			query:   "join=node,owner",
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "/?"+test.query, nil)
+			require.NoError(t, err)
+			got, err := ParseJoins(&types.APIRequest{Request: req}, []Join{nodeJoin, pvcJoin})
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestJoinIndex(t *testing.T) {
+	node := func(name string) map[string]interface{} {
+		return map[string]interface{}{
+			"kind":     "Node",
+			"metadata": map[string]interface{}{"name": name},
+		}
+	}
+	pod := func(name, nodeName string) *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{Object: map[string]interface{}{
+			"kind":     "Pod",
+			"metadata": map[string]interface{}{"name": name},
+		}}
+		if nodeName != "" {
+			obj.Object["spec"] = map[string]interface{}{"nodeName": nodeName}
+		}
+		return obj
+	}
+	index := NewJoinIndex(nodeJoin, []map[string]interface{}{node("node1"), node("node2")})
+
+	scheduled := pod("scheduled", "node1")
+	index.Join(scheduled, scheduled)
+	assert.Equal(t, node("node1"), scheduled.Object["joined"].(map[string]interface{})["node"])
+
+	pending := pod("pending", "")
+	index.Join(pending, pending)
+	assert.NotContains(t, pending.Object, "joined", "expected an object without the field to not be joined")
+
+	hidden := pod("hidden", "node3")
+	index.Join(hidden, hidden)
+	assert.NotContains(t, hidden.Object, "joined", "expected an object without a related object to not be joined")
+
+	source := pod("projected", "node2")
+	projected := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "projected"},
+	}}
+	index.Join(source, projected)
+	assert.Equal(t, map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "projected"},
+		"joined":   map[string]interface{}{"node": node("node2")},
+	}, projected.Object, "expected the field of the source to be joined to the target")
+
+	claims := NewJoinIndex(pvcJoin, []map[string]interface{}{
+		{"metadata": map[string]interface{}{"name": "claim1"}, "spec": map[string]interface{}{"volumeName": "pv1"}},
+	})
+	volume := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "pv1"},
+	}}
+	claims.Join(volume, volume)
+	name, _, _ := unstructured.NestedString(volume.Object, "joined", "claim", "metadata", "name")
+	assert.Equal(t, "claim1", name, "expected the related object to be joined by its related field")
+}
@@ -0,0 +1,40 @@
+package listprocessor
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// PartialMetadataRequested returns whether the request asks for the metadata of the objects only, with the Accept
+// header of the metadata-only lists of Kubernetes, application/json;as=PartialObjectMetadataList.
+func PartialMetadataRequested(req *http.Request) bool {
+	if req == nil {
+		return false
+	}
+	for _, accept := range strings.Split(req.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(accept)
+		if err == nil && mediaType == "application/json" && params["as"] == "PartialObjectMetadataList" {
+			return true
+		}
+	}
+	return false
+}
+
+// MetadataList returns the objects of the list with only their apiVersion, kind and metadata. The objects in the list
+// are not modified, but their metadata is shared with the returned objects.
+func MetadataList(list []unstructured.Unstructured) []unstructured.Unstructured {
+	result := make([]unstructured.Unstructured, 0, len(list))
+	for _, obj := range list {
+		partial := make(map[string]interface{}, 3)
+		for _, field := range []string{"apiVersion", "kind", "metadata"} {
+			if value, ok := obj.Object[field]; ok {
+				partial[field] = value
+			}
+		}
+		result = append(result, unstructured.Unstructured{Object: partial})
+	}
+	return result
+}
@@ -0,0 +1,47 @@
+package listprocessor
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestPartialMetadataRequested(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{accept: "application/json;as=PartialObjectMetadataList;g=meta.k8s.io;v=v1", want: true},
+		{accept: "application/json;as=Table;g=meta.k8s.io;v=v1, application/json;as=PartialObjectMetadataList", want: true},
+		{accept: "application/json"},
+		{accept: "application/json;as=Table;g=meta.k8s.io;v=v1"},
+		{accept: ""},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest(http.MethodGet, "/v1/pods", nil)
+		assert.NoError(t, err)
+		req.Header.Set("Accept", test.accept)
+		assert.Equal(t, test.want, PartialMetadataRequested(req), "unexpected result for %q", test.accept)
+	}
+	assert.False(t, PartialMetadataRequested(nil))
+}
+
+func TestMetadataList(t *testing.T) {
+	pod := unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata":   map[string]interface{}{"name": "a", "namespace": "ns"},
+		"spec":       map[string]interface{}{"nodeName": "n1"},
+		"status":     map[string]interface{}{"phase": "Running"},
+	}}
+
+	got := MetadataList([]unstructured.Unstructured{pod})
+	assert.Equal(t, []unstructured.Unstructured{{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata":   map[string]interface{}{"name": "a", "namespace": "ns"},
+	}}}, got)
+	assert.Contains(t, pod.Object, "spec", "expected the listed objects to not be modified")
+}
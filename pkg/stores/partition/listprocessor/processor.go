@@ -2,16 +2,20 @@
 package listprocessor
 
 import (
+	"fmt"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/rancher/apiserver/pkg/types"
 	"github.com/rancher/wrangler/pkg/data"
 	"github.com/rancher/wrangler/pkg/data/convert"
 	corecontrollers "github.com/rancher/wrangler/pkg/generated/controllers/core/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
 const (
@@ -23,20 +27,49 @@ const (
 	pageSizeParam           = "pagesize"
 	pageParam               = "page"
 	revisionParam           = "revision"
+	sortNullsParam          = "sortnulls"
+	projectionParam         = "projection"
+	countOnlyParam          = "countonly"
 	projectsOrNamespacesVar = "projectsornamespaces"
 	projectIDFieldLabel     = "field.cattle.io/projectId"
 
 	orOp  = ","
 	notOp = "!"
+
+	// maxRegexLength is the maximum length of the pattern of a regular expression filter.
+	maxRegexLength = 256
 )
 
-var opReg = regexp.MustCompile(`[!]?=`)
+// regexMatchBudget is the number of bytes the regular expression filters of a query can match in total, so that a
+// query can not spend unbounded time matching large values.
+var regexMatchBudget = 16 << 20
+
+var opReg = regexp.MustCompile(`=~|[!~^<>]?=|[<>]`)
 
 type op string
 
 const (
 	eq    op = ""
 	notEq op = "!="
+	// foldEq matches values equal to the filter under Unicode case folding.
+	foldEq op = "~="
+	// foldPrefix matches values starting with the filter under Unicode case folding.
+	foldPrefix op = "^="
+	// exactEq and exactNotEq match values equal to the filter, and values which are not. They are only used by field
+	// selectors and cannot be written in a filter parameter.
+	exactEq    op = "=="
+	exactNotEq op = "!=="
+	// gt, lt, gte and lte match RFC3339 timestamps after, before, at or after, and at or before the filter's.
+	gt  op = ">"
+	lt  op = "<"
+	gte op = ">="
+	lte op = "<="
+	// exists and notExists match objects which have the field set to a value other than null, and objects which do
+	// not. They are written as the field alone, and as the field prefixed with '!'.
+	exists    op = "exists"
+	notExists op = "!exists"
+	// regexOp matches values matching the filter as an RE2 regular expression, which is not anchored.
+	regexOp op = "=~"
 )
 
 // ListOptions represents the query parameters that may be included in a list request.
@@ -48,6 +81,65 @@ type ListOptions struct {
 	Pagination           Pagination
 	Revision             string
 	ProjectsOrNamespaces ProjectsOrNamespacesFilter
+	Projection           Projection
+	// CountOnly requests the number of resources matching the other options without the resources themselves.
+	CountOnly bool
+	// MetadataOnly requests the resources with only their apiVersion, kind and metadata, as requested with
+	// PartialMetadataRequested.
+	MetadataOnly bool
+
+	filterErr   error
+	regexBudget *regexBudget
+}
+
+// Err returns an error if the filters can not be applied, because a regular expression is invalid, or because the
+// regular expressions exceeded their budget while filtering a list, whose result is then incomplete.
+func (o *ListOptions) Err() error {
+	if o.filterErr != nil {
+		return o.filterErr
+	}
+	if o.regexBudget != nil && o.regexBudget.exceeded {
+		return fmt.Errorf("regular expression filters matched more than %d bytes", regexMatchBudget)
+	}
+	return nil
+}
+
+// regexBudget is the number of bytes the regular expression filters of a query can still match, shared by all of them.
+type regexBudget struct {
+	remaining int
+	exceeded  bool
+}
+
+// Projection represents the fields to keep in the listed objects, with every other field removed.
+// The fields are represented in a request query as a comma separated list using . notation, e.g. 'projection=metadata.name,status.phase'.
+// A field inside a list applies to every element of the list, e.g. 'spec.containers.image'.
+type Projection struct {
+	fields    [][]string
+	requested bool
+}
+
+// NewProjection returns a projection keeping the fields, in . notation.
+func NewProjection(fields ...string) Projection {
+	p := Projection{}
+	for _, field := range fields {
+		if field != "" {
+			p.fields = append(p.fields, strings.Split(field, "."))
+		}
+	}
+	return p
+}
+
+// Requested returns whether the projection was requested with the projection parameter, even if it has no fields.
+func (p Projection) Requested() bool {
+	return p.requested
+}
+
+// projectionBaseFields are always kept by a projection, so that objects can still be identified.
+var projectionBaseFields = [][]string{
+	{"apiVersion"},
+	{"kind"},
+	{"metadata", "name"},
+	{"metadata", "namespace"},
 }
 
 // Filter represents a field to filter by.
@@ -57,12 +149,118 @@ type Filter struct {
 	field []string
 	match string
 	op    op
+	// matchTime is the match parsed as an RFC3339 timestamp for the comparison operators, or nil if it is not one.
+	matchTime *time.Time
+	// regex is the match compiled for the regexOp operator, which uses the budget of the query.
+	regex  *regexp.Regexp
+	budget *regexBudget
 }
 
 // String returns the filter as a query string.
 func (f Filter) String() string {
 	field := strings.Join(f.field, ".")
-	return field + "=" + f.match
+	switch f.op {
+	case exists:
+		return field
+	case notExists:
+		return notOp + field
+	}
+	return field + f.op.String() + f.match
+}
+
+// String returns the operator as it appears in a query string.
+func (o op) String() string {
+	if o == eq {
+		return "="
+	}
+	return string(o)
+}
+
+// matchesValue returns whether a value of the filtered field matches the filter, regardless of negation. Values
+// otherwise match if they contain the filter.
+func (f Filter) matchesValue(value string) bool {
+	switch f.op {
+	case foldEq:
+		return strings.EqualFold(value, f.match)
+	case foldPrefix:
+		return hasPrefixFold(value, f.match)
+	case exactEq, exactNotEq:
+		return value == f.match
+	case gt, lt, gte, lte:
+		return f.matchesTime(value)
+	case regexOp:
+		return f.matchesRegex(value)
+	}
+	return strings.Contains(value, f.match)
+}
+
+// matchesRegex returns whether the value matches the regular expression of the filter, as long as the budget of the
+// query allows matching it.
+func (f Filter) matchesRegex(value string) bool {
+	if f.regex == nil || f.budget == nil || f.budget.exceeded {
+		return false
+	}
+	if len(value) > f.budget.remaining {
+		f.budget.exceeded = true
+		return false
+	}
+	f.budget.remaining -= len(value)
+	return f.regex.MatchString(value)
+}
+
+// matchesTime returns whether a value is an RFC3339 timestamp which compares to the filter's as its operator requires.
+// Timestamps are compared as instants, so they match regardless of their time zones.
+func (f Filter) matchesTime(value string) bool {
+	if f.matchTime == nil {
+		return false
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return false
+	}
+	switch f.op {
+	case gt:
+		return t.After(*f.matchTime)
+	case lt:
+		return t.Before(*f.matchTime)
+	case gte:
+		return !t.Before(*f.matchTime)
+	case lte:
+		return !t.After(*f.matchTime)
+	}
+	return false
+}
+
+// parseMatchTime parses the match of a comparison filter as an RFC3339 timestamp, normalized to UTC. A '+' in a query
+// string is decoded as a space, so a space before the offset of the time zone is read as a '+'.
+func parseMatchTime(match string) *time.Time {
+	t, err := time.Parse(time.RFC3339, strings.Replace(match, " ", "+", 1))
+	if err != nil {
+		return nil
+	}
+	t = t.UTC()
+	return &t
+}
+
+// negated returns whether objects match the operator when their value does not match the filter.
+func (o op) negated() bool {
+	return o == notEq || o == exactNotEq || o == notExists
+}
+
+// hasPrefixFold is like strings.HasPrefix, comparing the runes of the value and the prefix under simple Unicode case
+// folding like strings.EqualFold does.
+func hasPrefixFold(value, prefix string) bool {
+	for _, p := range prefix {
+		v, size := utf8.DecodeRuneInString(value)
+		if size == 0 {
+			return false
+		}
+		if v != p && !strings.EqualFold(string(v), string(p)) {
+			return false
+		}
+		value = value[size:]
+	}
+	return true
 }
 
 // OrFilter represents a set of possible fields to filter by, where an item may match any filter in the set to be included in the result.
@@ -74,9 +272,7 @@ type OrFilter struct {
 func (f OrFilter) String() string {
 	var fields strings.Builder
 	for i, field := range f.filters {
-		fields.WriteString(strings.Join(field.field, "."))
-		fields.WriteByte('=')
-		fields.WriteString(field.match)
+		fields.WriteString(field.String())
 		if i < len(f.filters)-1 {
 			fields.WriteByte(',')
 		}
@@ -98,30 +294,67 @@ const (
 // The subfield to sort by is represented in a request query using . notation, e.g. 'metadata.name'.
 // The subfield is internally represented as a slice, e.g. [metadata, name].
 // The order is represented by prefixing the sort key by '-', e.g. sort=-metadata.name.
+// Multiple comma separated keys break ties in order, e.g. sort=data.color,-metadata.name, and objects that are still
+// tied are ordered by namespace and name.
+// An element of a list is represented by its index, e.g. 'spec.containers[0].image', internally [spec, containers, [0], image].
+// Objects missing the field are sorted after the others in either order, unless sortnulls=first is requested.
 type Sort struct {
-	primaryField   []string
-	secondaryField []string
-	primaryOrder   SortOrder
-	secondaryOrder SortOrder
+	keys       []sortKey
+	nullsFirst bool
+}
+
+// sortKey is a single field to sort on and its order.
+type sortKey struct {
+	field []string
+	order SortOrder
 }
 
 // String returns the sort parameters as a query string.
 func (s Sort) String() string {
-	field := ""
-	if s.primaryOrder == DESC {
-		field = "-" + field
-	}
-	field += strings.Join(s.primaryField, ".")
-	if len(s.secondaryField) > 0 {
-		field += ","
-		if s.secondaryOrder == DESC {
-			field += "-"
+	keys := make([]string, 0, len(s.keys))
+	for _, key := range s.keys {
+		field := fieldString(key.field)
+		if key.order == DESC {
+			field = "-" + field
 		}
-		field += strings.Join(s.secondaryField, ".")
+		keys = append(keys, field)
+	}
+	field := strings.Join(keys, ",")
+	if s.nullsFirst {
+		field += "&" + sortNullsParam + "=first"
 	}
 	return field
 }
 
+var indexReg = regexp.MustCompile(`\[[0-9]+\]`)
+
+// parseField splits a sort field into its subfields, making every list index a subfield of its own.
+func parseField(field string) []string {
+	var result []string
+	for _, part := range strings.Split(field, ".") {
+		for {
+			loc := indexReg.FindStringIndex(part)
+			if loc == nil {
+				break
+			}
+			if loc[0] > 0 {
+				result = append(result, part[:loc[0]])
+			}
+			result = append(result, part[loc[0]:loc[1]])
+			part = part[loc[1]:]
+		}
+		if part != "" {
+			result = append(result, part)
+		}
+	}
+	return result
+}
+
+// fieldString joins subfields split by parseField back into a sort field.
+func fieldString(field []string) string {
+	return strings.ReplaceAll(strings.Join(field, "."), ".[", "[")
+}
+
 // Pagination represents how to return paginated results.
 type Pagination struct {
 	pageSize int
@@ -133,12 +366,30 @@ func (p Pagination) PageSize() int {
 	return p.pageSize
 }
 
+// Clamp returns the pagination with a page size of at most max, and whether the page size was reduced to max.
+// The pagination is unchanged if max is not positive.
+func (p Pagination) Clamp(max int) (Pagination, bool) {
+	if max <= 0 || p.pageSize <= max {
+		return p, false
+	}
+	p.pageSize = max
+	return p, true
+}
+
 type ProjectsOrNamespacesFilter struct {
 	filter map[string]struct{}
 	op     op
 }
 
 // ParseQuery parses the query params of a request and returns a ListOptions.
+// Each filter param is an OR group of comma separated filters, and an object must match every group to be listed, so
+// filter=a=x,b=x&filter=c=y selects objects where (a contains x OR b contains x) AND c contains y. Commas always
+// separate filters; they cannot be part of a filter's value. Timestamps are compared with the >, <, >= and <=
+// operators, so filter=metadata.creationTimestamp>=2024-01-01T00:00:00Z&filter=metadata.creationTimestamp<2024-02-01T00:00:00Z
+// selects the objects created in January 2024. A filter without an operator selects the objects which have the field,
+// and one prefixed with '!' those which do not, so filter=!spec.nodeName selects the pods which are not scheduled.
+// The =~ operator matches values with a regular expression, such as filter=metadata.name=~^web-[0-9]+$. Errors in
+// regular expressions are returned by Err.
 func ParseQuery(apiOp *types.APIRequest) *ListOptions {
 	opts := ListOptions{}
 
@@ -150,19 +401,35 @@ func ParseQuery(apiOp *types.APIRequest) *ListOptions {
 
 	filterParams := q[filterParam]
 	filterOpts := []OrFilter{}
+	opts.regexBudget = &regexBudget{remaining: regexMatchBudget}
 	for _, filters := range filterParams {
 		orFilters := strings.Split(filters, orOp)
 		orFilter := OrFilter{}
 		for _, filter := range orFilters {
-			var op op
-			if strings.Contains(filter, "!=") {
-				op = "!="
+			if f, ok := existsFilter(filter); ok {
+				orFilter.filters = append(orFilter.filters, f)
+				continue
+			}
+			// only the first operator splits the field from the value, which may contain operators too
+			loc := opReg.FindStringIndex(filter)
+			if loc == nil {
+				continue
 			}
-			filter := opReg.Split(filter, -1)
-			if len(filter) != 2 {
+			op := opFromString(filter[loc[0]:loc[1]])
+			if op == regexOp {
+				f, err := regexFilter(filter, opts.regexBudget)
+				if err != nil && opts.filterErr == nil {
+					opts.filterErr = err
+				}
+				orFilter.filters = append(orFilter.filters, f)
 				continue
 			}
-			orFilter.filters = append(orFilter.filters, Filter{field: strings.Split(filter[0], "."), match: filter[1], op: op})
+			f := Filter{field: strings.Split(filter[:loc[0]], "."), match: filter[loc[1]:], op: op}
+			switch op {
+			case gt, lt, gte, lte:
+				f.matchTime = parseMatchTime(f.match)
+			}
+			orFilter.filters = append(orFilter.filters, f)
 		}
 		filterOpts = append(filterOpts, orFilter)
 	}
@@ -187,28 +454,19 @@ func ParseQuery(apiOp *types.APIRequest) *ListOptions {
 	opts.Filters = filterOpts
 
 	sortOpts := Sort{}
-	sortKeys := q.Get(sortParam)
-	if sortKeys != "" {
-		sortParts := strings.SplitN(sortKeys, ",", 2)
-		primaryField := sortParts[0]
-		if primaryField != "" && primaryField[0] == '-' {
-			sortOpts.primaryOrder = DESC
-			primaryField = primaryField[1:]
-		}
-		if primaryField != "" {
-			sortOpts.primaryField = strings.Split(primaryField, ".")
-		}
-		if len(sortParts) > 1 {
-			secondaryField := sortParts[1]
-			if secondaryField != "" && secondaryField[0] == '-' {
-				sortOpts.secondaryOrder = DESC
-				secondaryField = secondaryField[1:]
-			}
-			if secondaryField != "" {
-				sortOpts.secondaryField = strings.Split(secondaryField, ".")
-			}
+	for _, field := range strings.Split(q.Get(sortParam), ",") {
+		key := sortKey{}
+		if field != "" && field[0] == '-' {
+			key.order = DESC
+			field = field[1:]
+		}
+		if field == "" {
+			continue
 		}
+		key.field = parseField(field)
+		sortOpts.keys = append(sortOpts.keys, key)
 	}
+	sortOpts.nullsFirst = q.Get(sortNullsParam) == "first"
 	opts.Sort = sortOpts
 
 	var err error
@@ -223,6 +481,12 @@ func ParseQuery(apiOp *types.APIRequest) *ListOptions {
 	}
 	opts.Pagination = pagination
 
+	opts.Projection = NewProjection(strings.Split(q.Get(projectionParam), ",")...)
+	opts.Projection.requested = q.Has(projectionParam)
+
+	opts.CountOnly = q.Get(countOnlyParam) == "true"
+	opts.MetadataOnly = PartialMetadataRequested(apiOp.Request)
+
 	revision := q.Get(revisionParam)
 	opts.Revision = revision
 
@@ -246,6 +510,61 @@ func ParseQuery(apiOp *types.APIRequest) *ListOptions {
 	return &opts
 }
 
+// existsFilter returns the exists filter of a filter without an operator, or the notExists filter if it starts with '!'.
+func existsFilter(filter string) (Filter, bool) {
+	if opReg.MatchString(filter) {
+		return Filter{}, false
+	}
+	op := exists
+	if strings.HasPrefix(filter, notOp) {
+		op = notExists
+		filter = filter[len(notOp):]
+	}
+	if filter == "" {
+		return Filter{}, false
+	}
+	return Filter{field: strings.Split(filter, "."), op: op}, true
+}
+
+// regexFilter returns the filter of a regular expression, whose pattern is everything after the operator. It returns an
+// error if the pattern is too long or is not a valid RE2 regular expression.
+func regexFilter(filter string, budget *regexBudget) (Filter, error) {
+	loc := opReg.FindStringIndex(filter)
+	f := Filter{field: strings.Split(filter[:loc[0]], "."), match: filter[loc[1]:], op: regexOp, budget: budget}
+	if len(f.match) > maxRegexLength {
+		return f, fmt.Errorf("regular expression of filter on %s is longer than %d characters", filter[:loc[0]], maxRegexLength)
+	}
+	regex, err := regexp.Compile(f.match)
+	if err != nil {
+		return f, fmt.Errorf("invalid regular expression of filter on %s: %w", filter[:loc[0]], err)
+	}
+	f.regex = regex
+	return f, nil
+}
+
+// opFromString returns the operator matching the text found by opReg, defaulting to eq.
+func opFromString(s string) op {
+	switch s {
+	case string(notEq):
+		return notEq
+	case string(foldEq):
+		return foldEq
+	case string(foldPrefix):
+		return foldPrefix
+	case string(gt):
+		return gt
+	case string(lt):
+		return lt
+	case string(gte):
+		return gte
+	case string(lte):
+		return lte
+	case string(regexOp):
+		return regexOp
+	}
+	return eq
+}
+
 // getLimit extracts the limit parameter from the request or sets a default of 100000.
 // The default limit can be explicitly disabled by setting it to zero or negative.
 // If the default is accepted, clients must be aware that the list may be incomplete, and use the "continue" token to get the next chunk of results.
@@ -277,6 +596,9 @@ func FilterList(list <-chan []unstructured.Unstructured, filters []OrFilter) []u
 }
 
 func matchesOne(obj map[string]interface{}, filter Filter) bool {
+	if filter.op == exists || filter.op == notExists {
+		return fieldExists(obj, filter.field)
+	}
 	var objValue interface{}
 	var ok bool
 	subField := []string{}
@@ -296,11 +618,11 @@ func matchesOne(obj map[string]interface{}, filter Filter) bool {
 			return false
 		}
 		stringVal := convert.ToString(typedVal)
-		if strings.Contains(stringVal, filter.match) {
+		if filter.matchesValue(stringVal) {
 			return true
 		}
 	case []interface{}:
-		filter = Filter{field: subField, match: filter.match, op: filter.op}
+		filter.field = subField
 		if matchesOneInList(typedVal, filter) {
 			return true
 		}
@@ -313,7 +635,7 @@ func matchesOneInList(obj []interface{}, filter Filter) bool {
 		switch typedItem := v.(type) {
 		case string, int, bool:
 			stringVal := convert.ToString(typedItem)
-			if strings.Contains(stringVal, filter.match) {
+			if filter.matchesValue(stringVal) {
 				return true
 			}
 		case map[string]interface{}:
@@ -329,49 +651,166 @@ func matchesOneInList(obj []interface{}, filter Filter) bool {
 	return false
 }
 
+// fieldExists returns whether the value has the field set to a value other than null. Empty values, such as an empty
+// string or list, exist. A field inside a list exists if it exists in any element of the list.
+func fieldExists(value interface{}, field []string) bool {
+	if len(field) == 0 {
+		return value != nil
+	}
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		return fieldExists(typed[field[0]], field[1:])
+	case []interface{}:
+		for _, item := range typed {
+			if fieldExists(item, field) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchesAny returns whether the object matches any of the filters. Regular expressions are only matched if no other
+// filter matches, since they are the most expensive.
 func matchesAny(obj map[string]interface{}, filter OrFilter) bool {
-	for _, f := range filter.filters {
-		matches := matchesOne(obj, f)
-		if (matches && f.op == eq) || (!matches && f.op == notEq) {
-			return true
+	for _, regex := range []bool{false, true} {
+		for _, f := range filter.filters {
+			if (f.op == regexOp) != regex {
+				continue
+			}
+			matches := matchesOne(obj, f)
+			if matches != f.op.negated() {
+				return true
+			}
 		}
 	}
 	return false
 }
 
+// matchesAll returns whether the object matches all the filters. The filters with regular expressions are applied
+// last, to the objects the other filters select.
 func matchesAll(obj map[string]interface{}, filters []OrFilter) bool {
-	for _, f := range filters {
-		if !matchesAny(obj, f) {
-			return false
+	for _, regex := range []bool{false, true} {
+		for _, f := range filters {
+			if f.hasRegex() != regex {
+				continue
+			}
+			if !matchesAny(obj, f) {
+				return false
+			}
 		}
 	}
 	return true
 }
 
+func (f OrFilter) hasRegex() bool {
+	for _, filter := range f.filters {
+		if filter.op == regexOp {
+			return true
+		}
+	}
+	return false
+}
+
 // SortList sorts the slice by the provided sort criteria.
+// Numbers are compared by value, any other values by their string representation.
 func SortList(list []unstructured.Unstructured, s Sort) []unstructured.Unstructured {
-	if len(s.primaryField) == 0 {
+	if len(s.keys) == 0 {
 		return list
 	}
 	sort.Slice(list, func(i, j int) bool {
-		leftPrime := convert.ToString(data.GetValueN(list[i].Object, s.primaryField...))
-		rightPrime := convert.ToString(data.GetValueN(list[j].Object, s.primaryField...))
-		if leftPrime == rightPrime && len(s.secondaryField) > 0 {
-			leftSecond := convert.ToString(data.GetValueN(list[i].Object, s.secondaryField...))
-			rightSecond := convert.ToString(data.GetValueN(list[j].Object, s.secondaryField...))
-			if s.secondaryOrder == ASC {
-				return leftSecond < rightSecond
+		for _, key := range s.keys {
+			if cmp := s.compare(list[i].Object, list[j].Object, key.field, key.order); cmp != 0 {
+				return cmp < 0
 			}
-			return rightSecond < leftSecond
 		}
-		if s.primaryOrder == ASC {
-			return leftPrime < rightPrime
+		if list[i].GetNamespace() != list[j].GetNamespace() {
+			return list[i].GetNamespace() < list[j].GetNamespace()
 		}
-		return rightPrime < leftPrime
+		return list[i].GetName() < list[j].GetName()
 	})
 	return list
 }
 
+// compare returns -1, 0 or 1 depending on whether the left object sorts before, with or after the right one on the field.
+func (s Sort) compare(left, right map[string]interface{}, field []string, order SortOrder) int {
+	leftValue, leftOK := sortValue(left, field)
+	rightValue, rightOK := sortValue(right, field)
+	if !leftOK || !rightOK {
+		switch {
+		case leftOK == rightOK:
+			return 0
+		case leftOK == s.nullsFirst:
+			return 1
+		default:
+			return -1
+		}
+	}
+
+	var cmp int
+	leftNumber, leftIsNumber := toNumber(leftValue)
+	rightNumber, rightIsNumber := toNumber(rightValue)
+	if leftIsNumber && rightIsNumber {
+		cmp = compareOrdered(leftNumber, rightNumber)
+	} else {
+		cmp = compareOrdered(convert.ToString(leftValue), convert.ToString(rightValue))
+	}
+	if order == DESC {
+		return -cmp
+	}
+	return cmp
+}
+
+// sortValue returns the value of the field in the object, indexing into lists for subfields such as [0].
+// It returns false if the field is missing or null.
+func sortValue(obj map[string]interface{}, field []string) (interface{}, bool) {
+	var value interface{} = obj
+	for _, subField := range field {
+		switch typed := value.(type) {
+		case map[string]interface{}:
+			value = typed[subField]
+		case []interface{}:
+			if !indexReg.MatchString(subField) {
+				return nil, false
+			}
+			i, err := strconv.Atoi(subField[1 : len(subField)-1])
+			if err != nil || i >= len(typed) {
+				return nil, false
+			}
+			value = typed[i]
+		default:
+			return nil, false
+		}
+	}
+	return value, value != nil
+}
+
+func toNumber(value interface{}) (float64, bool) {
+	switch typed := value.(type) {
+	case int:
+		return float64(typed), true
+	case int32:
+		return float64(typed), true
+	case int64:
+		return float64(typed), true
+	case float32:
+		return float64(typed), true
+	case float64:
+		return typed, true
+	}
+	return 0, false
+}
+
+func compareOrdered[T float64 | string](left, right T) int {
+	switch {
+	case left < right:
+		return -1
+	case left > right:
+		return 1
+	}
+	return 0
+}
+
 // PaginateList returns a subset of the result based on the pagination criteria as well as the total number of pages the caller can expect.
 func PaginateList(list []unstructured.Unstructured, p Pagination) ([]unstructured.Unstructured, int) {
 	if p.pageSize <= 0 {
@@ -395,6 +834,72 @@ func PaginateList(list []unstructured.Unstructured, p Pagination) ([]unstructure
 	return list[offset : offset+p.pageSize], pages
 }
 
+// ProjectList returns the objects of the list with only the fields of the projection, along with the apiVersion, kind,
+// name and namespace of each object. Fields missing from an object are left out rather than set to null.
+// The list is returned unchanged if the projection has no fields. The objects in the list are not modified.
+func ProjectList(list []unstructured.Unstructured, p Projection) []unstructured.Unstructured {
+	if len(p.fields) == 0 {
+		return list
+	}
+	fields := append(append([][]string{}, projectionBaseFields...), p.fields...)
+	result := make([]unstructured.Unstructured, 0, len(list))
+	for _, obj := range list {
+		projected := map[string]interface{}{}
+		for _, field := range fields {
+			projectField(obj.Object, projected, field)
+		}
+		result = append(result, unstructured.Unstructured{Object: projected})
+	}
+	return result
+}
+
+// projectField copies the field from the source object to the destination object, copying the field of every element
+// of the lists it goes through. Values are copied deeply, so the destination shares no data with the source.
+func projectField(src, dst map[string]interface{}, field []string) {
+	value, ok := src[field[0]]
+	if !ok {
+		return
+	}
+	if len(field) == 1 {
+		dst[field[0]] = runtime.DeepCopyJSONValue(value)
+		return
+	}
+
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		child, _ := dst[field[0]].(map[string]interface{})
+		if child == nil {
+			child = map[string]interface{}{}
+		}
+		projectField(typed, child, field[1:])
+		if len(child) > 0 {
+			dst[field[0]] = child
+		}
+	case []interface{}:
+		children, _ := dst[field[0]].([]interface{})
+		if len(children) != len(typed) {
+			children = make([]interface{}, len(typed))
+		}
+		found := false
+		for i, item := range typed {
+			itemMap, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			child, _ := children[i].(map[string]interface{})
+			if child == nil {
+				child = map[string]interface{}{}
+			}
+			projectField(itemMap, child, field[1:])
+			children[i] = child
+			found = found || len(child) > 0
+		}
+		if found {
+			dst[field[0]] = children
+		}
+	}
+}
+
 func FilterByProjectsAndNamespaces(list []unstructured.Unstructured, projectsOrNamespaces ProjectsOrNamespacesFilter, namespaceCache corecontrollers.NamespaceCache) []unstructured.Unstructured {
 	if len(projectsOrNamespaces.filter) == 0 {
 		return list
@@ -1,8 +1,13 @@
 package listprocessor
 
 import (
+	"net/http"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/rancher/apiserver/pkg/types"
 	"github.com/rancher/wrangler/pkg/generic"
 	"github.com/stretchr/testify/assert"
 	corev1 "k8s.io/api/core/v1"
@@ -1742,6 +1747,400 @@ func TestFilterList(t *testing.T) {
 	}
 }
 
+func TestParseQueryFilterGroups(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "/?filter=metadata.name=foo,metadata.labels.app=foo&filter=status.phase!=Failed", nil)
+	assert.NoError(t, err)
+	opts := ParseQuery(&types.APIRequest{Request: req})
+	assert.Equal(t, []OrFilter{
+		{
+			filters: []Filter{
+				{field: []string{"metadata", "labels", "app"}, match: "foo"},
+				{field: []string{"metadata", "name"}, match: "foo"},
+			},
+		},
+		{
+			filters: []Filter{
+				{field: []string{"status", "phase"}, match: "Failed", op: notEq},
+			},
+		},
+	}, opts.Filters)
+
+	objects := []unstructured.Unstructured{
+		{Object: map[string]interface{}{"metadata": map[string]interface{}{"name": "foo"}, "status": map[string]interface{}{"phase": "Running"}}},
+		{Object: map[string]interface{}{"metadata": map[string]interface{}{"name": "bar", "labels": map[string]interface{}{"app": "foo"}}, "status": map[string]interface{}{"phase": "Running"}}},
+		{Object: map[string]interface{}{"metadata": map[string]interface{}{"name": "foo-failed"}, "status": map[string]interface{}{"phase": "Failed"}}},
+		{Object: map[string]interface{}{"metadata": map[string]interface{}{"name": "baz"}, "status": map[string]interface{}{"phase": "Running"}}},
+	}
+	ch := make(chan []unstructured.Unstructured, 1)
+	ch <- objects
+	close(ch)
+	var got []string
+	for _, obj := range FilterList(ch, opts.Filters) {
+		got = append(got, obj.GetName())
+	}
+	assert.Equal(t, []string{"foo", "bar"}, got, "expected the filters of a param to be ORed and the params to be ANDed")
+}
+
+func TestFilterListFold(t *testing.T) {
+	names := []string{"Fuji", "fuji-2", "granny-smith", "KELVIN", "\u212aelvin", "ΣΊΣΥΦΟΣ", "straße"}
+	var objects []unstructured.Unstructured
+	for _, name := range names {
+		objects = append(objects, unstructured.Unstructured{
+			Object: map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"name": name,
+				},
+			},
+		})
+	}
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{name: "case-insensitive equality", query: "metadata.name~=fUJI", want: []string{"Fuji"}},
+		{name: "case-insensitive prefix", query: "metadata.name^=fu", want: []string{"Fuji", "fuji-2"}},
+		{name: "negated substring remains case sensitive", query: "metadata.name!=fuji", want: []string{"Fuji", "granny-smith", "KELVIN", "\u212aelvin", "ΣΊΣΥΦΟΣ", "straße"}},
+		{name: "kelvin sign folds to k", query: "metadata.name^=kel", want: []string{"KELVIN", "\u212aelvin"}},
+		{name: "final sigma folds to sigma", query: "metadata.name~=σίσυφος", want: []string{"ΣΊΣΥΦΟΣ"}},
+		{name: "multi-letter folds are not expanded", query: "metadata.name~=STRASSE"},
+		{name: "prefix longer than value", query: "metadata.name^=fuji-23"},
+		{name: "empty prefix matches everything", query: "metadata.name^=", want: names},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "/?filter="+url.QueryEscape(test.query), nil)
+			assert.NoError(t, err)
+			opts := ParseQuery(&types.APIRequest{Request: req})
+			if assert.Len(t, opts.Filters, 1) {
+				assert.Equal(t, test.query, opts.Filters[0].String())
+			}
+
+			ch := make(chan []unstructured.Unstructured, 1)
+			ch <- objects
+			close(ch)
+			var got []string
+			for _, obj := range FilterList(ch, opts.Filters) {
+				got = append(got, obj.GetName())
+			}
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestFilterListTimestamps(t *testing.T) {
+	created := map[string]string{
+		"old":     "2023-12-31T23:00:00Z",
+		"new-day": "2024-01-01T00:00:00Z",
+		"later":   "2024-01-01T12:30:00Z",
+		// 2024-01-01T22:00:00Z
+		"offset":  "2024-01-02T00:00:00+02:00",
+		"invalid": "yesterday",
+	}
+	var objects []unstructured.Unstructured
+	for _, name := range []string{"old", "new-day", "later", "offset", "invalid"} {
+		objects = append(objects, unstructured.Unstructured{
+			Object: map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"name":              name,
+					"creationTimestamp": created[name],
+				},
+			},
+		})
+	}
+	objects = append(objects, unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"name": "missing",
+			},
+		},
+	})
+	tests := []struct {
+		name    string
+		filters []string
+		want    []string
+	}{
+		{
+			name:    "after",
+			filters: []string{"metadata.creationTimestamp>2024-01-01T00:00:00Z"},
+			want:    []string{"later", "offset"},
+		},
+		{
+			name:    "at or after",
+			filters: []string{"metadata.creationTimestamp>=2024-01-01T00:00:00Z"},
+			want:    []string{"new-day", "later", "offset"},
+		},
+		{
+			name:    "before",
+			filters: []string{"metadata.creationTimestamp<2024-01-01T00:00:00Z"},
+			want:    []string{"old"},
+		},
+		{
+			name:    "at or before",
+			filters: []string{"metadata.creationTimestamp<=2024-01-01T00:00:00Z"},
+			want:    []string{"old", "new-day"},
+		},
+		{
+			name:    "range",
+			filters: []string{"metadata.creationTimestamp>=2024-01-01T00:00:00Z", "metadata.creationTimestamp<2024-01-01T22:00:00Z"},
+			want:    []string{"new-day", "later"},
+		},
+		{
+			name:    "time zones are normalized",
+			filters: []string{"metadata.creationTimestamp<2024-01-01T09:00:00+08:00"},
+			want:    []string{"old", "new-day"},
+		},
+		{
+			name:    "invalid timestamps match nothing",
+			filters: []string{"metadata.creationTimestamp>last-week"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query := url.Values{filterParam: test.filters}
+			req, err := http.NewRequest(http.MethodGet, "/?"+query.Encode(), nil)
+			assert.NoError(t, err)
+			opts := ParseQuery(&types.APIRequest{Request: req})
+			if assert.Len(t, opts.Filters, len(test.filters)) {
+				for i, filter := range opts.Filters {
+					assert.Contains(t, test.filters, filter.String())
+					assert.Len(t, opts.Filters[i].filters, 1)
+				}
+			}
+
+			ch := make(chan []unstructured.Unstructured, 1)
+			ch <- objects
+			close(ch)
+			var got []string
+			for _, obj := range FilterList(ch, opts.Filters) {
+				got = append(got, obj.GetName())
+			}
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestFilterListExists(t *testing.T) {
+	pod := func(name string, spec map[string]interface{}) unstructured.Unstructured {
+		return unstructured.Unstructured{
+			Object: map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"name": name,
+				},
+				"spec": spec,
+			},
+		}
+	}
+	objects := []unstructured.Unstructured{
+		pod("scheduled", map[string]interface{}{
+			"nodeName": "node1",
+			"containers": []interface{}{
+				map[string]interface{}{"name": "web", "ports": []interface{}{}},
+			},
+		}),
+		pod("empty", map[string]interface{}{
+			"nodeName": "",
+			"containers": []interface{}{
+				map[string]interface{}{"name": "web"},
+			},
+		}),
+		pod("null", map[string]interface{}{
+			"nodeName": nil,
+		}),
+		pod("absent", map[string]interface{}{}),
+	}
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{name: "exists includes empty values", query: "spec.nodeName", want: []string{"scheduled", "empty"}},
+		{name: "not exists includes null values", query: "!spec.nodeName", want: []string{"null", "absent"}},
+		{name: "empty substring matches any value", query: "spec.nodeName=", want: []string{"scheduled", "empty"}},
+		{name: "empty value", query: "spec.nodeName~=", want: []string{"empty"}},
+		{name: "missing or empty", query: "!spec.nodeName,spec.nodeName~=", want: []string{"empty", "null", "absent"}},
+		{name: "exists in a list item", query: "spec.containers.ports", want: []string{"scheduled"}},
+		{name: "empty lists exist", query: "spec.containers", want: []string{"scheduled", "empty"}},
+		{name: "not exists in any list item", query: "!spec.containers.ports", want: []string{"empty", "null", "absent"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "/?filter="+url.QueryEscape(test.query), nil)
+			assert.NoError(t, err)
+			opts := ParseQuery(&types.APIRequest{Request: req})
+			if assert.Len(t, opts.Filters, 1) {
+				assert.ElementsMatch(t, strings.Split(test.query, ","), strings.Split(opts.Filters[0].String(), ","))
+			}
+
+			ch := make(chan []unstructured.Unstructured, 1)
+			ch <- objects
+			close(ch)
+			var got []string
+			for _, obj := range FilterList(ch, opts.Filters) {
+				got = append(got, obj.GetName())
+			}
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestFilterListRegex(t *testing.T) {
+	names := []string{"web-1", "web-12", "web-canary", "db-1", "a=b"}
+	var objects []unstructured.Unstructured
+	for _, name := range names {
+		objects = append(objects, unstructured.Unstructured{
+			Object: map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"name":   name,
+					"labels": map[string]interface{}{"tier": strings.Split(name, "-")[0]},
+				},
+			},
+		})
+	}
+	tests := []struct {
+		name    string
+		filters []string
+		want    []string
+	}{
+		{name: "anchored pattern", filters: []string{"metadata.name=~^web-[0-9]+$"}, want: []string{"web-1", "web-12"}},
+		{name: "unanchored pattern", filters: []string{"metadata.name=~1"}, want: []string{"web-1", "web-12", "db-1"}},
+		{name: "pattern with operators", filters: []string{"metadata.name=~^a=b$"}, want: []string{"a=b"}},
+		{name: "case-insensitive flag", filters: []string{"metadata.name=~(?i)^WEB-C"}, want: []string{"web-canary"}},
+		{name: "ORed with other filters", filters: []string{"metadata.name=db-1,metadata.name=~canary$"}, want: []string{"web-canary", "db-1"}},
+		{name: "ANDed with other filters", filters: []string{"metadata.name=~-1", "metadata.labels.tier=web"}, want: []string{"web-1", "web-12"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query := url.Values{filterParam: test.filters}
+			req, err := http.NewRequest(http.MethodGet, "/?"+query.Encode(), nil)
+			assert.NoError(t, err)
+			opts := ParseQuery(&types.APIRequest{Request: req})
+			assert.NoError(t, opts.Err())
+
+			ch := make(chan []unstructured.Unstructured, 1)
+			ch <- objects
+			close(ch)
+			var got []string
+			for _, obj := range FilterList(ch, opts.Filters) {
+				got = append(got, obj.GetName())
+			}
+			assert.Equal(t, test.want, got)
+			assert.NoError(t, opts.Err())
+		})
+	}
+}
+
+func TestParseQueryRegexErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		wantErr bool
+	}{
+		{name: "longest pattern", pattern: strings.Repeat("a", maxRegexLength)},
+		{name: "pattern too long", pattern: strings.Repeat("a", maxRegexLength+1), wantErr: true},
+		{name: "invalid pattern", pattern: "web-(", wantErr: true},
+		{name: "backreferences are not supported", pattern: `(a)\1`, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "/?filter="+url.QueryEscape("metadata.name=~"+test.pattern), nil)
+			assert.NoError(t, err)
+			opts := ParseQuery(&types.APIRequest{Request: req})
+			if test.wantErr {
+				assert.Error(t, opts.Err())
+			} else {
+				assert.NoError(t, opts.Err())
+			}
+		})
+	}
+}
+
+func TestFilterListRegexBudget(t *testing.T) {
+	defaultBudget := regexMatchBudget
+	regexMatchBudget = 20
+	defer func() {
+		regexMatchBudget = defaultBudget
+	}()
+
+	var objects []unstructured.Unstructured
+	for _, name := range []string{"web-1", "web-2", "web-3", "web-4", "web-5"} {
+		objects = append(objects, unstructured.Unstructured{
+			Object: map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"name": name,
+				},
+			},
+		})
+	}
+	filter := func(query string) ([]unstructured.Unstructured, error) {
+		req, err := http.NewRequest(http.MethodGet, "/?filter="+url.QueryEscape(query), nil)
+		assert.NoError(t, err)
+		opts := ParseQuery(&types.APIRequest{Request: req})
+		ch := make(chan []unstructured.Unstructured, 1)
+		ch <- objects
+		close(ch)
+		list := FilterList(ch, opts.Filters)
+		return list, opts.Err()
+	}
+
+	list, err := filter("metadata.name=~web")
+	assert.Error(t, err, "expected matching 25 bytes to exceed the budget")
+	assert.Len(t, list, 4)
+
+	// the other filters narrow the list before the regular expressions are matched
+	list, err = filter("metadata.name=~web,metadata.name=web-1")
+	assert.NoError(t, err)
+	assert.Len(t, list, 5)
+}
+
+func TestParseQueryTimestampOffset(t *testing.T) {
+	// an unescaped + is decoded as a space
+	req, err := http.NewRequest(http.MethodGet, "/?filter=metadata.creationTimestamp>2024-01-01T08:00:00+08:00", nil)
+	assert.NoError(t, err)
+	opts := ParseQuery(&types.APIRequest{Request: req})
+	want := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if assert.Len(t, opts.Filters, 1) && assert.NotNil(t, opts.Filters[0].filters[0].matchTime) {
+		assert.Equal(t, want, *opts.Filters[0].filters[0].matchTime)
+	}
+}
+
+func TestParseQueryOperatorInValue(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter string
+		want   Filter
+	}{
+		{
+			name:   "greater than sign in an equality",
+			filter: "metadata.annotations.note=a>b",
+			want:   Filter{field: []string{"metadata", "annotations", "note"}, match: "a>b", op: eq},
+		},
+		{
+			name:   "less than sign in an inequality",
+			filter: "metadata.annotations.note!=a<b",
+			want:   Filter{field: []string{"metadata", "annotations", "note"}, match: "a<b", op: notEq},
+		},
+		{
+			name:   "equal sign in an equality",
+			filter: "metadata.annotations.note=a=b",
+			want:   Filter{field: []string{"metadata", "annotations", "note"}, match: "a=b", op: eq},
+		},
+		{
+			name:   "comparison signs in a prefix",
+			filter: "metadata.annotations.note^=<b>",
+			want:   Filter{field: []string{"metadata", "annotations", "note"}, match: "<b>", op: foldPrefix},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "/?filter="+url.QueryEscape(test.filter), nil)
+			assert.NoError(t, err)
+			opts := ParseQuery(&types.APIRequest{Request: req})
+			assert.Equal(t, []OrFilter{{filters: []Filter{test.want}}}, opts.Filters)
+		})
+	}
+}
+
 func TestSortList(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -1787,7 +2186,9 @@ func TestSortList(t *testing.T) {
 				},
 			},
 			sort: Sort{
-				primaryField: []string{"metadata", "name"},
+				keys: []sortKey{
+					{field: []string{"metadata", "name"}},
+				},
 			},
 			want: []unstructured.Unstructured{
 				{
@@ -1863,8 +2264,9 @@ func TestSortList(t *testing.T) {
 				},
 			},
 			sort: Sort{
-				primaryField: []string{"metadata", "name"},
-				primaryOrder: DESC,
+				keys: []sortKey{
+					{field: []string{"metadata", "name"}, order: DESC},
+				},
 			},
 			want: []unstructured.Unstructured{
 				{
@@ -1903,7 +2305,7 @@ func TestSortList(t *testing.T) {
 			},
 		},
 		{
-			name: "invalid field",
+			name: "invalid field is ordered by name",
 			objects: []unstructured.Unstructured{
 				{
 					Object: map[string]interface{}{
@@ -1940,17 +2342,19 @@ func TestSortList(t *testing.T) {
 				},
 			},
 			sort: Sort{
-				primaryField: []string{"data", "productType"},
+				keys: []sortKey{
+					{field: []string{"data", "productType"}},
+				},
 			},
 			want: []unstructured.Unstructured{
 				{
 					Object: map[string]interface{}{
 						"kind": "apple",
 						"metadata": map[string]interface{}{
-							"name": "granny-smith",
+							"name": "fuji",
 						},
 						"data": map[string]interface{}{
-							"color": "green",
+							"color": "pink",
 						},
 					},
 				},
@@ -1958,10 +2362,10 @@ func TestSortList(t *testing.T) {
 					Object: map[string]interface{}{
 						"kind": "apple",
 						"metadata": map[string]interface{}{
-							"name": "fuji",
+							"name": "granny-smith",
 						},
 						"data": map[string]interface{}{
-							"color": "pink",
+							"color": "green",
 						},
 					},
 				},
@@ -2090,8 +2494,10 @@ func TestSortList(t *testing.T) {
 				},
 			},
 			sort: Sort{
-				primaryField:   []string{"data", "color"},
-				secondaryField: []string{"metadata", "name"},
+				keys: []sortKey{
+					{field: []string{"data", "color"}},
+					{field: []string{"metadata", "name"}},
+				},
 			},
 			want: []unstructured.Unstructured{
 				{
@@ -2167,9 +2573,10 @@ func TestSortList(t *testing.T) {
 				},
 			},
 			sort: Sort{
-				primaryField:   []string{"data", "color"},
-				secondaryField: []string{"metadata", "name"},
-				secondaryOrder: DESC,
+				keys: []sortKey{
+					{field: []string{"data", "color"}},
+					{field: []string{"metadata", "name"}, order: DESC},
+				},
 			},
 			want: []unstructured.Unstructured{
 				{
@@ -2245,9 +2652,10 @@ func TestSortList(t *testing.T) {
 				},
 			},
 			sort: Sort{
-				primaryField:   []string{"data", "color"},
-				primaryOrder:   DESC,
-				secondaryField: []string{"metadata", "name"},
+				keys: []sortKey{
+					{field: []string{"data", "color"}, order: DESC},
+					{field: []string{"metadata", "name"}},
+				},
 			},
 			want: []unstructured.Unstructured{
 				{
@@ -2323,10 +2731,10 @@ func TestSortList(t *testing.T) {
 				},
 			},
 			sort: Sort{
-				primaryField:   []string{"data", "color"},
-				primaryOrder:   DESC,
-				secondaryField: []string{"metadata", "name"},
-				secondaryOrder: DESC,
+				keys: []sortKey{
+					{field: []string{"data", "color"}, order: DESC},
+					{field: []string{"metadata", "name"}, order: DESC},
+				},
 			},
 			want: []unstructured.Unstructured{
 				{
@@ -2373,6 +2781,128 @@ func TestSortList(t *testing.T) {
 	}
 }
 
+func TestSortListNested(t *testing.T) {
+	pod := func(name string, restarts interface{}, image string) unstructured.Unstructured {
+		obj := map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"name": name,
+			},
+		}
+		if restarts != nil {
+			obj["status"] = map[string]interface{}{
+				"restartCount": restarts,
+			}
+		}
+		if image != "" {
+			obj["spec"] = map[string]interface{}{
+				"containers": []interface{}{
+					map[string]interface{}{"image": image},
+				},
+			}
+		}
+		return unstructured.Unstructured{Object: obj}
+	}
+	objects := []unstructured.Unstructured{
+		pod("a", int64(10), "nginx"),
+		pod("b", nil, "busybox"),
+		pod("c", int64(9), ""),
+		pod("d", float64(2.5), "alpine"),
+		pod("e", nil, ""),
+		pod("f", int64(100), "nginx"),
+	}
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{name: "numeric ascending, missing last", query: "sort=status.restartCount", want: []string{"d", "c", "a", "f", "b", "e"}},
+		{name: "numeric descending, missing last", query: "sort=-status.restartCount", want: []string{"f", "a", "c", "d", "b", "e"}},
+		{name: "missing first", query: "sort=status.restartCount&sortnulls=first", want: []string{"b", "e", "d", "c", "a", "f"}},
+		{name: "list element", query: "sort=spec.containers[0].image,metadata.name", want: []string{"d", "b", "a", "f", "c", "e"}},
+		{name: "list element out of range", query: "sort=spec.containers[1].image,-metadata.name", want: []string{"f", "e", "d", "c", "b", "a"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "/?"+test.query, nil)
+			assert.NoError(t, err)
+			opts := ParseQuery(&types.APIRequest{Request: req})
+			assert.Equal(t, strings.TrimPrefix(test.query, "sort="), opts.Sort.String())
+
+			list := append([]unstructured.Unstructured{}, objects...)
+			var got []string
+			for _, obj := range SortList(list, opts.Sort) {
+				got = append(got, obj.GetName())
+			}
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestSortListMultiKey(t *testing.T) {
+	apple := func(namespace, name, color, size string, weight int64) unstructured.Unstructured {
+		return unstructured.Unstructured{Object: map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"namespace": namespace,
+				"name":      name,
+			},
+			"data": map[string]interface{}{
+				"color":  color,
+				"size":   size,
+				"weight": weight,
+			},
+		}}
+	}
+	objects := []unstructured.Unstructured{
+		apple("ns2", "fuji", "pink", "small", 150),
+		apple("ns1", "gala", "red", "large", 200),
+		apple("ns1", "fuji", "pink", "small", 150),
+		apple("ns1", "braeburn", "red", "large", 180),
+		apple("ns1", "granny-smith", "green", "large", 200),
+		apple("ns1", "honeycrisp", "pink", "large", 150),
+	}
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{name: "three keys", query: "sort=data.color,-data.size,-data.weight", want: []string{"ns1/granny-smith", "ns1/fuji", "ns2/fuji", "ns1/honeycrisp", "ns1/gala", "ns1/braeburn"}},
+		{name: "descending then ascending", query: "sort=-data.weight,metadata.name", want: []string{"ns1/gala", "ns1/granny-smith", "ns1/braeburn", "ns1/fuji", "ns2/fuji", "ns1/honeycrisp"}},
+		{name: "ties broken by namespace and name", query: "sort=data.size", want: []string{"ns1/braeburn", "ns1/gala", "ns1/granny-smith", "ns1/honeycrisp", "ns1/fuji", "ns2/fuji"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "/?"+test.query, nil)
+			assert.NoError(t, err)
+			opts := ParseQuery(&types.APIRequest{Request: req})
+			assert.Equal(t, strings.TrimPrefix(test.query, "sort="), opts.Sort.String())
+
+			// the result must not depend on the order of the input
+			for _, list := range [][]unstructured.Unstructured{append([]unstructured.Unstructured{}, objects...), reversed(objects)} {
+				var got []string
+				for _, obj := range SortList(list, opts.Sort) {
+					got = append(got, obj.GetNamespace()+"/"+obj.GetName())
+				}
+				assert.Equal(t, test.want, got)
+			}
+		})
+	}
+}
+
+func reversed(list []unstructured.Unstructured) []unstructured.Unstructured {
+	result := make([]unstructured.Unstructured, 0, len(list))
+	for i := len(list) - 1; i >= 0; i-- {
+		result = append(result, list[i])
+	}
+	return result
+}
+
+func TestParseField(t *testing.T) {
+	assert.Equal(t, []string{"metadata", "name"}, parseField("metadata.name"))
+	assert.Equal(t, []string{"spec", "containers", "[0]", "image"}, parseField("spec.containers[0].image"))
+	assert.Equal(t, []string{"a", "[1]", "[2]", "b"}, parseField("a[1][2].b"))
+	assert.Equal(t, "a[1][2].b", fieldString(parseField("a[1][2].b")))
+}
+
 func TestPaginateList(t *testing.T) {
 	objects := []unstructured.Unstructured{
 		{
@@ -2572,6 +3102,79 @@ func TestPaginateList(t *testing.T) {
 	}
 }
 
+func TestProjectList(t *testing.T) {
+	objects := []unstructured.Unstructured{
+		{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Pod",
+			"metadata": map[string]interface{}{
+				"name":      "pod1",
+				"namespace": "ns1",
+				"labels":    map[string]interface{}{"app": "web"},
+			},
+			"spec": map[string]interface{}{
+				"nodeName": "node1",
+				"containers": []interface{}{
+					map[string]interface{}{"name": "web", "image": "nginx"},
+					map[string]interface{}{"name": "sidecar", "image": "envoy"},
+				},
+			},
+			"status": map[string]interface{}{
+				"phase": "Running",
+				"podIP": "10.0.0.1",
+			},
+		}},
+		{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Pod",
+			"metadata": map[string]interface{}{
+				"name": "pod2",
+			},
+			"spec": map[string]interface{}{
+				"nodeName": "node2",
+			},
+		}},
+	}
+	original := []unstructured.Unstructured{*objects[0].DeepCopy(), *objects[1].DeepCopy()}
+
+	req, err := http.NewRequest(http.MethodGet, "/?projection=status.phase,spec.containers.image,metadata.labels.missing", nil)
+	assert.NoError(t, err)
+	opts := ParseQuery(&types.APIRequest{Request: req})
+	got := ProjectList(objects, opts.Projection)
+
+	assert.Equal(t, []unstructured.Unstructured{
+		{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Pod",
+			"metadata": map[string]interface{}{
+				"name":      "pod1",
+				"namespace": "ns1",
+			},
+			"spec": map[string]interface{}{
+				"containers": []interface{}{
+					map[string]interface{}{"image": "nginx"},
+					map[string]interface{}{"image": "envoy"},
+				},
+			},
+			"status": map[string]interface{}{
+				"phase": "Running",
+			},
+		}},
+		{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Pod",
+			"metadata": map[string]interface{}{
+				"name": "pod2",
+			},
+		}},
+	}, got)
+	_, ok := got[1].Object["status"]
+	assert.False(t, ok, "expected a missing field to be absent rather than null")
+	assert.Equal(t, original, objects, "expected the listed objects to not be modified")
+
+	assert.Equal(t, objects, ProjectList(objects, Projection{}), "expected no projection to keep the objects whole")
+}
+
 func TestFilterByProjectsAndNamespaces(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"sync/atomic"
 
 	"github.com/rancher/apiserver/pkg/types"
 
@@ -126,10 +127,12 @@ type listState struct {
 // request.
 func (p *ParallelPartitionLister) feeder(ctx context.Context, state listState, limit int, result chan []unstructured.Unstructured) {
 	var (
-		sem      = semaphore.NewWeighted(p.Concurrency)
-		capacity = limit
+		sem = semaphore.NewWeighted(p.Concurrency)
+		// capacity is read here while the partitions are listed, and updated by the partitions in order
+		capacity atomic.Int64
 		last     chan struct{}
 	)
+	capacity.Store(int64(limit))
 
 	eg, ctx := errgroup.WithContext(ctx)
 	defer func() {
@@ -140,8 +143,14 @@ func (p *ParallelPartitionLister) feeder(ctx context.Context, state listState, l
 		close(result)
 	}()
 
+	// full is the index of the first partition left out because the capacity was reached, if any
+	full := -1
 	for i := indexOrZero(p.Partitions, state.PartitionName); i < len(p.Partitions); i++ {
-		if (limit > 0 && capacity <= 0) || isDone(ctx) {
+		if limit > 0 && capacity.Load() <= 0 {
+			full = i
+			break
+		}
+		if isDone(ctx) {
 			break
 		}
 
@@ -156,9 +165,13 @@ func (p *ParallelPartitionLister) feeder(ctx context.Context, state listState, l
 		last = next
 
 		// state.Revision is decoded from the continue token, there won't be a revision on the first request.
+		// Without one, the first partition is listed alone to set the revision, and the other partitions are then
+		// listed concurrently at that revision.
+		var revisionSet chan string
 		if state.Revision == "" {
 			// don't have a revision yet so grab all tickets to set a revision
-			tickets = 3
+			tickets = p.Concurrency
+			revisionSet = make(chan string, 1)
 		}
 		if err := sem.Acquire(ctx, tickets); err != nil {
 			p.err = err
@@ -166,10 +179,15 @@ func (p *ParallelPartitionLister) feeder(ctx context.Context, state listState, l
 		}
 
 		// make state local for this partition
+		shared := &state
 		state := state
 		eg.Go(func() error {
 			defer sem.Release(tickets)
 			defer close(next)
+			setRevision := revisionSet
+			if setRevision != nil {
+				defer close(setRevision)
+			}
 
 			for {
 				cont := ""
@@ -180,6 +198,10 @@ func (p *ParallelPartitionLister) feeder(ctx context.Context, state listState, l
 				if err != nil {
 					return err
 				}
+				if setRevision != nil {
+					setRevision <- list.GetResourceVersion()
+					setRevision = nil
+				}
 
 				waitForTurn(ctx, turn)
 				if p.state != nil {
@@ -201,21 +223,21 @@ func (p *ParallelPartitionLister) feeder(ctx context.Context, state listState, l
 
 				// Case 1: the capacity has been reached across all goroutines but the list is still only partial,
 				// so save the state so that the next page can be requested later.
-				if limit > 0 && len(list.Items) > capacity {
-					result <- list.Items[:capacity]
+				if remaining := int(capacity.Load()); limit > 0 && len(list.Items) > remaining {
+					result <- list.Items[:remaining]
 					// save state to redo this list at this offset
 					p.state = &listState{
 						Revision:      list.GetResourceVersion(),
 						PartitionName: partition.Name(),
 						Continue:      cont,
-						Offset:        capacity,
+						Offset:        remaining,
 						Limit:         limit,
 					}
-					capacity = 0
+					capacity.Store(0)
 					return nil
 				}
 				result <- list.Items
-				capacity -= len(list.Items)
+				capacity.Add(-int64(len(list.Items)))
 				// Case 2: all objects have been returned, we are done.
 				if list.GetContinue() == "" {
 					return nil
@@ -228,9 +250,25 @@ func (p *ParallelPartitionLister) feeder(ctx context.Context, state listState, l
 				state.Offset = 0
 			}
 		})
+
+		if revisionSet != nil {
+			select {
+			case shared.Revision = <-revisionSet:
+			case <-ctx.Done():
+			}
+		}
 	}
 
 	p.err = eg.Wait()
+	// the capacity was reached exactly before listing the remaining partitions, so the next page starts at the first
+	// of them
+	if p.err == nil && p.state == nil && full >= 0 {
+		p.state = &listState{
+			Revision:      p.revision,
+			PartitionName: p.Partitions[full].Name(),
+			Limit:         limit,
+		}
+	}
 }
 
 func waitForTurn(ctx context.Context, turn chan struct{}) {
@@ -0,0 +1,161 @@
+package partition
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rancher/apiserver/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// namespaceLister lists objects from partitions named after namespaces, each holding size objects at revision 1.
+// It records the largest number of partitions listed at once and the revisions each partition was listed at.
+type namespaceLister struct {
+	size    int
+	latency time.Duration
+
+	inFlight    atomic.Int64
+	maxInFlight atomic.Int64
+
+	lock      sync.Mutex
+	revisions map[string]string
+}
+
+func (n *namespaceLister) list(ctx context.Context, partition Partition, cont string, revision string, limit int) (*unstructured.UnstructuredList, []types.Warning, error) {
+	inFlight := n.inFlight.Add(1)
+	defer n.inFlight.Add(-1)
+	for {
+		max := n.maxInFlight.Load()
+		if inFlight <= max || n.maxInFlight.CompareAndSwap(max, inFlight) {
+			break
+		}
+	}
+	n.lock.Lock()
+	if n.revisions == nil {
+		n.revisions = map[string]string{}
+	}
+	n.revisions[partition.Name()] = revision
+	n.lock.Unlock()
+
+	time.Sleep(n.latency)
+
+	list := &unstructured.UnstructuredList{}
+	list.SetResourceVersion("1")
+	for i := 0; i < n.size; i++ {
+		obj := unstructured.Unstructured{}
+		obj.SetNamespace(partition.Name())
+		obj.SetName(fmt.Sprintf("obj%d", i))
+		list.Items = append(list.Items, obj)
+	}
+	return list, nil, nil
+}
+
+func namespacePartitions(count int) []Partition {
+	var partitions []Partition
+	for i := 0; i < count; i++ {
+		partitions = append(partitions, mockPartition{name: fmt.Sprintf("ns%03d", i)})
+	}
+	return partitions
+}
+
+func collect(t *testing.T, lister *ParallelPartitionLister, limit int, resume string) []string {
+	stream, err := lister.List(context.Background(), limit, resume, "")
+	require.NoError(t, err)
+	var got []string
+	for items := range stream {
+		for _, item := range items {
+			got = append(got, item.GetNamespace()+"/"+item.GetName())
+		}
+	}
+	require.NoError(t, lister.Err())
+	return got
+}
+
+func TestParallelPartitionListerConcurrency(t *testing.T) {
+	n := &namespaceLister{size: 1, latency: time.Millisecond}
+	partitions := namespacePartitions(200)
+	lister := &ParallelPartitionLister{
+		Lister:      n.list,
+		Concurrency: 3,
+		Partitions:  partitions,
+	}
+
+	got := collect(t, lister, 100000, "")
+	var want []string
+	for _, partition := range partitions {
+		want = append(want, partition.Name()+"/obj0")
+	}
+	assert.Equal(t, want, got, "expected the objects in partition order")
+	assert.Equal(t, "1", lister.Revision())
+	assert.Greater(t, n.maxInFlight.Load(), int64(1), "expected partitions to be listed concurrently")
+	assert.LessOrEqual(t, n.maxInFlight.Load(), int64(3), "expected at most 3 partitions to be listed at once")
+	assert.Equal(t, "", n.revisions["ns000"], "expected the first partition to be listed at the latest revision")
+	for _, partition := range partitions[1:] {
+		assert.Equal(t, "1", n.revisions[partition.Name()], "expected %s to be listed at the revision of the first partition", partition.Name())
+	}
+}
+
+func TestParallelPartitionListerContinue(t *testing.T) {
+	n := &namespaceLister{size: 2}
+	newLister := func() *ParallelPartitionLister {
+		return &ParallelPartitionLister{
+			Lister:      n.list,
+			Concurrency: 3,
+			Partitions:  namespacePartitions(3),
+		}
+	}
+
+	// the limit is reached at the end of a partition
+	lister := newLister()
+	got := collect(t, lister, 4, "")
+	assert.Equal(t, []string{"ns000/obj0", "ns000/obj1", "ns001/obj0", "ns001/obj1"}, got)
+	cont := lister.Continue()
+	require.NotEmpty(t, cont, "expected a continue token for the partitions left")
+
+	lister = newLister()
+	got = collect(t, lister, 4, cont)
+	assert.Equal(t, []string{"ns002/obj0", "ns002/obj1"}, got)
+	assert.Empty(t, lister.Continue())
+
+	// the limit is reached in the middle of a partition
+	lister = newLister()
+	got = collect(t, lister, 3, "")
+	assert.Equal(t, []string{"ns000/obj0", "ns000/obj1", "ns001/obj0"}, got)
+	cont = lister.Continue()
+	require.NotEmpty(t, cont)
+
+	lister = newLister()
+	got = collect(t, lister, 3, cont)
+	assert.Equal(t, []string{"ns001/obj1", "ns002/obj0", "ns002/obj1"}, got)
+}
+
+func BenchmarkParallelPartitionLister(b *testing.B) {
+	partitions := namespacePartitions(200)
+	for _, concurrency := range []int64{1, 3, 10} {
+		b.Run(fmt.Sprintf("concurrency %d", concurrency), func(b *testing.B) {
+			n := &namespaceLister{size: 10, latency: time.Millisecond}
+			for i := 0; i < b.N; i++ {
+				lister := &ParallelPartitionLister{
+					Lister:      n.list,
+					Concurrency: concurrency,
+					Partitions:  partitions,
+				}
+				stream, err := lister.List(context.Background(), 100000, "", "")
+				if err != nil {
+					b.Fatal(err)
+				}
+				for range stream {
+				}
+				if lister.Err() != nil {
+					b.Fatal(lister.Err())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package partition
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rancher/apiserver/pkg/types"
+	"golang.org/x/time/rate"
+	"k8s.io/apimachinery/pkg/util/cache"
+	"k8s.io/apiserver/pkg/endpoints/request"
+)
+
+const (
+	// maxLimitedUsers is the number of users whose list limiters are kept. The limiters of the users who listed least
+	// recently are dropped first, which only gives them a full bucket again.
+	maxLimitedUsers  = 10000
+	retryAfterHeader = "Retry-After"
+)
+
+// listLimitExemptKey marks the context of the lists made on behalf of a list which was already limited, such as the
+// lists of the related objects of its joins.
+type listLimitExemptKey struct{}
+
+// listLimiters limits the lists of each user, by user name, with a token bucket per user.
+type listLimiters struct {
+	limit rate.Limit
+	burst int
+	now   func() time.Time
+
+	lock     sync.Mutex
+	limiters *cache.LRUExpireCache
+}
+
+// newListLimiters returns limiters allowing each user perMinute lists a minute, and up to burst lists at once. It
+// returns nil, which does not limit lists, if perMinute is not positive.
+func newListLimiters(perMinute, burst int) *listLimiters {
+	if perMinute <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+	return &listLimiters{
+		limit:    rate.Limit(float64(perMinute) / 60),
+		burst:    burst,
+		now:      time.Now,
+		limiters: cache.NewLRUExpireCache(maxLimitedUsers),
+	}
+}
+
+// wait takes a token from the bucket of the user of the request, and returns how long to wait for a token if the
+// bucket is empty, in which case the request is over the limit. Requests without a user are not limited.
+func (l *listLimiters) wait(apiOp *types.APIRequest) time.Duration {
+	if l == nil || apiOp.Context().Value(listLimitExemptKey{}) != nil {
+		return 0
+	}
+	user, ok := request.UserFrom(apiOp.Context())
+	if !ok {
+		return 0
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := l.now()
+	limiter, ok := l.limiters.Get(user.GetName())
+	if !ok {
+		limiter = rate.NewLimiter(l.limit, l.burst)
+	}
+	// a limiter unused for the time it takes to fill its bucket is the same as a new one, so it can expire then
+	l.limiters.Add(user.GetName(), limiter, time.Duration(float64(l.burst)/float64(l.limit)*float64(time.Second)))
+
+	reservation := limiter.(*rate.Limiter).ReserveN(now, 1)
+	delay := reservation.DelayFrom(now)
+	if delay > 0 {
+		// the request is rejected rather than delayed, so its token is given back
+		reservation.CancelAt(now)
+	}
+	return delay
+}
+
+// setRetryAfter sets the Retry-After header of the response to the request, in whole seconds.
+func setRetryAfter(apiOp *types.APIRequest, delay time.Duration) {
+	if apiOp.Response == nil {
+		return
+	}
+	apiOp.Response.Header().Set(retryAfterHeader, strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+}
+
+// exemptFromListLimit returns a copy of the request whose lists are not limited.
+func exemptFromListLimit(req *http.Request) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), listLimitExemptKey{}, true))
+}
@@ -4,25 +4,35 @@ package partition
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"reflect"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/rancher/apiserver/pkg/apierror"
 	"github.com/rancher/apiserver/pkg/types"
 	"github.com/rancher/steve/pkg/accesscontrol"
+	"github.com/rancher/steve/pkg/attributes"
 	"github.com/rancher/steve/pkg/stores/partition/listprocessor"
 	corecontrollers "github.com/rancher/wrangler/pkg/generated/controllers/core/v1"
+	"github.com/rancher/wrangler/pkg/schemas/validation"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/sync/errgroup"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	schema2 "k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/cache"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/client-go/util/retry"
 )
 
 const (
@@ -32,6 +42,47 @@ const (
 	defaultCacheSize = 1000
 	// Set to "false" to enable list request caching.
 	cacheDisableEnv = "CATTLE_REQUEST_CACHE_DISABLED"
+	// Comma separated resources, as resource.group, whose lists are never cached, such as "events,leases.coordination.k8s.io".
+	// Lists of resources which change constantly are rarely reused before a new revision replaces them.
+	cacheExcludedEnv = "CATTLE_REQUEST_CACHE_EXCLUDED_RESOURCES"
+	// Largest page size of a list, larger page sizes are reduced to it. Set to 0 to allow any page size.
+	maxPageSizeEnv     = "CATTLE_MAX_PAGE_SIZE_INT"
+	defaultMaxPageSize = 1000
+	// Longest time in seconds to list the partitions of a list request for, after which the request fails with a 504.
+	// Set to 0, the default, to allow lists to take any time.
+	listTimeoutEnv = "CATTLE_LIST_TIMEOUT_SECONDS_INT"
+	// Number of times a partition is listed again when Kubernetes is too busy to list it, before the list fails.
+	listRetriesEnv     = "CATTLE_LIST_RETRIES_INT"
+	defaultListRetries = 3
+	// Number of lists each user can make a minute, after which lists fail with a 429 until the user's bucket refills.
+	// Set to 0, the default, to not limit lists.
+	listRateEnv = "CATTLE_LIST_RATE_PER_MINUTE_INT"
+	// Number of lists a user can make at once, the capacity of the user's bucket.
+	listBurstEnv     = "CATTLE_LIST_RATE_BURST_INT"
+	defaultListBurst = 10
+	// Number of seconds between the bookmarks sent to the clients watching with the allowWatchBookmarks parameter.
+	// Set to 0 to never send bookmarks.
+	watchBookmarkIntervalEnv     = "CATTLE_WATCH_BOOKMARK_INTERVAL_SECONDS_INT"
+	defaultWatchBookmarkInterval = 60
+	// Response header set to the largest page size when the requested page size was reduced to it.
+	maxPageSizeHeader = "X-Max-Page-Size"
+	// TooOldError prefixes the error of a watch that cannot resume from the requested revision because it has been
+	// compacted. Clients need to list again and watch from the revision of the new list.
+	TooOldError = "tooOld"
+)
+
+var (
+	invalidSelector = validation.ErrorCode{Code: "InvalidSelector", Status: http.StatusBadRequest}
+	listTimeout     = validation.ErrorCode{Code: "Timeout", Status: http.StatusGatewayTimeout}
+	invalidJoin     = validation.ErrorCode{Code: "InvalidJoin", Status: http.StatusBadRequest}
+	invalidFilter   = validation.ErrorCode{Code: "InvalidFilter", Status: http.StatusBadRequest}
+	tooManyLists    = validation.ErrorCode{Code: "TooManyRequests", Status: http.StatusTooManyRequests}
+	// listBackoff is the backoff between the retries of a partition list, its steps are set from the number of retries.
+	listBackoff = wait.Backoff{
+		Duration: 100 * time.Millisecond,
+		Factor:   2,
+		Jitter:   0.1,
+	}
 )
 
 // Partitioner is an interface for interacting with partitions.
@@ -41,12 +92,26 @@ type Partitioner interface {
 	Store(apiOp *types.APIRequest, partition Partition) (UnstructuredStore, error)
 }
 
+// Reviser is implemented by the partitioners which can return the current revision of the resources of a schema. It
+// sets the revision of the lists which did not get one from their partitions, such as the lists of a user who cannot
+// see any object, so that every list has a revision to watch from.
+type Reviser interface {
+	Revision(apiOp *types.APIRequest, schema *types.APISchema) (string, error)
+}
+
 // Store implements types.Store for partitions.
 type Store struct {
 	Partitioner    Partitioner
 	listCache      *cache.LRUExpireCache
+	uncached       map[schema2.GroupResource]bool
 	asl            accesscontrol.AccessSetLookup
 	namespaceCache corecontrollers.NamespaceCache
+	maxPageSize    int
+	listTimeout    time.Duration
+	listBackoff    wait.Backoff
+	listLimiters   *listLimiters
+	// bookmarkInterval is the time between the bookmarks of a watch, or 0 to not send bookmarks.
+	bookmarkInterval time.Duration
 }
 
 // NewStore creates a types.Store implementation with a partitioner and an LRU expiring cache for list responses.
@@ -58,14 +123,71 @@ func NewStore(partitioner Partitioner, asl accesscontrol.AccessSetLookup, namesp
 			cacheSize = sizeInt
 		}
 	}
+	maxPageSize := defaultMaxPageSize
+	if v := os.Getenv(maxPageSizeEnv); v != "" {
+		sizeInt, err := strconv.Atoi(v)
+		if err == nil {
+			maxPageSize = sizeInt
+		}
+	}
+	var timeout time.Duration
+	if v := os.Getenv(listTimeoutEnv); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err == nil {
+			timeout = time.Duration(seconds) * time.Second
+		}
+	}
+	retries := defaultListRetries
+	if v := os.Getenv(listRetriesEnv); v != "" {
+		retriesInt, err := strconv.Atoi(v)
+		if err == nil && retriesInt >= 0 {
+			retries = retriesInt
+		}
+	}
+	backoff := listBackoff
+	backoff.Steps = retries + 1
+	var perMinute int
+	if v := os.Getenv(listRateEnv); v != "" {
+		rateInt, err := strconv.Atoi(v)
+		if err == nil {
+			perMinute = rateInt
+		}
+	}
+	burst := defaultListBurst
+	if v := os.Getenv(listBurstEnv); v != "" {
+		burstInt, err := strconv.Atoi(v)
+		if err == nil {
+			burst = burstInt
+		}
+	}
+	bookmarkInterval := defaultWatchBookmarkInterval
+	if v := os.Getenv(watchBookmarkIntervalEnv); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err == nil {
+			bookmarkInterval = seconds
+		}
+	}
 	s := &Store{
-		Partitioner:    partitioner,
-		asl:            asl,
-		namespaceCache: namespaceCache,
+		Partitioner:      partitioner,
+		asl:              asl,
+		namespaceCache:   namespaceCache,
+		maxPageSize:      maxPageSize,
+		listTimeout:      timeout,
+		listBackoff:      backoff,
+		listLimiters:     newListLimiters(perMinute, burst),
+		bookmarkInterval: time.Duration(bookmarkInterval) * time.Second,
 	}
 	if v := os.Getenv(cacheDisableEnv); v == "false" {
 		s.listCache = cache.NewLRUExpireCache(cacheSize)
 	}
+	if v := os.Getenv(cacheExcludedEnv); v != "" {
+		s.uncached = map[schema2.GroupResource]bool{}
+		for _, resource := range strings.Split(v, ",") {
+			if resource = strings.TrimSpace(resource); resource != "" {
+				s.uncached[schema2.ParseGroupResource(resource)] = true
+			}
+		}
+	}
 	return s
 }
 
@@ -78,6 +200,7 @@ type cacheKey struct {
 	accessID     string
 	resourcePath string
 	revision     string
+	metadataOnly bool
 }
 
 // UnstructuredStore is like types.Store but deals in k8s unstructured objects instead of apiserver types.
@@ -124,11 +247,30 @@ func (s *Store) ByID(apiOp *types.APIRequest, schema *types.APISchema, id string
 	if err != nil {
 		return types.APIObject{}, err
 	}
+	// a store returning no object without an error is treated as not finding it
+	if obj == nil || len(authorize(apiOp, schema, []unstructured.Unstructured{*obj})) == 0 {
+		return types.APIObject{}, apierror.NewAPIError(validation.NotFound, fmt.Sprintf("%s %s not found", schema.ID, id))
+	}
+	if notModified(apiOp, s.accessID(apiOp), obj.GetResourceVersion()) {
+		return types.APIObject{}, validation.ErrComplete
+	}
 	return toAPI(schema, obj, warnings), nil
 }
 
+// accessID returns the ID of the access set of the user of the request, if any.
+func (s *Store) accessID(apiOp *types.APIRequest) string {
+	if s.asl == nil {
+		return ""
+	}
+	user, ok := request.UserFrom(apiOp.Request.Context())
+	if !ok {
+		return ""
+	}
+	return s.asl.AccessFor(user).ID
+}
+
 func (s *Store) listPartition(ctx context.Context, apiOp *types.APIRequest, schema *types.APISchema, partition Partition,
-	cont string, revision string, limit int) (*unstructured.UnstructuredList, []types.Warning, error) {
+	cont string, revision string, limit int, fieldSelector string) (*unstructured.UnstructuredList, []types.Warning, error) {
 	store, err := s.Partitioner.Store(apiOp, partition)
 	if err != nil {
 		return nil, nil, err
@@ -148,9 +290,28 @@ func (s *Store) listPartition(ctx context.Context, apiOp *types.APIRequest, sche
 	} else {
 		values.Del("limit")
 	}
+	// Kubernetes only supports selecting most resources by name and namespace, so the field selector is reduced to
+	// those fields and the listed objects are filtered by the full selector
+	if fieldSelector != "" {
+		values.Set("fieldSelector", fieldSelector)
+	} else {
+		values.Del("fieldSelector")
+	}
 	req.Request.URL.RawQuery = values.Encode()
 
-	return store.List(req, schema)
+	var (
+		list     *unstructured.UnstructuredList
+		warnings []types.Warning
+	)
+	// Kubernetes rejects requests it is too busy to serve, which is transient, so those are retried after a backoff
+	// rather than failing the whole list
+	err = retry.OnError(s.listBackoff, func(err error) bool {
+		return ctx.Err() == nil && (apierrors.IsTooManyRequests(err) || apierrors.IsServerTimeout(err))
+	}, func() error {
+		list, warnings, err = store.List(req, schema)
+		return err
+	})
+	return list, warnings, err
 }
 
 // List returns a list of objects across all applicable partitions.
@@ -160,6 +321,22 @@ func (s *Store) List(apiOp *types.APIRequest, schema *types.APISchema) (types.AP
 		result types.APIObjectList
 	)
 
+	if delay := s.listLimiters.wait(apiOp); delay > 0 {
+		setRetryAfter(apiOp, delay)
+		return result, apierror.NewAPIError(tooManyLists, fmt.Sprintf("too many lists of %s, retry later", schema.ID))
+	}
+
+	fieldSelector, err := listprocessor.ParseFieldSelector(apiOp, schema)
+	if err != nil {
+		return result, apierror.NewAPIError(invalidSelector, err.Error())
+	}
+
+	joins, _ := attributes.Joins(schema).([]listprocessor.Join)
+	joins, err = listprocessor.ParseJoins(apiOp, joins)
+	if err != nil {
+		return result, apierror.NewAPIError(invalidJoin, err.Error())
+	}
+
 	partitions, err := s.Partitioner.All(apiOp, schema, "list", "")
 	if err != nil {
 		return result, err
@@ -167,22 +344,36 @@ func (s *Store) List(apiOp *types.APIRequest, schema *types.APISchema) (types.AP
 
 	lister := ParallelPartitionLister{
 		Lister: func(ctx context.Context, partition Partition, cont string, revision string, limit int) (*unstructured.UnstructuredList, []types.Warning, error) {
-			return s.listPartition(ctx, apiOp, schema, partition, cont, revision, limit)
+			return s.listPartition(ctx, apiOp, schema, partition, cont, revision, limit, fieldSelector.Indexed)
 		},
 		Concurrency: 3,
 		Partitions:  partitions,
 	}
 
 	opts := listprocessor.ParseQuery(apiOp)
+	if err := opts.Err(); err != nil {
+		return result, apierror.NewAPIError(invalidFilter, err.Error())
+	}
+	opts.Filters = append(opts.Filters, fieldSelector.Filters...)
+	if pagination, clamped := opts.Pagination.Clamp(s.maxPageSize); clamped {
+		opts.Pagination = pagination
+		if apiOp.Response != nil {
+			apiOp.Response.Header().Set(maxPageSizeHeader, strconv.Itoa(s.maxPageSize))
+		}
+	}
 
 	key, err := s.getCacheKey(apiOp, opts)
 	if err != nil {
 		return result, err
 	}
 
+	listCache := s.listCache
+	if s.uncached[attributes.GVR(schema).GroupResource()] {
+		listCache = nil
+	}
 	var list []unstructured.Unstructured
-	if key.revision != "" && s.listCache != nil {
-		cachedList, ok := s.listCache.Get(key)
+	if key.revision != "" && listCache != nil {
+		cachedList, ok := listCache.Get(key)
 		if ok {
 			logrus.Tracef("found cached list for query %s?%s", apiOp.Request.URL.Path, apiOp.Request.URL.RawQuery)
 			list = cachedList.(*unstructured.UnstructuredList).Items
@@ -190,19 +381,40 @@ func (s *Store) List(apiOp *types.APIRequest, schema *types.APISchema) (types.AP
 		}
 	}
 	if list == nil { // did not look in cache or was not found in cache
-		stream, err := lister.List(apiOp.Context(), opts.ChunkSize, opts.Resume, opts.Revision)
+		ctx := apiOp.Context()
+		if s.listTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, s.listTimeout)
+			defer cancel()
+		}
+		stream, err := lister.List(ctx, opts.ChunkSize, opts.Resume, opts.Revision)
 		if err != nil {
 			return result, err
 		}
 		list = listprocessor.FilterList(stream, opts.Filters)
+		// The partitions stop being listed once the timeout expires, possibly without an error, so the partial list
+		// must not be cached.
+		if s.listTimeout > 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return result, apierror.NewAPIError(listTimeout, fmt.Sprintf("listing %s did not complete within %s", schema.ID, s.listTimeout))
+		}
 		// Check for any errors returned during the parallel listing requests.
 		// We don't want to cache the list or bother with further processing if the list is empty or corrupt.
 		// FilterList guarantees that the stream has been consumed and the error is populated if there is any.
 		if lister.Err() != nil {
 			return result, lister.Err()
 		}
+		if err := opts.Err(); err != nil {
+			return result, apierror.NewAPIError(invalidFilter, err.Error())
+		}
 		list = listprocessor.SortList(list, opts.Sort)
+		if opts.MetadataOnly {
+			// the stores may not support listing metadata only, so the rest of the objects is dropped before caching
+			list = listprocessor.MetadataList(list)
+		}
 		key.revision = lister.Revision()
+		if key.revision == "" {
+			key.revision = s.currentRevision(apiOp, schema)
+		}
 		listToCache := &unstructured.UnstructuredList{
 			Items: list,
 		}
@@ -211,24 +423,122 @@ func (s *Store) List(apiOp *types.APIRequest, schema *types.APISchema) (types.AP
 		if c != "" {
 			listToCache.SetContinue(c)
 		}
-		if s.listCache != nil {
-			s.listCache.Add(key, listToCache, 30*time.Minute)
+		if listCache != nil {
+			listCache.Add(key, listToCache, 30*time.Minute)
 		}
 		result.Continue = lister.Continue()
 	}
+	// the cached lists are shared by the users with the same access, so the objects are authorized for the user
+	// after the cache
+	list = authorize(apiOp, schema, list)
 	result.Count = len(list)
+	if opts.CountOnly {
+		result.Revision = key.revision
+		return result, lister.Err()
+	}
 	list, pages := listprocessor.PaginateList(list, opts.Pagination)
+	if !opts.Projection.Requested() {
+		opts.Projection = listprocessor.NewProjection(attributes.DefaultProjection(schema)...)
+	}
+	projected := listprocessor.ProjectList(list, opts.Projection)
+	indexes, err := joinIndexes(apiOp, joins, len(list))
+	if err != nil {
+		return result, err
+	}
 
-	for _, item := range list {
+	for i, item := range projected {
 		item := item.DeepCopy()
+		for _, index := range indexes {
+			index.Join(&list[i], item)
+		}
 		result.Objects = append(result.Objects, toAPI(schema, item, nil))
 	}
 
 	result.Revision = key.revision
 	result.Pages = pages
+	if lister.Err() == nil && notModified(apiOp, key.accessID, result.Revision) {
+		return types.APIObjectList{}, validation.ErrComplete
+	}
 	return result, lister.Err()
 }
 
+// currentRevision returns the current revision of the resources of the schema if the partitioner is a Reviser, or an
+// empty revision if it is not or if the revision can not be read.
+func (s *Store) currentRevision(apiOp *types.APIRequest, schema *types.APISchema) string {
+	reviser, ok := s.Partitioner.(Reviser)
+	if !ok {
+		return ""
+	}
+	revision, err := reviser.Revision(apiOp, schema)
+	if err != nil {
+		logrus.Debugf("failed to get the revision of %s: %v", schema.ID, err)
+		return ""
+	}
+	return revision
+}
+
+// authorize returns the objects of the list the authorizer of the schema allows the user of the request to see, or
+// the list as it is if the schema has no authorizer.
+func authorize(apiOp *types.APIRequest, schema *types.APISchema, list []unstructured.Unstructured) []unstructured.Unstructured {
+	authorizer, ok := attributes.Authorizer(schema).(listprocessor.Authorizer)
+	if !ok {
+		return list
+	}
+	user, _ := request.UserFrom(apiOp.Request.Context())
+	return listprocessor.AuthorizeList(list, user, authorizer)
+}
+
+// joinIndexes lists the related objects of the joins, with the access of the user of the request, so they can be
+// joined to a page of count objects. The related objects of a schema the user cannot list are not joined.
+func joinIndexes(apiOp *types.APIRequest, joins []listprocessor.Join, count int) ([]*listprocessor.JoinIndex, error) {
+	if count == 0 {
+		return nil, nil
+	}
+	var indexes []*listprocessor.JoinIndex
+	for _, join := range joins {
+		var related []map[string]interface{}
+		relatedSchema := apiOp.Schemas.LookupSchema(join.Schema)
+		if relatedSchema != nil && relatedSchema.Store != nil && canList(relatedSchema) {
+			list, err := relatedSchema.Store.List(joinRequest(apiOp, relatedSchema), relatedSchema)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list %s to join: %w", relatedSchema.ID, err)
+			}
+			for _, obj := range list.Objects {
+				related = append(related, obj.Data())
+			}
+		}
+		indexes = append(indexes, listprocessor.NewJoinIndex(join, related))
+	}
+	return indexes, nil
+}
+
+// canList returns whether the user the schema was computed for can list its objects.
+func canList(schema *types.APISchema) bool {
+	for _, method := range schema.CollectionMethods {
+		if method == http.MethodGet {
+			return true
+		}
+	}
+	return false
+}
+
+// joinRequest returns a request listing every object of the related schema of a join, without the query parameters
+// and the conditional headers of the list request it is made for.
+func joinRequest(apiOp *types.APIRequest, schema *types.APISchema) *types.APIRequest {
+	req := apiOp.Clone()
+	// the related objects are part of the list, which was already limited
+	req.Request = exemptFromListLimit(apiOp.Request.Clone(apiOp.Context()))
+	req.Request.URL.Path = "/v1/" + schema.ID
+	req.Request.URL.RawQuery = ""
+	req.Request.Header.Del("If-None-Match")
+	req.Response = nil
+	req.Type = schema.ID
+	req.Schema = schema
+	req.Name = ""
+	req.Namespace = ""
+	return req
+}
+
 // getCacheKey returns a hashable struct identifying a unique user and request.
 func (s *Store) getCacheKey(apiOp *types.APIRequest, opts *listprocessor.ListOptions) (cacheKey, error) {
 	user, ok := request.UserFrom(apiOp.Request.Context())
@@ -248,6 +558,7 @@ func (s *Store) getCacheKey(apiOp *types.APIRequest, opts *listprocessor.ListOpt
 		accessID:     s.asl.AccessFor(user).ID,
 		resourcePath: apiOp.Request.URL.Path,
 		revision:     opts.Revision,
+		metadataOnly: opts.MetadataOnly,
 	}, nil
 }
 
@@ -291,8 +602,13 @@ func (s *Store) Watch(apiOp *types.APIRequest, schema *types.APISchema, wr types
 
 	eg := errgroup.Group{}
 	response := make(chan types.APIEvent)
+	var revisions *watchRevisions
+	if s.bookmarkInterval > 0 && WatchBookmarks(apiOp) {
+		revisions = newWatchRevisions(len(partitions), wr.Revision)
+	}
 
-	for _, partition := range partitions {
+	for index, partition := range partitions {
+		index := index
 		store, err := s.Partitioner.Store(apiOp, partition)
 		if err != nil {
 			cancel()
@@ -306,11 +622,27 @@ func (s *Store) Watch(apiOp *types.APIRequest, schema *types.APISchema, wr types
 				return err
 			}
 			for i := range c {
-				response <- toAPIEvent(apiOp, schema, i)
+				if revisions != nil {
+					revisions.observe(index, i)
+				}
+				// the bookmarks of Kubernetes only advance the revision of the partition, the bookmarks of the
+				// watch are sent for every partition at once
+				if i.Type == watch.Bookmark {
+					continue
+				}
+				if i, ok := authorizeEvent(apiOp, schema, i); ok {
+					response <- toAPIEvent(apiOp, schema, i)
+				}
 			}
 			return nil
 		})
 	}
+	if revisions != nil {
+		eg.Go(func() error {
+			sendBookmarks(ctx, apiOp, schema, revisions, s.bookmarkInterval, response)
+			return nil
+		})
+	}
 
 	go func() {
 		defer close(response)
@@ -322,6 +654,21 @@ func (s *Store) Watch(apiOp *types.APIRequest, schema *types.APISchema, wr types
 	return response, nil
 }
 
+// authorizeEvent returns the event if the authorizer of the schema allows the user of the request to see its object.
+// A change to an object the user may no longer see is sent as its removal, so that clients stop showing it, and the
+// other events of objects the user may not see are dropped.
+func authorizeEvent(apiOp *types.APIRequest, schema *types.APISchema, event watch.Event) (watch.Event, bool) {
+	obj, ok := event.Object.(*unstructured.Unstructured)
+	if !ok || len(authorize(apiOp, schema, []unstructured.Unstructured{*obj})) > 0 {
+		return event, true
+	}
+	if event.Type == watch.Modified {
+		event.Type = watch.Deleted
+		return event, true
+	}
+	return event, false
+}
+
 func toAPI(schema *types.APISchema, obj runtime.Object, warnings []types.Warning) types.APIObject {
 	if obj == nil || reflect.ValueOf(obj).IsNil() {
 		return types.APIObject{}
@@ -385,7 +732,11 @@ func toAPIEvent(apiOp *types.APIRequest, schema *types.APISchema, event watch.Ev
 
 	if event.Type == watch.Error {
 		status, _ := event.Object.(*metav1.Status)
-		apiEvent.Error = fmt.Errorf(status.Message)
+		if status.Code == http.StatusGone {
+			apiEvent.Error = fmt.Errorf("%s: %s", TooOldError, status.Message)
+		} else {
+			apiEvent.Error = fmt.Errorf(status.Message)
+		}
 		return apiEvent
 	}
 
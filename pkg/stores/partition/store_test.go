@@ -6,19 +6,29 @@ import (
 	"encoding/base64"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"strconv"
 	"testing"
+	"time"
 
+	"github.com/rancher/apiserver/pkg/apierror"
+	"github.com/rancher/apiserver/pkg/store/empty"
 	"github.com/rancher/apiserver/pkg/types"
 	"github.com/rancher/steve/pkg/accesscontrol"
+	"github.com/rancher/steve/pkg/attributes"
+	"github.com/rancher/steve/pkg/stores/partition/listprocessor"
 	"github.com/rancher/wrangler/pkg/generic"
 	"github.com/rancher/wrangler/pkg/schemas"
+	"github.com/rancher/wrangler/pkg/schemas/validation"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/apiserver/pkg/authentication/user"
 	"k8s.io/apiserver/pkg/endpoints/request"
@@ -523,7 +533,7 @@ func TestList(t *testing.T) {
 			},
 		},
 		{
-			name: "sorting with missing primary sort is unsorted",
+			name: "sorting with missing primary sort skips it",
 			apiOps: []*types.APIRequest{
 				newRequest("sort=,metadata.name", "user1"),
 			},
@@ -553,8 +563,8 @@ func TestList(t *testing.T) {
 					Count: 3,
 					Objects: []types.APIObject{
 						newApple("fuji").toObj(),
-						newApple("honeycrisp").toObj(),
 						newApple("granny-smith").toObj(),
+						newApple("honeycrisp").toObj(),
 					},
 				},
 			},
@@ -2147,6 +2157,1094 @@ func TestListByRevision(t *testing.T) {
 	assert.Equal(t, wantVersion, got.Revision)
 }
 
+func TestListRevision(t *testing.T) {
+	schema := &types.APISchema{Schema: &schemas.Schema{ID: "apple"}}
+	asl := &mockAccessSetLookup{userRoles: []map[string]string{
+		{"user1": "roleA", "user2": "roleB"},
+		{"user1": "roleA", "user2": "roleB"},
+		{"user1": "roleA", "user2": "roleB"},
+		{"user1": "roleA", "user2": "roleB"},
+	}}
+	versioned := &mockVersionedStore{}
+	addVersion := func(revision string, names ...string) {
+		list := &unstructured.UnstructuredList{
+			Object: map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"resourceVersion": revision,
+				},
+			},
+		}
+		for _, name := range names {
+			list.Items = append(list.Items, newApple(name).Unstructured)
+		}
+		versioned.versions = append(versioned.versions, mockStore{contents: list})
+	}
+	partitioner := &mockReviser{
+		mockPartitioner: mockPartitioner{
+			stores: map[string]UnstructuredStore{
+				"all": versioned,
+			},
+			partitions: map[string][]Partition{
+				"user1": {
+					mockPartition{
+						name: "all",
+					},
+				},
+				// user2 can not list any apple
+				"user2": {},
+			},
+		},
+	}
+	store := NewStore(partitioner, asl, mockNamespaceCache{})
+
+	// sequential lists return the revision of their snapshot, which never decreases
+	var last int
+	for i, names := range [][]string{{"fuji"}, {"fuji", "honeycrisp"}, {"honeycrisp"}} {
+		addVersion(strconv.Itoa(i+1), names...)
+		got, err := store.List(newRequest("", "user1"), schema)
+		assert.NoError(t, err)
+		revision, err := strconv.Atoi(got.Revision)
+		assert.NoError(t, err, "expected every list to have a revision")
+		assert.Greater(t, revision, last)
+		last = revision
+	}
+
+	// lists without partitions get the current revision from the partitioner
+	partitioner.revision = "3"
+	got, err := store.List(newRequest("", "user2"), schema)
+	assert.NoError(t, err)
+	assert.Len(t, got.Objects, 0)
+	assert.Equal(t, "3", got.Revision)
+}
+
+func TestListETag(t *testing.T) {
+	schema := &types.APISchema{Schema: &schemas.Schema{ID: "apple"}}
+	asl := &mockAccessSetLookup{}
+	for i := 0; i < 5; i++ {
+		asl.userRoles = append(asl.userRoles, map[string]string{"user1": "roleA", "user2": "roleB"})
+	}
+	contents := &unstructured.UnstructuredList{
+		Object: map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"resourceVersion": "1",
+			},
+		},
+		Items: []unstructured.Unstructured{
+			newApple("fuji").Unstructured,
+			newApple("granny-smith").Unstructured,
+		},
+	}
+	store := NewStore(mockPartitioner{
+		stores: map[string]UnstructuredStore{
+			"all": &mockStore{contents: contents},
+		},
+		partitions: map[string][]Partition{
+			"user1": {mockPartition{name: "all"}},
+			"user2": {mockPartition{name: "all"}},
+		},
+	}, asl, mockNamespaceCache{})
+
+	list := func(query, username, ifNoneMatch string) (*httptest.ResponseRecorder, types.APIObjectList, error) {
+		req := newRequest(query, username)
+		req.Request.Header = http.Header{}
+		if ifNoneMatch != "" {
+			req.Request.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		req.Response = rec
+		result, err := store.List(req, schema)
+		return rec, result, err
+	}
+
+	rec, result, err := list("", "user1", "")
+	assert.NoError(t, err)
+	assert.Len(t, result.Objects, 2)
+	tag := rec.Header().Get("ETag")
+	assert.NotEmpty(t, tag)
+
+	rec, result, err = list("", "user1", tag)
+	assert.Equal(t, validation.ErrComplete, err, "expected an unchanged list to not be written")
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+	assert.Empty(t, result.Objects)
+
+	rec, result, err = list("filter=metadata.name=fuji", "user1", tag)
+	assert.NoError(t, err, "expected a list with another query to not match")
+	assert.Len(t, result.Objects, 1)
+	assert.NotEqual(t, tag, rec.Header().Get("ETag"))
+
+	rec, result, err = list("", "user2", tag)
+	assert.NoError(t, err, "expected a list for another user to not match")
+	assert.Len(t, result.Objects, 2)
+	assert.NotEqual(t, tag, rec.Header().Get("ETag"))
+
+	contents.SetResourceVersion("2")
+	rec, result, err = list("", "user1", tag)
+	assert.NoError(t, err, "expected a list at another revision to not match")
+	assert.Len(t, result.Objects, 2)
+	assert.NotEqual(t, tag, rec.Header().Get("ETag"))
+}
+
+func TestListMaxPageSize(t *testing.T) {
+	schema := &types.APISchema{Schema: &schemas.Schema{ID: "apple"}}
+	asl := &mockAccessSetLookup{}
+	for i := 0; i < 3; i++ {
+		asl.userRoles = append(asl.userRoles, map[string]string{"user1": "roleA"})
+	}
+	store := NewStore(mockPartitioner{
+		stores: map[string]UnstructuredStore{
+			"all": &mockStore{
+				contents: &unstructured.UnstructuredList{
+					Items: []unstructured.Unstructured{
+						newApple("fuji").Unstructured,
+						newApple("granny-smith").Unstructured,
+						newApple("bramley").Unstructured,
+						newApple("crispin").Unstructured,
+						newApple("red-delicious").Unstructured,
+					},
+				},
+			},
+		},
+		partitions: map[string][]Partition{
+			"user1": {mockPartition{name: "all"}},
+		},
+	}, asl, mockNamespaceCache{})
+	store.maxPageSize = 2
+
+	tests := []struct {
+		name       string
+		query      string
+		wantCount  int
+		wantPages  int
+		wantHeader string
+	}{
+		{
+			name:       "page size over the cap is reduced",
+			query:      "pagesize=3",
+			wantCount:  2,
+			wantPages:  3,
+			wantHeader: "2",
+		},
+		{
+			name:      "page size at the cap is unchanged",
+			query:     "pagesize=2",
+			wantCount: 2,
+			wantPages: 3,
+		},
+		{
+			name:      "no page size is unchanged",
+			query:     "",
+			wantCount: 5,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := newRequest(test.query, "user1")
+			rec := httptest.NewRecorder()
+			req.Response = rec
+			got, err := store.List(req, schema)
+			assert.NoError(t, err)
+			assert.Len(t, got.Objects, test.wantCount)
+			assert.Equal(t, test.wantPages, got.Pages)
+			assert.Equal(t, test.wantHeader, rec.Header().Get("X-Max-Page-Size"))
+		})
+	}
+}
+
+func TestListCountOnly(t *testing.T) {
+	schema := &types.APISchema{Schema: &schemas.Schema{ID: "apple"}}
+	asl := &mockAccessSetLookup{}
+	for i := 0; i < 10; i++ {
+		asl.userRoles = append(asl.userRoles, map[string]string{"user1": "roleA"})
+	}
+	store := NewStore(mockPartitioner{
+		stores: map[string]UnstructuredStore{
+			"green": &mockStore{
+				contents: &unstructured.UnstructuredList{
+					Items: []unstructured.Unstructured{
+						newApple("granny-smith").Unstructured,
+						newApple("bramley").Unstructured,
+					},
+				},
+			},
+			"other": &mockStore{
+				contents: &unstructured.UnstructuredList{
+					Items: []unstructured.Unstructured{
+						newApple("fuji").Unstructured,
+						newApple("crispin").Unstructured,
+						newApple("red-delicious").Unstructured,
+					},
+				},
+			},
+			"forbidden": &mockStore{
+				contents: &unstructured.UnstructuredList{
+					Items: []unstructured.Unstructured{
+						newApple("golden-delicious").Unstructured,
+					},
+				},
+			},
+		},
+		// the user can only list the partitions it has access to
+		partitions: map[string][]Partition{
+			"user1": {mockPartition{name: "green"}, mockPartition{name: "other"}},
+		},
+	}, asl, mockNamespaceCache{})
+
+	for _, filter := range []string{"", "filter=data.color!=green"} {
+		got, err := store.List(newRequest(filter+"&countonly=true", "user1"), schema)
+		assert.NoError(t, err)
+		assert.Empty(t, got.Objects, "expected count only lists to not have objects")
+		assert.NotZero(t, got.Count)
+
+		enumerated := 0
+		for page := 1; ; page++ {
+			list, err := store.List(newRequest(fmt.Sprintf("%s&pagesize=2&page=%d", filter, page), "user1"), schema)
+			assert.NoError(t, err)
+			enumerated += len(list.Objects)
+			if page >= list.Pages {
+				break
+			}
+		}
+		assert.Equal(t, enumerated, got.Count, "expected the count of %q to match the listed objects", filter)
+	}
+}
+
+func TestListPartialMetadata(t *testing.T) {
+	schema := &types.APISchema{Schema: &schemas.Schema{ID: "apple"}}
+	asl := &mockAccessSetLookup{}
+	for i := 0; i < 10; i++ {
+		asl.userRoles = append(asl.userRoles, map[string]string{"user1": "roleA"})
+	}
+	store := NewStore(mockPartitioner{
+		stores: map[string]UnstructuredStore{
+			"all": &mockStore{
+				contents: &unstructured.UnstructuredList{
+					Items: []unstructured.Unstructured{
+						newApple("fuji").Unstructured,
+						newApple("bramley").Unstructured,
+					},
+				},
+			},
+		},
+		partitions: map[string][]Partition{
+			"user1": {mockPartition{name: "all"}},
+		},
+	}, asl, mockNamespaceCache{})
+
+	list := func(accept string) []types.APIObject {
+		apiOp := newRequest("sort=metadata.name", "user1")
+		apiOp.Request.Header = http.Header{"Accept": []string{accept}}
+		got, err := store.List(apiOp, schema)
+		require.NoError(t, err)
+		return got.Objects
+	}
+
+	objects := list("application/json;as=PartialObjectMetadataList;g=meta.k8s.io;v=v1")
+	require.Len(t, objects, 2)
+	for _, obj := range objects {
+		data := obj.Object.(*unstructured.Unstructured).Object
+		assert.Contains(t, data, "metadata")
+		assert.NotContains(t, data, "data", "expected metadata-only lists to not have the rest of the objects")
+	}
+	assert.Equal(t, "bramley", objects[0].ID, "expected the other options to still apply")
+
+	objects = list("application/json")
+	require.Len(t, objects, 2)
+	assert.Contains(t, objects[0].Object.(*unstructured.Unstructured).Object, "data", "expected other lists to have the full objects")
+}
+
+// lookupPartitioner is a mockPartitioner which also looks partitions up, in a single partition named "all".
+type lookupPartitioner struct {
+	mockPartitioner
+}
+
+func (l lookupPartitioner) Lookup(apiOp *types.APIRequest, schema *types.APISchema, verb, id string) (Partition, error) {
+	return mockPartition{name: "all"}, nil
+}
+
+// byIDStore is a mockStore which also gets its objects by name.
+type byIDStore struct {
+	*mockStore
+}
+
+func (b byIDStore) ByID(apiOp *types.APIRequest, schema *types.APISchema, id string) (*unstructured.Unstructured, []types.Warning, error) {
+	for _, obj := range b.contents.Items {
+		if obj.GetName() == id {
+			return obj.DeepCopy(), nil, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("%s not found", id)
+}
+
+func TestListDefaultProjection(t *testing.T) {
+	schema := &types.APISchema{Schema: &schemas.Schema{ID: "apple"}}
+	attributes.SetDefaultProjection(schema, []string{"data.color"})
+	asl := &mockAccessSetLookup{}
+	for i := 0; i < 10; i++ {
+		asl.userRoles = append(asl.userRoles, map[string]string{"user1": "roleA"})
+	}
+	fuji := newApple("fuji").with(map[string]string{"size": "large"})
+	store := NewStore(lookupPartitioner{mockPartitioner{
+		stores: map[string]UnstructuredStore{
+			"all": byIDStore{&mockStore{
+				contents: &unstructured.UnstructuredList{
+					Items: []unstructured.Unstructured{fuji.Unstructured},
+				},
+			}},
+		},
+		partitions: map[string][]Partition{
+			"user1": {mockPartition{name: "all"}},
+		},
+	}}, asl, mockNamespaceCache{})
+
+	listed := func(query string) map[string]interface{} {
+		list, err := store.List(newRequest(query, "user1"), schema)
+		require.NoError(t, err)
+		require.Len(t, list.Objects, 1)
+		return list.Objects[0].Object.(*unstructured.Unstructured).Object
+	}
+
+	assert.Equal(t, map[string]interface{}{
+		"kind":     "apple",
+		"metadata": map[string]interface{}{"name": "fuji"},
+		"data":     map[string]interface{}{"color": "pink"},
+	}, listed(""), "expected lists to have the default projection")
+	assert.Equal(t, map[string]interface{}{
+		"kind":     "apple",
+		"metadata": map[string]interface{}{"name": "fuji"},
+		"data":     map[string]interface{}{"size": "large"},
+	}, listed("projection=data.size"), "expected the projection of the request to replace the default projection")
+	assert.Equal(t, fuji.Object, listed("projection="), "expected an empty projection to list the full objects")
+
+	got, err := store.ByID(newRequest("", "user1"), schema, "fuji")
+	require.NoError(t, err)
+	assert.Equal(t, fuji.Object, got.Object.(*unstructured.Unstructured).Object, "expected get to return the full object")
+}
+
+// nilByIDStore is a mockStore whose gets find no object, without an error.
+type nilByIDStore struct {
+	*mockStore
+}
+
+func (nilByIDStore) ByID(apiOp *types.APIRequest, schema *types.APISchema, id string) (*unstructured.Unstructured, []types.Warning, error) {
+	return nil, nil, nil
+}
+
+func TestByIDNil(t *testing.T) {
+	schema := &types.APISchema{Schema: &schemas.Schema{ID: "apple"}}
+	store := NewStore(lookupPartitioner{mockPartitioner{
+		stores: map[string]UnstructuredStore{
+			"all": nilByIDStore{&mockStore{}},
+		},
+	}}, &mockAccessSetLookup{userRoles: []map[string]string{{"user1": "roleA"}}}, mockNamespaceCache{})
+
+	_, err := store.ByID(newRequest("", "user1"), schema, "fuji")
+	var apiErr *apierror.APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusNotFound, apiErr.Code.Status, "expected a missing object to not be found")
+}
+
+// selectorStore is a mockStore which records the field selectors it is listed with.
+type selectorStore struct {
+	*mockStore
+	selectors []string
+}
+
+func (s *selectorStore) List(apiOp *types.APIRequest, schema *types.APISchema) (*unstructured.UnstructuredList, []types.Warning, error) {
+	s.selectors = append(s.selectors, apiOp.Request.URL.Query().Get("fieldSelector"))
+	return s.mockStore.List(apiOp, schema)
+}
+
+func TestListFieldSelector(t *testing.T) {
+	schema := &types.APISchema{Schema: &schemas.Schema{ID: "apple"}}
+	asl := &mockAccessSetLookup{}
+	for i := 0; i < 10; i++ {
+		asl.userRoles = append(asl.userRoles, map[string]string{"user1": "roleA"})
+	}
+	partitionStore := &selectorStore{mockStore: &mockStore{
+		contents: &unstructured.UnstructuredList{
+			Items: []unstructured.Unstructured{
+				newApple("fuji").Unstructured,
+				newApple("honeycrisp").Unstructured,
+				newApple("granny-smith").Unstructured,
+			},
+		},
+	}}
+	store := NewStore(mockPartitioner{
+		stores: map[string]UnstructuredStore{
+			"all": partitionStore,
+		},
+		partitions: map[string][]Partition{
+			"user1": {mockPartition{name: "all"}},
+		},
+	}, asl, mockNamespaceCache{})
+
+	listed := func(query string) []string {
+		list, err := store.List(newRequest(query, "user1"), schema)
+		require.NoError(t, err)
+		var names []string
+		for _, obj := range list.Objects {
+			names = append(names, obj.Object.(*unstructured.Unstructured).GetName())
+		}
+		return names
+	}
+
+	assert.Equal(t, []string{"fuji", "honeycrisp"}, listed("fieldSelector=data.color%3Dpink"))
+	assert.Equal(t, []string{"granny-smith"}, listed("fieldSelector=data.color!%3Dpink"))
+	assert.Empty(t, listed("fieldSelector=data.color%3Dpin"), "expected the field to equal the selector's value")
+	assert.Equal(t, []string{"", "", ""}, partitionStore.selectors, "expected selectors on unindexed fields to not be sent to the partitions")
+
+	partitionStore.selectors = nil
+	// the mock store does not select by name itself, so the objects are filtered by the store
+	assert.Equal(t, []string{"fuji"}, listed("fieldSelector=metadata.name%3Dfuji,data.color%3Dpink"))
+	assert.Equal(t, []string{"metadata.name=fuji"}, partitionStore.selectors, "expected selectors on indexed fields to be sent to the partitions")
+
+	schema.ResourceFields = map[string]schemas.Field{
+		"metadata": {Type: "io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"},
+		"data":     {Type: "map[string]"},
+	}
+	_, err := store.List(newRequest("fieldSelector=spec.color%3Dpink", "user1"), schema)
+	var apiErr *apierror.APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusBadRequest, apiErr.Code.Status, "expected a field the schema does not have to be rejected")
+	_, err = store.List(newRequest("fieldSelector=data.color", "user1"), schema)
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusBadRequest, apiErr.Code.Status, "expected a malformed selector to be rejected")
+}
+
+// slowStore is a mockStore which takes delay to list its objects, or until the request is canceled if it is
+// cancelable.
+type slowStore struct {
+	*mockStore
+	delay      time.Duration
+	cancelable bool
+	canceled   bool
+}
+
+func (s *slowStore) List(apiOp *types.APIRequest, schema *types.APISchema) (*unstructured.UnstructuredList, []types.Warning, error) {
+	if !s.cancelable {
+		time.Sleep(s.delay)
+		return s.mockStore.List(apiOp, schema)
+	}
+	select {
+	case <-time.After(s.delay):
+		return s.mockStore.List(apiOp, schema)
+	case <-apiOp.Context().Done():
+		s.canceled = true
+		return nil, nil, apiOp.Context().Err()
+	}
+}
+
+func TestListTimeout(t *testing.T) {
+	tests := []struct {
+		name       string
+		timeout    time.Duration
+		delay      time.Duration
+		cancelable bool
+		wantErr    bool
+	}{
+		{
+			name:       "canceled query",
+			timeout:    10 * time.Millisecond,
+			delay:      time.Minute,
+			cancelable: true,
+			wantErr:    true,
+		},
+		{
+			name:    "query which ignores the cancelation",
+			timeout: 10 * time.Millisecond,
+			delay:   50 * time.Millisecond,
+			wantErr: true,
+		},
+		{
+			name:       "query within the timeout",
+			timeout:    time.Minute,
+			delay:      10 * time.Millisecond,
+			cancelable: true,
+		},
+		{
+			name:  "no timeout",
+			delay: 10 * time.Millisecond,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			schema := &types.APISchema{Schema: &schemas.Schema{ID: "apple"}}
+			asl := &mockAccessSetLookup{userRoles: []map[string]string{{"user1": "roleA"}}}
+			partitionStore := &slowStore{
+				mockStore: &mockStore{
+					contents: &unstructured.UnstructuredList{
+						Items: []unstructured.Unstructured{newApple("fuji").Unstructured},
+					},
+				},
+				delay:      test.delay,
+				cancelable: test.cancelable,
+			}
+			store := NewStore(mockPartitioner{
+				stores: map[string]UnstructuredStore{
+					"all": partitionStore,
+				},
+				partitions: map[string][]Partition{
+					"user1": {mockPartition{name: "all"}},
+				},
+			}, asl, mockNamespaceCache{})
+			store.listTimeout = test.timeout
+
+			list, err := store.List(newRequest("", "user1"), schema)
+			if !test.wantErr {
+				require.NoError(t, err)
+				assert.Len(t, list.Objects, 1)
+				return
+			}
+			var apiErr *apierror.APIError
+			require.ErrorAs(t, err, &apiErr)
+			assert.Equal(t, http.StatusGatewayTimeout, apiErr.Code.Status)
+			assert.Empty(t, list.Objects, "expected no partial list")
+			assert.Equal(t, test.cancelable, partitionStore.canceled, "expected the query to be canceled")
+		})
+	}
+}
+
+// busyStore is a mockStore which fails to list its objects with an error a number of times first.
+type busyStore struct {
+	*mockStore
+	err      error
+	failures int
+}
+
+func (b *busyStore) List(apiOp *types.APIRequest, schema *types.APISchema) (*unstructured.UnstructuredList, []types.Warning, error) {
+	if b.failures > 0 {
+		b.failures--
+		b.called++
+		return nil, nil, b.err
+	}
+	return b.mockStore.List(apiOp, schema)
+}
+
+func TestListRetries(t *testing.T) {
+	tooManyRequests := apierrors.NewTooManyRequests("too many requests", 1)
+	tests := []struct {
+		name      string
+		err       error
+		failures  int
+		wantErr   error
+		wantCalls int
+	}{
+		{
+			name:      "too many requests",
+			err:       tooManyRequests,
+			failures:  2,
+			wantCalls: 3,
+		},
+		{
+			name:      "server timeout",
+			err:       apierrors.NewServerTimeout(schema.GroupResource{Resource: "apples"}, "list", 1),
+			failures:  3,
+			wantCalls: 4,
+		},
+		{
+			name:      "too many retries",
+			err:       tooManyRequests,
+			failures:  4,
+			wantErr:   tooManyRequests,
+			wantCalls: 4,
+		},
+		{
+			name:      "error which is not retried",
+			err:       apierrors.NewForbidden(schema.GroupResource{Resource: "apples"}, "", fmt.Errorf("forbidden")),
+			failures:  1,
+			wantErr:   apierrors.NewForbidden(schema.GroupResource{Resource: "apples"}, "", fmt.Errorf("forbidden")),
+			wantCalls: 1,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			asl := &mockAccessSetLookup{userRoles: []map[string]string{{"user1": "roleA"}}}
+			partitionStore := &busyStore{
+				mockStore: &mockStore{
+					contents: &unstructured.UnstructuredList{
+						Items: []unstructured.Unstructured{newApple("fuji").Unstructured},
+					},
+				},
+				err:      test.err,
+				failures: test.failures,
+			}
+			store := NewStore(mockPartitioner{
+				stores: map[string]UnstructuredStore{
+					"all": partitionStore,
+				},
+				partitions: map[string][]Partition{
+					"user1": {mockPartition{name: "all"}},
+				},
+			}, asl, mockNamespaceCache{})
+			store.listBackoff.Duration = time.Millisecond
+
+			list, err := store.List(newRequest("", "user1"), &types.APISchema{Schema: &schemas.Schema{ID: "apple"}})
+			assert.Equal(t, test.wantCalls, partitionStore.called)
+			if test.wantErr != nil {
+				assert.Equal(t, test.wantErr, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, list.Objects, 1, "expected the list to eventually succeed")
+		})
+	}
+}
+
+func TestListUncachedResources(t *testing.T) {
+	t.Setenv("CATTLE_REQUEST_CACHE_DISABLED", "false")
+	t.Setenv("CATTLE_REQUEST_CACHE_EXCLUDED_RESOURCES", "events, leases.coordination.k8s.io")
+	tests := []struct {
+		name       string
+		gvr        schema.GroupVersionResource
+		wantCached bool
+	}{
+		{
+			name:       "cached resource",
+			gvr:        schema.GroupVersionResource{Version: "v1", Resource: "pods"},
+			wantCached: true,
+		},
+		{
+			name: "core resource",
+			gvr:  schema.GroupVersionResource{Version: "v1", Resource: "events"},
+		},
+		{
+			name: "resource of a group",
+			gvr:  schema.GroupVersionResource{Group: "coordination.k8s.io", Version: "v1", Resource: "leases"},
+		},
+		{
+			name:       "resource of another group",
+			gvr:        schema.GroupVersionResource{Group: "events.k8s.io", Version: "v1", Resource: "events"},
+			wantCached: true,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			apiSchema := &types.APISchema{Schema: &schemas.Schema{ID: test.gvr.Resource}}
+			attributes.SetGVR(apiSchema, test.gvr)
+			asl := &mockAccessSetLookup{}
+			for i := 0; i < 4; i++ {
+				asl.userRoles = append(asl.userRoles, map[string]string{"user1": "roleA"})
+			}
+			contents := &unstructured.UnstructuredList{
+				Items: []unstructured.Unstructured{newApple("fuji").Unstructured},
+			}
+			contents.SetResourceVersion("42")
+			partitionStore := &mockStore{contents: contents}
+			store := NewStore(mockPartitioner{
+				stores: map[string]UnstructuredStore{
+					"all": partitionStore,
+				},
+				partitions: map[string][]Partition{
+					"user1": {mockPartition{name: "all"}},
+				},
+			}, asl, mockNamespaceCache{})
+
+			for i := 0; i < 2; i++ {
+				list, err := store.List(newRequest("revision=42", "user1"), apiSchema)
+				require.NoError(t, err)
+				assert.Len(t, list.Objects, 1)
+			}
+			if test.wantCached {
+				assert.Equal(t, 1, partitionStore.called, "expected the list to be served from the cache")
+				assert.Len(t, store.listCache.Keys(), 1)
+			} else {
+				assert.Equal(t, 2, partitionStore.called, "expected the list to be served from the partitions")
+				assert.Empty(t, store.listCache.Keys(), "expected the list to not be cached")
+			}
+		})
+	}
+}
+
+// relatedStore is a types.Store listing the related objects of a join which the user may see, and the requests it
+// lists them for.
+func TestListRateLimit(t *testing.T) {
+	t.Setenv(listRateEnv, "60")
+	t.Setenv(listBurstEnv, "2")
+	schema := &types.APISchema{Schema: &schemas.Schema{ID: "apple"}}
+	asl := &mockAccessSetLookup{}
+	for i := 0; i < 10; i++ {
+		asl.userRoles = append(asl.userRoles, map[string]string{"user1": "roleA", "user2": "roleA"})
+	}
+	store := NewStore(mockPartitioner{
+		stores: map[string]UnstructuredStore{
+			"all": &mockStore{
+				contents: &unstructured.UnstructuredList{
+					Items: []unstructured.Unstructured{newApple("fuji").Unstructured},
+				},
+			},
+		},
+		partitions: map[string][]Partition{
+			"user1": {mockPartition{name: "all"}},
+			"user2": {mockPartition{name: "all"}},
+		},
+	}, asl, mockNamespaceCache{})
+	now := time.Now()
+	store.listLimiters.now = func() time.Time { return now }
+
+	list := func(username string) (*httptest.ResponseRecorder, error) {
+		apiOp := newRequest("", username)
+		recorder := httptest.NewRecorder()
+		apiOp.Response = recorder
+		_, err := store.List(apiOp, schema)
+		return recorder, err
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err := list("user1")
+		require.NoError(t, err, "expected the lists of the burst to be allowed")
+	}
+	recorder, err := list("user1")
+	var apiErr *apierror.APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusTooManyRequests, apiErr.Code.Status)
+	assert.Equal(t, "1", recorder.Header().Get("Retry-After"))
+
+	_, err = list("user2")
+	assert.NoError(t, err, "expected the lists of each user to be limited separately")
+
+	now = now.Add(500 * time.Millisecond)
+	recorder, err = list("user1")
+	assert.Error(t, err, "expected the list to be limited until a token is added")
+	assert.Equal(t, "1", recorder.Header().Get("Retry-After"))
+
+	now = now.Add(500 * time.Millisecond)
+	_, err = list("user1")
+	assert.NoError(t, err, "expected a list to be allowed once a token is added, since rejected lists do not take tokens")
+	_, err = list("user1")
+	assert.Error(t, err, "expected the bucket to be empty again")
+}
+
+type relatedStore struct {
+	empty.Store
+	objects  []types.APIObject
+	requests []*types.APIRequest
+}
+
+func (r *relatedStore) List(apiOp *types.APIRequest, schema *types.APISchema) (types.APIObjectList, error) {
+	r.requests = append(r.requests, apiOp)
+	return types.APIObjectList{Objects: r.objects}, nil
+}
+
+func TestListJoin(t *testing.T) {
+	pod := func(name, nodeName string) unstructured.Unstructured {
+		return unstructured.Unstructured{Object: map[string]interface{}{
+			"kind":     "Pod",
+			"metadata": map[string]interface{}{"name": name},
+			"spec":     map[string]interface{}{"nodeName": nodeName},
+		}}
+	}
+	node1 := &unstructured.Unstructured{Object: map[string]interface{}{
+		"kind":     "Node",
+		"metadata": map[string]interface{}{"name": "node1"},
+	}}
+	podSchema := &types.APISchema{Schema: &schemas.Schema{ID: "pod"}}
+	attributes.SetJoins(podSchema, []listprocessor.Join{{Name: "node", Field: "spec.nodeName", Schema: "node"}})
+
+	tests := []struct {
+		name       string
+		query      string
+		canList    bool
+		noSchema   bool
+		wantJoined map[string]interface{}
+		wantErr    bool
+	}{
+		{
+			name:       "join with partial access",
+			query:      "join=node",
+			canList:    true,
+			wantJoined: map[string]interface{}{"web-1": node1.Object},
+		},
+		{
+			name:       "join with a projection",
+			query:      "join=node&projection=metadata.name",
+			canList:    true,
+			wantJoined: map[string]interface{}{"web-1": node1.Object},
+		},
+		{
+			name:    "no join",
+			canList: true,
+		},
+		{
+			name:  "related schema which cannot be listed",
+			query: "join=node",
+		},
+		{
+			name:     "related schema which is not accessible",
+			query:    "join=node",
+			noSchema: true,
+		},
+		{
+			name:    "undeclared join",
+			query:   "join=owner",
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			asl := &mockAccessSetLookup{userRoles: []map[string]string{{"user1": "roleA"}}}
+			store := NewStore(mockPartitioner{
+				stores: map[string]UnstructuredStore{
+					"all": &mockStore{
+						contents: &unstructured.UnstructuredList{
+							Items: []unstructured.Unstructured{pod("web-1", "node1"), pod("web-2", "node2")},
+						},
+					},
+				},
+				partitions: map[string][]Partition{
+					"user1": {mockPartition{name: "all"}},
+				},
+			}, asl, mockNamespaceCache{})
+
+			// the user may only see node1
+			nodes := &relatedStore{objects: []types.APIObject{{Type: "node", ID: "node1", Object: node1}}}
+			userSchemas := types.EmptyAPISchemas()
+			if !test.noSchema {
+				nodeSchema := types.APISchema{Schema: &schemas.Schema{ID: "node"}, Store: nodes}
+				if test.canList {
+					nodeSchema.CollectionMethods = []string{http.MethodGet}
+				}
+				userSchemas.MustAddSchema(nodeSchema)
+			}
+			apiOp := newRequest(test.query, "user1")
+			apiOp.Schemas = userSchemas
+			apiOp.Request.Header = http.Header{"If-None-Match": []string{`"etag"`}}
+
+			list, err := store.List(apiOp, podSchema)
+			if test.wantErr {
+				var apiErr *apierror.APIError
+				require.ErrorAs(t, err, &apiErr)
+				assert.Equal(t, http.StatusBadRequest, apiErr.Code.Status)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, list.Objects, 2)
+			joined := map[string]interface{}{}
+			for _, obj := range list.Objects {
+				object := obj.Object.(*unstructured.Unstructured).Object
+				if node, ok := object["joined"]; ok {
+					joined[obj.ID] = node.(map[string]interface{})["node"]
+				}
+			}
+			if test.wantJoined == nil {
+				assert.Empty(t, joined)
+			} else {
+				assert.Equal(t, test.wantJoined, joined)
+			}
+			if len(nodes.requests) > 0 {
+				req := nodes.requests[0]
+				assert.Equal(t, "node", req.Type)
+				assert.Empty(t, req.Request.URL.RawQuery, "expected the related objects to be listed without the query of the list")
+				assert.Empty(t, req.Request.Header.Get("If-None-Match"), "expected the related objects to be listed unconditionally")
+			}
+		})
+	}
+}
+
+func TestListAuthorizer(t *testing.T) {
+	owned := func(name, owner string) unstructured.Unstructured {
+		obj := newApple(name).Unstructured
+		obj.SetLabels(map[string]string{"owner": owner})
+		return obj
+	}
+	schema := &types.APISchema{Schema: &schemas.Schema{ID: "apple"}}
+	attributes.SetAuthorizer(schema, listprocessor.AuthorizeEach(func(user user.Info, obj *unstructured.Unstructured) bool {
+		return obj.GetLabels()["owner"] == user.GetName()
+	}))
+	asl := &mockAccessSetLookup{}
+	for i := 0; i < 10; i++ {
+		asl.userRoles = append(asl.userRoles, map[string]string{"user1": "roleA", "user2": "roleA"})
+	}
+	backing := &mockStore{
+		contents: &unstructured.UnstructuredList{
+			Items: []unstructured.Unstructured{owned("fuji", "user1"), owned("granny-smith", "user2"), owned("red-delicious", "user1")},
+		},
+	}
+	backing.contents.SetResourceVersion("1")
+	t.Setenv(cacheDisableEnv, "false")
+	store := NewStore(lookupPartitioner{mockPartitioner{
+		stores: map[string]UnstructuredStore{
+			"all": byIDStore{backing},
+		},
+		partitions: map[string][]Partition{
+			"user1": {mockPartition{name: "all"}},
+			"user2": {mockPartition{name: "all"}},
+		},
+	}}, asl, mockNamespaceCache{})
+
+	listed := func(query, username string) ([]string, int) {
+		list, err := store.List(newRequest(query, username), schema)
+		require.NoError(t, err)
+		var names []string
+		for _, obj := range list.Objects {
+			names = append(names, obj.ID)
+		}
+		return names, list.Count
+	}
+
+	names, count := listed("", "user1")
+	assert.Equal(t, []string{"fuji", "red-delicious"}, names)
+	assert.Equal(t, 2, count, "expected the count to be of the authorized objects")
+	names, _ = listed("pagesize=1", "user1")
+	assert.Equal(t, []string{"fuji"}, names, "expected the pages to be of the authorized objects")
+	names, _ = listed("", "user2")
+	assert.Equal(t, []string{"granny-smith"}, names)
+	names, _ = listed("revision=1", "user1")
+	assert.Equal(t, []string{"fuji", "red-delicious"}, names)
+	called := backing.called
+	names, _ = listed("revision=1", "user2")
+	assert.Equal(t, []string{"granny-smith"}, names, "expected a cached list to be authorized for each user")
+	assert.Equal(t, called, backing.called, "expected the list to be cached for the users with the same access")
+
+	_, err := store.ByID(newRequest("", "user1"), schema, "fuji")
+	assert.NoError(t, err)
+	_, err = store.ByID(newRequest("", "user1"), schema, "granny-smith")
+	var apiErr *apierror.APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusNotFound, apiErr.Code.Status, "expected an object the user may not see to not be found")
+
+	event := func(eventType watch.EventType, name string) watch.Event {
+		obj := owned(name, "user2")
+		return watch.Event{Type: eventType, Object: &obj}
+	}
+	_, ok := authorizeEvent(newRequest("", "user1"), schema, event(watch.Added, "granny-smith"))
+	assert.False(t, ok, "expected the addition of an object the user may not see to be dropped")
+	got, ok := authorizeEvent(newRequest("", "user1"), schema, event(watch.Modified, "granny-smith"))
+	assert.True(t, ok)
+	assert.Equal(t, watch.Deleted, got.Type, "expected a change to an object the user may not see to be sent as a removal")
+	got, ok = authorizeEvent(newRequest("", "user2"), schema, event(watch.Modified, "granny-smith"))
+	assert.True(t, ok)
+	assert.Equal(t, watch.Modified, got.Type)
+}
+
+func TestETagMatches(t *testing.T) {
+	assert.False(t, etagMatches("", `W/"a"`))
+	assert.True(t, etagMatches(`W/"a"`, `W/"a"`))
+	assert.True(t, etagMatches(`"a"`, `W/"a"`))
+	assert.True(t, etagMatches(`W/"b", W/"a"`, `W/"a"`))
+	assert.True(t, etagMatches("*", `W/"a"`))
+	assert.False(t, etagMatches(`W/"b"`, `W/"a"`))
+}
+
+// watchStore is a mockStore whose watch sends the events sent to its channel.
+type watchStore struct {
+	*mockStore
+	events chan watch.Event
+}
+
+func (w *watchStore) Watch(apiOp *types.APIRequest, schema *types.APISchema, wr types.WatchRequest) (chan watch.Event, error) {
+	return w.events, nil
+}
+
+func TestWatchBookmarks(t *testing.T) {
+	schema := &types.APISchema{Schema: &schemas.Schema{ID: "apple"}}
+	fuji := func(revision string) watch.Event {
+		obj := newApple("fuji").Unstructured
+		obj.SetResourceVersion(revision)
+		return watch.Event{Type: watch.Modified, Object: &obj}
+	}
+	bookmark := func(revision string) watch.Event {
+		obj := &unstructured.Unstructured{Object: map[string]interface{}{}}
+		obj.SetResourceVersion(revision)
+		return watch.Event{Type: watch.Bookmark, Object: obj}
+	}
+
+	tests := []struct {
+		name          string
+		query         string
+		wantBookmarks bool
+	}{
+		{name: "bookmarks", query: "allowWatchBookmarks=true", wantBookmarks: true},
+		{name: "no bookmarks", query: ""},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			a := &watchStore{mockStore: &mockStore{}, events: make(chan watch.Event)}
+			b := &watchStore{mockStore: &mockStore{}, events: make(chan watch.Event)}
+			store := NewStore(mockPartitioner{
+				stores: map[string]UnstructuredStore{"a": a, "b": b},
+				partitions: map[string][]Partition{
+					"user1": {mockPartition{name: "a"}, mockPartition{name: "b"}},
+				},
+			}, &mockAccessSetLookup{}, mockNamespaceCache{})
+			interval := 50 * time.Millisecond
+			store.bookmarkInterval = interval
+
+			apiOp := newRequest(test.query, "user1")
+			ctx, cancel := context.WithCancel(apiOp.Request.Context())
+			defer cancel()
+			apiOp.Request = apiOp.Request.WithContext(ctx)
+			events, err := store.Watch(apiOp, schema, types.WatchRequest{})
+			require.NoError(t, err)
+
+			next := func() types.APIEvent {
+				select {
+				case event := <-events:
+					return event
+				case <-time.After(10 * interval):
+					return types.APIEvent{}
+				}
+			}
+			var bookmarks []string
+			nextBookmark := func() string {
+				for {
+					event := next()
+					if event.Name == "" {
+						return ""
+					}
+					if event.Name == BookmarkAPIEvent {
+						bookmarks = append(bookmarks, event.Revision)
+						return event.Revision
+					}
+				}
+			}
+
+			a.events <- fuji("10")
+			assert.Equal(t, types.ChangeAPIEvent, next().Name)
+			// the bookmarks of Kubernetes are not sent as they are, but advance the revision of the partition
+			b.events <- bookmark("12")
+			if !test.wantBookmarks {
+				assert.Empty(t, next().Name, "expected no bookmark for a client which did not opt in")
+				return
+			}
+			assert.Equal(t, "10", nextBookmark(), "expected the bookmark to be the revision every partition has been watched up to")
+			start := time.Now()
+			assert.Equal(t, "10", nextBookmark())
+			assert.GreaterOrEqual(t, time.Since(start), interval/2, "expected bookmarks to be sent every interval")
+
+			go func() { a.events <- fuji("15") }()
+			assert.Equal(t, types.ChangeAPIEvent, next().Name)
+			assert.Equal(t, "12", nextBookmark())
+			b.events <- bookmark("11")
+			assert.Equal(t, "12", nextBookmark(), "expected the revisions of the bookmarks to never decrease")
+			assert.Equal(t, []string{"10", "10", "12", "12"}, bookmarks)
+		})
+	}
+}
+
+func TestToAPIEventTooOld(t *testing.T) {
+	schema := &types.APISchema{Schema: &schemas.Schema{ID: "apple"}}
+	req := newRequest("", "user1")
+
+	event := toAPIEvent(req, schema, watch.Event{Type: watch.Error, Object: &metav1.Status{
+		Code:    http.StatusGone,
+		Reason:  metav1.StatusReasonExpired,
+		Message: "too old resource version: 1 (10)",
+	}})
+	assert.Equal(t, "resource.error", event.Name)
+	assert.EqualError(t, event.Error, "tooOld: too old resource version: 1 (10)")
+
+	event = toAPIEvent(req, schema, watch.Event{Type: watch.Error, Object: &metav1.Status{
+		Code:    http.StatusInternalServerError,
+		Message: "event watch error",
+	}})
+	assert.EqualError(t, event.Error, "event watch error")
+}
+
 type mockPartitioner struct {
 	stores     map[string]UnstructuredStore
 	partitions map[string][]Partition
@@ -2165,6 +3263,16 @@ func (m mockPartitioner) Store(apiOp *types.APIRequest, partition Partition) (Un
 	return m.stores[partition.Name()], nil
 }
 
+// mockReviser is a partitioner returning the revision of the resources.
+type mockReviser struct {
+	mockPartitioner
+	revision string
+}
+
+func (m *mockReviser) Revision(apiOp *types.APIRequest, schema *types.APISchema) (string, error) {
+	return m.revision, nil
+}
+
 type mockPartition struct {
 	name string
 }
@@ -2367,6 +3475,10 @@ func (m *mockAccessSetLookup) PurgeUserData(_ string) {
 	panic("not implemented")
 }
 
+func (m *mockAccessSetLookup) PurgeUserDataBulk(_ []string) {
+	panic("not implemented")
+}
+
 func getAccessID(user, role string) string {
 	h := sha256.Sum256([]byte(user + role))
 	return string(h[:])
@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/rancher/apiserver/pkg/apierror"
+	"github.com/rancher/apiserver/pkg/types"
+	"github.com/rancher/steve/pkg/attributes"
+	"github.com/rancher/wrangler/pkg/kv"
+	"github.com/rancher/wrangler/pkg/schemas/validation"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apiserver/pkg/endpoints/request"
+)
+
+const (
+	auditSuccess = "success"
+	auditFailure = "failure"
+)
+
+// auditStore logs an audit entry for each access to the objects of sensitive schemas. The entries only have the
+// metadata of the access, who did what to which object and with which outcome, and never the objects themselves, so
+// that the values of secrets are not logged.
+type auditStore struct {
+	types.Store
+	logger logrus.FieldLogger
+}
+
+// ByID looks up a single object by its ID.
+func (a *auditStore) ByID(apiOp *types.APIRequest, schema *types.APISchema, id string) (types.APIObject, error) {
+	obj, err := a.Store.ByID(apiOp, schema, id)
+	a.audit(apiOp, schema, "get", id, err)
+	return obj, err
+}
+
+// List returns a list of resources.
+func (a *auditStore) List(apiOp *types.APIRequest, schema *types.APISchema) (types.APIObjectList, error) {
+	list, err := a.Store.List(apiOp, schema)
+	a.audit(apiOp, schema, "list", "", err)
+	return list, err
+}
+
+// Create creates a single object in the store.
+func (a *auditStore) Create(apiOp *types.APIRequest, schema *types.APISchema, data types.APIObject) (types.APIObject, error) {
+	obj, err := a.Store.Create(apiOp, schema, data)
+	id := data.Name()
+	if ns := data.Namespace(); ns != "" {
+		id = ns + "/" + id
+	}
+	a.audit(apiOp, schema, "create", id, err)
+	return obj, err
+}
+
+// Update updates a single object in the store.
+func (a *auditStore) Update(apiOp *types.APIRequest, schema *types.APISchema, data types.APIObject, id string) (types.APIObject, error) {
+	obj, err := a.Store.Update(apiOp, schema, data, id)
+	verb := "update"
+	if apiOp.Method == http.MethodPatch {
+		verb = "patch"
+	}
+	a.audit(apiOp, schema, verb, id, err)
+	return obj, err
+}
+
+// Delete deletes an object from a store.
+func (a *auditStore) Delete(apiOp *types.APIRequest, schema *types.APISchema, id string) (types.APIObject, error) {
+	obj, err := a.Store.Delete(apiOp, schema, id)
+	a.audit(apiOp, schema, "delete", id, err)
+	return obj, err
+}
+
+// Watch returns a channel of events for a list or resource.
+func (a *auditStore) Watch(apiOp *types.APIRequest, schema *types.APISchema, wr types.WatchRequest) (chan types.APIEvent, error) {
+	c, err := a.Store.Watch(apiOp, schema, wr)
+	a.audit(apiOp, schema, "watch", wr.ID, err)
+	return c, err
+}
+
+// audit logs the access of the user of the request to the object with the ID, or to the collection if the ID is
+// empty, if the schema is sensitive.
+func (a *auditStore) audit(apiOp *types.APIRequest, schema *types.APISchema, verb, id string, err error) {
+	if !attributes.Sensitive(schema) {
+		return
+	}
+	namespace, name := kv.RSplit(id, "/")
+	if namespace == "" {
+		namespace = apiOp.Namespace
+	}
+	fields := logrus.Fields{
+		"verb":      verb,
+		"resource":  attributes.GVR(schema).GroupResource().String(),
+		"namespace": namespace,
+		"name":      name,
+		"outcome":   auditSuccess,
+	}
+	if user, ok := request.UserFrom(apiOp.Context()); ok {
+		fields["user"] = user.GetName()
+		fields["groups"] = user.GetGroups()
+	}
+	// a response which was not modified since the client's is complete without the object, which is a success
+	if err != nil && !errors.Is(err, validation.ErrComplete) {
+		fields["outcome"] = auditFailure
+		fields["code"] = http.StatusInternalServerError
+		var apiErr *apierror.APIError
+		if errors.As(err, &apiErr) {
+			fields["code"] = apiErr.Code.Status
+		}
+	}
+	a.logger.WithFields(fields).Info("audit: access to a sensitive resource")
+}
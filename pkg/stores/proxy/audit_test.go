@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/rancher/apiserver/pkg/apierror"
+	"github.com/rancher/apiserver/pkg/store/empty"
+	"github.com/rancher/apiserver/pkg/types"
+	"github.com/rancher/steve/pkg/attributes"
+	"github.com/rancher/wrangler/pkg/schemas"
+	"github.com/rancher/wrangler/pkg/schemas/validation"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/endpoints/request"
+)
+
+// secretStore gets the secret named secret1 and fails to get any other.
+type secretStore struct {
+	empty.Store
+}
+
+func (s *secretStore) ByID(apiOp *types.APIRequest, schema *types.APISchema, id string) (types.APIObject, error) {
+	if id != "ns1/secret1" {
+		return types.APIObject{}, apierror.NewAPIError(validation.PermissionDenied, fmt.Sprintf("can not get %s", id))
+	}
+	return types.APIObject{ID: id, Object: &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "secret1", "namespace": "ns1"},
+		"data":     map[string]interface{}{"password": "aHVudGVyMg=="},
+	}}}, nil
+}
+
+func TestAuditStore(t *testing.T) {
+	newSchema := func(sensitive bool) *types.APISchema {
+		s := &types.APISchema{Schema: &schemas.Schema{ID: "secret"}}
+		attributes.SetGVR(s, schema.GroupVersionResource{Version: "v1", Resource: "secrets"})
+		attributes.SetSensitive(s, sensitive)
+		return s
+	}
+	apiOp := &types.APIRequest{
+		Request: (&http.Request{}).WithContext(request.WithUser(context.Background(), &user.DefaultInfo{
+			Name:   "user1",
+			Groups: []string{"group1"},
+		})),
+	}
+
+	tests := []struct {
+		name       string
+		sensitive  bool
+		id         string
+		wantFields logrus.Fields
+	}{
+		{
+			name:      "get of a sensitive resource",
+			sensitive: true,
+			id:        "ns1/secret1",
+			wantFields: logrus.Fields{
+				"user":      "user1",
+				"groups":    []string{"group1"},
+				"verb":      "get",
+				"resource":  "secrets",
+				"namespace": "ns1",
+				"name":      "secret1",
+				"outcome":   "success",
+			},
+		},
+		{
+			name:      "denied get of a sensitive resource",
+			sensitive: true,
+			id:        "ns1/secret2",
+			wantFields: logrus.Fields{
+				"user":      "user1",
+				"groups":    []string{"group1"},
+				"verb":      "get",
+				"resource":  "secrets",
+				"namespace": "ns1",
+				"name":      "secret2",
+				"outcome":   "failure",
+				"code":      http.StatusForbidden,
+			},
+		},
+		{
+			name: "get of a resource which is not sensitive",
+			id:   "ns1/secret1",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logger, hook := logtest.NewNullLogger()
+			store := &auditStore{Store: &secretStore{}, logger: logger}
+			_, _ = store.ByID(apiOp, newSchema(test.sensitive), test.id)
+			if test.wantFields == nil {
+				assert.Empty(t, hook.AllEntries(), "expected no audit entry")
+				return
+			}
+			require.Len(t, hook.AllEntries(), 1)
+			entry := hook.LastEntry()
+			assert.Equal(t, logrus.InfoLevel, entry.Level)
+			assert.Equal(t, test.wantFields, entry.Data)
+			line, err := entry.String()
+			require.NoError(t, err)
+			assert.NotContains(t, line, "aHVudGVyMg==", "expected the values of the secret to not be logged")
+		})
+	}
+}
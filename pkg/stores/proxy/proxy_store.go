@@ -7,17 +7,20 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"mime"
 	"net/http"
 	"os"
 	"regexp"
 	"strconv"
 
 	"github.com/pkg/errors"
+	"github.com/rancher/apiserver/pkg/apierror"
 	"github.com/rancher/apiserver/pkg/types"
 	"github.com/rancher/steve/pkg/accesscontrol"
 	"github.com/rancher/steve/pkg/attributes"
 	metricsStore "github.com/rancher/steve/pkg/stores/metrics"
 	"github.com/rancher/steve/pkg/stores/partition"
+	"github.com/rancher/steve/pkg/stores/partition/listprocessor"
 	"github.com/rancher/wrangler/pkg/data"
 	corecontrollers "github.com/rancher/wrangler/pkg/generated/controllers/core/v1"
 	"github.com/rancher/wrangler/pkg/schemas/validation"
@@ -39,6 +42,8 @@ import (
 const watchTimeoutEnv = "CATTLE_WATCH_TIMEOUT_SECONDS"
 
 var (
+	unsupportedMediaType = validation.ErrorCode{Code: "UnsupportedMediaType", Status: http.StatusUnsupportedMediaType}
+
 	lowerChars  = regexp.MustCompile("[a-z]+")
 	paramScheme = runtime.NewScheme()
 	paramCodec  = runtime.NewParameterCodec(paramScheme)
@@ -60,6 +65,7 @@ type ClientGetter interface {
 	TableAdminClient(ctx *types.APIRequest, schema *types.APISchema, namespace string, warningHandler rest.WarningHandler) (dynamic.ResourceInterface, error)
 	TableClientForWatch(ctx *types.APIRequest, schema *types.APISchema, namespace string, warningHandler rest.WarningHandler) (dynamic.ResourceInterface, error)
 	TableAdminClientForWatch(ctx *types.APIRequest, schema *types.APISchema, namespace string, warningHandler rest.WarningHandler) (dynamic.ResourceInterface, error)
+	PartialMetadataClient(ctx *types.APIRequest, schema *types.APISchema, namespace string, warningHandler rest.WarningHandler) (dynamic.ResourceInterface, error)
 }
 
 // WarningBuffer holds warnings that may be returned from the kubernetes api
@@ -87,20 +93,23 @@ type Store struct {
 
 // NewProxyStore returns a wrapped types.Store.
 func NewProxyStore(clientGetter ClientGetter, notifier RelationshipNotifier, lookup accesscontrol.AccessSetLookup, namespaceCache corecontrollers.NamespaceCache) types.Store {
-	return &errorStore{
-		Store: &unformatterStore{
-			Store: &WatchRefresh{
-				Store: partition.NewStore(
-					&rbacPartitioner{
-						proxyStore: &Store{
-							clientGetter: clientGetter,
-							notifier:     notifier,
+	return &auditStore{
+		logger: logrus.StandardLogger(),
+		Store: &errorStore{
+			Store: &unformatterStore{
+				Store: &WatchRefresh{
+					Store: partition.NewStore(
+						&rbacPartitioner{
+							proxyStore: &Store{
+								clientGetter: clientGetter,
+								notifier:     notifier,
+							},
 						},
-					},
-					lookup,
-					namespaceCache,
-				),
-				asl: lookup,
+						lookup,
+						namespaceCache,
+					),
+					asl: lookup,
+				},
 			},
 		},
 	}
@@ -232,17 +241,54 @@ func (s *Store) ByNames(apiOp *types.APIRequest, schema *types.APISchema, names
 	return objs, buffer, nil
 }
 
-// List returns an unstructured list of resources.
+// Revision returns the current revision of the resources of the schema, by listing a single object with the admin
+// client, so that it does not depend on the access of the user of the request. The revision is shared by all the
+// resources, so it does not reveal any object.
+func (s *Store) Revision(apiOp *types.APIRequest, schema *types.APISchema) (string, error) {
+	buffer := WarningBuffer{}
+	client, err := s.clientGetter.AdminClient(apiOp, schema, "", &buffer)
+	if err != nil {
+		return "", err
+	}
+	k8sClient, _ := metricsStore.Wrap(client, nil)
+	list, err := k8sClient.List(apiOp, metav1.ListOptions{Limit: 1})
+	if err != nil {
+		return "", err
+	}
+	return list.GetResourceVersion(), nil
+}
+
+// List returns an unstructured list of resources. If the request asks for the metadata of the resources only, only
+// their metadata is listed from Kubernetes.
 func (s *Store) List(apiOp *types.APIRequest, schema *types.APISchema) (*unstructured.UnstructuredList, []types.Warning, error) {
 	buffer := WarningBuffer{}
-	client, err := s.clientGetter.TableClient(apiOp, schema, apiOp.Namespace, &buffer)
+	metadataOnly := listprocessor.PartialMetadataRequested(apiOp.Request)
+	getClient := s.clientGetter.TableClient
+	if metadataOnly {
+		getClient = s.clientGetter.PartialMetadataClient
+	}
+	client, err := getClient(apiOp, schema, apiOp.Namespace, &buffer)
 	if err != nil {
 		return nil, nil, err
 	}
 	result, err := s.list(apiOp, schema, client)
+	if err == nil && metadataOnly {
+		partialToObjects(result, schema)
+	}
 	return result, buffer, err
 }
 
+// partialToObjects sets the apiVersion and kind of the PartialObjectMetadata items of a metadata-only list to those of
+// the schema, so that they are formatted like the objects of the schema.
+func partialToObjects(list *unstructured.UnstructuredList, schema *types.APISchema) {
+	gvk := attributes.GVK(schema)
+	for i := range list.Items {
+		if list.Items[i].GetKind() == "PartialObjectMetadata" {
+			list.Items[i].SetGroupVersionKind(gvk)
+		}
+	}
+}
+
 func (s *Store) list(apiOp *types.APIRequest, schema *types.APISchema, client dynamic.ResourceInterface) (*unstructured.UnstructuredList, error) {
 	opts := metav1.ListOptions{}
 	if err := decodeParams(apiOp, &opts); err != nil {
@@ -285,12 +331,19 @@ func (s *Store) listAndWatch(apiOp *types.APIRequest, client dynamic.ResourceInt
 			timeout = int64(userSetTimeout)
 		}
 	}
+	selector, err := parseWatchSelector(w.Selector)
+	if err != nil {
+		returnErr(errors.Wrapf(err, "stopping watch for %s: %v", schema.ID, err), result)
+		return
+	}
 	k8sClient, _ := metricsStore.Wrap(client, nil)
 	watcher, err := k8sClient.Watch(apiOp, metav1.ListOptions{
 		Watch:           true,
 		TimeoutSeconds:  &timeout,
 		ResourceVersion: rev,
-		LabelSelector:   w.Selector,
+		LabelSelector:   selector.labelSelector,
+		// the bookmarks of Kubernetes advance the revision of the watch even when no object changes
+		AllowWatchBookmarks: partition.WatchBookmarks(apiOp),
 	})
 	if err != nil {
 		returnErr(errors.Wrapf(err, "stopping watch for %s: %v", schema.ID, err), result)
@@ -312,7 +365,9 @@ func (s *Store) listAndWatch(apiOp *types.APIRequest, client dynamic.ResourceInt
 				obj, _, err := s.byID(apiOp, schema, rel.Namespace, rel.Name)
 				if err == nil {
 					rowToObject(obj)
-					result <- watch.Event{Type: watch.Modified, Object: obj}
+					if event, ok := selector.filter(watch.Event{Type: watch.Modified, Object: obj}); ok {
+						result <- event
+					}
 				} else {
 					returnErr(errors.Wrapf(err, "notifier watch error: %v", err), result)
 				}
@@ -324,7 +379,11 @@ func (s *Store) listAndWatch(apiOp *types.APIRequest, client dynamic.ResourceInt
 	eg.Go(func() error {
 		for event := range watcher.ResultChan() {
 			if event.Type == watch.Error {
-				if status, ok := event.Object.(*metav1.Status); ok {
+				if status, ok := event.Object.(*metav1.Status); ok && status.Code == http.StatusGone {
+					// the revision to resume from has been compacted, so the client has to list again
+					result <- event
+					return fmt.Errorf("revision %s is too old", rev)
+				} else if ok {
 					returnErr(fmt.Errorf("event watch error: %s", status.Message), result)
 				} else {
 					logrus.Debugf("event watch error: could not decode event object %T", event.Object)
@@ -334,7 +393,9 @@ func (s *Store) listAndWatch(apiOp *types.APIRequest, client dynamic.ResourceInt
 			if unstr, ok := event.Object.(*unstructured.Unstructured); ok {
 				rowToObject(unstr)
 			}
-			result <- event
+			if event, ok := selector.filter(event); ok {
+				result <- event
+			}
 		}
 		return fmt.Errorf("closed")
 	})
@@ -364,6 +425,10 @@ func (s *Store) WatchNames(apiOp *types.APIRequest, schema *types.APISchema, w t
 	go func() {
 		defer close(result)
 		for item := range c {
+			if item.Type == watch.Bookmark {
+				result <- item
+				continue
+			}
 			if item.Type == watch.Error {
 				if status, ok := item.Object.(*metav1.Status); ok {
 					logrus.Debugf("WatchNames received error: %s", status.Message)
@@ -408,6 +473,28 @@ func (s *Store) watch(apiOp *types.APIRequest, schema *types.APISchema, w types.
 	return result, nil
 }
 
+// patchType returns the type of patch sent with the content type. Patches without a content type, or with the plain
+// JSON content type, are strategic merge patches.
+func patchType(contentType string) (apitypes.PatchType, error) {
+	if contentType == "" {
+		return apitypes.StrategicMergePatchType, nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", apierror.NewAPIError(unsupportedMediaType, fmt.Sprintf("invalid content type %q: %v", contentType, err))
+	}
+	switch apitypes.PatchType(mediaType) {
+	case apitypes.StrategicMergePatchType, "application/json":
+		return apitypes.StrategicMergePatchType, nil
+	case apitypes.MergePatchType:
+		return apitypes.MergePatchType, nil
+	case apitypes.JSONPatchType:
+		return apitypes.JSONPatchType, nil
+	}
+	return "", apierror.NewAPIError(unsupportedMediaType, fmt.Sprintf("unsupported patch content type %q, expected %s, %s or %s",
+		mediaType, apitypes.StrategicMergePatchType, apitypes.MergePatchType, apitypes.JSONPatchType))
+}
+
 // Create creates a single object in the store.
 func (s *Store) Create(apiOp *types.APIRequest, schema *types.APISchema, params types.APIObject) (*unstructured.Unstructured, []types.Warning, error) {
 	var (
@@ -432,6 +519,9 @@ func (s *Store) Create(apiOp *types.APIRequest, schema *types.APISchema, params
 
 	gvk := attributes.GVK(schema)
 	input["apiVersion"], input["kind"] = gvk.ToAPIVersionAndKind()
+	if err := validateObject(apiOp, schema, input); err != nil {
+		return nil, nil, err
+	}
 
 	buffer := WarningBuffer{}
 	k8sClient, err := metricsStore.Wrap(s.clientGetter.TableClient(apiOp, schema, ns, &buffer))
@@ -469,9 +559,9 @@ func (s *Store) Update(apiOp *types.APIRequest, schema *types.APISchema, params
 			return nil, nil, err
 		}
 
-		pType := apitypes.StrategicMergePatchType
-		if apiOp.Request.Header.Get("content-type") == string(apitypes.JSONPatchType) {
-			pType = apitypes.JSONPatchType
+		pType, err := patchType(apiOp.Request.Header.Get("content-type"))
+		if err != nil {
+			return nil, nil, err
 		}
 
 		opts := metav1.PatchOptions{}
@@ -479,10 +569,23 @@ func (s *Store) Update(apiOp *types.APIRequest, schema *types.APISchema, params
 			return nil, nil, err
 		}
 
-		if pType == apitypes.StrategicMergePatchType {
+		if pType == apitypes.JSONPatchType {
+			var operations []map[string]interface{}
+			if err := json.Unmarshal(bytes, &operations); err != nil {
+				return nil, nil, apierror.NewAPIError(validation.InvalidBodyContent, "a JSON patch must be a list of operations: "+err.Error())
+			}
+			for _, operation := range operations {
+				if _, ok := operation["op"].(string); !ok {
+					return nil, nil, apierror.NewAPIError(validation.InvalidBodyContent, "every JSON patch operation must have an op")
+				}
+				if _, ok := operation["path"].(string); !ok {
+					return nil, nil, apierror.NewAPIError(validation.InvalidBodyContent, "every JSON patch operation must have a path")
+				}
+			}
+		} else {
 			data := map[string]interface{}{}
 			if err := json.Unmarshal(bytes, &data); err != nil {
-				return nil, nil, err
+				return nil, nil, apierror.NewAPIError(validation.InvalidBodyContent, "a merge patch must be an object: "+err.Error())
 			}
 			data = moveFromUnderscore(data)
 			bytes, err = json.Marshal(data)
@@ -501,7 +604,11 @@ func (s *Store) Update(apiOp *types.APIRequest, schema *types.APISchema, params
 
 	resourceVersion := input.String("metadata", "resourceVersion")
 	if resourceVersion == "" {
-		return nil, nil, fmt.Errorf("metadata.resourceVersion is required for update")
+		// the resource version makes Kubernetes reject updates of an object changed since it was read with a conflict
+		return nil, nil, apierror.NewFieldAPIError(validation.MissingRequired, "metadata.resourceVersion", "metadata.resourceVersion is required for update")
+	}
+	if err := validateObject(apiOp, schema, input); err != nil {
+		return nil, nil, err
 	}
 
 	opts := metav1.UpdateOptions{}
@@ -509,7 +616,7 @@ func (s *Store) Update(apiOp *types.APIRequest, schema *types.APISchema, params
 		return nil, nil, err
 	}
 
-	resp, err := k8sClient.Update(apiOp, &unstructured.Unstructured{Object: moveFromUnderscore(input)}, metav1.UpdateOptions{})
+	resp, err := k8sClient.Update(apiOp, &unstructured.Unstructured{Object: moveFromUnderscore(input)}, opts)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -522,7 +629,7 @@ func (s *Store) Update(apiOp *types.APIRequest, schema *types.APISchema, params
 func (s *Store) Delete(apiOp *types.APIRequest, schema *types.APISchema, id string) (*unstructured.Unstructured, []types.Warning, error) {
 	opts := metav1.DeleteOptions{}
 	if err := decodeParams(apiOp, &opts); err != nil {
-		return nil, nil, nil
+		return nil, nil, err
 	}
 
 	buffer := WarningBuffer{}
@@ -1,21 +1,28 @@
 package proxy
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/rancher/apiserver/pkg/apierror"
 	"github.com/rancher/apiserver/pkg/types"
 	"github.com/rancher/steve/pkg/client"
 	"github.com/rancher/wrangler/pkg/schemas"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/sync/errgroup"
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	schema2 "k8s.io/apimachinery/pkg/runtime/schema"
+	apitypes "k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/dynamic"
@@ -29,7 +36,7 @@ var c *watch.FakeWatcher
 type testFactory struct {
 	*client.Factory
 
-	fakeClient *fake.FakeDynamicClient
+	fakeClient dynamic.Interface
 }
 
 func TestWatchNamesErrReceive(t *testing.T) {
@@ -80,6 +87,480 @@ func TestByNames(t *testing.T) {
 	assert.Nil(t, warn)
 }
 
+func TestWatchSelector(t *testing.T) {
+	pod := func(name, app, phase string) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Pod",
+			"metadata": map[string]interface{}{
+				"name":   name,
+				"labels": map[string]interface{}{"app": app},
+			},
+			"status": map[string]interface{}{
+				"phase": phase,
+			},
+		}}
+	}
+	tests := []struct {
+		name     string
+		selector string
+		want     []watch.EventType
+		wantErr  bool
+	}{
+		{
+			name:     "no selector",
+			selector: "",
+			want:     []watch.EventType{watch.Added, watch.Added, watch.Modified, watch.Modified, watch.Deleted},
+		},
+		{
+			name:     "label selector",
+			selector: "app=web",
+			want:     []watch.EventType{watch.Added, watch.Modified, watch.Modified, watch.Deleted},
+		},
+		{
+			name:     "label and field selectors",
+			selector: "labelSelector=app%3Dweb&fieldSelector=status.phase%3DRunning",
+			want:     []watch.EventType{watch.Added, watch.Deleted, watch.Modified, watch.Deleted},
+		},
+		{
+			name:     "field selector",
+			selector: "fieldSelector=status.phase!%3DRunning",
+			want:     []watch.EventType{watch.Modified, watch.Deleted, watch.Deleted},
+		},
+		{
+			name:     "invalid selector",
+			selector: "fieldSelector=status.phase",
+			wantErr:  true,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			fakeClient := fake.NewSimpleDynamicClient(runtime.NewScheme())
+			w := watch.NewFakeWithChanSize(5, true)
+			w.Add(pod("a", "web", "Running"))
+			w.Add(pod("b", "db", "Running"))
+			// a stops matching the field selector, then matches it again
+			w.Modify(pod("a", "web", "Pending"))
+			w.Modify(pod("a", "web", "Running"))
+			w.Delete(pod("a", "web", "Running"))
+			w.Stop()
+			fakeClient.PrependWatchReactor("*", func(action clientgotesting.Action) (handled bool, ret watch.Interface, err error) {
+				return true, w, nil
+			})
+			s := Store{}
+			apiSchema := &types.APISchema{Schema: &schemas.Schema{ID: "pods"}}
+			apiOp := &types.APIRequest{Schema: apiSchema, Request: &http.Request{}}
+			wc, err := s.watch(apiOp, apiSchema, types.WatchRequest{Selector: test.selector}, fakeClient.Resource(schema2.GroupVersionResource{}))
+			assert.NoError(t, err)
+
+			var got []watch.EventType
+			for event := range wc {
+				if event.Type == watch.Error {
+					assert.True(t, test.wantErr, "unexpected error event %v", event.Object)
+					continue
+				}
+				got = append(got, event.Type)
+			}
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestWatchResume(t *testing.T) {
+	tests := []struct {
+		name       string
+		revision   string
+		compacted  bool
+		wantEvents []watch.EventType
+	}{
+		{
+			name:       "resume from valid revision",
+			revision:   "5",
+			wantEvents: []watch.EventType{watch.Modified, watch.Added},
+		},
+		{
+			name:       "resume from compacted revision",
+			revision:   "1",
+			compacted:  true,
+			wantEvents: []watch.EventType{watch.Error},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			fakeClient := fake.NewSimpleDynamicClient(runtime.NewScheme())
+			w := watch.NewFakeWithChanSize(5, true)
+			if test.compacted {
+				w.Error(&metav1.Status{
+					Status:  metav1.StatusFailure,
+					Code:    http.StatusGone,
+					Reason:  metav1.StatusReasonExpired,
+					Message: "too old resource version: 1 (10)",
+				})
+			}
+			w.Modify(&v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "testsecret1", ResourceVersion: "6"}})
+			w.Add(&v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "testsecret2", ResourceVersion: "7"}})
+			if !test.compacted {
+				w.Stop()
+			}
+			var resourceVersion string
+			fakeClient.PrependWatchReactor("*", func(action clientgotesting.Action) (handled bool, ret watch.Interface, err error) {
+				resourceVersion = action.(clientgotesting.WatchActionImpl).GetWatchRestrictions().ResourceVersion
+				return true, w, nil
+			})
+			s := Store{}
+			apiSchema := &types.APISchema{Schema: &schemas.Schema{ID: "secrets"}}
+			apiOp := &types.APIRequest{Schema: apiSchema, Request: &http.Request{}}
+			wc, err := s.watch(apiOp, apiSchema, types.WatchRequest{Revision: test.revision}, fakeClient.Resource(schema2.GroupVersionResource{}))
+			assert.NoError(t, err)
+
+			var got []watch.EventType
+			for event := range wc {
+				got = append(got, event.Type)
+				if event.Type == watch.Error {
+					status, ok := event.Object.(*metav1.Status)
+					assert.True(t, ok)
+					assert.Equal(t, int32(http.StatusGone), status.Code)
+				}
+			}
+			assert.Equal(t, test.revision, resourceVersion)
+			assert.Equal(t, test.wantEvents, got)
+		})
+	}
+}
+
+func TestUpdatePatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantType    apitypes.PatchType
+		wantPatch   string
+		wantStatus  int
+	}{
+		{
+			name:      "no content type is a strategic merge patch",
+			body:      `{"metadata":{"labels":{"a":"b"}},"_type":"t"}`,
+			wantType:  apitypes.StrategicMergePatchType,
+			wantPatch: `{"metadata":{"labels":{"a":"b"}},"type":"t"}`,
+		},
+		{
+			name:        "strategic merge patch",
+			contentType: "application/strategic-merge-patch+json; charset=utf-8",
+			body:        `{"metadata":{"labels":{"a":"b"}}}`,
+			wantType:    apitypes.StrategicMergePatchType,
+			wantPatch:   `{"metadata":{"labels":{"a":"b"}}}`,
+		},
+		{
+			name:        "merge patch",
+			contentType: "application/merge-patch+json",
+			body:        `{"metadata":{"labels":{"a":null}},"_type":"t"}`,
+			wantType:    apitypes.MergePatchType,
+			wantPatch:   `{"metadata":{"labels":{"a":null}},"type":"t"}`,
+		},
+		{
+			name:        "JSON patch",
+			contentType: "application/json-patch+json",
+			body:        `[{"op":"add","path":"/metadata/labels/a","value":"b"}]`,
+			wantType:    apitypes.JSONPatchType,
+			wantPatch:   `[{"op":"add","path":"/metadata/labels/a","value":"b"}]`,
+		},
+		{
+			name:        "JSON patch which is not a list",
+			contentType: "application/json-patch+json",
+			body:        `{"op":"add"}`,
+			wantStatus:  http.StatusUnprocessableEntity,
+		},
+		{
+			name:        "JSON patch operation without a path",
+			contentType: "application/json-patch+json",
+			body:        `[{"op":"remove"}]`,
+			wantStatus:  http.StatusUnprocessableEntity,
+		},
+		{
+			name:        "merge patch which is not an object",
+			contentType: "application/merge-patch+json",
+			body:        `[]`,
+			wantStatus:  http.StatusUnprocessableEntity,
+		},
+		{
+			name:        "unsupported content type",
+			contentType: "application/apply-patch+yaml",
+			body:        `metadata: {}`,
+			wantStatus:  http.StatusUnsupportedMediaType,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			testClientFactory, err := client.NewFactory(&rest.Config{}, false)
+			assert.NoError(t, err)
+			fakeClient := fake.NewSimpleDynamicClient(runtime.NewScheme())
+			var action clientgotesting.PatchActionImpl
+			fakeClient.PrependReactor("patch", "*", func(a clientgotesting.Action) (bool, runtime.Object, error) {
+				action = a.(clientgotesting.PatchActionImpl)
+				obj := &unstructured.Unstructured{}
+				obj.SetAPIVersion("v1")
+				obj.SetKind("Pod")
+				obj.SetName(action.GetName())
+				return true, obj, nil
+			})
+			s := Store{
+				clientGetter: &testFactory{Factory: testClientFactory, fakeClient: fakeClient},
+			}
+			req, err := http.NewRequest(http.MethodPatch, "/v1/pods/ns/a", strings.NewReader(test.body))
+			assert.NoError(t, err)
+			if test.contentType != "" {
+				req.Header.Set("Content-Type", test.contentType)
+			}
+			apiSchema := &types.APISchema{Schema: &schemas.Schema{ID: "pods"}}
+			apiOp := &types.APIRequest{Schema: apiSchema, Request: req, Method: http.MethodPatch}
+
+			obj, _, err := s.Update(apiOp, apiSchema, types.APIObject{Object: map[string]interface{}{}}, "a")
+			if test.wantStatus != 0 {
+				var apiErr *apierror.APIError
+				assert.ErrorAs(t, err, &apiErr)
+				assert.Equal(t, test.wantStatus, apiErr.Code.Status)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "a", obj.GetName())
+			assert.Equal(t, test.wantType, action.GetPatchType())
+			assert.JSONEq(t, test.wantPatch, string(action.GetPatch()))
+		})
+	}
+}
+
+func TestUpdateResourceVersion(t *testing.T) {
+	tests := []struct {
+		name            string
+		resourceVersion string
+		current         string
+		wantErr         func(error) bool
+	}{
+		{
+			name:            "update of the current resource version",
+			resourceVersion: "5",
+			current:         "5",
+		},
+		{
+			name:            "update of an older resource version conflicts",
+			resourceVersion: "4",
+			current:         "5",
+			wantErr:         apierrors.IsConflict,
+		},
+		{
+			name:    "update without a resource version is rejected",
+			current: "5",
+			wantErr: func(err error) bool {
+				var apiErr *apierror.APIError
+				return errors.As(err, &apiErr) && apiErr.Code.Status == http.StatusUnprocessableEntity
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			testClientFactory, err := client.NewFactory(&rest.Config{}, false)
+			assert.NoError(t, err)
+			fakeClient := fake.NewSimpleDynamicClient(runtime.NewScheme())
+			updated := false
+			fakeClient.PrependReactor("update", "*", func(a clientgotesting.Action) (bool, runtime.Object, error) {
+				obj := a.(clientgotesting.UpdateActionImpl).GetObject().(*unstructured.Unstructured)
+				// like Kubernetes, reject updates of an object which has changed since it was read
+				if obj.GetResourceVersion() != test.current {
+					return true, nil, apierrors.NewConflict(schema2.GroupResource{Resource: "pods"}, obj.GetName(), fmt.Errorf("the object has been modified"))
+				}
+				updated = true
+				obj = obj.DeepCopy()
+				obj.SetResourceVersion("6")
+				return true, obj, nil
+			})
+			s := Store{
+				clientGetter: &testFactory{Factory: testClientFactory, fakeClient: fakeClient},
+			}
+			req, err := http.NewRequest(http.MethodPut, "/v1/pods/ns/a", nil)
+			assert.NoError(t, err)
+			apiSchema := &types.APISchema{Schema: &schemas.Schema{ID: "pods"}}
+			apiOp := &types.APIRequest{Schema: apiSchema, Request: req, Method: http.MethodPut}
+			metadata := map[string]interface{}{"name": "a", "namespace": "ns"}
+			if test.resourceVersion != "" {
+				metadata["resourceVersion"] = test.resourceVersion
+			}
+			input := types.APIObject{Object: map[string]interface{}{"apiVersion": "v1", "kind": "Pod", "metadata": metadata}}
+
+			obj, _, err := s.Update(apiOp, apiSchema, input, "a")
+			if test.wantErr != nil {
+				assert.True(t, test.wantErr(err), "unexpected error %v", err)
+				assert.False(t, updated)
+				return
+			}
+			assert.NoError(t, err)
+			assert.True(t, updated)
+			assert.Equal(t, "6", obj.GetResourceVersion())
+		})
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	stored := map[string]map[string]interface{}{
+		"a": {"apiVersion": "v1", "kind": "Pod", "metadata": map[string]interface{}{"name": "a", "namespace": "ns", "resourceVersion": "1"}},
+	}
+	var dryRuns []string
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		name := strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, "/api/v1/namespaces/ns/pods"), "/")
+		if req.Method == http.MethodGet {
+			obj, ok := stored[name]
+			if !ok {
+				rw.WriteHeader(http.StatusNotFound)
+				status := apierrors.NewNotFound(schema2.GroupResource{Resource: "pods"}, name).ErrStatus
+				status.TypeMeta = metav1.TypeMeta{Kind: "Status", APIVersion: "v1"}
+				json.NewEncoder(rw).Encode(status)
+				return
+			}
+			json.NewEncoder(rw).Encode(obj)
+			return
+		}
+		if req.Method == http.MethodDelete {
+			// delete options are sent in the body
+			opts := metav1.DeleteOptions{}
+			json.NewDecoder(req.Body).Decode(&opts)
+			dryRuns = append(dryRuns, strings.Join(opts.DryRun, ","))
+			json.NewEncoder(rw).Encode(metav1.Status{TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"}, Status: metav1.StatusSuccess})
+			return
+		}
+		dryRuns = append(dryRuns, req.URL.Query().Get("dryRun"))
+		obj := map[string]interface{}{}
+		json.NewDecoder(req.Body).Decode(&obj)
+		if obj["metadata"].(map[string]interface{})["name"] == "invalid" {
+			status := apierrors.NewInvalid(schema2.GroupKind{Kind: "Pod"}, "invalid", nil).ErrStatus
+			status.TypeMeta = metav1.TypeMeta{Kind: "Status", APIVersion: "v1"}
+			status.Message = `Pod "invalid" is invalid: spec.containers: Required value`
+			rw.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(rw).Encode(status)
+			return
+		}
+		// the response of a dry run is the object as it would have been persisted
+		obj["metadata"].(map[string]interface{})["uid"] = "would-be"
+		json.NewEncoder(rw).Encode(obj)
+	}))
+	defer srv.Close()
+
+	dynamicClient, err := dynamic.NewForConfig(&rest.Config{Host: srv.URL})
+	assert.NoError(t, err)
+	testClientFactory, err := client.NewFactory(&rest.Config{}, false)
+	assert.NoError(t, err)
+	s := Store{
+		clientGetter: &testFactory{Factory: testClientFactory, fakeClient: dynamicClient},
+	}
+	apiSchema := &types.APISchema{Schema: &schemas.Schema{ID: "pod", Attributes: map[string]interface{}{"version": "v1", "kind": "Pod"}}}
+	newRequest := func(method, path string) *types.APIRequest {
+		req, err := http.NewRequest(method, path+"?dryRun=All", nil)
+		assert.NoError(t, err)
+		return &types.APIRequest{Schema: apiSchema, Request: req, Method: method, Namespace: "ns"}
+	}
+	pod := func(name string) types.APIObject {
+		return types.APIObject{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Pod",
+			"metadata":   map[string]interface{}{"name": name, "namespace": "ns", "resourceVersion": "1"},
+		}}
+	}
+
+	obj, _, err := s.Create(newRequest(http.MethodPost, "/v1/pods/ns"), apiSchema, pod("b"))
+	assert.NoError(t, err)
+	assert.Equal(t, "would-be", string(obj.GetUID()), "expected the would-be object")
+
+	obj, _, err = s.Update(newRequest(http.MethodPut, "/v1/pods/ns/a"), apiSchema, pod("a"), "a")
+	assert.NoError(t, err)
+	assert.Equal(t, "would-be", string(obj.GetUID()))
+
+	obj, _, err = s.Delete(newRequest(http.MethodDelete, "/v1/pods/ns/a"), apiSchema, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, "a", obj.GetName(), "expected the object which would have been deleted")
+
+	_, _, err = s.Create(newRequest(http.MethodPost, "/v1/pods/ns"), apiSchema, pod("invalid"))
+	assert.True(t, apierrors.IsInvalid(err))
+	assert.EqualError(t, err, `Pod "invalid" is invalid: spec.containers: Required value`, "expected the validation error verbatim")
+
+	assert.Equal(t, []string{"All", "All", "All", "All"}, dryRuns, "expected every change to be a dry run")
+	assert.Len(t, stored, 1)
+}
+
+func TestListPartialMetadata(t *testing.T) {
+	var accepts []string
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		accepts = append(accepts, req.Header.Get("Accept"))
+		rw.Header().Set("Content-Type", "application/json")
+		if strings.Contains(req.Header.Get("Accept"), "as=PartialObjectMetadataList") {
+			json.NewEncoder(rw).Encode(map[string]interface{}{
+				"apiVersion": "meta.k8s.io/v1",
+				"kind":       "PartialObjectMetadataList",
+				"metadata":   map[string]interface{}{"resourceVersion": "5"},
+				"items": []interface{}{
+					map[string]interface{}{
+						"apiVersion": "meta.k8s.io/v1",
+						"kind":       "PartialObjectMetadata",
+						"metadata":   map[string]interface{}{"name": "a", "namespace": "ns"},
+					},
+				},
+			})
+			return
+		}
+		json.NewEncoder(rw).Encode(map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "PodList",
+			"metadata":   map[string]interface{}{"resourceVersion": "5"},
+			"items": []interface{}{
+				map[string]interface{}{
+					"apiVersion": "v1",
+					"kind":       "Pod",
+					"metadata":   map[string]interface{}{"name": "a", "namespace": "ns"},
+					"spec":       map[string]interface{}{"nodeName": "n1"},
+					"status":     map[string]interface{}{"phase": "Running"},
+				},
+			},
+		})
+	}))
+	defer srv.Close()
+
+	testClientFactory, err := client.NewFactory(&rest.Config{Host: srv.URL}, false)
+	assert.NoError(t, err)
+	s := Store{
+		clientGetter: testClientFactory,
+	}
+	apiSchema := &types.APISchema{Schema: &schemas.Schema{ID: "pod", Attributes: map[string]interface{}{"version": "v1", "kind": "Pod", "resource": "pods"}}}
+	list := func(accept string) *unstructured.UnstructuredList {
+		req, err := http.NewRequest(http.MethodGet, "/v1/pods/ns", nil)
+		assert.NoError(t, err)
+		req.Header.Set("Accept", accept)
+		result, _, err := s.List(&types.APIRequest{Schema: apiSchema, Request: req, Namespace: "ns"}, apiSchema)
+		assert.NoError(t, err)
+		return result
+	}
+
+	result := list("application/json;as=PartialObjectMetadataList;g=meta.k8s.io;v=v1")
+	if assert.Len(t, result.Items, 1) {
+		obj := result.Items[0].Object
+		assert.Equal(t, "v1", obj["apiVersion"], "expected the apiVersion of the schema")
+		assert.Equal(t, "Pod", obj["kind"], "expected the kind of the schema")
+		assert.NotContains(t, obj, "spec")
+		assert.NotContains(t, obj, "status")
+	}
+	assert.Contains(t, accepts[0], "as=PartialObjectMetadataList", "expected only the metadata to be listed from Kubernetes")
+
+	result = list("application/json")
+	if assert.Len(t, result.Items, 1) {
+		assert.Contains(t, result.Items[0].Object, "spec", "expected other lists to have the full objects")
+	}
+}
+
+func (t *testFactory) TableClient(ctx *types.APIRequest, schema *types.APISchema, namespace string, warningHandler rest.WarningHandler) (dynamic.ResourceInterface, error) {
+	return t.fakeClient.Resource(schema2.GroupVersionResource{Version: "v1", Resource: "pods"}).Namespace(namespace), nil
+}
+
 func (t *testFactory) TableAdminClientForWatch(ctx *types.APIRequest, schema *types.APISchema, namespace string, warningHandler rest.WarningHandler) (dynamic.ResourceInterface, error) {
 	return t.fakeClient.Resource(schema2.GroupVersionResource{}), nil
 }
@@ -4,11 +4,13 @@ import (
 	"fmt"
 	"sort"
 
+	"github.com/rancher/apiserver/pkg/apierror"
 	"github.com/rancher/apiserver/pkg/types"
 	"github.com/rancher/steve/pkg/accesscontrol"
 	"github.com/rancher/steve/pkg/attributes"
 	"github.com/rancher/steve/pkg/stores/partition"
 	"github.com/rancher/wrangler/pkg/kv"
+	"github.com/rancher/wrangler/pkg/schemas/validation"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/watch"
@@ -43,18 +45,39 @@ type rbacPartitioner struct {
 func (p *rbacPartitioner) Lookup(apiOp *types.APIRequest, schema *types.APISchema, verb, id string) (partition.Partition, error) {
 	switch verb {
 	case "create":
-		fallthrough
+		return passthroughPartitions[0], nil
 	case "get":
 		fallthrough
 	case "update":
 		fallthrough
 	case "delete":
+		if !grantsObject(apiOp, schema, verb, id) {
+			return nil, apierror.NewAPIError(validation.PermissionDenied, fmt.Sprintf("can not %s %s %s", verb, schema.ID, id))
+		}
 		return passthroughPartitions[0], nil
 	default:
 		return nil, fmt.Errorf("partition list: invalid verb %s", verb)
 	}
 }
 
+// grantsObject returns whether the access of the schema grants the verb on the object with the ID, so that access
+// granted to some objects by name only applies to those objects even when the request is not impersonated. Updates
+// are granted by patch access too, since they are used for patch requests. Schemas without access are not checked.
+func grantsObject(apiOp *types.APIRequest, schema *types.APISchema, verb, id string) bool {
+	accessListByVerb, ok := attributes.Access(schema).(accesscontrol.AccessListByVerb)
+	if !ok {
+		return true
+	}
+	namespace, name := kv.RSplit(id, "/")
+	if namespace == "" {
+		namespace = apiOp.Namespace
+	}
+	if accessListByVerb.Grants(verb, namespace, name) {
+		return true
+	}
+	return verb == "update" && accessListByVerb.Grants("patch", namespace, name)
+}
+
 // All returns a slice of partitions applicable to the API schema and the user's access level.
 // For watching individual resources or for blanket access permissions, it returns the passthrough partition.
 // For more granular permissions, it returns a slice of partitions matching an allowed namespace or resource names.
@@ -95,6 +118,11 @@ func (p *rbacPartitioner) Store(apiOp *types.APIRequest, partition partition.Par
 	}, nil
 }
 
+// Revision returns the current revision of the resources of the schema, for the lists which did not list any partition.
+func (p *rbacPartitioner) Revision(apiOp *types.APIRequest, schema *types.APISchema) (string, error) {
+	return p.proxyStore.Revision(apiOp, schema)
+}
+
 type byNameOrNamespaceStore struct {
 	*Store
 	partition Partition
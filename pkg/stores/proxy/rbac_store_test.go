@@ -250,3 +250,57 @@ func TestAll(t *testing.T) {
 		})
 	}
 }
+
+func TestLookup(t *testing.T) {
+	schema := &types.APISchema{
+		Schema: &schemas.Schema{
+			ID: "secret",
+			Attributes: map[string]interface{}{
+				"namespaced": true,
+				"access": accesscontrol.AccessListByVerb{
+					"get": accesscontrol.AccessList{
+						accesscontrol.Access{
+							Namespace:    "n1",
+							ResourceName: "r1",
+						},
+					},
+					"patch": accesscontrol.AccessList{
+						accesscontrol.Access{
+							Namespace:    "n1",
+							ResourceName: "r1",
+						},
+					},
+				},
+			},
+		},
+	}
+	tests := []struct {
+		name    string
+		apiOp   *types.APIRequest
+		schema  *types.APISchema
+		verb    string
+		id      string
+		wantErr bool
+	}{
+		{name: "named object", apiOp: &types.APIRequest{}, schema: schema, verb: "get", id: "n1/r1"},
+		{name: "named object in the namespace of the request", apiOp: &types.APIRequest{Namespace: "n1"}, schema: schema, verb: "get", id: "r1"},
+		{name: "other object", apiOp: &types.APIRequest{}, schema: schema, verb: "get", id: "n1/r2", wantErr: true},
+		{name: "named object in another namespace", apiOp: &types.APIRequest{}, schema: schema, verb: "get", id: "n2/r1", wantErr: true},
+		{name: "update with patch access", apiOp: &types.APIRequest{}, schema: schema, verb: "update", id: "n1/r1"},
+		{name: "delete without access", apiOp: &types.APIRequest{}, schema: schema, verb: "delete", id: "n1/r1", wantErr: true},
+		{name: "create", apiOp: &types.APIRequest{}, schema: schema, verb: "create"},
+		{name: "schema without access", apiOp: &types.APIRequest{}, schema: &types.APISchema{Schema: &schemas.Schema{ID: "foo"}}, verb: "get", id: "n1/r2"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			partitioner := rbacPartitioner{}
+			gotPartition, gotErr := partitioner.Lookup(test.apiOp, test.schema, test.verb, test.id)
+			if test.wantErr {
+				assert.Error(t, gotErr)
+				return
+			}
+			assert.Nil(t, gotErr)
+			assert.Equal(t, passthroughPartitions[0], gotPartition)
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rancher/apiserver/pkg/apierror"
+	"github.com/rancher/apiserver/pkg/types"
+	"github.com/rancher/steve/pkg/attributes"
+	"github.com/rancher/wrangler/pkg/schemas"
+	"github.com/rancher/wrangler/pkg/schemas/validation"
+)
+
+// formattedMetadataFields are the fields the formatters add to the metadata of objects, which clients may send back.
+var formattedMetadataFields = map[string]bool{
+	"fields":        true,
+	"relationships": true,
+	"state":         true,
+}
+
+// fieldError is an error of a field of an object, by its path in the object.
+type fieldError struct {
+	code    validation.ErrorCode
+	path    string
+	message string
+}
+
+// validator validates objects against the fields of their schema and of the schemas of their nested objects.
+type validator struct {
+	schemas *types.APISchemas
+	strict  bool
+	errors  []fieldError
+}
+
+// validateObject validates the object against the fields of the schema, if the schema opted in to validation. It returns
+// an error with the first invalid field and the messages of all the invalid fields.
+//
+// The validation is lenient so that it never rejects an object Kubernetes would accept: it checks the required fields
+// and the types of the values which would not decode as their field, and only checks the fields of the schemas it
+// has. Strings accept numbers, since Kubernetes reads numbers as integers or strings and as quantities.
+func validateObject(apiOp *types.APIRequest, schema *types.APISchema, obj map[string]interface{}) error {
+	mode := attributes.Validation(schema)
+	if mode == "" || schema.ResourceFields == nil {
+		return nil
+	}
+	v := &validator{
+		schemas: apiOp.Schemas,
+		strict:  mode == attributes.ValidationStrict,
+	}
+	v.validateFields("", schema.Schema, obj)
+	if len(v.errors) == 0 {
+		return nil
+	}
+
+	sort.Slice(v.errors, func(i, j int) bool {
+		return v.errors[i].path < v.errors[j].path
+	})
+	messages := make([]string, len(v.errors))
+	for i, err := range v.errors {
+		messages[i] = err.message
+	}
+	return apierror.NewFieldAPIError(v.errors[0].code, v.errors[0].path, strings.Join(messages, "; "))
+}
+
+// validateFields validates the fields of the object at the path against the fields of the schema.
+func (v *validator) validateFields(path string, schema *schemas.Schema, obj map[string]interface{}) {
+	for name, field := range schema.ResourceFields {
+		key := name
+		// the fields named as the fields of the API are renamed with an underscore, which clients only send at the top
+		if reserved := strings.TrimPrefix(name, "_"); reserved != name && types.ReservedFields[reserved] && path != "" {
+			key = reserved
+		}
+		value, ok := obj[key]
+		if !ok || value == nil {
+			// Kubernetes sets the default of a required field before validating the object
+			if field.Required && field.Default == nil {
+				v.addError(validation.MissingRequired, join(path, key), "%s is required", join(path, key))
+			}
+			continue
+		}
+		v.validateValue(join(path, key), field.Type, value)
+	}
+
+	if !v.strict {
+		return
+	}
+	for key := range obj {
+		if _, ok := schema.ResourceFields[key]; ok {
+			continue
+		}
+		if _, ok := schema.ResourceFields["_"+key]; ok && path != "" {
+			continue
+		}
+		if (path == "" && types.ReservedFields[key]) || (path == "metadata" && formattedMetadataFields[key]) {
+			continue
+		}
+		v.addError(validation.InvalidOption, join(path, key), "%s is not a field of %s", join(path, key), schema.ID)
+	}
+}
+
+// validateValue validates the value at the path against the type of its field.
+func (v *validator) validateValue(path, fieldType string, value interface{}) {
+	if value == nil {
+		return
+	}
+	switch {
+	case strings.HasPrefix(fieldType, "array[") && strings.HasSuffix(fieldType, "]"):
+		values, ok := value.([]interface{})
+		if !ok {
+			v.addError(validation.InvalidType, path, "%s must be an array", path)
+			return
+		}
+		for i, value := range values {
+			v.validateValue(fmt.Sprintf("%s[%d]", path, i), fieldType[len("array["):len(fieldType)-1], value)
+		}
+	case strings.HasPrefix(fieldType, "map[") && strings.HasSuffix(fieldType, "]"):
+		values, ok := value.(map[string]interface{})
+		if !ok {
+			v.addError(validation.InvalidType, path, "%s must be an object", path)
+			return
+		}
+		for key, value := range values {
+			v.validateValue(join(path, key), fieldType[len("map["):len(fieldType)-1], value)
+		}
+	case fieldType == "string":
+		if !isStringOrNumber(value) {
+			v.addError(validation.InvalidType, path, "%s must be a string", path)
+		}
+	case fieldType == "int" || fieldType == "integer" || fieldType == "number":
+		if !isNumber(value) {
+			v.addError(validation.InvalidType, path, "%s must be a number", path)
+		}
+	case fieldType == "boolean":
+		if _, ok := value.(bool); !ok {
+			v.addError(validation.InvalidType, path, "%s must be a boolean", path)
+		}
+	default:
+		var schema *types.APISchema
+		if v.schemas != nil {
+			schema = v.schemas.Schemas[fieldType]
+		}
+		if schema == nil || schema.ResourceFields == nil {
+			// json fields and the objects of unknown schemas can be anything
+			return
+		}
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			v.addError(validation.InvalidType, path, "%s must be an object", path)
+			return
+		}
+		v.validateFields(path, schema.Schema, obj)
+	}
+}
+
+func (v *validator) addError(code validation.ErrorCode, path, format string, args ...interface{}) {
+	v.errors = append(v.errors, fieldError{
+		code:    code,
+		path:    path,
+		message: fmt.Sprintf(format, args...),
+	})
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func isNumber(value interface{}) bool {
+	switch value.(type) {
+	case json.Number, float64, float32, int, int64, int32:
+		return true
+	}
+	return false
+}
+
+func isStringOrNumber(value interface{}) bool {
+	if _, ok := value.(string); ok {
+		return true
+	}
+	return isNumber(value)
+}
@@ -0,0 +1,211 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/rancher/apiserver/pkg/apierror"
+	"github.com/rancher/apiserver/pkg/types"
+	"github.com/rancher/steve/pkg/attributes"
+	"github.com/rancher/steve/pkg/client"
+	"github.com/rancher/wrangler/pkg/schemas"
+	"github.com/rancher/wrangler/pkg/schemas/validation"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/rest"
+	clientgotesting "k8s.io/client-go/testing"
+)
+
+func TestValidateObject(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      string
+		obj       string
+		wantField string
+		wantCode  validation.ErrorCode
+	}{
+		{
+			name: "valid object",
+			mode: attributes.ValidationLenient,
+			obj: `{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "a", "labels": {"app": "a"}},
+				"spec": {"containers": [{"name": "a", "ports": [{"containerPort": 80}], "limits": {"cpu": 1, "memory": "1Gi"}}]}}`,
+		},
+		{
+			name:      "missing required field",
+			mode:      attributes.ValidationLenient,
+			obj:       `{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "a"}, "spec": {"nodeName": "a"}}`,
+			wantField: "spec.containers",
+			wantCode:  validation.MissingRequired,
+		},
+		{
+			name:      "null required field",
+			mode:      attributes.ValidationLenient,
+			obj:       `{"metadata": {"name": "a"}, "spec": {"containers": null}}`,
+			wantField: "spec.containers",
+			wantCode:  validation.MissingRequired,
+		},
+		{
+			name:      "missing required field of a nested object",
+			mode:      attributes.ValidationLenient,
+			obj:       `{"metadata": {"name": "a"}, "spec": {"containers": [{"image": "nginx"}]}}`,
+			wantField: "spec.containers[0].name",
+			wantCode:  validation.MissingRequired,
+		},
+		{
+			name: "missing required field with a default",
+			mode: attributes.ValidationLenient,
+			obj:  `{"metadata": {"name": "a"}, "spec": {"containers": [{"name": "a", "ports": [{"containerPort": 80}]}]}}`,
+		},
+		{
+			name:      "invalid type",
+			mode:      attributes.ValidationLenient,
+			obj:       `{"metadata": {"name": "a"}, "spec": {"containers": [{"name": "a", "ports": [{"containerPort": "http"}]}]}}`,
+			wantField: "spec.containers[0].ports[0].containerPort",
+			wantCode:  validation.InvalidType,
+		},
+		{
+			name:      "object instead of an array",
+			mode:      attributes.ValidationLenient,
+			obj:       `{"metadata": {"name": "a"}, "spec": {"containers": {"name": "a"}}}`,
+			wantField: "spec.containers",
+			wantCode:  validation.InvalidType,
+		},
+		{
+			name: "unknown fields are allowed",
+			mode: attributes.ValidationLenient,
+			obj:  `{"metadata": {"name": "a"}, "spec": {"containers": [{"name": "a"}], "newField": true}}`,
+		},
+		{
+			name:      "unknown fields are rejected in strict mode",
+			mode:      attributes.ValidationStrict,
+			obj:       `{"metadata": {"name": "a"}, "spec": {"containers": [{"name": "a"}], "newField": true}}`,
+			wantField: "spec.newField",
+			wantCode:  validation.InvalidOption,
+		},
+		{
+			name: "fields of the API are allowed in strict mode",
+			mode: attributes.ValidationStrict,
+			obj: `{"id": "ns/a", "type": "pod", "links": {}, "metadata": {"name": "a", "state": {"name": "running"}},
+				"spec": {"containers": [{"name": "a"}]}}`,
+		},
+		{
+			name: "schemas without validation accept anything",
+			obj:  `{"spec": {"containers": "a"}}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			apiOp, schema := newValidationRequest(test.mode)
+			obj := map[string]interface{}{}
+			decoder := json.NewDecoder(strings.NewReader(test.obj))
+			decoder.UseNumber()
+			require.NoError(t, decoder.Decode(&obj))
+
+			err := validateObject(apiOp, schema, obj)
+			if test.wantField == "" {
+				assert.NoError(t, err)
+				return
+			}
+			var apiErr *apierror.APIError
+			require.True(t, errors.As(err, &apiErr), "expected an API error, got %v", err)
+			assert.Equal(t, test.wantCode, apiErr.Code)
+			assert.Equal(t, test.wantField, apiErr.FieldName)
+			assert.Contains(t, apiErr.Message, test.wantField)
+		})
+	}
+}
+
+func TestCreateValidation(t *testing.T) {
+	testClientFactory, err := client.NewFactory(&rest.Config{}, false)
+	require.NoError(t, err)
+	fakeClient := fake.NewSimpleDynamicClient(runtime.NewScheme())
+	created := false
+	fakeClient.PrependReactor("create", "*", func(a clientgotesting.Action) (bool, runtime.Object, error) {
+		created = true
+		return true, a.(clientgotesting.CreateActionImpl).GetObject(), nil
+	})
+	s := Store{
+		clientGetter: &testFactory{Factory: testClientFactory, fakeClient: fakeClient},
+	}
+	apiOp, schema := newValidationRequest(attributes.ValidationLenient)
+
+	_, _, err = s.Create(apiOp, schema, types.APIObject{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "a", "namespace": "ns"},
+		"spec":     map[string]interface{}{},
+	}})
+	var apiErr *apierror.APIError
+	require.True(t, errors.As(err, &apiErr), "expected an API error, got %v", err)
+	assert.Equal(t, http.StatusUnprocessableEntity, apiErr.Code.Status)
+	assert.Equal(t, "spec.containers", apiErr.FieldName)
+	assert.False(t, created, "an invalid object must not be sent to Kubernetes")
+
+	obj, _, err := s.Create(apiOp, schema, types.APIObject{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "a", "namespace": "ns"},
+		"spec":     map[string]interface{}{"containers": []interface{}{map[string]interface{}{"name": "a"}}},
+	}})
+	require.NoError(t, err)
+	assert.True(t, created)
+	assert.Equal(t, "a", obj.GetName())
+}
+
+// newValidationRequest returns a request with the schemas of a pod, validated with the mode.
+func newValidationRequest(mode string) (*types.APIRequest, *types.APISchema) {
+	apiSchemas := types.EmptyAPISchemas()
+	for _, schema := range []schemas.Schema{
+		{
+			ID: "io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta",
+			ResourceFields: map[string]schemas.Field{
+				"name":      {Type: "string"},
+				"namespace": {Type: "string"},
+				"labels":    {Type: "map[string]"},
+			},
+		},
+		{
+			ID: "io.k8s.api.core.v1.PodSpec",
+			ResourceFields: map[string]schemas.Field{
+				"containers": {Type: "array[io.k8s.api.core.v1.Container]", Required: true},
+				"nodeName":   {Type: "string"},
+			},
+		},
+		{
+			ID: "io.k8s.api.core.v1.Container",
+			ResourceFields: map[string]schemas.Field{
+				"name":   {Type: "string", Required: true},
+				"image":  {Type: "string"},
+				"ports":  {Type: "array[io.k8s.api.core.v1.ContainerPort]"},
+				"limits": {Type: "map[string]"},
+			},
+		},
+		{
+			ID: "io.k8s.api.core.v1.ContainerPort",
+			ResourceFields: map[string]schemas.Field{
+				"containerPort": {Type: "int", Required: true},
+				"protocol":      {Type: "string", Required: true, Default: "TCP"},
+			},
+		},
+	} {
+		schema := schema
+		apiSchemas.MustAddSchema(types.APISchema{Schema: &schema})
+	}
+	apiSchemas.MustAddSchema(types.APISchema{Schema: &schemas.Schema{
+		ID: "pod",
+		ResourceFields: map[string]schemas.Field{
+			"apiVersion": {Type: "string"},
+			"kind":       {Type: "string"},
+			"metadata":   {Type: "io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"},
+			"spec":       {Type: "io.k8s.api.core.v1.PodSpec"},
+		},
+		Attributes: map[string]interface{}{"version": "v1", "kind": "Pod"},
+	}})
+	schema := apiSchemas.LookupSchema("pod")
+	if mode != "" {
+		attributes.SetValidation(schema, mode)
+	}
+	req, _ := http.NewRequest(http.MethodPost, "/v1/pods/ns", nil)
+	return &types.APIRequest{Schemas: apiSchemas, Schema: schema, Request: req, Method: http.MethodPost, Namespace: "ns"}, schema
+}
@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// watchSelector filters the events of a watch by the label and field selectors of the watch request.
+type watchSelector struct {
+	labelSelector string
+	label         labels.Selector
+	field         fields.Selector
+}
+
+// parseWatchSelector parses the selector of a watch request. The selector is either a label selector, or the URL
+// encoded labelSelector and fieldSelector parameters, such as "labelSelector=app%3Dweb&fieldSelector=spec.nodeName%3Dn1".
+func parseWatchSelector(selector string) (*watchSelector, error) {
+	labelSelector, fieldSelector := selector, ""
+	if strings.HasPrefix(selector, "labelSelector=") || strings.HasPrefix(selector, "fieldSelector=") {
+		params, err := url.ParseQuery(selector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid watch selector %q: %w", selector, err)
+		}
+		labelSelector, fieldSelector = params.Get("labelSelector"), params.Get("fieldSelector")
+	}
+	label, err := labels.Parse(labelSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid label selector %q: %w", labelSelector, err)
+	}
+	field, err := fields.ParseSelector(fieldSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid field selector %q: %w", fieldSelector, err)
+	}
+	return &watchSelector{
+		labelSelector: labelSelector,
+		label:         label,
+		field:         field,
+	}, nil
+}
+
+// filter returns the event to send for the watch event, and whether to send it. Kubernetes already applies the label
+// selector to its own events, but the field selector is applied here since Kubernetes only supports a few fields per
+// resource, and events from other sources, such as relationship changes, go through here too. An object that stops
+// matching the selectors after a modification is sent as deleted, since the client may have it from earlier events or
+// from a list.
+func (w *watchSelector) filter(event watch.Event) (watch.Event, bool) {
+	if w.label.Empty() && w.field.Empty() {
+		return event, true
+	}
+	switch event.Type {
+	case watch.Added:
+		return event, w.matches(event.Object)
+	case watch.Modified:
+		if !w.matches(event.Object) {
+			event.Type = watch.Deleted
+		}
+		return event, true
+	default:
+		return event, true
+	}
+}
+
+func (w *watchSelector) matches(obj runtime.Object) bool {
+	if !w.label.Empty() {
+		m, err := meta.Accessor(obj)
+		if err != nil || !w.label.Matches(labels.Set(m.GetLabels())) {
+			return false
+		}
+	}
+	if !w.field.Empty() {
+		return w.field.Matches(fieldSet(obj, w.field.Requirements()))
+	}
+	return true
+}
+
+// fieldSet returns the values of the fields of the object which are used by the requirements. Values which are not
+// strings are formatted, and missing values are left out of the set.
+func fieldSet(obj runtime.Object, requirements fields.Requirements) fields.Set {
+	var content map[string]interface{}
+	if unstr, ok := obj.(*unstructured.Unstructured); ok {
+		content = unstr.Object
+	} else {
+		var err error
+		if content, err = runtime.DefaultUnstructuredConverter.ToUnstructured(obj); err != nil {
+			return fields.Set{}
+		}
+	}
+	set := fields.Set{}
+	for _, requirement := range requirements {
+		value, ok, err := unstructured.NestedFieldNoCopy(content, strings.Split(requirement.Field, ".")...)
+		if err != nil || !ok || value == nil {
+			continue
+		}
+		if s, ok := value.(string); ok {
+			set[requirement.Field] = s
+		} else {
+			set[requirement.Field] = fmt.Sprint(value)
+		}
+	}
+	return set
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/rancher/steve/pkg/clustercache"
 	"github.com/rancher/steve/pkg/schema"
 	"github.com/rancher/steve/pkg/schema/converter"
+	"github.com/rancher/wrangler/pkg/data"
 	"github.com/rancher/wrangler/pkg/slice"
 	"github.com/rancher/wrangler/pkg/summary"
 	"k8s.io/apimachinery/pkg/api/meta"
@@ -27,6 +28,35 @@ var (
 	cbID = 0
 )
 
+func init() {
+	summary.Summarizers = append(summary.Summarizers, checkServiceEndpoints)
+}
+
+// checkServiceEndpoints relates a service to the endpoints Kubernetes keeps for it, which have the name of the service,
+// and to its endpoint slices, which are selected by the service name label. Services of the ExternalName type have no
+// endpoints.
+func checkServiceEndpoints(obj data.Object, _ []summary.Condition, s summary.Summary) summary.Summary {
+	if obj.String("kind") != "Service" || obj.String("apiVersion") != "v1" || obj.String("spec", "type") == "ExternalName" {
+		return s
+	}
+	s.Relationships = append(s.Relationships, summary.Relationship{
+		Name:       obj.String("metadata", "name"),
+		Kind:       "Endpoints",
+		APIVersion: "v1",
+		Type:       "uses",
+	}, summary.Relationship{
+		Kind:       "EndpointSlice",
+		APIVersion: "discovery.k8s.io/v1",
+		Type:       "uses",
+		Selector: &metav1.LabelSelector{
+			MatchLabels: map[string]string{
+				"kubernetes.io/service-name": obj.String("metadata", "name"),
+			},
+		},
+	})
+	return s
+}
+
 type Relationship struct {
 	ToID        string `json:"toId,omitempty"`
 	ToType      string `json:"toType,omitempty"`
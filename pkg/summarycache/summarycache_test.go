@@ -0,0 +1,170 @@
+package summarycache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rancher/apiserver/pkg/types"
+	"github.com/rancher/steve/pkg/attributes"
+	"github.com/rancher/steve/pkg/clustercache"
+	"github.com/rancher/steve/pkg/schema"
+	"github.com/rancher/steve/pkg/schema/converter"
+	wschemas "github.com/rancher/wrangler/pkg/schemas"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	runtimeschema "k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+type fakeClusterCache struct {
+	objects map[string]*unstructured.Unstructured
+}
+
+func (f *fakeClusterCache) Get(gvk runtimeschema.GroupVersionKind, namespace, name string) (interface{}, bool, error) {
+	obj, ok := f.objects[toKeyFrom(namespace, name, gvk)]
+	return obj, ok, nil
+}
+
+func (f *fakeClusterCache) List(gvk runtimeschema.GroupVersionKind) []interface{} {
+	return nil
+}
+
+func (f *fakeClusterCache) OnAdd(ctx context.Context, handler clustercache.Handler)          {}
+func (f *fakeClusterCache) OnRemove(ctx context.Context, handler clustercache.Handler)       {}
+func (f *fakeClusterCache) OnChange(ctx context.Context, handler clustercache.ChangeHandler) {}
+func (f *fakeClusterCache) OnSchemas(schemas *schema.Collection) error                       { return nil }
+func (f *fakeClusterCache) Pause(gvr runtimeschema.GroupVersionResource) error               { return nil }
+func (f *fakeClusterCache) Resume(gvr runtimeschema.GroupVersionResource) error              { return nil }
+
+// newSummaryCache returns a summary cache holding the objects, with namespaced schemas for their kinds.
+func newSummaryCache(t *testing.T, objs ...*unstructured.Unstructured) *SummaryCache {
+	schemas := map[string]*types.APISchema{}
+	for _, gvk := range []runtimeschema.GroupVersionKind{
+		{Version: "v1", Kind: "Pod"},
+		{Version: "v1", Kind: "Service"},
+		{Version: "v1", Kind: "Endpoints"},
+		{Group: "discovery.k8s.io", Version: "v1", Kind: "EndpointSlice"},
+		{Group: "apps", Version: "v1", Kind: "ReplicaSet"},
+	} {
+		s := &types.APISchema{
+			Schema: &wschemas.Schema{
+				ID:         converter.GVKToSchemaID(gvk),
+				Attributes: map[string]interface{}{},
+			},
+		}
+		attributes.SetGVK(s, gvk)
+		attributes.SetNamespaced(s, true)
+		schemas[s.ID] = s
+	}
+	collection := schema.NewCollection(context.Background(), types.EmptyAPISchemas(), nil)
+	require.NoError(t, collection.Reset(schemas))
+
+	clusterCache := &fakeClusterCache{objects: map[string]*unstructured.Unstructured{}}
+	summaryCache := New(collection, clusterCache)
+	for _, obj := range objs {
+		clusterCache.objects[toKey(obj)] = obj
+		summaryCache.Add(obj)
+	}
+	return summaryCache
+}
+
+func newObject(apiVersion, kind, namespace, name string, fields map[string]interface{}) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: fields}
+	if obj.Object == nil {
+		obj.Object = map[string]interface{}{}
+	}
+	obj.SetAPIVersion(apiVersion)
+	obj.SetKind(kind)
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	return obj
+}
+
+// links returns the relationships of the object without the state of the related objects.
+func links(summaryCache *SummaryCache, obj *unstructured.Unstructured) []Relationship {
+	_, rels := summaryCache.SummaryAndRelationship(obj)
+	for i := range rels {
+		rels[i].State, rels[i].Message, rels[i].Error, rels[i].Transitioning = "", "", false, false
+	}
+	return rels
+}
+
+func TestOwnerRelationships(t *testing.T) {
+	replicaSet := newObject("apps/v1", "ReplicaSet", "ns1", "web-1", nil)
+	pod := newObject("v1", "Pod", "ns1", "web-1-abc", nil)
+	pod.SetOwnerReferences([]metav1.OwnerReference{
+		{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "web-1", UID: "1"},
+	})
+	summaryCache := newSummaryCache(t, replicaSet, pod)
+
+	rels := links(summaryCache, pod)
+	assert.Contains(t, rels, Relationship{
+		FromID:   "ns1/web-1",
+		FromType: "apps.replicaset",
+		Rel:      "owner",
+	}, "expected the pod to be related to its owner")
+
+	rels = links(summaryCache, replicaSet)
+	assert.Contains(t, rels, Relationship{
+		ToID:   "ns1/web-1-abc",
+		ToType: "pod",
+		Rel:    "owner",
+	}, "expected the owner to be related to the pod it owns")
+}
+
+func TestServiceRelationships(t *testing.T) {
+	service := newObject("v1", "Service", "ns1", "web", map[string]interface{}{
+		"spec": map[string]interface{}{
+			"selector": map[string]interface{}{"app": "web"},
+		},
+	})
+	endpoints := newObject("v1", "Endpoints", "ns1", "web", nil)
+	other := newObject("v1", "Endpoints", "ns1", "db", nil)
+	summaryCache := newSummaryCache(t, service, endpoints, other)
+
+	rels := links(summaryCache, service)
+	assert.Contains(t, rels, Relationship{
+		ToType:      "pod",
+		ToNamespace: "ns1",
+		Rel:         "selects",
+		Selector:    "app=web",
+	}, "expected the service to select its pods")
+	assert.Contains(t, rels, Relationship{
+		ToID:   "ns1/web",
+		ToType: "endpoints",
+		Rel:    "uses",
+	}, "expected the service to be related to its endpoints")
+	assert.Contains(t, rels, Relationship{
+		ToType:      "discovery.k8s.io.endpointslice",
+		ToNamespace: "ns1",
+		Rel:         "uses",
+		Selector:    "kubernetes.io/service-name=web",
+	}, "expected the service to select its endpoint slices")
+
+	rels = links(summaryCache, endpoints)
+	assert.Contains(t, rels, Relationship{
+		FromID:   "ns1/web",
+		FromType: "service",
+		Rel:      "uses",
+	}, "expected the endpoints to be related to their service")
+
+	rels = links(summaryCache, other)
+	assert.Empty(t, rels, "expected endpoints of another service to be unrelated")
+}
+
+func TestExternalNameServiceRelationships(t *testing.T) {
+	service := newObject("v1", "Service", "ns1", "web", map[string]interface{}{
+		"spec": map[string]interface{}{
+			"type":         "ExternalName",
+			"externalName": "example.com",
+		},
+	})
+	summaryCache := newSummaryCache(t, service)
+
+	rels := links(summaryCache, service)
+	for _, rel := range rels {
+		assert.NotEqual(t, "endpoints", rel.ToType)
+		assert.NotEqual(t, "discovery.k8s.io.endpointslice", rel.ToType)
+	}
+}